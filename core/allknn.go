@@ -0,0 +1,43 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AllKNNStream computes the k nearest neighbors of the stored vector of each id, excluding the id
+// itself as SearchByID does, and passes them to emit one id at a time in the given order. Nothing is
+// kept after emit returns, so memory use does not grow with the number of ids. An error returned by
+// emit stops the computation and is returned. Ids deleted since the list was taken are skipped.
+func AllKNNStream(index Index, ids []int, k int, emit func(id int, neighbors []Neighbor) error) error {
+	if k <= 0 {
+		return fmt.Errorf("%w: k must be positive, got %d", ErrInvalidK, k)
+	}
+	for _, id := range ids {
+		neighbors, err := SearchByID(index, id, k)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("id %d: %w", id, err)
+		}
+		if err := emit(id, neighbors); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllKNN returns the k nearest neighbors of the stored vector of each id keyed by id. It holds all
+// results in memory; use AllKNNStream for large indexes.
+func AllKNN(index Index, ids []int, k int) (map[int][]Neighbor, error) {
+	results := make(map[int][]Neighbor, len(ids))
+	err := AllKNNStream(index, ids, k, func(id int, neighbors []Neighbor) error {
+		results[id] = neighbors
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,26 @@
+package core
+
+import (
+	"fmt"
+	"math"
+)
+
+// SearchBucketed returns the k nearest neighbors of the query grouped into distance buckets of width
+// bucketWidth, keyed by int(distance/bucketWidth), for example to draw a histogram of the neighborhood.
+// Within each bucket the neighbors keep the ascending order of Search. Empty buckets have no entry.
+// bucketWidth must be positive and finite.
+func SearchBucketed(index Index, query []float32, k int, bucketWidth float64) (map[int][]Neighbor, error) {
+	if !(bucketWidth > 0) || math.IsInf(bucketWidth, 1) {
+		return nil, fmt.Errorf("bucket width must be positive and finite, got %v", bucketWidth)
+	}
+	results, err := index.Search(query, k)
+	if err != nil {
+		return nil, err
+	}
+	buckets := make(map[int][]Neighbor)
+	for _, n := range results {
+		b := int(n.Distance / bucketWidth)
+		buckets[b] = append(buckets[b], n)
+	}
+	return buckets, nil
+}
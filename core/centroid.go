@@ -0,0 +1,36 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Centroid returns the mean of the vectors stored in an index for the given ids.
+// If the index uses the cosine distance, the centroid is L2-normalized like the stored vectors.
+// It returns an error if ids is empty or any id is not found.
+func Centroid(index Index, ids []int) ([]float32, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("no ids to compute a centroid for")
+	}
+	var sum []float64
+	for _, id := range ids {
+		vec, err := index.GetVector(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get vector for id %d: %w", id, err)
+		}
+		if sum == nil {
+			sum = make([]float64, len(vec))
+		}
+		for i, v := range vec {
+			sum[i] += float64(v)
+		}
+	}
+	centroid := make([]float32, len(sum))
+	for i, s := range sum {
+		centroid[i] = float32(s / float64(len(ids)))
+	}
+	if index.Stats().Distance == "cosine" {
+		NormalizeVector(centroid)
+	}
+	return centroid, nil
+}
@@ -0,0 +1,51 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+func TestCentroid(t *testing.T) {
+	index := hnsw.NewHNSW(3, 4, 10, core.Euclidean, "euclidean")
+	vectors := map[int][]float32{
+		1: {1, 2, 3},
+		2: {3, 4, 5},
+		3: {5, 0, -2},
+		4: {100, 100, 100},
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	centroid, err := core.Centroid(index, []int{1, 2, 3})
+	if err != nil {
+		t.Fatalf("Centroid failed: %v", err)
+	}
+	want := []float32{3, 2, 2}
+	for i := range want {
+		if centroid[i] != want[i] {
+			t.Errorf("centroid[%d] = %f, want %f", i, centroid[i], want[i])
+		}
+	}
+
+	if _, err := core.Centroid(index, nil); err == nil {
+		t.Error("expected error for empty ids, got none")
+	}
+	if _, err := core.Centroid(index, []int{1, 99}); err == nil {
+		t.Error("expected error for missing id, got none")
+	}
+
+	cosine := hnsw.NewHNSW(2, 4, 10, core.Cosine, "cosine")
+	if err := cosine.BulkAdd(map[int][]float32{1: {2, 0}, 2: {0, 5}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	centroid, err = core.Centroid(cosine, []int{1, 2})
+	if err != nil {
+		t.Fatalf("Centroid failed: %v", err)
+	}
+	if diff := centroid[0] - centroid[1]; diff > 1e-6 || diff < -1e-6 || centroid[0] < 0.7071 || centroid[0] > 0.7072 {
+		t.Errorf("expected normalized centroid [0.7071 0.7071], got %v", centroid)
+	}
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// SaveCompressed saves the index to w like Save, but gzip-compresses the stream. Compressed files are
+// typically noticeably smaller, mostly because graph links and ids compress well while float vectors
+// compress little, at the cost of slower saving and loading. Load them with LoadCompressed.
+func SaveCompressed(index Index, w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if err := index.Save(zw); err != nil {
+		zw.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to finish compressed stream: %w", err)
+	}
+	return nil
+}
+
+// LoadCompressed loads the index from a stream written by SaveCompressed.
+func LoadCompressed(index Index, r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read compressed stream: %w", err)
+	}
+	defer zr.Close()
+	return index.Load(zr)
+}
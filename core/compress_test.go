@@ -0,0 +1,62 @@
+package core_test
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+func TestSaveLoadCompressed(t *testing.T) {
+	const dim = 16
+	index := hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(4))
+	vectors := make(map[int][]float32, 500)
+	for i := 0; i < 500; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = float32(rng.Intn(100)) / 10
+		}
+		vectors[i] = vec
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	var plain, compressed bytes.Buffer
+	if err := index.Save(&plain); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := core.SaveCompressed(index, &compressed); err != nil {
+		t.Fatalf("SaveCompressed failed: %v", err)
+	}
+	if compressed.Len() >= plain.Len() {
+		t.Errorf("expected the compressed stream to be smaller, got %d bytes vs %d", compressed.Len(), plain.Len())
+	}
+
+	loaded := hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean")
+	if err := core.LoadCompressed(loaded, &compressed); err != nil {
+		t.Fatalf("LoadCompressed failed: %v", err)
+	}
+	if loaded.Stats() != index.Stats() {
+		t.Errorf("expected stats %+v after loading, got %+v", index.Stats(), loaded.Stats())
+	}
+	query := vectors[7]
+	want, err := index.Search(query, 5)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	got, err := loaded.Search(query, 5)
+	if err != nil {
+		t.Fatalf("Search on loaded index failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the loaded index to return %v, got %v", want, got)
+	}
+	if err := core.LoadCompressed(loaded, &plain); err == nil {
+		t.Error("expected error loading an uncompressed stream, got none")
+	}
+}
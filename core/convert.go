@@ -0,0 +1,33 @@
+package core
+
+import "fmt"
+
+// Convert copies all vectors of src, keeping their ids, into a new index created by dstFactory, for
+// example to migrate data from one index type to another. The new index must be empty and have the
+// same dimension as src. Vectors are copied as Export returns them, so for indexes that normalize or
+// quantize vectors the copies are the stored forms, not the originals.
+func Convert(src Index, dstFactory func() Index) (Index, error) {
+	dst := dstFactory()
+	if dst == nil {
+		return nil, fmt.Errorf("index factory returned nil")
+	}
+	srcStats, dstStats := src.Stats(), dst.Stats()
+	if srcStats.Dimension != dstStats.Dimension {
+		return nil, fmt.Errorf("%w: source dimension %d does not match destination dimension %d",
+			ErrDimensionMismatch, srcStats.Dimension, dstStats.Dimension)
+	}
+	if dstStats.Count > 0 {
+		return nil, fmt.Errorf("destination index must be empty, it holds %d vectors", dstStats.Count)
+	}
+	vectors, err := src.Export()
+	if err != nil {
+		return nil, fmt.Errorf("failed to export source index: %w", err)
+	}
+	if len(vectors) == 0 {
+		return dst, nil
+	}
+	if err := dst.BulkAdd(vectors); err != nil {
+		return nil, fmt.Errorf("failed to add vectors to destination index: %w", err)
+	}
+	return dst, nil
+}
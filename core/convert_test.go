@@ -0,0 +1,76 @@
+package core_test
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+	"github.com/patrikhermansson/hann/rpt"
+)
+
+func TestConvert_RPTToHNSW(t *testing.T) {
+	const dim = 6
+	src := rpt.NewRPTIndex(dim, 10, 3, 100, 1e9)
+	rng := rand.New(rand.NewSource(17))
+	vectors := make(map[int][]float32, 300)
+	for i := 0; i < 300; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i*3] = vec
+	}
+	if err := src.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	if _, err := core.Convert(src, func() core.Index {
+		return hnsw.NewHNSW(dim+1, 16, 100, core.Euclidean, "euclidean")
+	}); !errors.Is(err, core.ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch, got %v", err)
+	}
+	converted, err := core.Convert(src, func() core.Index {
+		return hnsw.NewHNSW(dim, 16, 100, core.Euclidean, "euclidean")
+	})
+	if err != nil {
+		t.Fatalf("Convert failed: %v", err)
+	}
+	if n := converted.Stats().Count; n != len(vectors) {
+		t.Fatalf("expected %d converted vectors, got %d", len(vectors), n)
+	}
+	// Ids are kept.
+	if vec, err := converted.GetVector(3); err != nil || !reflect.DeepEqual(vec, vectors[3]) {
+		t.Errorf("expected vector %v for id 3, got %v, %v", vectors[3], vec, err)
+	}
+
+	var matches int
+	for q := 0; q < 20; q++ {
+		query := make([]float32, dim)
+		for j := range query {
+			query[j] = rng.Float32()
+		}
+		want, err := src.Search(query, 5)
+		if err != nil {
+			t.Fatalf("RPT search failed: %v", err)
+		}
+		got, err := converted.Search(query, 5)
+		if err != nil {
+			t.Fatalf("HNSW search failed: %v", err)
+		}
+		ids := make(map[int]bool, len(want))
+		for _, n := range want {
+			ids[n.ID] = true
+		}
+		for _, n := range got {
+			if ids[n.ID] {
+				matches++
+			}
+		}
+	}
+	if overlap := float64(matches) / 100; overlap < 0.9 {
+		t.Errorf("expected the converted index to return comparable results, got overlap %.2f", overlap)
+	}
+}
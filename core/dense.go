@@ -0,0 +1,105 @@
+package core
+
+// DenseStore holds vectors of one dimension contiguously in a single slice and maps arbitrary ids, such
+// as scattered 64-bit ids, to dense slots 0 to Len()-1. Compared to a map from ids to vectors it saves
+// the allocation and slice header of every vector, and scans over the slots read memory sequentially.
+// Deleting an id moves the vector in the last slot into the freed one, so slots, and the slices returned
+// by Get and Vector, are only valid until the next Set or Delete. A DenseStore is not safe for concurrent
+// modification; indexes guard it with their own lock.
+type DenseStore struct {
+	dim   int
+	slots map[int]int32 // id to its dense slot
+	ids   []int         // dense slot to its id
+	data  []float32     // dim values per slot
+}
+
+// NewDenseStore creates an empty store for vectors of the given dimension.
+func NewDenseStore(dim int) *DenseStore {
+	return &DenseStore{dim: dim, slots: make(map[int]int32)}
+}
+
+// Len returns the number of stored vectors.
+func (s *DenseStore) Len() int {
+	return len(s.ids)
+}
+
+// Contains reports whether a vector is stored for id.
+func (s *DenseStore) Contains(id int) bool {
+	_, ok := s.slots[id]
+	return ok
+}
+
+// Get returns the stored vector of id without copying it, and false if id is not stored.
+func (s *DenseStore) Get(id int) ([]float32, bool) {
+	slot, ok := s.slots[id]
+	if !ok {
+		return nil, false
+	}
+	return s.Vector(int(slot)), true
+}
+
+// ID returns the id stored in a slot.
+func (s *DenseStore) ID(slot int) int {
+	return s.ids[slot]
+}
+
+// Vector returns the vector stored in a slot without copying it.
+func (s *DenseStore) Vector(slot int) []float32 {
+	start := slot * s.dim
+	// The capacity is capped so that appending to the result can't overwrite the next slot.
+	return s.data[start : start+s.dim : start+s.dim]
+}
+
+// Set stores a copy of vector under id, replacing the vector of an existing id in place.
+// The vector must have the store's dimension.
+func (s *DenseStore) Set(id int, vector []float32) {
+	if slot, ok := s.slots[id]; ok {
+		copy(s.Vector(int(slot)), vector)
+		return
+	}
+	s.slots[id] = int32(len(s.ids))
+	s.ids = append(s.ids, id)
+	s.data = append(s.data, vector...)
+}
+
+// Delete removes the vector of id and reports whether it was stored.
+func (s *DenseStore) Delete(id int) bool {
+	slot, ok := s.slots[id]
+	if !ok {
+		return false
+	}
+	last := len(s.ids) - 1
+	if int(slot) != last {
+		moved := s.ids[last]
+		copy(s.Vector(int(slot)), s.Vector(last))
+		s.ids[slot] = moved
+		s.slots[moved] = slot
+	}
+	delete(s.slots, id)
+	s.ids = s.ids[:last]
+	s.data = s.data[:last*s.dim]
+	return true
+}
+
+// IDs returns the stored ids in slot order.
+func (s *DenseStore) IDs() []int {
+	return append([]int(nil), s.ids...)
+}
+
+// Reserve grows the store's capacity for n additional vectors, so that adding them doesn't reallocate.
+func (s *DenseStore) Reserve(n int) {
+	if n <= 0 {
+		return
+	}
+	slots := make(map[int]int32, len(s.slots)+n)
+	for id, slot := range s.slots {
+		slots[id] = slot
+	}
+	s.slots = slots
+	ids := make([]int, len(s.ids), len(s.ids)+n)
+	copy(ids, s.ids)
+	s.ids = ids
+	data := make([]float32, len(s.data), len(s.data)+n*s.dim)
+	copy(data, s.data)
+	s.data = data
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDenseStore(t *testing.T) {
+	s := NewDenseStore(2)
+	ids := []int{1 << 62, -7, 3, 1<<40 + 1}
+	for i, id := range ids {
+		s.Set(id, []float32{float32(i), float32(-i)})
+	}
+	if s.Len() != len(ids) {
+		t.Fatalf("expected %d vectors, got %d", len(ids), s.Len())
+	}
+	// Deleting from the middle moves the last vector into the freed slot.
+	if !s.Delete(-7) {
+		t.Fatal("expected Delete to report a stored id")
+	}
+	if s.Delete(-7) {
+		t.Error("expected a second Delete of the same id to report false")
+	}
+	want := map[int][]float32{1 << 62: {0, 0}, 3: {2, -2}, 1<<40 + 1: {3, -3}}
+	for id, vec := range want {
+		got, ok := s.Get(id)
+		if !ok || !reflect.DeepEqual(got, vec) {
+			t.Errorf("id %d: expected %v, got %v (found %v)", id, vec, got, ok)
+		}
+	}
+	if s.Contains(-7) {
+		t.Error("expected a deleted id not to be contained")
+	}
+	for slot := 0; slot < s.Len(); slot++ {
+		if got, _ := s.Get(s.ID(slot)); !reflect.DeepEqual(got, s.Vector(slot)) {
+			t.Errorf("slot %d: Vector and Get of its id disagree", slot)
+		}
+	}
+	got := s.IDs()
+	sort.Ints(got)
+	if expected := []int{3, 1<<40 + 1, 1 << 62}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected ids %v, got %v", expected, got)
+	}
+
+	// Set copies the vector and replaces an existing one in place.
+	vec := []float32{9, 9}
+	s.Set(3, vec)
+	vec[0] = 0
+	if got, _ := s.Get(3); !reflect.DeepEqual(got, []float32{9, 9}) {
+		t.Errorf("expected the stored copy [9 9], got %v", got)
+	}
+	if s.Len() != 3 {
+		t.Errorf("expected replacing a vector to keep 3 vectors, got %d", s.Len())
+	}
+	// Appending to a returned vector must not overwrite the next slot.
+	first := s.Vector(0)
+	_ = append(first, 42)
+	if s.Vector(1)[0] == 42 {
+		t.Error("expected appending to a returned vector to leave the next slot alone")
+	}
+}
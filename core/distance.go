@@ -19,3 +19,126 @@ func Euclidean(a, b []float32) float64 {
 	}
 	return math.Sqrt(sum)
 }
+
+// SquaredEuclidean computes the squared Euclidean distance between two vectors.
+// It gives the same ordering as Euclidean but skips the square root.
+func SquaredEuclidean(a, b []float32) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += float64(a[i]-b[i]) * float64(a[i]-b[i])
+	}
+	return sum
+}
+
+// Manhattan computes the Manhattan (L1) distance between two vectors.
+func Manhattan(a, b []float32) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += math.Abs(float64(a[i] - b[i]))
+	}
+	return sum
+}
+
+// Cosine computes the cosine distance (1 - cosine similarity) between two vectors.
+// If either vector has zero norm, the distance is 1.
+func Cosine(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB))
+}
+
+// Distances maps the names of the built-in distance metrics to their functions.
+var Distances = map[string]DistanceFunc{
+	"euclidean":         Euclidean,
+	"squared_euclidean": SquaredEuclidean,
+	"manhattan":         Manhattan,
+	"cosine":            Cosine,
+}
+
+// EarlyStopDistanceFunc computes the distance between two vectors like a DistanceFunc, but may stop
+// as soon as the distance is known to exceed threshold. In that case it returns a value larger than
+// threshold that is not the full distance. Otherwise it returns the same value as the full distance.
+type EarlyStopDistanceFunc func(a, b []float32, threshold float64) float64
+
+// SquaredEuclideanEarlyStop computes the squared Euclidean distance, abandoning the sum once it exceeds threshold.
+// The sum is checked every eight dimensions, and the terms are added in the same order as in SquaredEuclidean,
+// so a distance that is not abandoned is exactly the full distance.
+func SquaredEuclideanEarlyStop(a, b []float32, threshold float64) float64 {
+	b = b[:len(a)]
+	sum := 0.0
+	i := 0
+	for ; i+8 <= len(a); i += 8 {
+		x, y := a[i:i+8:i+8], b[i:i+8:i+8]
+		d0 := float64(x[0] - y[0])
+		d1 := float64(x[1] - y[1])
+		d2 := float64(x[2] - y[2])
+		d3 := float64(x[3] - y[3])
+		d4 := float64(x[4] - y[4])
+		d5 := float64(x[5] - y[5])
+		d6 := float64(x[6] - y[6])
+		d7 := float64(x[7] - y[7])
+		sum += d0 * d0
+		sum += d1 * d1
+		sum += d2 * d2
+		sum += d3 * d3
+		sum += d4 * d4
+		sum += d5 * d5
+		sum += d6 * d6
+		sum += d7 * d7
+		if sum > threshold {
+			return sum
+		}
+	}
+	for ; i < len(a); i++ {
+		d := float64(a[i] - b[i])
+		sum += d * d
+	}
+	return sum
+}
+
+// EuclideanEarlyStop computes the Euclidean distance, abandoning the sum once it exceeds threshold.
+func EuclideanEarlyStop(a, b []float32, threshold float64) float64 {
+	sum := SquaredEuclideanEarlyStop(a, b, threshold*threshold)
+	return math.Sqrt(sum)
+}
+
+// ManhattanEarlyStop computes the Manhattan distance, abandoning the sum once it exceeds threshold.
+// Like SquaredEuclideanEarlyStop, it checks the sum every eight dimensions.
+func ManhattanEarlyStop(a, b []float32, threshold float64) float64 {
+	b = b[:len(a)]
+	sum := 0.0
+	i := 0
+	for ; i+8 <= len(a); i += 8 {
+		x, y := a[i:i+8:i+8], b[i:i+8:i+8]
+		sum += math.Abs(float64(x[0] - y[0]))
+		sum += math.Abs(float64(x[1] - y[1]))
+		sum += math.Abs(float64(x[2] - y[2]))
+		sum += math.Abs(float64(x[3] - y[3]))
+		sum += math.Abs(float64(x[4] - y[4]))
+		sum += math.Abs(float64(x[5] - y[5]))
+		sum += math.Abs(float64(x[6] - y[6]))
+		sum += math.Abs(float64(x[7] - y[7]))
+		if sum > threshold {
+			return sum
+		}
+	}
+	for ; i < len(a); i++ {
+		sum += math.Abs(float64(a[i] - b[i]))
+	}
+	return sum
+}
+
+// EarlyStopDistances maps the names of the built-in distance metrics that support early termination
+// to their early-stopping functions. The cosine distance needs the full norms and has no entry.
+var EarlyStopDistances = map[string]EarlyStopDistanceFunc{
+	"euclidean":         EuclideanEarlyStop,
+	"squared_euclidean": SquaredEuclideanEarlyStop,
+	"manhattan":         ManhattanEarlyStop,
+}
@@ -0,0 +1,124 @@
+package core
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDistances(t *testing.T) {
+	a := []float32{1, 0, 0}
+	b := []float32{0, 2, 0}
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{"euclidean", math.Sqrt(5)},
+		{"squared_euclidean", 5},
+		{"manhattan", 3},
+		{"cosine", 1},
+	}
+	for _, tt := range tests {
+		got := Distances[tt.name](a, b)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s distance = %f; want %f", tt.name, got, tt.want)
+		}
+	}
+	if d := Cosine(a, []float32{2, 0, 0}); math.Abs(d) > 1e-9 {
+		t.Errorf("cosine distance of parallel vectors = %f; want 0", d)
+	}
+}
+
+func TestDistanceToSimilarity(t *testing.T) {
+	if s, _ := DistanceToSimilarity("cosine", 0.25); s != 0.75 {
+		t.Errorf("cosine similarity = %f; want 0.75", s)
+	}
+	if s, _ := DistanceToSimilarity("euclidean", 1); s != 0.5 {
+		t.Errorf("euclidean similarity = %f; want 0.5", s)
+	}
+	if _, err := DistanceToSimilarity("unknown", 1); err == nil {
+		t.Error("expected error for unknown metric, got none")
+	}
+}
+
+func TestEarlyStopDistances(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	dim := 100
+	for trial := 0; trial < 50; trial++ {
+		a, b := make([]float32, dim), make([]float32, dim)
+		for i := range a {
+			a[i], b[i] = r.Float32(), r.Float32()
+		}
+		for name, early := range EarlyStopDistances {
+			full := Distances[name](a, b)
+			// Below the threshold the early-stop distance is exactly the full distance.
+			if got := early(a, b, full*1.5); got != full {
+				t.Errorf("%s: early-stop distance %f below threshold; want %f", name, got, full)
+			}
+			if got := early(a, b, full); got != full {
+				t.Errorf("%s: early-stop distance %f at threshold; want %f", name, got, full)
+			}
+			// Above it the result only has to exceed the threshold.
+			if got := early(a, b, full/2); got <= full/2 || got > full {
+				t.Errorf("%s: early-stop distance %f for threshold %f; want a value in (%f, %f]",
+					name, got, full/2, full/2, full)
+			}
+		}
+	}
+}
+
+// TestDistanceTails checks the distance kernels that process eight dimensions per iteration against a
+// scalar float64 reference at dimensions that leave a remainder, so that the tail can't be dropped or
+// counted twice without notice.
+func TestDistanceTails(t *testing.T) {
+	r := rand.New(rand.NewSource(8))
+	reference := map[string]func(a, b []float32) float64{
+		"euclidean": func(a, b []float32) float64 {
+			var sum float64
+			for i := range a {
+				d := float64(a[i]) - float64(b[i])
+				sum += d * d
+			}
+			return math.Sqrt(sum)
+		},
+		"squared_euclidean": func(a, b []float32) float64 {
+			var sum float64
+			for i := range a {
+				d := float64(a[i]) - float64(b[i])
+				sum += d * d
+			}
+			return sum
+		},
+		"manhattan": func(a, b []float32) float64 {
+			var sum float64
+			for i := range a {
+				sum += math.Abs(float64(a[i]) - float64(b[i]))
+			}
+			return sum
+		},
+	}
+	for _, dim := range []int{1, 7, 9, 25, 100, 201} {
+		a, b := make([]float32, dim), make([]float32, dim)
+		for i := range a {
+			a[i], b[i] = r.Float32()*2-1, r.Float32()*2-1
+		}
+		for name, early := range EarlyStopDistances {
+			want := reference[name](a, b)
+			for _, got := range []float64{Distances[name](a, b), early(a, b, math.Inf(1))} {
+				if math.Abs(got-want) > 1e-6*math.Max(1, want) {
+					t.Errorf("%s at dimension %d: got %v, want %v", name, dim, got, want)
+				}
+			}
+		}
+
+		vec := append([]float32(nil), a...)
+		NormalizeVector(vec)
+		var norm float64
+		for _, v := range vec {
+			norm += float64(v) * float64(v)
+		}
+		if math.Abs(math.Sqrt(norm)-1) > 1e-6 {
+			t.Errorf("normalized vector of dimension %d has norm %v", dim, math.Sqrt(norm))
+		}
+	}
+}
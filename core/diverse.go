@@ -0,0 +1,83 @@
+package core
+
+import (
+	"fmt"
+	"math"
+)
+
+// DiverseCandidateFactor is the number of candidates per requested result that SearchDiverse fetches
+// from the index before re-ranking them.
+var DiverseCandidateFactor = 4
+
+// SearchDiverse returns k neighbors of the query chosen by Maximal Marginal Relevance (MMR).
+// It fetches k*DiverseCandidateFactor candidates with a normal search and then greedily selects the
+// candidate with the highest
+//
+//	lambda * -distance(query, c) + (1 - lambda) * min distance(c, s) over already selected s,
+//
+// so lambda = 1 gives plain nearest-neighbor order and smaller values favor results that differ from
+// each other. Distances between candidates use the metric named by the index's Stats and its stored
+// vectors. The returned distances are the distances to the query.
+func SearchDiverse(index Index, query []float32, k int, lambda float64) ([]Neighbor, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("%w: k must be positive, got %d", ErrInvalidK, k)
+	}
+	if lambda < 0 || lambda > 1 {
+		return nil, fmt.Errorf("lambda must be in [0, 1], got %v", lambda)
+	}
+	metric := index.Stats().Distance
+	distance, ok := Distances[metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown distance %q", metric)
+	}
+	factor := DiverseCandidateFactor
+	if factor < 1 {
+		factor = 1
+	}
+	candidates, err := index.Search(query, k*factor)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(candidates))
+	for i, c := range candidates {
+		if vectors[i], err = index.GetVector(c.ID); err != nil {
+			return nil, fmt.Errorf("failed to get vector for id %d: %w", c.ID, err)
+		}
+	}
+
+	if k > len(candidates) {
+		k = len(candidates)
+	}
+	selected := make([]Neighbor, 0, k)
+	// minDist[i] is the distance from candidate i to the closest selected result.
+	minDist := make([]float64, len(candidates))
+	for i := range minDist {
+		minDist[i] = math.Inf(1)
+	}
+	used := make([]bool, len(candidates))
+	for len(selected) < k {
+		best := -1
+		bestScore := math.Inf(-1)
+		for i, c := range candidates {
+			if used[i] {
+				continue
+			}
+			score := -lambda * c.Distance
+			if len(selected) > 0 {
+				score += (1 - lambda) * minDist[i]
+			}
+			// Candidates are in ascending distance order, so ties keep the closer one.
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		used[best] = true
+		selected = append(selected, candidates[best])
+		for i := range candidates {
+			if !used[i] {
+				minDist[i] = math.Min(minDist[i], distance(vectors[i], vectors[best]))
+			}
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,159 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DocumentCandidateFactor is the number of chunk vectors searched per requested document by
+// SearchDocuments before the search is widened.
+var DocumentCandidateFactor = 4
+
+// DocumentIndex wraps an index to store documents made of several vectors (for example, one embedding
+// per chunk) under one document id. Each vector gets an internal id in the wrapped index, which is mapped
+// back to its document when searching. The mapping is kept in memory only, so the wrapped index should
+// only be modified through the DocumentIndex.
+type DocumentIndex struct {
+	Index
+
+	mu       sync.RWMutex
+	nextID   int           // next internal vector id
+	docOf    map[int]int   // internal vector id to document id
+	chunksOf map[int][]int // document id to its internal vector ids
+}
+
+// NewDocumentIndex wraps an empty index for multi-vector documents.
+func NewDocumentIndex(index Index) (*DocumentIndex, error) {
+	if n := index.Stats().Count; n > 0 {
+		return nil, fmt.Errorf("document index requires an empty index, it holds %d vectors", n)
+	}
+	return &DocumentIndex{
+		Index:    index,
+		docOf:    make(map[int]int),
+		chunksOf: make(map[int][]int),
+	}, nil
+}
+
+// AddDocument stores the vectors of a document under docID. Either all of its vectors are stored or,
+// if adding them fails, none are.
+func (d *DocumentIndex) AddDocument(docID int, vectors [][]float32) error {
+	if len(vectors) == 0 {
+		return fmt.Errorf("document %d has no vectors", docID)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.chunksOf[docID]; exists {
+		return fmt.Errorf("document %d: %w", docID, ErrDuplicateID)
+	}
+	dimension := d.Index.Stats().Dimension
+	for i, vec := range vectors {
+		if len(vec) != dimension {
+			return fmt.Errorf("document %d, vector %d: %w: dimension %d does not match index dimension %d",
+				docID, i, ErrDimensionMismatch, len(vec), dimension)
+		}
+	}
+	batch := make(map[int][]float32, len(vectors))
+	ids := make([]int, len(vectors))
+	for i, vec := range vectors {
+		ids[i] = d.nextID + i
+		batch[ids[i]] = vec
+	}
+	// The ids are used up even if the insert fails, so they never collide with a later document.
+	d.nextID += len(vectors)
+	if err := d.Index.BulkAdd(batch); err != nil {
+		// Not every index rolls back a failed bulk insert, so remove the vectors it may have stored.
+		if delErr := d.Index.BulkDelete(ids); delErr != nil {
+			log.Warn().Err(delErr).Msgf("Failed to remove the vectors of document %d after a failed insert", docID)
+		}
+		return fmt.Errorf("document %d: %w", docID, err)
+	}
+	for _, id := range ids {
+		d.docOf[id] = docID
+	}
+	d.chunksOf[docID] = ids
+	return nil
+}
+
+// DeleteDocument removes all vectors of a document.
+func (d *DocumentIndex) DeleteDocument(docID int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ids, exists := d.chunksOf[docID]
+	if !exists {
+		return fmt.Errorf("document %d: %w", docID, ErrNotFound)
+	}
+	if err := d.Index.BulkDelete(ids); err != nil {
+		return fmt.Errorf("document %d: %w", docID, err)
+	}
+	for _, id := range ids {
+		delete(d.docOf, id)
+	}
+	delete(d.chunksOf, docID)
+	return nil
+}
+
+// DocumentCount returns the number of documents in the index.
+func (d *DocumentIndex) DocumentCount() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.chunksOf)
+}
+
+// SearchDocuments returns up to k documents nearest to the query, each once, with the distance of its
+// nearest vector, sorted by that distance. It searches DocumentCandidateFactor*k vectors and widens
+// the search while it finds fewer than k distinct documents and more vectors remain.
+func (d *DocumentIndex) SearchDocuments(query []float32, k int) ([]Neighbor, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("%w: k must be positive, got %d", ErrInvalidK, k)
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	total := len(d.docOf)
+	if total == 0 {
+		return nil, ErrEmptyIndex
+	}
+	factor := DocumentCandidateFactor
+	if factor < 1 {
+		factor = 1
+	}
+	fetch := factor * k
+	for {
+		if fetch > total {
+			fetch = total
+		}
+		chunks, err := d.Index.Search(query, fetch)
+		if err != nil {
+			return nil, err
+		}
+		best := make(map[int]float64, len(chunks))
+		for _, c := range chunks {
+			doc, ok := d.docOf[c.ID]
+			if !ok {
+				continue
+			}
+			if dist, seen := best[doc]; !seen || c.Distance < dist {
+				best[doc] = c.Distance
+			}
+		}
+		if len(best) >= k || fetch == total {
+			results := make([]Neighbor, 0, len(best))
+			for doc, dist := range best {
+				results = append(results, Neighbor{ID: doc, Distance: dist})
+			}
+			sort.Slice(results, func(i, j int) bool {
+				if results[i].Distance == results[j].Distance {
+					return results[i].ID < results[j].ID
+				}
+				return results[i].Distance < results[j].Distance
+			})
+			if len(results) > k {
+				results = results[:k]
+			}
+			return results, nil
+		}
+		fetch *= 2
+	}
+}
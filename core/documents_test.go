@@ -0,0 +1,103 @@
+package core_test
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+	"github.com/patrikhermansson/hann/rpt"
+)
+
+func TestDocumentIndex_SearchDocuments(t *testing.T) {
+	dim := 4
+	docs, err := core.NewDocumentIndex(hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean"))
+	if err != nil {
+		t.Fatalf("NewDocumentIndex failed: %v", err)
+	}
+	rng := rand.New(rand.NewSource(6))
+	chunks := make(map[int][][]float32)
+	for doc := 10; doc < 20; doc++ {
+		for c := 0; c < 3+doc%3; c++ {
+			chunks[doc] = append(chunks[doc], []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()})
+		}
+		if err := docs.AddDocument(doc, chunks[doc]); err != nil {
+			t.Fatalf("AddDocument failed: %v", err)
+		}
+	}
+	if err := docs.AddDocument(10, chunks[10]); !errors.Is(err, core.ErrDuplicateID) {
+		t.Errorf("expected ErrDuplicateID for an existing document, got %v", err)
+	}
+
+	query := []float32{0.5, 0.5, 0.5, 0.5}
+	results, err := docs.SearchDocuments(query, 10)
+	if err != nil {
+		t.Fatalf("SearchDocuments failed: %v", err)
+	}
+	if len(results) != 10 {
+		t.Fatalf("expected all 10 documents, got %d", len(results))
+	}
+	seen := make(map[int]bool)
+	for i, r := range results {
+		if seen[r.ID] {
+			t.Fatalf("document %d appears more than once", r.ID)
+		}
+		seen[r.ID] = true
+		best := math.Inf(1)
+		for _, vec := range chunks[r.ID] {
+			best = math.Min(best, core.Euclidean(query, vec))
+		}
+		if math.Abs(r.Distance-best) > 1e-9 {
+			t.Errorf("document %d: distance %f; want its best chunk distance %f", r.ID, r.Distance, best)
+		}
+		if i > 0 && r.Distance < results[i-1].Distance {
+			t.Errorf("results are not sorted by best chunk distance: %v", results)
+		}
+	}
+
+	if err := docs.DeleteDocument(results[0].ID); err != nil {
+		t.Fatalf("DeleteDocument failed: %v", err)
+	}
+	top, err := docs.SearchDocuments(query, 1)
+	if err != nil {
+		t.Fatalf("SearchDocuments failed: %v", err)
+	}
+	if len(top) != 1 || top[0].ID != results[1].ID {
+		t.Errorf("expected document %d after deleting the nearest one, got %v", results[1].ID, top)
+	}
+	if docs.DocumentCount() != 9 {
+		t.Errorf("expected 9 documents, got %d", docs.DocumentCount())
+	}
+}
+
+func TestDocumentIndex_AddDocumentRejectsWholeDocument(t *testing.T) {
+	// RPT stores the vectors of a bulk insert up to the first invalid one, so the document index must
+	// validate the document before inserting it.
+	docs, err := core.NewDocumentIndex(rpt.NewRPTIndex(2, 10, 3, 100, 1e9))
+	if err != nil {
+		t.Fatalf("NewDocumentIndex failed: %v", err)
+	}
+	invalid := [][]float32{{0, 0}, {1, 1}, {1, 2, 3}, {2, 2}}
+	if err := docs.AddDocument(1, invalid); !errors.Is(err, core.ErrDimensionMismatch) {
+		t.Fatalf("expected ErrDimensionMismatch, got %v", err)
+	}
+	if n := docs.Stats().Count; n != 0 {
+		t.Errorf("expected no vectors after the rejected document, got %d", n)
+	}
+	if n := docs.DocumentCount(); n != 0 {
+		t.Errorf("expected no documents after the rejected document, got %d", n)
+	}
+
+	if err := docs.AddDocument(2, [][]float32{{0, 0}, {1, 1}}); err != nil {
+		t.Fatalf("AddDocument failed after a rejected document: %v", err)
+	}
+	results, err := docs.SearchDocuments([]float32{1, 1}, 1)
+	if err != nil {
+		t.Fatalf("SearchDocuments failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != 2 || results[0].Distance != 0 {
+		t.Errorf("expected document 2 at distance 0, got %+v", results)
+	}
+}
@@ -0,0 +1,25 @@
+package core
+
+import "errors"
+
+// Sentinel errors returned (wrapped) by the indexes for common failures.
+// Use errors.Is to check for them, since the returned errors carry additional context.
+var (
+	// ErrNotFound is returned when an operation refers to an id that is not in the index.
+	ErrNotFound = errors.New("not found")
+
+	// ErrDuplicateID is returned when adding an id that is already in the index.
+	ErrDuplicateID = errors.New("already exists")
+
+	// ErrDimensionMismatch is returned when a vector or query does not have the index dimension.
+	ErrDimensionMismatch = errors.New("dimension mismatch")
+
+	// ErrEmptyIndex is returned when searching an index that holds no vectors.
+	ErrEmptyIndex = errors.New("index is empty")
+
+	// ErrInvalidK is returned when the number of requested neighbors is not positive.
+	ErrInvalidK = errors.New("invalid k")
+
+	// ErrFrozen is returned when modifying an index that has been frozen with Freeze.
+	ErrFrozen = errors.New("index is frozen")
+)
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EstimateBuildTime estimates how long adding target vectors to an index will take, for sizing
+// infrastructure before a full build. It creates an empty index with newIndex, adds sampleVectors to it
+// with BulkAdd, runs one search so that structures built lazily on the first search (such as the RPT
+// tree) are included, and scales the measured time per vector linearly to target vectors.
+// The result is a rough estimate: the time per vector of graph and tree indexes grows slowly with their
+// size, so a sample much smaller than the target underestimates, and the timing of a small sample is
+// sensitive to machine load. Samples of at least a few thousand vectors give more useful estimates.
+func EstimateBuildTime(newIndex func() Index, sampleVectors map[int][]float32, target int) (time.Duration, error) {
+	if len(sampleVectors) == 0 {
+		return 0, errors.New("no sample vectors to build")
+	}
+	if target <= 0 {
+		return 0, fmt.Errorf("target count must be positive, got %d", target)
+	}
+	var query []float32
+	for _, vec := range sampleVectors {
+		query = vec
+		break
+	}
+	index := newIndex()
+	defer index.Close()
+
+	start := time.Now()
+	if err := index.BulkAdd(sampleVectors); err != nil {
+		return 0, fmt.Errorf("build sample: %w", err)
+	}
+	if _, err := index.Search(query, 1); err != nil {
+		return 0, fmt.Errorf("search sample: %w", err)
+	}
+	perVector := time.Since(start) / time.Duration(len(sampleVectors))
+	return perVector * time.Duration(target), nil
+}
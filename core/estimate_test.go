@@ -0,0 +1,53 @@
+package core_test
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+func TestEstimateBuildTime(t *testing.T) {
+	rng := rand.New(rand.NewSource(12))
+	vectors := make(map[int][]float32, 2000)
+	for i := 0; i < 2000; i++ {
+		vec := make([]float32, 16)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	sample := make(map[int][]float32, 500)
+	for id := 0; id < 500; id++ {
+		sample[id] = vectors[id]
+	}
+	newIndex := func() core.Index { return hnsw.NewHNSW(16, 8, 50, core.Euclidean, "euclidean") }
+
+	estimate, err := core.EstimateBuildTime(newIndex, sample, len(vectors))
+	if err != nil {
+		t.Fatalf("EstimateBuildTime failed: %v", err)
+	}
+	start := time.Now()
+	if err := newIndex().BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	actual := time.Since(start)
+	// Timings of small builds are noisy, so the estimate only needs to be in the right order of magnitude.
+	if estimate < actual/10 || estimate > actual*10 {
+		t.Errorf("expected the estimate %v to be within a factor of 10 of the actual build time %v", estimate, actual)
+	}
+
+	if _, err := core.EstimateBuildTime(newIndex, nil, 100); err == nil {
+		t.Error("expected an error without sample vectors")
+	}
+	if _, err := core.EstimateBuildTime(newIndex, sample, 0); err == nil {
+		t.Error("expected an error for a non-positive target")
+	}
+	wrongDimension := map[int][]float32{1: {1, 2}}
+	if _, err := core.EstimateBuildTime(newIndex, wrongDimension, 100); !errors.Is(err, core.ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch from the sample build, got %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"sort"
+	"sync"
+)
+
+// ExactSearch returns the k nearest of n vectors to the query found by a scan over all of them, sorted by
+// ascending distance and equal distances by ascending id. vector returns the id and vector at position i,
+// for 0 <= i < n. The distances are computed by Workers(workers, n) goroutines. Indexes use it for small
+// datasets, where a scan is both faster and more accurate than their approximate structures.
+func ExactSearch(query []float32, k, n int, vector func(i int) (int, []float32), distance DistanceFunc,
+	workers int) []Neighbor {
+	neighbors := make([]Neighbor, n)
+	numWorkers := Workers(workers, n)
+	chunkSize := (n + numWorkers - 1) / numWorkers
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += chunkSize {
+		end := min(start+chunkSize, n)
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				id, vec := vector(i)
+				neighbors[i] = Neighbor{ID: id, Distance: distance(query, vec)}
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	sort.Slice(neighbors, func(i, j int) bool {
+		if neighbors[i].Distance == neighbors[j].Distance {
+			return neighbors[i].ID < neighbors[j].ID
+		}
+		return neighbors[i].Distance < neighbors[j].Distance
+	})
+	if k < len(neighbors) {
+		neighbors = neighbors[:k]
+	}
+	return neighbors
+}
@@ -0,0 +1,40 @@
+package core
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestExactSearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	ids := make([]int, 300)
+	vectors := make([][]float32, len(ids))
+	for i := range ids {
+		ids[i] = 1000 - 3*i
+		vectors[i] = []float32{float32(rng.Intn(10)), float32(rng.Intn(10))}
+	}
+	query := []float32{4, 4}
+	want := make([]Neighbor, len(ids))
+	for i := range ids {
+		want[i] = Neighbor{ID: ids[i], Distance: Euclidean(query, vectors[i])}
+	}
+	sort.Slice(want, func(i, j int) bool {
+		if want[i].Distance == want[j].Distance {
+			return want[i].ID < want[j].ID
+		}
+		return want[i].Distance < want[j].Distance
+	})
+	vector := func(i int) (int, []float32) { return ids[i], vectors[i] }
+
+	for _, workers := range []int{1, 4, 7} {
+		got := ExactSearch(query, 20, len(ids), vector, Euclidean, workers)
+		if !reflect.DeepEqual(got, want[:20]) {
+			t.Errorf("workers %d: expected %v, got %v", workers, want[:20], got)
+		}
+	}
+	if got := ExactSearch(query, 500, len(ids), vector, Euclidean, 0); len(got) != len(ids) {
+		t.Errorf("expected all %d vectors for k above their number, got %d", len(ids), len(got))
+	}
+}
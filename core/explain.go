@@ -0,0 +1,18 @@
+package core
+
+// SearchExplanation describes the path a graph search took to its results, for teaching and debugging.
+type SearchExplanation struct {
+	EntryPoint int          // id of the node the search started from
+	Path       []LevelVisit // nodes visited on each level, from the top level down to level 0
+	Fallback   bool         // whether a brute-force scan supplied part of the results
+	Exact      bool         // whether the index was small enough to be scanned instead of searched, leaving Path empty
+	Results    []Neighbor   // the returned neighbors, sorted by distance
+}
+
+// LevelVisit lists the nodes a search visited on one level of the graph, with their distances to the
+// query, in the order they were visited. On the upper levels these are the nodes of the greedy descent;
+// on level 0 they are all nodes whose distance was computed by the beam search.
+type LevelVisit struct {
+	Level   int        // graph level
+	Visited []Neighbor // visited nodes in visiting order
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+// QueryMeasurer is implemented by indexes that can report how they compare a query with their stored
+// vectors, so that helpers scanning the stored vectors compute the same distances as a search.
+type QueryMeasurer interface {
+	// MeasureQuery returns the query prepared the way the index prepares its stored vectors (for
+	// example, normalized), and the distance function the index compares them with.
+	MeasureQuery(query []float32) ([]float32, DistanceFunc)
+}
+
+// SearchFarthest returns the k vectors with the largest distance to the query, farthest first, for
+// example to sample negatives for contrastive training. Graph and cluster searches only find near
+// vectors, so it scans every stored vector with ForEach, which makes it as expensive as a brute force
+// search. If the index implements QueryMeasurer, the distances are the ones its searches compute;
+// otherwise the metric named by the index's Stats is applied to the query as given.
+func SearchFarthest(index Index, query []float32, k int) ([]Neighbor, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("%w: k must be positive, got %d", ErrInvalidK, k)
+	}
+	stats := index.Stats()
+	if len(query) != stats.Dimension {
+		return nil, fmt.Errorf("%w: query dimension %d does not match index dimension %d",
+			ErrDimensionMismatch, len(query), stats.Dimension)
+	}
+	var distance DistanceFunc
+	if m, ok := index.(QueryMeasurer); ok {
+		query, distance = m.MeasureQuery(query)
+	} else {
+		var known bool
+		if distance, known = Distances[stats.Distance]; !known {
+			return nil, fmt.Errorf("unknown distance %q", stats.Distance)
+		}
+	}
+	results := make([]Neighbor, 0, stats.Count)
+	err := index.ForEach(func(id int, vec []float32) error {
+		results = append(results, Neighbor{ID: id, Distance: distance(query, vec)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrEmptyIndex
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance == results[j].Distance {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].Distance > results[j].Distance
+	})
+	if k > len(results) {
+		k = len(results)
+	}
+	return results[:k], nil
+}
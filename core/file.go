@@ -0,0 +1,79 @@
+package core
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// newFileMode is the permission of a file created by SaveFile, as os.Create gives with the usual umask.
+const newFileMode os.FileMode = 0o644
+
+// SaveFile saves the index to the file at path. The index is written to a temporary file in the same
+// directory, synced to disk, and then renamed over path, so readers never see a partially written file
+// and an existing file is only replaced after a successful write. The directory is synced after the
+// rename so that the replacement survives a crash. A replaced file keeps its permissions, and a new file
+// is created with mode 0644.
+func SaveFile(index Index, path string) (err error) {
+	mode := newFileMode
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	// Remove the temporary file if anything goes wrong before the rename.
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	// CreateTemp creates the file with mode 0600, which would otherwise replace the permissions of path.
+	if err = tmp.Chmod(mode); err != nil {
+		return fmt.Errorf("failed to set index file permissions: %w", err)
+	}
+	if err = index.Save(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync index file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close index file: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace index file: %w", err)
+	}
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("failed to sync index directory: %w", err)
+	}
+	return nil
+}
+
+// syncDir flushes the entries of a directory, such as a rename, to disk. Directories can't be synced
+// on Windows, so it does nothing there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// LoadFile loads the index from the file at path.
+func LoadFile(index Index, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return index.Load(f)
+}
@@ -0,0 +1,104 @@
+package core_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+// failingSaveIndex writes part of the index and then fails, simulating an interrupted save.
+type failingSaveIndex struct {
+	*hnsw.HNSWIndex
+}
+
+func (f failingSaveIndex) Save(w io.Writer) error {
+	if _, err := w.Write([]byte("partial")); err != nil {
+		return err
+	}
+	return errors.New("simulated write failure")
+}
+
+func TestSaveFile(t *testing.T) {
+	index := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	if err := index.BulkAdd(map[int][]float32{1: {0, 0}, 2: {1, 1}, 3: {2, 2}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "index.gob")
+	if err := core.SaveFile(index, path); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read saved file: %v", err)
+	}
+
+	loaded := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	if err := core.LoadFile(loaded, path); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if loaded.Stats().Count != 3 {
+		t.Errorf("expected 3 vectors after load, got %d", loaded.Stats().Count)
+	}
+
+	// A failed save leaves the existing file untouched and no temporary files behind.
+	if err := core.SaveFile(failingSaveIndex{index}, path); err == nil {
+		t.Fatal("expected SaveFile to fail, got no error")
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file after failed save: %v", err)
+	}
+	if !bytes.Equal(saved, after) {
+		t.Error("expected the existing file to be unchanged after a failed save")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the index file in the directory, got %d entries", len(entries))
+	}
+}
+
+func TestSaveFile_Permissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not supported on Windows")
+	}
+	index := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	if err := index.Add(1, []float32{0, 0}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "index.gob")
+	if err := core.SaveFile(index, path); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o644 {
+		t.Errorf("expected a new file to have mode 0644, got %o", mode)
+	}
+
+	// Replacing an existing file keeps its permissions.
+	if err := os.Chmod(path, 0o640); err != nil {
+		t.Fatalf("Chmod failed: %v", err)
+	}
+	if err := core.SaveFile(index, path); err != nil {
+		t.Fatalf("SaveFile failed: %v", err)
+	}
+	if info, err = os.Stat(path); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o640 {
+		t.Errorf("expected the replaced file to keep mode 0640, got %o", mode)
+	}
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// formatMagic starts the header that Save writes before the gob stream of an index. It is followed by
+// one byte holding FormatVersion and one byte holding the index type.
+const formatMagic = "HANN"
+
+// formatHeaderSize is the size of the format header: the magic, the version byte, and the type byte.
+const formatHeaderSize = len(formatMagic) + 2
+
+// FormatVersion is the version of the format header written by Save.
+const FormatVersion byte = 1
+
+// Index types recorded in the format header.
+const (
+	FormatHNSW  byte = 1
+	FormatPQIVF byte = 2
+	FormatRPT   byte = 3
+)
+
+// IndexLoader creates an empty index that Load can fill in.
+type IndexLoader func() Index
+
+type format struct {
+	kind   string
+	loader IndexLoader
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   = make(map[byte]format)
+)
+
+// RegisterFormat makes an index type available to LoadIndex under the type byte of its format header.
+// Index packages call it from their init functions next to RegisterIndex, so a package must be imported
+// (possibly with a blank import) before LoadIndex can load its indexes.
+// It panics if the type byte is already registered.
+func RegisterFormat(typ byte, kind string, loader IndexLoader) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if f, exists := formats[typ]; exists {
+		panic(fmt.Sprintf("index format %d is already registered for %q", typ, f.kind))
+	}
+	formats[typ] = format{kind: kind, loader: loader}
+}
+
+// formatKind returns the registered kind of a type byte for error messages.
+func formatKind(typ byte) string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	if f, ok := formats[typ]; ok {
+		return f.kind
+	}
+	return fmt.Sprintf("unknown type %d", typ)
+}
+
+// WriteHeader writes the format header for an index of type typ to w. Save calls it before encoding the index.
+func WriteHeader(w io.Writer, typ byte) error {
+	header := append([]byte(formatMagic), FormatVersion, typ)
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write index header: %w", err)
+	}
+	return nil
+}
+
+// ReadHeader reads the format header from r and checks that it holds an index of type typ. It returns the
+// reader to decode the index from. A stream without the header, written before headers were added, is
+// passed on unchanged so that older files can still be loaded.
+func ReadHeader(r io.Reader, typ byte) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(formatHeaderSize)
+	if err != nil || string(header[:len(formatMagic)]) != formatMagic {
+		return br, nil
+	}
+	if version := header[len(formatMagic)]; version > FormatVersion {
+		return nil, fmt.Errorf("unsupported index format version %d, newest supported is %d", version, FormatVersion)
+	}
+	if saved := header[len(formatMagic)+1]; saved != typ {
+		return nil, fmt.Errorf("stream holds a %s index, not a %s index", formatKind(saved), formatKind(typ))
+	}
+	if _, err := br.Discard(formatHeaderSize); err != nil {
+		return nil, err
+	}
+	return br, nil
+}
+
+// LoadIndex reads an index saved by Save from r without knowing its type in advance. It reads the format
+// header, creates an empty index of the recorded type, and loads the stream into it. The package of the
+// index type must be imported so that its format is registered. Streams without a header can't be loaded
+// this way; load them with the Load method of the right type instead.
+func LoadIndex(r io.Reader) (Index, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(formatHeaderSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index header: %w", err)
+	}
+	if string(header[:len(formatMagic)]) != formatMagic {
+		return nil, errors.New("stream has no index header")
+	}
+	typ := header[len(formatMagic)+1]
+	formatsMu.RLock()
+	f, ok := formats[typ]
+	formatsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown index type %d in header; is its package imported?", typ)
+	}
+	index := f.loader()
+	if err := index.Load(br); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
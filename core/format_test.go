@@ -0,0 +1,72 @@
+package core_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+	"github.com/patrikhermansson/hann/pqivf"
+	"github.com/patrikhermansson/hann/rpt"
+)
+
+func TestLoadIndex(t *testing.T) {
+	indexes := map[string]core.Index{
+		"hnsw":  hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean"),
+		"pqivf": pqivf.NewPQIVFIndex(2, 2, 1, 4, 10),
+		"rpt":   rpt.NewRPTIndex(2, 2, 3, 100, 0.2),
+	}
+	vectors := map[int][]float32{1: {0, 0}, 2: {3, 0}, 3: {0, 4}, 4: {5, 5}}
+	query := []float32{1, 0}
+	for name, idx := range indexes {
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("%s: BulkAdd failed: %v", name, err)
+		}
+		want, err := idx.Search(query, 3)
+		if err != nil {
+			t.Fatalf("%s: Search failed: %v", name, err)
+		}
+		var buf bytes.Buffer
+		if err := idx.Save(&buf); err != nil {
+			t.Fatalf("%s: Save failed: %v", name, err)
+		}
+		loaded, err := core.LoadIndex(&buf)
+		if err != nil {
+			t.Fatalf("%s: LoadIndex failed: %v", name, err)
+		}
+		if got, want := reflect.TypeOf(loaded), reflect.TypeOf(idx); got != want {
+			t.Errorf("%s: expected a %v, got a %v", name, want, got)
+		}
+		if stats := loaded.Stats(); stats.Count != len(vectors) || stats.Dimension != 2 {
+			t.Errorf("%s: expected 4 vectors of dimension 2, got %+v", name, stats)
+		}
+		results, err := loaded.Search(query, 3)
+		if err != nil {
+			t.Fatalf("%s: Search after LoadIndex failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(results, want) {
+			t.Errorf("%s: expected %v after LoadIndex, got %v", name, want, results)
+		}
+	}
+
+	// Load still accepts streams without the header, and rejects a header of another index type.
+	var legacy bytes.Buffer
+	if err := gob.NewEncoder(&legacy).Encode(indexes["rpt"]); err != nil {
+		t.Fatalf("gob encoding failed: %v", err)
+	}
+	if err := rpt.NewRPTIndex(2, 2, 3, 100, 0.2).Load(&legacy); err != nil {
+		t.Errorf("expected a stream without a header to load, got %v", err)
+	}
+	var buf bytes.Buffer
+	if err := indexes["pqivf"].Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean").Load(&buf); err == nil {
+		t.Error("expected loading a PQIVF stream into an HNSW index to fail")
+	}
+	if _, err := core.LoadIndex(bytes.NewReader([]byte("not an index"))); err == nil {
+		t.Error("expected LoadIndex to reject a stream without a header")
+	}
+}
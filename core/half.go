@@ -0,0 +1,93 @@
+package core
+
+import "math"
+
+// Float32ToFloat16 converts a float32 to IEEE 754 half precision, rounding to nearest even.
+// Values too large for half precision become infinities; values too small become zero.
+func Float32ToFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16((bits >> 16) & 0x8000)
+	exp := int((bits >> 23) & 0xff)
+	mant := bits & 0x7fffff
+
+	// Infinity or NaN.
+	if exp == 0xff {
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	}
+	e := exp - 127 + 15
+	// Overflow to infinity.
+	if e >= 0x1f {
+		return sign | 0x7c00
+	}
+	// Subnormal half or zero.
+	if e <= 0 {
+		if e < -10 {
+			return sign
+		}
+		mant |= 0x800000
+		shift := uint(14 - e)
+		half := mant >> shift
+		rem := mant & (1<<shift - 1)
+		halfway := uint32(1) << (shift - 1)
+		if rem > halfway || (rem == halfway && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+	half := uint32(e)<<10 | mant>>13
+	rem := mant & 0x1fff
+	// A carry out of the mantissa correctly increments the exponent.
+	if rem > 0x1000 || (rem == 0x1000 && half&1 == 1) {
+		half++
+	}
+	return sign | uint16(half)
+}
+
+// Float16ToFloat32 converts an IEEE 754 half-precision value to float32. The conversion is exact.
+func Float16ToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+	switch {
+	case exp == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case exp == 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// Normalize the subnormal value.
+		e := uint32(127 - 15 + 1)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		mant &= 0x3ff
+		return math.Float32frombits(sign | e<<23 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+// EncodeFloat16 converts a float32 vector to half precision.
+func EncodeFloat16(vec []float32) []uint16 {
+	out := make([]uint16, len(vec))
+	for i, v := range vec {
+		out[i] = Float32ToFloat16(v)
+	}
+	return out
+}
+
+// DecodeFloat16 converts a half-precision vector to float32, writing into dst if it has enough
+// capacity. It returns the decoded vector.
+func DecodeFloat16(dst []float32, vec []uint16) []float32 {
+	if cap(dst) < len(vec) {
+		dst = make([]float32, len(vec))
+	}
+	dst = dst[:len(vec)]
+	for i, v := range vec {
+		dst[i] = Float16ToFloat32(v)
+	}
+	return dst
+}
@@ -0,0 +1,44 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFloat16RoundTrip(t *testing.T) {
+	exact := []float32{0, 1, -1, 0.5, 2, 1024, 65504, -65504, 6.103515625e-05, 5.960464477539063e-08}
+	for _, v := range exact {
+		if got := Float16ToFloat32(Float32ToFloat16(v)); got != v {
+			t.Errorf("round trip of %g = %g", v, got)
+		}
+	}
+
+	// Values that aren't exactly representable are within half-precision relative error.
+	for _, v := range []float32{0.1, 3.14159, -123.456, 0.001} {
+		got := Float16ToFloat32(Float32ToFloat16(v))
+		if rel := math.Abs(float64(got-v)) / math.Abs(float64(v)); rel > 1e-3 {
+			t.Errorf("round trip of %g = %g (relative error %g)", v, got, rel)
+		}
+	}
+
+	if got := Float16ToFloat32(Float32ToFloat16(1e6)); !math.IsInf(float64(got), 1) {
+		t.Errorf("expected overflow to +Inf, got %g", got)
+	}
+	if got := Float16ToFloat32(Float32ToFloat16(1e-10)); got != 0 {
+		t.Errorf("expected underflow to 0, got %g", got)
+	}
+	nan := Float16ToFloat32(Float32ToFloat16(float32(math.NaN())))
+	if !math.IsNaN(float64(nan)) {
+		t.Errorf("expected NaN, got %g", nan)
+	}
+}
+
+func TestEncodeDecodeFloat16(t *testing.T) {
+	vec := []float32{1, -2, 0.25, 8}
+	decoded := DecodeFloat16(nil, EncodeFloat16(vec))
+	for i := range vec {
+		if decoded[i] != vec[i] {
+			t.Errorf("element %d: got %g, want %g", i, decoded[i], vec[i])
+		}
+	}
+}
@@ -38,12 +38,55 @@ type Index interface {
 	// Returns an error if the operation fails.
 	BulkUpdate(updates map[int][]float32) error
 
-	// Search returns the ids and distances of the k nearest neighbors for a query vector.
+	// Search returns the ids and distances of the k nearest neighbors for a query vector, sorted by
+	// ascending distance and equal distances by ascending id, unless an index option such as HNSW's
+	// RandomTieBreak orders ties differently.
+	// If k exceeds the number of vectors in the index, all vectors are returned without an error, so
+	// the result holds exactly min(k, Stats().Count) neighbors, unless an index option such as HNSW's
+	// DisableFallback allows fewer.
 	// query: the vector to search for.
 	// k: the number of nearest neighbors to return.
 	// Returns a slice of Neighbor structs and an error if the operation fails.
 	Search(query []float32, k int) ([]Neighbor, error)
 
+	// SearchWith is like Search but applies per-call options, such as a filter on the returned ids.
+	// Each index honors the options that apply to it and ignores the rest.
+	// query: the vector to search for.
+	// k: the number of nearest neighbors to return.
+	// opts: the options for this search.
+	// Returns a slice of Neighbor structs and an error if the operation fails.
+	SearchWith(query []float32, k int, opts SearchOptions) ([]Neighbor, error)
+
+	// KthDistance returns the distance to the k-th nearest neighbor of a query vector, computed with
+	// the same search as Search but without returning the neighbors.
+	// query: the vector to search for.
+	// k: the rank of the neighbor whose distance is returned.
+	// Returns the distance and an error if the search fails or finds fewer than k neighbors.
+	KthDistance(query []float32, k int) (float64, error)
+
+	// Export returns copies of all stored vectors keyed by their ids.
+	// The result can be passed to BulkAdd to rebuild the data in another index.
+	// Returns the exported vectors and an error if the operation fails.
+	Export() (map[int][]float32, error)
+
+	// ForEach calls fn for every stored vector, in no particular order, without copying all vectors first.
+	// It holds the index's read lock while iterating, so fn must not modify the index, which would
+	// deadlock; other readers may still search concurrently. The vector passed to fn must not be modified
+	// or retained after fn returns.
+	// fn: the function called with each id and its stored vector.
+	// Returns the first error returned by fn, which stops the iteration.
+	ForEach(fn func(id int, vec []float32) error) error
+
+	// GetVector returns a copy of the vector stored for an id.
+	// id: the identifier of the vector.
+	// Returns the stored vector and an error if the id is not found.
+	GetVector(id int) ([]float32, error)
+
+	// NeedsRebuild reports whether the index has pending changes that will trigger an
+	// expensive rebuild of its internal structures on the next search.
+	// Returns true if a rebuild is pending.
+	NeedsRebuild() bool
+
 	// Stats returns metadata about the index, such as count and dimensionality.
 	// Returns an IndexStats struct containing the metadata.
 	Stats() IndexStats
@@ -57,6 +100,25 @@ type Index interface {
 	// r: the reader from which the index state will be loaded.
 	// Returns an error if the operation fails.
 	Load(r io.Reader) error
+
+	// Freeze makes the index read-only. Afterwards, Add, Delete, Update, their bulk variants, and Load
+	// return ErrFrozen without changing the index, while searches work as before. An index can't be unfrozen.
+	Freeze()
+
+	// Frozen reports whether Freeze has been called.
+	// Returns true if the index is read-only.
+	Frozen() bool
+
+	// Prefetch loads the vectors for the given ids, or for all ids if ids is nil, into memory ahead of a
+	// burst of searches, so that the searches don't stall on page faults of a memory-mapped store.
+	// ids: the identifiers of the vectors to load, or nil for all.
+	// Returns an error if the vectors could not be loaded.
+	Prefetch(ids []int) error
+
+	// Close releases OS resources held by the index, such as mapped or open files.
+	// The index must not be used after Close. Calling Close more than once is safe.
+	// Returns an error if a resource could not be released.
+	Close() error
 }
 
 // Neighbor holds a neighbor's id and its computed distance.
@@ -70,4 +132,13 @@ type IndexStats struct {
 	Count     int    // total number of indexed vectors.
 	Dimension int    // dimensionality of vectors.
 	Distance  string // name of the distance function used by the index.
+	Size      int    // approximate number of bytes used to store the vectors.
+
+	QuantizationScale float32 // scale of int8-quantized vectors, or 0 if vectors are not stored as int8.
+}
+
+// BuildStats reports the work an index has done to build its structure. Indexes that also compute
+// other vector operations of similar cost while building, such as projections, count them as well.
+type BuildStats struct {
+	DistanceEvaluations int64 // cumulative number of distance computations performed while building.
 }
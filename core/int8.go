@@ -0,0 +1,46 @@
+package core
+
+import "math"
+
+// Int8ScaleFor returns the scale that maps the largest absolute value in the vectors to 127,
+// so the vectors can be quantized to int8 without clipping. It returns 1 if all values are zero.
+func Int8ScaleFor(vectors ...[]float32) float32 {
+	var maxAbs float64
+	for _, vec := range vectors {
+		for _, v := range vec {
+			maxAbs = math.Max(maxAbs, math.Abs(float64(v)))
+		}
+	}
+	if maxAbs == 0 {
+		return 1
+	}
+	return float32(maxAbs / 127)
+}
+
+// QuantizeInt8 quantizes a vector to int8 with the given scale, so that v is approximated by scale*q.
+// Values are rounded to the nearest step and clipped to [-127, 127].
+func QuantizeInt8(vec []float32, scale float32) []int8 {
+	q := make([]int8, len(vec))
+	for i, v := range vec {
+		x := math.Round(float64(v / scale))
+		if x > 127 {
+			x = 127
+		} else if x < -127 {
+			x = -127
+		}
+		q[i] = int8(x)
+	}
+	return q
+}
+
+// DequantizeInt8 decodes an int8 vector quantized with the given scale, reusing dst if it is large enough.
+func DequantizeInt8(dst []float32, q []int8, scale float32) []float32 {
+	if cap(dst) < len(q) {
+		dst = make([]float32, len(q))
+	}
+	dst = dst[:len(q)]
+	for i, v := range q {
+		dst[i] = float32(v) * scale
+	}
+	return dst
+}
@@ -0,0 +1,31 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQuantizeInt8RoundTrip(t *testing.T) {
+	vec := []float32{-2, -0.5, 0, 0.013, 1.7, 2}
+	scale := Int8ScaleFor(vec)
+	if math.Abs(float64(scale)-2.0/127) > 1e-9 {
+		t.Fatalf("Int8ScaleFor = %f; want %f", scale, 2.0/127)
+	}
+	q := QuantizeInt8(vec, scale)
+	if q[0] != -127 || q[len(q)-1] != 127 {
+		t.Errorf("expected the extremes to map to -127 and 127, got %v", q)
+	}
+	decoded := DequantizeInt8(nil, q, scale)
+	for i := range vec {
+		if math.Abs(float64(decoded[i]-vec[i])) > float64(scale)/2+1e-6 {
+			t.Errorf("value %d: decoded %f, want %f within half a step", i, decoded[i], vec[i])
+		}
+	}
+	// Values beyond the scale are clipped rather than wrapped.
+	if q := QuantizeInt8([]float32{10, -10}, scale); q[0] != 127 || q[1] != -127 {
+		t.Errorf("expected clipping to [-127, 127], got %v", q)
+	}
+	if s := Int8ScaleFor([]float32{0, 0}); s != 1 {
+		t.Errorf("Int8ScaleFor of a zero vector = %f; want 1", s)
+	}
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"fmt"
+	"math"
+)
+
+// LOF returns the local outlier factor of the vector stored for id: the average local reachability
+// density of its k nearest neighbors divided by its own. Values near 1 mean the vector lies in a region
+// as dense as its neighbors' and values well above 1 mark an outlier.
+// The local reachability density of a vector p is the inverse of the mean reachability distance
+// max(k-distance(o), distance(p, o)) over its k nearest neighbors o, where k-distance(o) is the distance
+// from o to its own k-th nearest neighbor. Neighbors are found with SearchByID, so the score is only as
+// exact as the index: an approximate index that misses true neighbors gives an approximate score.
+// Searching the neighbors of the neighbors takes about k*k+k searches. Vectors with k or more
+// duplicates have an infinite density; a ratio of two infinite densities counts as 1.
+// It returns an error if the id is not found or the index holds no more than k vectors.
+func LOF(index Index, id int, k int) (float64, error) {
+	if k <= 0 {
+		return 0, fmt.Errorf("%w: k must be positive, got %d", ErrInvalidK, k)
+	}
+	neighborsOf := make(map[int][]Neighbor)
+	neighbors := func(id int) ([]Neighbor, error) {
+		if n, ok := neighborsOf[id]; ok {
+			return n, nil
+		}
+		n, err := SearchByID(index, id, k)
+		if err != nil {
+			return nil, err
+		}
+		if len(n) < k {
+			return nil, fmt.Errorf("%w: k=%d exceeds the %d neighbors found for id %d", ErrInvalidK, k, len(n), id)
+		}
+		neighborsOf[id] = n
+		return n, nil
+	}
+	// lrd returns the local reachability density of id.
+	lrd := func(id int) (float64, error) {
+		ns, err := neighbors(id)
+		if err != nil {
+			return 0, err
+		}
+		var sum float64
+		for _, o := range ns {
+			ons, err := neighbors(o.ID)
+			if err != nil {
+				return 0, err
+			}
+			sum += math.Max(ons[k-1].Distance, o.Distance)
+		}
+		if sum == 0 {
+			return math.Inf(1), nil
+		}
+		return float64(k) / sum, nil
+	}
+
+	own, err := lrd(id)
+	if err != nil {
+		return 0, err
+	}
+	ns, _ := neighbors(id)
+	var total float64
+	for _, o := range ns {
+		density, err := lrd(o.ID)
+		if err != nil {
+			return 0, err
+		}
+		if math.IsInf(density, 1) && math.IsInf(own, 1) {
+			total++
+			continue
+		}
+		total += density / own
+	}
+	return total / float64(k), nil
+}
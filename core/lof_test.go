@@ -0,0 +1,49 @@
+package core_test
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+func TestLOF(t *testing.T) {
+	idx := hnsw.NewHNSW(2, 8, 50, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(14))
+	vectors := make(map[int][]float32, 101)
+	for i := 0; i < 100; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32()}
+	}
+	const outlier = 100
+	vectors[outlier] = []float32{3, 3}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	const k = 5
+	outlierScore, err := core.LOF(idx, outlier, k)
+	if err != nil {
+		t.Fatalf("LOF failed: %v", err)
+	}
+	for id := 0; id < 100; id++ {
+		score, err := core.LOF(idx, id, k)
+		if err != nil {
+			t.Fatalf("LOF failed: %v", err)
+		}
+		if score >= outlierScore {
+			t.Errorf("inlier %d scores %f, not below the outlier's %f", id, score, outlierScore)
+		}
+	}
+	if outlierScore < 3 {
+		t.Errorf("expected the outlier to score well above 1, got %f", outlierScore)
+	}
+
+	if _, err := core.LOF(idx, 1000, k); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing id, got %v", err)
+	}
+	if _, err := core.LOF(idx, 0, 200); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK for k above the index size, got %v", err)
+	}
+}
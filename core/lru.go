@@ -0,0 +1,196 @@
+package core
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// LRUIndex wraps an index and bounds the number of vectors it holds by evicting the least recently
+// used ones. A vector counts as used when it is added or updated and whenever it appears in the
+// results of Search. When an Add or BulkAdd pushes the count above MaxVectors, the least recently used
+// vectors are deleted from the wrapped index until the limit is met again.
+// The wrapped index should only be modified through the LRUIndex so the access order stays in sync.
+type LRUIndex struct {
+	Index
+	MaxVectors int // maximum number of vectors to keep (0 or less disables eviction)
+
+	mu      sync.Mutex
+	order   *list.List            // ids from most to least recently used
+	entries map[int]*list.Element // id to its element in order
+}
+
+// NewLRUIndex wraps an index with least-recently-used eviction at maxVectors vectors.
+// Vectors already in the index are tracked in ascending id order, the highest id being the most recent.
+func NewLRUIndex(index Index, maxVectors int) (*LRUIndex, error) {
+	l := &LRUIndex{Index: index, MaxVectors: maxVectors}
+	if err := l.reset(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reset rebuilds the access order from the vectors currently in the wrapped index.
+func (l *LRUIndex) reset() error {
+	vectors, err := l.Index.Export()
+	if err != nil {
+		return fmt.Errorf("failed to list existing vectors: %w", err)
+	}
+	ids := make([]int, 0, len(vectors))
+	for id := range vectors {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.order = list.New()
+	l.entries = make(map[int]*list.Element, len(ids))
+	for _, id := range ids {
+		l.entries[id] = l.order.PushFront(id)
+	}
+	return nil
+}
+
+// touch marks ids as most recently used. The caller must hold mu.
+func (l *LRUIndex) touch(ids ...int) {
+	for _, id := range ids {
+		if e, ok := l.entries[id]; ok {
+			l.order.MoveToFront(e)
+			continue
+		}
+		l.entries[id] = l.order.PushFront(id)
+	}
+}
+
+// forget stops tracking ids. The caller must hold mu.
+func (l *LRUIndex) forget(ids ...int) {
+	for _, id := range ids {
+		if e, ok := l.entries[id]; ok {
+			l.order.Remove(e)
+			delete(l.entries, id)
+		}
+	}
+}
+
+// evict deletes least recently used vectors until at most MaxVectors remain. The caller must hold mu.
+func (l *LRUIndex) evict() error {
+	if l.MaxVectors <= 0 || l.order.Len() <= l.MaxVectors {
+		return nil
+	}
+	victims := make([]int, 0, l.order.Len()-l.MaxVectors)
+	for e := l.order.Back(); len(victims) < l.order.Len()-l.MaxVectors; e = e.Prev() {
+		victims = append(victims, e.Value.(int))
+	}
+	if err := l.Index.BulkDelete(victims); err != nil {
+		return fmt.Errorf("failed to evict %d vectors: %w", len(victims), err)
+	}
+	l.forget(victims...)
+	return nil
+}
+
+// Add inserts a vector, marks it as most recently used, and evicts vectors if the index is over capacity.
+func (l *LRUIndex) Add(id int, vector []float32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.Index.Add(id, vector); err != nil {
+		return err
+	}
+	l.touch(id)
+	return l.evict()
+}
+
+// BulkAdd inserts vectors, marks them as most recently used, and evicts vectors if the index is
+// over capacity. The new vectors are marked in ascending id order, so with more new vectors than
+// MaxVectors the lowest ids are evicted first.
+func (l *LRUIndex) BulkAdd(vectors map[int][]float32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.Index.BulkAdd(vectors); err != nil {
+		return err
+	}
+	ids := make([]int, 0, len(vectors))
+	for id := range vectors {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	l.touch(ids...)
+	return l.evict()
+}
+
+// Delete removes a vector and stops tracking it.
+func (l *LRUIndex) Delete(id int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.Index.Delete(id); err != nil {
+		return err
+	}
+	l.forget(id)
+	return nil
+}
+
+// BulkDelete removes vectors and stops tracking them.
+func (l *LRUIndex) BulkDelete(ids []int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.Index.BulkDelete(ids); err != nil {
+		return err
+	}
+	l.forget(ids...)
+	return nil
+}
+
+// Update modifies a vector and marks it as most recently used.
+func (l *LRUIndex) Update(id int, vector []float32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.Index.Update(id, vector); err != nil {
+		return err
+	}
+	l.touch(id)
+	return nil
+}
+
+// BulkUpdate modifies vectors and marks them as most recently used.
+func (l *LRUIndex) BulkUpdate(updates map[int][]float32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.Index.BulkUpdate(updates); err != nil {
+		return err
+	}
+	ids := make([]int, 0, len(updates))
+	for id := range updates {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	l.touch(ids...)
+	return nil
+}
+
+// Search returns the k nearest neighbors of the query and marks them as most recently used.
+// The farthest result is marked first, so the nearest neighbor ends up the most recent.
+func (l *LRUIndex) Search(query []float32, k int) ([]Neighbor, error) {
+	results, err := l.Index.Search(query, k)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := len(results) - 1; i >= 0; i-- {
+		// Skip ids evicted by a concurrent Add after the search returned them.
+		if _, ok := l.entries[results[i].ID]; ok {
+			l.touch(results[i].ID)
+		}
+	}
+	return results, nil
+}
+
+// Load initializes the wrapped index from a saved state and tracks the loaded vectors
+// as described in NewLRUIndex.
+func (l *LRUIndex) Load(r io.Reader) error {
+	if err := l.Index.Load(r); err != nil {
+		return err
+	}
+	return l.reset()
+}
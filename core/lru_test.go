@@ -0,0 +1,52 @@
+package core_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+func TestLRUIndex_EvictsLeastRecentlySearched(t *testing.T) {
+	index, err := core.NewLRUIndex(hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean"), 3)
+	if err != nil {
+		t.Fatalf("NewLRUIndex failed: %v", err)
+	}
+	vectors := map[int][]float32{1: {0, 0}, 2: {10, 0}, 3: {0, 10}, 4: {10, 10}, 5: {20, 20}}
+	for _, id := range []int{1, 2, 3} {
+		if err := index.Add(id, vectors[id]); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	// Searching for id 1 makes id 2 the least recently used, so it is evicted by the next Add.
+	if _, err := index.Search(vectors[1], 1); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if err := index.Add(4, vectors[4]); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if _, err := index.GetVector(2); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("expected id 2 to be evicted, got %v", err)
+	}
+
+	// Now id 1 is the least recently used.
+	if _, err := index.Search(vectors[3], 1); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if err := index.Add(5, vectors[5]); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if _, err := index.GetVector(1); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("expected id 1 to be evicted, got %v", err)
+	}
+	if count := index.Stats().Count; count != 3 {
+		t.Errorf("expected 3 vectors after eviction, got %d", count)
+	}
+	for _, id := range []int{3, 4, 5} {
+		if _, err := index.GetVector(id); err != nil {
+			t.Errorf("expected id %d to be kept, got %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MultiIndexSearch searches several indexes (for example, shards of one dataset) concurrently, at most
+// MaxParallelism() at a time, and returns the global k nearest neighbors. Results are merged and
+// de-duplicated by id, keeping the smaller distance when an id appears in more than one index. All
+// indexes must share the same dimension and distance metric; empty indexes are skipped.
+func MultiIndexSearch(indexes []Index, query []float32, k int) ([]Neighbor, error) {
+	if len(indexes) == 0 {
+		return nil, errors.New("no indexes to search")
+	}
+	if k <= 0 {
+		return nil, fmt.Errorf("%w: k must be positive, got %d", ErrInvalidK, k)
+	}
+
+	// Validate that all shards are compatible.
+	first := indexes[0].Stats()
+	active := make([]Index, 0, len(indexes))
+	for i, index := range indexes {
+		stats := index.Stats()
+		if stats.Dimension != first.Dimension {
+			return nil, fmt.Errorf("index %d has dimension %d, expected %d", i, stats.Dimension, first.Dimension)
+		}
+		if stats.Distance != first.Distance {
+			return nil, fmt.Errorf("index %d uses distance %q, expected %q", i, stats.Distance, first.Distance)
+		}
+		if stats.Count > 0 {
+			active = append(active, index)
+		}
+	}
+	if len(active) == 0 {
+		return nil, ErrEmptyIndex
+	}
+
+	// Search all shards concurrently.
+	results := make([][]Neighbor, len(active))
+	errs := make([]error, len(active))
+	sem := make(chan struct{}, Workers(0, len(active)))
+	var wg sync.WaitGroup
+	for i, index := range active {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, index Index) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i], errs[i] = index.Search(query, k)
+		}(i, index)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("search on index %d failed: %w", i, err)
+		}
+	}
+
+	// Merge and de-duplicate by id, keeping the smaller distance.
+	best := make(map[int]float64)
+	for _, neighbors := range results {
+		for _, n := range neighbors {
+			if d, ok := best[n.ID]; !ok || n.Distance < d {
+				best[n.ID] = n.Distance
+			}
+		}
+	}
+	merged := make([]Neighbor, 0, len(best))
+	for id, d := range best {
+		merged = append(merged, Neighbor{ID: id, Distance: d})
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if merged[i].Distance == merged[j].Distance {
+			return merged[i].ID < merged[j].ID
+		}
+		return merged[i].Distance < merged[j].Distance
+	})
+	if k > len(merged) {
+		k = len(merged)
+	}
+	return merged[:k], nil
+}
@@ -0,0 +1,40 @@
+package core
+
+import "fmt"
+
+// SearchMultiQuery returns the k nearest neighbors of the weighted centroid of several query vectors,
+// for example several positive examples in search by example. Each query is scaled by its weight and
+// the sum is divided by the total weight, so a query with twice the weight pulls the centroid twice
+// as far towards itself. Weights must be non-negative with a positive sum, and there must be one
+// weight per query.
+func SearchMultiQuery(index Index, queries [][]float32, weights []float32, k int) ([]Neighbor, error) {
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no query vectors")
+	}
+	if len(weights) != len(queries) {
+		return nil, fmt.Errorf("got %d weights for %d query vectors", len(weights), len(queries))
+	}
+	dim := len(queries[0])
+	centroid := make([]float32, dim)
+	var total float32
+	for i, q := range queries {
+		if len(q) != dim {
+			return nil, fmt.Errorf("%w: query %d has dimension %d, query 0 has dimension %d",
+				ErrDimensionMismatch, i, len(q), dim)
+		}
+		if weights[i] < 0 {
+			return nil, fmt.Errorf("weight %d is negative: %v", i, weights[i])
+		}
+		for j, v := range q {
+			centroid[j] += weights[i] * v
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("weights must have a positive sum")
+	}
+	for j := range centroid {
+		centroid[j] /= total
+	}
+	return index.Search(centroid, k)
+}
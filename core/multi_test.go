@@ -0,0 +1,60 @@
+package core_test
+
+import (
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+func TestMultiIndexSearch(t *testing.T) {
+	dim := 6
+	single := hnsw.NewHNSW(dim, 8, 200, core.Euclidean, "euclidean")
+	shardA := hnsw.NewHNSW(dim, 8, 200, core.Euclidean, "euclidean")
+	shardB := hnsw.NewHNSW(dim, 8, 200, core.Euclidean, "euclidean")
+	// Exhaustive search makes every index exact, so the results are directly comparable.
+	for _, index := range []*hnsw.HNSWIndex{single, shardA, shardB} {
+		index.ExhaustiveSearch = true
+	}
+
+	for i := 0; i < 100; i++ {
+		vec := []float32{
+			float32(i % 7), float32(i % 11), float32(i % 13),
+			float32(i % 5), float32(i % 3), float32(i % 17),
+		}
+		if err := single.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		shard := shardA
+		if i%2 == 1 {
+			shard = shardB
+		}
+		if err := shard.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	query := []float32{3, 5, 7, 2, 1, 8}
+	k := 10
+	want, err := single.Search(query, k)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	got, err := core.MultiIndexSearch([]core.Index{shardA, shardB}, query, k)
+	if err != nil {
+		t.Fatalf("MultiIndexSearch failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || got[i].Distance != want[i].Distance {
+			t.Errorf("result %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	mismatched := hnsw.NewHNSW(dim+1, 8, 200, core.Euclidean, "euclidean")
+	if _, err := core.MultiIndexSearch([]core.Index{shardA, mismatched}, query, k); err == nil {
+		t.Error("expected error for shards with different dimensions, got none")
+	}
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// NormEpsilon is the smallest L2 norm for which NormalizeVector rescales a vector.
+// Vectors with a smaller norm are treated as zero vectors and left unchanged, because dividing
+// by a tiny norm amplifies rounding errors and can overflow to Inf.
+var NormEpsilon = 1e-12
+
+// NormalizeVector scales the vector in place to unit L2 norm.
+// Zero vectors, and vectors whose norm is below NormEpsilon, are left unchanged.
+func NormalizeVector(vec []float32) {
+	var sum float64
+	for _, v := range vec {
+		sum += float64(v) * float64(v)
+	}
+	norm := math.Sqrt(sum)
+	if norm < NormEpsilon {
+		if norm > 0 {
+			log.Debug().Msgf("Skipping normalization of vector with norm %g (below epsilon %g)",
+				norm, NormEpsilon)
+		}
+		return
+	}
+	for i, v := range vec {
+		vec[i] = float32(float64(v) / norm)
+	}
+}
+
+// NormMode selects the norm used by NormalizeVectorMode.
+type NormMode int
+
+const (
+	NormL2  NormMode = iota // Euclidean norm, the square root of the sum of squares (the default)
+	NormL1                  // sum of absolute values
+	NormMax                 // largest absolute value
+)
+
+// String returns the name of the norm.
+func (m NormMode) String() string {
+	switch m {
+	case NormL2:
+		return "l2"
+	case NormL1:
+		return "l1"
+	case NormMax:
+		return "max"
+	}
+	return fmt.Sprintf("NormMode(%d)", int(m))
+}
+
+// NormalizeVectorMode scales the vector in place to unit norm in the given mode.
+// NormL2 is the same as NormalizeVector. As there, vectors whose norm is below NormEpsilon are left
+// unchanged. Unknown modes leave the vector unchanged.
+func NormalizeVectorMode(vec []float32, mode NormMode) {
+	var norm float64
+	switch mode {
+	case NormL2:
+		NormalizeVector(vec)
+		return
+	case NormL1:
+		for _, v := range vec {
+			norm += math.Abs(float64(v))
+		}
+	case NormMax:
+		for _, v := range vec {
+			norm = math.Max(norm, math.Abs(float64(v)))
+		}
+	default:
+		log.Warn().Msgf("Skipping normalization with unknown mode %v", mode)
+		return
+	}
+	if norm < NormEpsilon {
+		return
+	}
+	for i, v := range vec {
+		vec[i] = float32(float64(v) / norm)
+	}
+}
+
+// DefaultNormalizeChunkSize is the number of vectors a NormalizeBatch worker takes at a time when no
+// chunk size is given.
+const DefaultNormalizeChunkSize = 1024
+
+// NormalizeBatch scales each vector in place to unit norm in the given mode, like NormalizeVectorMode,
+// so zero vectors and vectors whose norm is below NormEpsilon are left unchanged. The vectors are split
+// into chunks of chunkSize (0 means DefaultNormalizeChunkSize) that a pool of at most workers goroutines
+// (0 means MaxParallelism) take in turn, so the number of goroutines does not grow with the batch.
+func NormalizeBatch(vectors [][]float32, mode NormMode, chunkSize, workers int) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultNormalizeChunkSize
+	}
+	numChunks := (len(vectors) + chunkSize - 1) / chunkSize
+	workers = Workers(workers, numChunks)
+	if workers <= 1 {
+		for _, vec := range vectors {
+			NormalizeVectorMode(vec, mode)
+		}
+		return
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				chunk := int(next.Add(1)) - 1
+				if chunk >= numChunks {
+					return
+				}
+				end := min((chunk+1)*chunkSize, len(vectors))
+				for _, vec := range vectors[chunk*chunkSize : end] {
+					NormalizeVectorMode(vec, mode)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestNormalizeVector(t *testing.T) {
+	vec := []float32{3, 4}
+	NormalizeVector(vec)
+	if math.Abs(float64(vec[0])-0.6) > 1e-6 || math.Abs(float64(vec[1])-0.8) > 1e-6 {
+		t.Errorf("NormalizeVector([3 4]) = %v; want [0.6 0.8]", vec)
+	}
+}
+
+func TestNormalizeVectorZero(t *testing.T) {
+	vec := []float32{0, 0, 0}
+	NormalizeVector(vec)
+	for i, v := range vec {
+		if v != 0 {
+			t.Errorf("element %d of zero vector changed to %f", i, v)
+		}
+	}
+}
+
+func TestNormalizeVectorTinyNorm(t *testing.T) {
+	orig := []float32{1e-20, -1e-20, 1e-20}
+	vec := append([]float32(nil), orig...)
+	NormalizeVector(vec)
+	for i, v := range vec {
+		if math.IsInf(float64(v), 0) || math.IsNaN(float64(v)) {
+			t.Fatalf("element %d became %f", i, v)
+		}
+		if v != orig[i] {
+			t.Errorf("element %d was amplified to %g; want it left unchanged", i, v)
+		}
+	}
+}
+
+func TestNormalizeVectorMode(t *testing.T) {
+	tests := []struct {
+		mode NormMode
+		want []float32
+	}{
+		{NormL2, []float32{0.6, -0.8}},
+		{NormL1, []float32{3.0 / 7, -4.0 / 7}},
+		{NormMax, []float32{0.75, -1}},
+	}
+	for _, tt := range tests {
+		vec := []float32{3, -4}
+		NormalizeVectorMode(vec, tt.mode)
+		for i := range vec {
+			if math.Abs(float64(vec[i]-tt.want[i])) > 1e-6 {
+				t.Errorf("%v: NormalizeVectorMode([3 -4]) = %v; want %v", tt.mode, vec, tt.want)
+				break
+			}
+		}
+		zero := []float32{0, 0}
+		NormalizeVectorMode(zero, tt.mode)
+		if zero[0] != 0 || zero[1] != 0 {
+			t.Errorf("%v: zero vector changed to %v", tt.mode, zero)
+		}
+	}
+}
+
+func TestNormalizeBatch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vectors := make([][]float32, 1000)
+	for i := range vectors {
+		vectors[i] = []float32{rng.Float32() - 0.5, rng.Float32() - 0.5, rng.Float32() - 0.5}
+	}
+	vectors[10] = []float32{0, 0, 0}
+	vectors[500] = []float32{1e-20, 0, 0}
+	for _, mode := range []NormMode{NormL2, NormL1, NormMax} {
+		want := make([][]float32, len(vectors))
+		for i, vec := range vectors {
+			want[i] = append([]float32(nil), vec...)
+			NormalizeVectorMode(want[i], mode)
+		}
+		// Chunk sizes that divide the batch, leave a short last chunk, and exceed the batch.
+		for _, chunkSize := range []int{0, 7, 100, 5000} {
+			for _, workers := range []int{0, 1, 3} {
+				got := make([][]float32, len(vectors))
+				for i, vec := range vectors {
+					got[i] = append([]float32(nil), vec...)
+				}
+				NormalizeBatch(got, mode, chunkSize, workers)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%v, chunk size %d, %d workers: results differ from NormalizeVectorMode",
+						mode, chunkSize, workers)
+				}
+			}
+		}
+	}
+	NormalizeBatch(nil, NormL2, 0, 0)
+}
+
+func normalizeBenchmarkVectors() [][]float32 {
+	rng := rand.New(rand.NewSource(1))
+	vectors := make([][]float32, 500000)
+	for i := range vectors {
+		vec := make([]float32, 16)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	return vectors
+}
+
+// BenchmarkNormalizeBatch normalizes 500k vectors with a bounded pool of workers taking chunks.
+func BenchmarkNormalizeBatch(b *testing.B) {
+	vectors := normalizeBenchmarkVectors()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NormalizeBatch(vectors, NormL2, 0, 0)
+	}
+}
+
+// BenchmarkNormalizeBatch_PerVector normalizes the same vectors with one goroutine per vector, for
+// comparison with BenchmarkNormalizeBatch.
+func BenchmarkNormalizeBatch_PerVector(b *testing.B) {
+	vectors := normalizeBenchmarkVectors()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for _, vec := range vectors {
+			wg.Add(1)
+			go func(vec []float32) {
+				defer wg.Done()
+				NormalizeVectorMode(vec, NormL2)
+			}(vec)
+		}
+		wg.Wait()
+	}
+}
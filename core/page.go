@@ -0,0 +1,29 @@
+package core
+
+import "fmt"
+
+// SearchPage returns the neighbors of the query ranked [offset, offset+limit) in distance order.
+// It searches for offset+limit neighbors and returns the requested slice of them, which is empty if
+// the index holds no more than offset vectors. Consecutive pages line up with a single larger Search
+// as long as the index returns the same nearest neighbors for every k; for HNSW this holds when Ef is
+// at least offset+limit, since smaller Ef values are raised to k.
+func SearchPage(index Index, query []float32, offset, limit int) ([]Neighbor, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("offset must be non-negative, got %d", offset)
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: limit must be positive, got %d", ErrInvalidK, limit)
+	}
+	results, err := index.Search(query, offset+limit)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= len(results) {
+		return []Neighbor{}, nil
+	}
+	end := offset + limit
+	if end > len(results) {
+		end = len(results)
+	}
+	return results[offset:end], nil
+}
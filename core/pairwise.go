@@ -0,0 +1,34 @@
+package core
+
+import "sync"
+
+// PairwiseDistances returns the symmetric matrix of distances between all pairs of vectors, for example
+// to re-rank or diversify a small candidate set. Only the upper triangle is computed, each distance
+// once, and mirrored into the lower one; the diagonal is zero. Rows are spread over up to
+// MaxParallelism() goroutines, interleaved so that the long rows at the top and the short ones at the
+// bottom even out between them.
+func PairwiseDistances(vectors [][]float32, distance DistanceFunc) [][]float64 {
+	n := len(vectors)
+	matrix := make([][]float64, n)
+	for i := range matrix {
+		matrix[i] = make([]float64, n)
+	}
+	workers := Workers(0, n)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Each cell is written by the worker owning the row of its upper-triangle position only.
+			for i := w; i < n; i += workers {
+				for j := i + 1; j < n; j++ {
+					d := distance(vectors[i], vectors[j])
+					matrix[i][j] = d
+					matrix[j][i] = d
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return matrix
+}
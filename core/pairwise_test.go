@@ -0,0 +1,81 @@
+package core
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPairwiseDistances(t *testing.T) {
+	vectors := [][]float32{{0, 0}, {3, 4}, {1, 0}, {0, -2}, {3, 4}}
+	matrix := PairwiseDistances(vectors, Euclidean)
+	if len(matrix) != len(vectors) {
+		t.Fatalf("expected %d rows, got %d", len(vectors), len(matrix))
+	}
+	for i := range vectors {
+		if len(matrix[i]) != len(vectors) {
+			t.Fatalf("row %d: expected %d columns, got %d", i, len(vectors), len(matrix[i]))
+		}
+		if matrix[i][i] != 0 {
+			t.Errorf("expected zero diagonal, got %f at %d", matrix[i][i], i)
+		}
+		for j := range vectors {
+			if matrix[i][j] != matrix[j][i] {
+				t.Errorf("matrix not symmetric at (%d, %d): %f vs %f", i, j, matrix[i][j], matrix[j][i])
+			}
+			if want := Euclidean(vectors[i], vectors[j]); matrix[i][j] != want {
+				t.Errorf("distance (%d, %d): expected %f, got %f", i, j, want, matrix[i][j])
+			}
+		}
+	}
+
+	var calls atomic.Int64
+	counting := func(a, b []float32) float64 {
+		calls.Add(1)
+		return Euclidean(a, b)
+	}
+	PairwiseDistances(vectors, counting)
+	if n := len(vectors); calls.Load() != int64(n*(n-1)/2) {
+		t.Errorf("expected %d distance computations, got %d", n*(n-1)/2, calls.Load())
+	}
+
+	if matrix := PairwiseDistances(nil, Euclidean); len(matrix) != 0 {
+		t.Errorf("expected empty matrix for no vectors, got %v", matrix)
+	}
+}
+
+func pairwiseBenchmarkVectors() [][]float32 {
+	rng := rand.New(rand.NewSource(1))
+	vectors := make([][]float32, 200)
+	for i := range vectors {
+		vectors[i] = make([]float32, 128)
+		for j := range vectors[i] {
+			vectors[i][j] = rng.Float32()
+		}
+	}
+	return vectors
+}
+
+func BenchmarkPairwiseDistances(b *testing.B) {
+	vectors := pairwiseBenchmarkVectors()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		PairwiseDistances(vectors, Euclidean)
+	}
+}
+
+// BenchmarkPairwiseDistances_Full computes the distance of every ordered pair with the same parallelism,
+// as a baseline for PairwiseDistances, which computes half as many distances by mirroring the upper
+// triangle.
+func BenchmarkPairwiseDistances_Full(b *testing.B) {
+	vectors := pairwiseBenchmarkVectors()
+	full := func(a, b []float32) float64 {
+		// Compute each distance twice, as filling both triangles independently would.
+		Euclidean(b, a)
+		return Euclidean(a, b)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		PairwiseDistances(vectors, full)
+	}
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// maxParallelism is the limit set by SetMaxParallelism, or 0 for runtime.NumCPU().
+var maxParallelism atomic.Int64
+
+// SetMaxParallelism limits the number of goroutines each parallel loop in Hann runs, such as the HNSW
+// brute-force fallback, the RPT distance computations and tree build, PairwiseDistances, and
+// MultiIndexSearch, so that several indexes or services sharing a machine don't over-subscribe it.
+// A value of n <= 0 restores the default, runtime.NumCPU(). Indexes with a MaxParallelism field
+// override the limit for their own loops.
+func SetMaxParallelism(n int) {
+	if n < 0 {
+		n = 0
+	}
+	maxParallelism.Store(int64(n))
+}
+
+// MaxParallelism returns the limit set by SetMaxParallelism, or runtime.NumCPU() if none is set.
+func MaxParallelism() int {
+	if n := maxParallelism.Load(); n > 0 {
+		return int(n)
+	}
+	return runtime.NumCPU()
+}
+
+// Workers returns the number of goroutines a parallel loop over n items should run: the per-index
+// override if it is positive, or MaxParallelism otherwise, but no more than n and at least 1.
+func Workers(override, n int) int {
+	workers := override
+	if workers <= 0 {
+		workers = MaxParallelism()
+	}
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetMaxParallelism(t *testing.T) {
+	defer SetMaxParallelism(0)
+
+	if got := MaxParallelism(); got != runtime.NumCPU() {
+		t.Errorf("expected the default to be runtime.NumCPU() = %d, got %d", runtime.NumCPU(), got)
+	}
+	SetMaxParallelism(3)
+	if got := MaxParallelism(); got != 3 {
+		t.Errorf("expected 3, got %d", got)
+	}
+	for _, tc := range []struct{ override, n, want int }{
+		{0, 10, 3},
+		{2, 10, 2},
+		{8, 10, 8},
+		{0, 2, 2},
+		{0, 0, 1},
+	} {
+		if got := Workers(tc.override, tc.n); got != tc.want {
+			t.Errorf("Workers(%d, %d): expected %d, got %d", tc.override, tc.n, tc.want, got)
+		}
+	}
+	SetMaxParallelism(-1)
+	if got := MaxParallelism(); got != runtime.NumCPU() {
+		t.Errorf("expected a negative limit to restore the default %d, got %d", runtime.NumCPU(), got)
+	}
+}
+
+func TestSetMaxParallelism_PairwiseDistances(t *testing.T) {
+	defer SetMaxParallelism(0)
+	vectors := make([][]float32, 40)
+	for i := range vectors {
+		vectors[i] = []float32{float32(i), float32(i * i % 7)}
+	}
+	want := PairwiseDistances(vectors, Euclidean)
+
+	for _, n := range []int{1, 2} {
+		SetMaxParallelism(n)
+		var active, peak atomic.Int64
+		tracking := func(a, b []float32) float64 {
+			cur := active.Add(1)
+			for {
+				p := peak.Load()
+				if cur <= p || peak.CompareAndSwap(p, cur) {
+					break
+				}
+			}
+			defer active.Add(-1)
+			return Euclidean(a, b)
+		}
+		if got := PairwiseDistances(vectors, tracking); !reflect.DeepEqual(got, want) {
+			t.Errorf("parallelism %d: distance matrix differs from the default", n)
+		}
+		if p := peak.Load(); p > int64(n) {
+			t.Errorf("parallelism %d: %d distance computations ran at once", n, p)
+		}
+	}
+}
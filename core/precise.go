@@ -0,0 +1,104 @@
+package core
+
+import (
+	"math"
+	"reflect"
+)
+
+// The built-in distances accumulate their sums in float64, but compute each difference in float32
+// before widening it, which rounds differences between values of very different magnitude. The
+// precise variants below widen both values before subtracting and add the terms with Neumaier's
+// compensated summation, so sums over thousands of dimensions keep close to full float64 precision
+// and near-tie distances are ordered like an exact computation would order them. They are slower
+// than the built-in distances and meant for high-dimensional data where that ordering matters.
+
+// compensatedSum accumulates float64 terms with Neumaier's variant of Kahan summation.
+type compensatedSum struct {
+	sum, c float64
+}
+
+func (s *compensatedSum) add(x float64) {
+	t := s.sum + x
+	if math.Abs(s.sum) >= math.Abs(x) {
+		s.c += (s.sum - t) + x
+	} else {
+		s.c += (x - t) + s.sum
+	}
+	s.sum = t
+}
+
+func (s *compensatedSum) value() float64 {
+	return s.sum + s.c
+}
+
+// SquaredEuclideanPrecise computes the squared Euclidean distance in float64 with compensated summation.
+func SquaredEuclideanPrecise(a, b []float32) float64 {
+	var s compensatedSum
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		s.add(d * d)
+	}
+	return s.value()
+}
+
+// EuclideanPrecise computes the Euclidean distance in float64 with compensated summation.
+func EuclideanPrecise(a, b []float32) float64 {
+	return math.Sqrt(SquaredEuclideanPrecise(a, b))
+}
+
+// ManhattanPrecise computes the Manhattan distance in float64 with compensated summation.
+func ManhattanPrecise(a, b []float32) float64 {
+	var s compensatedSum
+	for i := range a {
+		s.add(math.Abs(float64(a[i]) - float64(b[i])))
+	}
+	return s.value()
+}
+
+// CosinePrecise computes the cosine distance in float64 with compensated summation.
+// If either vector has zero norm, the distance is 1.
+func CosinePrecise(a, b []float32) float64 {
+	var dot, normA, normB compensatedSum
+	for i := range a {
+		dot.add(float64(a[i]) * float64(b[i]))
+		normA.add(float64(a[i]) * float64(a[i]))
+		normB.add(float64(b[i]) * float64(b[i]))
+	}
+	if normA.value() == 0 || normB.value() == 0 {
+		return 1
+	}
+	return 1 - dot.value()/(math.Sqrt(normA.value())*math.Sqrt(normB.value()))
+}
+
+// PreciseDistances maps the names of the built-in distance metrics to their precise variants.
+// Pass one of them with the matching name to an index constructor to force float64 accumulation.
+var PreciseDistances = map[string]DistanceFunc{
+	"euclidean":         EuclideanPrecise,
+	"squared_euclidean": SquaredEuclideanPrecise,
+	"manhattan":         ManhattanPrecise,
+	"cosine":            CosinePrecise,
+}
+
+// IsPrecise reports whether distance is the precise variant of the distance named name.
+// Indexes persist this flag next to the name so that loading restores the same variant.
+func IsPrecise(name string, distance DistanceFunc) bool {
+	precise, ok := PreciseDistances[name]
+	if !ok || distance == nil {
+		return false
+	}
+	return reflect.ValueOf(distance).Pointer() == reflect.ValueOf(precise).Pointer()
+}
+
+// EarlyStopFor returns the early-stopping variant of the distance named name, or nil if there is none
+// or distance is not the built-in function of that name, for example a precise variant or a custom
+// function, whose results the early-stopping variant would not reproduce.
+func EarlyStopFor(name string, distance DistanceFunc) EarlyStopDistanceFunc {
+	early, ok := EarlyStopDistances[name]
+	if !ok || distance == nil {
+		return nil
+	}
+	if reflect.ValueOf(distance).Pointer() != reflect.ValueOf(Distances[name]).Pointer() {
+		return nil
+	}
+	return early
+}
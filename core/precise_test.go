@@ -0,0 +1,89 @@
+package core
+
+import (
+	"math/big"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// exactSquaredEuclidean computes the squared Euclidean distance with arbitrary precision.
+func exactSquaredEuclidean(a, b []float32) *big.Float {
+	sum := new(big.Float).SetPrec(512)
+	for i := range a {
+		d := new(big.Float).SetPrec(512).Sub(big.NewFloat(float64(a[i])), big.NewFloat(float64(b[i])))
+		sum.Add(sum, d.Mul(d, d))
+	}
+	return sum
+}
+
+func TestPreciseDistancesOrderNearTies(t *testing.T) {
+	const dim, n = 960, 200
+	r := rand.New(rand.NewSource(6))
+	query := make([]float32, dim)
+	base := make([]float32, dim)
+	for i := range query {
+		// Mix magnitudes so that float32 differences round.
+		query[i] = r.Float32() * 1000
+		base[i] = query[i] + r.Float32()*1e-3
+	}
+	// Every vector differs from base in a few coordinates by a few ulps, giving many near-tie distances.
+	vectors := make([][]float32, n)
+	for v := range vectors {
+		vec := append([]float32{}, base...)
+		for k := 0; k < 3; k++ {
+			j := r.Intn(dim)
+			vec[j] += float32(r.Intn(5)-2) * 6e-5
+		}
+		vectors[v] = vec
+	}
+
+	exact := make([]*big.Float, n)
+	order := make([]int, n)
+	for v := range vectors {
+		exact[v] = exactSquaredEuclidean(query, vectors[v])
+		order[v] = v
+	}
+	sort.SliceStable(order, func(i, j int) bool { return exact[order[i]].Cmp(exact[order[j]]) < 0 })
+
+	precise := make([]float64, n)
+	for v := range vectors {
+		precise[v] = SquaredEuclideanPrecise(query, vectors[v])
+	}
+	for i := 1; i < n; i++ {
+		prev, cur := order[i-1], order[i]
+		if exact[prev].Cmp(exact[cur]) < 0 && precise[prev] > precise[cur] {
+			t.Errorf("vectors %d and %d are ordered differently than by the exact distance", prev, cur)
+		}
+	}
+	if want, _ := exact[0].Float64(); precise[0] != want {
+		t.Errorf("SquaredEuclideanPrecise = %v; want %v", precise[0], want)
+	}
+}
+
+func TestEarlyStopFor(t *testing.T) {
+	if EarlyStopFor("euclidean", Euclidean) == nil {
+		t.Error("expected an early-stop variant for the built-in Euclidean distance")
+	}
+	if EarlyStopFor("euclidean", EuclideanPrecise) != nil {
+		t.Error("expected no early-stop variant for the precise Euclidean distance")
+	}
+	if EarlyStopFor("cosine", Cosine) != nil {
+		t.Error("expected no early-stop variant for the cosine distance")
+	}
+}
+
+func TestIsPrecise(t *testing.T) {
+	if !IsPrecise("euclidean", EuclideanPrecise) {
+		t.Error("expected EuclideanPrecise to be the precise euclidean distance")
+	}
+	if IsPrecise("euclidean", Euclidean) {
+		t.Error("expected the built-in Euclidean distance not to be precise")
+	}
+	if IsPrecise("cosine", EuclideanPrecise) {
+		t.Error("expected EuclideanPrecise not to be the precise cosine distance")
+	}
+	if IsPrecise("custom", EuclideanPrecise) {
+		t.Error("expected no precise variant for an unknown name")
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// RandomProjection returns a function that maps vectors of length fromDim to vectors of length toDim
+// using a random Gaussian projection matrix (Johnson-Lindenstrauss style dimension reduction).
+// The matrix is generated once from the given seed, so the returned function is deterministic and
+// can be reused for both indexing and querying.
+//
+// There is no projection hook inside the indexes; apply the returned function to every vector before
+// passing it to Add, BulkAdd, Update, and Search, and create the index with dimension toDim.
+// For example:
+//
+//	project := core.RandomProjection(960, 64, 42)
+//	index := hnsw.NewHNSW(64, 16, 100, core.Euclidean, "euclidean")
+//	_ = index.Add(id, project(vec))
+//	neighbors, _ := index.Search(project(query), 10)
+//
+// Distances reported by the index are distances in the projected space.
+// It panics if fromDim or toDim is not positive.
+func RandomProjection(fromDim, toDim int, seed int64) func([]float32) []float32 {
+	if fromDim <= 0 || toDim <= 0 {
+		panic(fmt.Sprintf("invalid projection dimensions: from %d to %d", fromDim, toDim))
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	// Scale the entries so that squared norms are preserved in expectation.
+	scale := 1 / math.Sqrt(float64(toDim))
+	matrix := make([][]float32, toDim)
+	for i := range matrix {
+		row := make([]float32, fromDim)
+		for j := range row {
+			row[j] = float32(rnd.NormFloat64() * scale)
+		}
+		matrix[i] = row
+	}
+
+	return func(vec []float32) []float32 {
+		if len(vec) != fromDim {
+			panic(fmt.Sprintf("vector dimension %d does not match projection input dimension %d",
+				len(vec), fromDim))
+		}
+		out := make([]float32, toDim)
+		for i, row := range matrix {
+			var dot float64
+			for j, v := range vec {
+				dot += float64(row[j]) * float64(v)
+			}
+			out[i] = float32(dot)
+		}
+		return out
+	}
+}
@@ -0,0 +1,64 @@
+package core
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestRandomProjectionPreservesOrdering(t *testing.T) {
+	fromDim, toDim := 100, 16
+	project := RandomProjection(fromDim, toDim, 42)
+	rnd := rand.New(rand.NewSource(7))
+
+	randomVec := func(scale float64) []float32 {
+		v := make([]float32, fromDim)
+		for i := range v {
+			v[i] = float32(rnd.NormFloat64() * scale)
+		}
+		return v
+	}
+
+	// Points at increasing (well separated) distances from the query.
+	query := randomVec(1)
+	var points [][]float32
+	for i := 1; i <= 8; i++ {
+		offset := randomVec(float64(i * i))
+		p := make([]float32, fromDim)
+		for j := range p {
+			p[j] = query[j] + offset[j]
+		}
+		points = append(points, p)
+	}
+
+	pq := project(query)
+	if len(pq) != toDim {
+		t.Fatalf("expected projected dimension %d, got %d", toDim, len(pq))
+	}
+
+	var pairs, preserved int
+	for i := range points {
+		for j := i + 1; j < len(points); j++ {
+			pairs++
+			orig := Euclidean(query, points[i]) < Euclidean(query, points[j])
+			proj := Euclidean(pq, project(points[i])) < Euclidean(pq, project(points[j]))
+			if orig == proj {
+				preserved++
+			}
+		}
+	}
+	if ratio := float64(preserved) / float64(pairs); ratio < 0.9 {
+		t.Errorf("expected at least 90%% of distance orderings to be preserved, got %.2f", ratio)
+	}
+}
+
+func TestRandomProjectionDeterministic(t *testing.T) {
+	a := RandomProjection(10, 4, 1)
+	b := RandomProjection(10, 4, 1)
+	vec := []float32{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	pa, pb := a(vec), b(vec)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			t.Fatalf("projections with the same seed differ at %d: %v vs %v", i, pa, pb)
+		}
+	}
+}
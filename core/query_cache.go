@@ -0,0 +1,132 @@
+package core
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// QueryCache is a least-recently-used cache of search results keyed by the query and k, for workloads
+// that repeat the same queries. Indexes enable it with EnableQueryCache and clear it with Invalidate on
+// every modification. It is safe for concurrent use.
+type QueryCache struct {
+	size int
+
+	mu         sync.Mutex
+	order      *list.List               // entries from most to least recently used
+	entries    map[uint64]*list.Element // key to its element in order
+	generation uint64                   // incremented by Invalidate
+	hits       atomic.Int64
+	misses     atomic.Int64
+}
+
+// queryCacheEntry holds the results of one query. The query is kept to tell apart queries whose keys collide.
+type queryCacheEntry struct {
+	key     uint64
+	query   []float32
+	k       int
+	results []Neighbor
+}
+
+// NewQueryCache creates a cache holding the results of up to size queries. size must be positive.
+func NewQueryCache(size int) *QueryCache {
+	return &QueryCache{size: size, order: list.New(), entries: make(map[uint64]*list.Element)}
+}
+
+// queryKey hashes the bytes of a query together with k.
+func queryKey(query []float32, k int) uint64 {
+	return TieRank(QuerySeed(query), k)
+}
+
+// equalVectors reports whether a and b hold the same values.
+func equalVectors(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Search returns a copy of the cached results for the query and k if there are any. Otherwise it
+// returns the results of search and caches a copy of them, unless Invalidate was called while search
+// ran, since they may then be outdated. Errors are not cached.
+func (c *QueryCache) Search(query []float32, k int, search func() ([]Neighbor, error)) ([]Neighbor, error) {
+	key := queryKey(query, k)
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*queryCacheEntry)
+		if entry.k == k && equalVectors(entry.query, query) {
+			c.order.MoveToFront(e)
+			results := append([]Neighbor(nil), entry.results...)
+			c.mu.Unlock()
+			c.hits.Add(1)
+			return results, nil
+		}
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	results, err := search()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return results, nil
+	}
+	entry := &queryCacheEntry{
+		key:     key,
+		query:   append([]float32(nil), query...),
+		k:       k,
+		results: append([]Neighbor(nil), results...),
+	}
+	if e, ok := c.entries[key]; ok {
+		// A colliding query, or the same query cached by a concurrent search.
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return results, nil
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*queryCacheEntry).key)
+	}
+	return results, nil
+}
+
+// Invalidate removes all cached results. It does nothing on a nil cache, so indexes can call it
+// whether or not the cache is enabled.
+func (c *QueryCache) Invalidate() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[uint64]*list.Element)
+	c.generation++
+}
+
+// Len returns the number of cached queries.
+func (c *QueryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// Hits returns the number of searches answered from the cache.
+func (c *QueryCache) Hits() int64 {
+	return c.hits.Load()
+}
+
+// Misses returns the number of searches that were not in the cache.
+func (c *QueryCache) Misses() int64 {
+	return c.misses.Load()
+}
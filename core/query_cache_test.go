@@ -0,0 +1,58 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryCache(t *testing.T) {
+	cache := NewQueryCache(2)
+	calls := 0
+	search := func(id int) func() ([]Neighbor, error) {
+		return func() ([]Neighbor, error) {
+			calls++
+			return []Neighbor{{ID: id, Distance: 1}}, nil
+		}
+	}
+	a, b, c := []float32{1, 0}, []float32{0, 1}, []float32{1, 1}
+
+	first, _ := cache.Search(a, 1, search(1))
+	second, _ := cache.Search(a, 1, search(2))
+	if calls != 1 || !reflect.DeepEqual(first, second) {
+		t.Fatalf("expected the second search to be answered from the cache, got %v after %v with %d calls",
+			second, first, calls)
+	}
+	second[0].ID = 9
+	if again, _ := cache.Search(a, 1, search(3)); again[0].ID != 1 {
+		t.Errorf("expected cached results to be copies, got %v", again)
+	}
+	cache.Search(a, 2, search(4))
+	if calls != 2 {
+		t.Errorf("expected another k to miss the cache, got %d calls", calls)
+	}
+
+	// a with k 2 is now the most recent entry, so caching c evicts a with k 1.
+	cache.Search(c, 1, search(5))
+	if cache.Len() != 2 {
+		t.Errorf("expected 2 cached queries, got %d", cache.Len())
+	}
+	cache.Search(a, 1, search(6))
+	if calls != 4 {
+		t.Errorf("expected the least recently used query to be evicted, got %d calls", calls)
+	}
+
+	// Results of a search that overlaps Invalidate are not cached.
+	cache.Search(b, 1, func() ([]Neighbor, error) {
+		cache.Invalidate()
+		return []Neighbor{{ID: 7}}, nil
+	})
+	if cache.Len() != 0 {
+		t.Errorf("expected Invalidate to empty the cache and the outdated result to be dropped, got %d entries",
+			cache.Len())
+	}
+	if cache.Hits() != 2 || cache.Misses() != 5 {
+		t.Errorf("expected 2 hits and 5 misses, got %d and %d", cache.Hits(), cache.Misses())
+	}
+	var disabled *QueryCache
+	disabled.Invalidate()
+}
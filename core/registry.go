@@ -0,0 +1,143 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// IndexConstructor creates an index from a configuration map.
+type IndexConstructor func(cfg map[string]any) (Index, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]IndexConstructor)
+)
+
+// RegisterIndex makes an index constructor available to NewIndex under the given kind.
+// Index packages call it from their init functions, so a package must be imported
+// (possibly with a blank import) before its kind can be constructed.
+// It panics if the kind is already registered.
+func RegisterIndex(kind string, ctor IndexConstructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[kind]; exists {
+		panic(fmt.Sprintf("index kind %q is already registered", kind))
+	}
+	registry[kind] = ctor
+}
+
+// NewIndex creates an index of the given kind (for example "hnsw", "pqivf", or "rpt")
+// from a configuration map. The parameters each kind expects are documented by the
+// registering package.
+func NewIndex(kind string, cfg map[string]any) (Index, error) {
+	registryMu.RLock()
+	ctor, ok := registry[kind]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown index kind %q (registered: %v)", kind, RegisteredIndexes())
+	}
+	return ctor(cfg)
+}
+
+// RegisteredIndexes returns the sorted names of all registered index kinds.
+func RegisteredIndexes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	kinds := make([]string, 0, len(registry))
+	for kind := range registry {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// IntParam reads a required positive integer parameter from a configuration map.
+// Integral float64 values (as produced by encoding/json) are accepted.
+func IntParam(cfg map[string]any, name string) (int, error) {
+	raw, ok := cfg[name]
+	if !ok {
+		return 0, fmt.Errorf("missing required parameter %q", name)
+	}
+	var v int
+	switch x := raw.(type) {
+	case int:
+		v = x
+	case int32:
+		v = int(x)
+	case int64:
+		v = int(x)
+	case float64:
+		if x != math.Trunc(x) {
+			return 0, fmt.Errorf("parameter %q must be an integer, got %v", name, x)
+		}
+		v = int(x)
+	default:
+		return 0, fmt.Errorf("parameter %q must be an integer, got %T", name, raw)
+	}
+	if v <= 0 {
+		return 0, fmt.Errorf("parameter %q must be positive, got %d", name, v)
+	}
+	return v, nil
+}
+
+// FloatParam reads a required non-negative floating-point parameter from a configuration map.
+func FloatParam(cfg map[string]any, name string) (float64, error) {
+	raw, ok := cfg[name]
+	if !ok {
+		return 0, fmt.Errorf("missing required parameter %q", name)
+	}
+	var v float64
+	switch x := raw.(type) {
+	case float64:
+		v = x
+	case float32:
+		v = float64(x)
+	case int:
+		v = float64(x)
+	default:
+		return 0, fmt.Errorf("parameter %q must be a number, got %T", name, raw)
+	}
+	if v < 0 || math.IsNaN(v) {
+		return 0, fmt.Errorf("parameter %q must be non-negative, got %v", name, v)
+	}
+	return v, nil
+}
+
+// DistanceParam reads an optional distance name from a configuration map and resolves it
+// using Distances. It defaults to "euclidean" if the parameter is absent.
+func DistanceParam(cfg map[string]any, name string) (DistanceFunc, string, error) {
+	distanceName := "euclidean"
+	if raw, ok := cfg[name]; ok {
+		s, ok := raw.(string)
+		if !ok {
+			return nil, "", fmt.Errorf("parameter %q must be a string, got %T", name, raw)
+		}
+		distanceName = s
+	}
+	distance, ok := Distances[distanceName]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown distance %q", distanceName)
+	}
+	return distance, distanceName, nil
+}
+
+// NormModeParam reads an optional normalization mode ("l2", "l1", or "max") from a configuration map.
+// The second result is false if the parameter is absent.
+func NormModeParam(cfg map[string]any, name string) (NormMode, bool, error) {
+	raw, ok := cfg[name]
+	if !ok {
+		return NormL2, false, nil
+	}
+	s, ok := raw.(string)
+	if !ok {
+		return NormL2, false, fmt.Errorf("parameter %q must be a string, got %T", name, raw)
+	}
+	for _, mode := range []NormMode{NormL2, NormL1, NormMax} {
+		if mode.String() == s {
+			return mode, true, nil
+		}
+	}
+	return NormL2, false, fmt.Errorf("unknown normalization mode %q", s)
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewIndexUnknownKind(t *testing.T) {
+	_, err := NewIndex("does-not-exist", nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown index kind") {
+		t.Errorf("expected unknown index kind error, got %v", err)
+	}
+}
+
+func TestIntParam(t *testing.T) {
+	cfg := map[string]any{"a": 3, "b": float64(4), "c": 1.5, "d": "x", "e": 0}
+	if v, err := IntParam(cfg, "a"); err != nil || v != 3 {
+		t.Errorf("IntParam(a) = %d, %v; want 3", v, err)
+	}
+	if v, err := IntParam(cfg, "b"); err != nil || v != 4 {
+		t.Errorf("IntParam(b) = %d, %v; want 4", v, err)
+	}
+	for _, name := range []string{"c", "d", "e", "missing"} {
+		if _, err := IntParam(cfg, name); err == nil {
+			t.Errorf("expected error for parameter %q, got none", name)
+		}
+	}
+}
+
+func TestDistanceParam(t *testing.T) {
+	if _, name, err := DistanceParam(map[string]any{}, "distance"); err != nil || name != "euclidean" {
+		t.Errorf("expected default euclidean distance, got %q, %v", name, err)
+	}
+	if _, _, err := DistanceParam(map[string]any{"distance": "nope"}, "distance"); err == nil {
+		t.Error("expected error for unknown distance, got none")
+	}
+}
+
+func TestNormModeParam(t *testing.T) {
+	if _, ok, err := NormModeParam(map[string]any{}, "normalize"); err != nil || ok {
+		t.Errorf("expected absent mode, got %v, %v", ok, err)
+	}
+	if mode, ok, err := NormModeParam(map[string]any{"normalize": "l1"}, "normalize"); err != nil || !ok || mode != NormL1 {
+		t.Errorf("expected l1 mode, got %v, %v, %v", mode, ok, err)
+	}
+	if _, _, err := NormModeParam(map[string]any{"normalize": "l3"}, "normalize"); err == nil {
+		t.Error("expected error for unknown mode, got none")
+	}
+}
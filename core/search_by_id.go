@@ -0,0 +1,32 @@
+package core
+
+import "fmt"
+
+// SearchByID returns the k nearest neighbors of the vector stored for id, excluding id itself.
+// The stored vector is used as is, so for cosine indexes it is the already normalized vector.
+// It returns an error if the id is not found.
+func SearchByID(index Index, id int, k int) ([]Neighbor, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("%w: k must be positive, got %d", ErrInvalidK, k)
+	}
+	vec, err := index.GetVector(id)
+	if err != nil {
+		return nil, err
+	}
+	// Ask for one extra result since the stored vector normally finds itself.
+	neighbors, err := index.Search(vec, k+1)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Neighbor, 0, k)
+	for _, n := range neighbors {
+		if n.ID == id {
+			continue
+		}
+		if len(results) == k {
+			break
+		}
+		results = append(results, n)
+	}
+	return results, nil
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// SearchCandidateFactor is the number of candidates fetched per requested neighbor by SearchWithOptions
+// when results are filtered or re-ranked, before the search is widened.
+var SearchCandidateFactor = 4
+
+// SearchOptions adjusts a single search. Each index honors the options that apply to it and ignores the
+// rest, and the zero value searches like Search.
+type SearchOptions struct {
+	Ef         int               // HNSW: beam width of the base layer search, or 0 for the index's Ef.
+	NProbe     int               // PQIVF: number of nearest clusters to scan, or 0 for the index default.
+	Filter     func(id int) bool // only ids for which Filter returns true are returned, or all if nil.
+	RankMetric DistanceFunc      // re-ranks the candidates and reports distances by this metric, if set.
+	ExcludeIDs []int             // ids that are never returned.
+}
+
+// SearchWithOptions implements the options of SearchWith that all indexes share on top of an index's own
+// search. search returns the n nearest neighbors with the index-specific options such as Ef or NProbe
+// applied, count is the number of vectors in the index, and vector returns the stored vector of an id.
+// Without Filter, ExcludeIDs, and RankMetric it returns search(k). Otherwise it fetches
+// SearchCandidateFactor*k candidates and doubles that while fewer than k of them pass Filter and
+// ExcludeIDs and more vectors remain, then re-ranks the remaining candidates by RankMetric and returns
+// the k best. Results outside the fetched candidates are never considered, so a RankMetric that
+// disagrees strongly with the index metric needs a larger SearchCandidateFactor.
+func SearchWithOptions(query []float32, k int, opts SearchOptions, count int,
+	search func(n int) ([]Neighbor, error), vector func(id int) ([]float32, error)) ([]Neighbor, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("%w: k must be positive, got %d", ErrInvalidK, k)
+	}
+	if opts.Filter == nil && len(opts.ExcludeIDs) == 0 && opts.RankMetric == nil {
+		return search(k)
+	}
+	excluded := make(map[int]bool, len(opts.ExcludeIDs))
+	for _, id := range opts.ExcludeIDs {
+		excluded[id] = true
+	}
+	factor := SearchCandidateFactor
+	if factor < 1 {
+		factor = 1
+	}
+	fetch := factor * k
+	var results []Neighbor
+	for {
+		if fetch > count {
+			fetch = count
+		}
+		if fetch == 0 {
+			// Let the index report the empty index.
+			fetch = k
+		}
+		candidates, err := search(fetch)
+		if err != nil {
+			return nil, err
+		}
+		results = make([]Neighbor, 0, len(candidates))
+		for _, c := range candidates {
+			if excluded[c.ID] || (opts.Filter != nil && !opts.Filter(c.ID)) {
+				continue
+			}
+			results = append(results, c)
+		}
+		if len(results) >= k || fetch >= count {
+			break
+		}
+		fetch *= 2
+	}
+
+	if opts.RankMetric != nil {
+		ranked := results[:0]
+		for _, r := range results {
+			vec, err := vector(r.ID)
+			if errors.Is(err, ErrNotFound) {
+				// Deleted after the search returned it.
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			ranked = append(ranked, Neighbor{ID: r.ID, Distance: opts.RankMetric(query, vec)})
+		}
+		results = ranked
+		sort.Slice(results, func(i, j int) bool {
+			if results[i].Distance == results[j].Distance {
+				return results[i].ID < results[j].ID
+			}
+			return results[i].Distance < results[j].Distance
+		})
+	}
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
@@ -0,0 +1,63 @@
+package core
+
+import "fmt"
+
+// SelectK reorders neighbors in place so that the first k elements are the k with the smallest
+// distances and returns them. The order within the returned slice is unspecified, and ties at the
+// k-th distance are broken arbitrarily. It runs in expected linear time, which is cheaper than a
+// full sort when only the set of nearest neighbors is needed. If k >= len(neighbors), the slice is
+// returned unchanged.
+func SelectK(neighbors []Neighbor, k int) []Neighbor {
+	if k >= len(neighbors) {
+		return neighbors
+	}
+	if k <= 0 {
+		return neighbors[:0]
+	}
+	lo, hi := 0, len(neighbors)
+	for hi-lo > 1 {
+		pivot := neighbors[lo+(hi-lo)/2].Distance
+		// Three-way partition of [lo, hi) into < pivot, == pivot, and > pivot.
+		lt, i, gt := lo, lo, hi
+		for i < gt {
+			switch d := neighbors[i].Distance; {
+			case d < pivot:
+				neighbors[lt], neighbors[i] = neighbors[i], neighbors[lt]
+				lt++
+				i++
+			case d > pivot:
+				gt--
+				neighbors[i], neighbors[gt] = neighbors[gt], neighbors[i]
+			default:
+				i++
+			}
+		}
+		switch {
+		case k < lt:
+			hi = lt
+		case k > gt:
+			lo = gt
+		default:
+			return neighbors[:k]
+		}
+	}
+	return neighbors[:k]
+}
+
+// KthNeighborDistance returns the distance to the k-th nearest neighbor among the k nearest neighbors
+// in results, which may be in any order. It returns an error if results holds fewer than k neighbors.
+func KthNeighborDistance(results []Neighbor, k int) (float64, error) {
+	if k <= 0 {
+		return 0, fmt.Errorf("%w: k must be positive, got %d", ErrInvalidK, k)
+	}
+	if len(results) < k {
+		return 0, fmt.Errorf("%w: k=%d exceeds the %d neighbors found", ErrInvalidK, k, len(results))
+	}
+	kth := results[0].Distance
+	for _, n := range results[1:k] {
+		if n.Distance > kth {
+			kth = n.Distance
+		}
+	}
+	return kth, nil
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSelectK(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 50; trial++ {
+		n := 1 + rng.Intn(200)
+		neighbors := make([]Neighbor, n)
+		for i := range neighbors {
+			// Use few distinct values to exercise duplicate handling.
+			neighbors[i] = Neighbor{ID: i, Distance: float64(rng.Intn(20))}
+		}
+		sorted := make([]Neighbor, n)
+		copy(sorted, neighbors)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Distance < sorted[j].Distance })
+
+		k := rng.Intn(n + 2)
+		selected := SelectK(neighbors, k)
+		want := k
+		if want > n {
+			want = n
+		}
+		if len(selected) != want {
+			t.Fatalf("expected %d neighbors, got %d", want, len(selected))
+		}
+		if want == 0 {
+			continue
+		}
+		// The selected distances must be exactly the smallest ones.
+		got := make([]float64, want)
+		for i, nb := range selected {
+			got[i] = nb.Distance
+		}
+		sort.Float64s(got)
+		for i := range got {
+			if got[i] != sorted[i].Distance {
+				t.Fatalf("k=%d: distance %d is %f, want %f", k, i, got[i], sorted[i].Distance)
+			}
+		}
+	}
+}
+
+func TestKthNeighborDistance(t *testing.T) {
+	results := []Neighbor{{ID: 1, Distance: 0.5}, {ID: 2, Distance: 2}, {ID: 3, Distance: 1}}
+	if d, err := KthNeighborDistance(results, 3); err != nil || d != 2 {
+		t.Errorf("KthNeighborDistance = %f, %v; want 2", d, err)
+	}
+	if d, err := KthNeighborDistance(results, 1); err != nil || d != 0.5 {
+		t.Errorf("KthNeighborDistance for k=1 = %f, %v; want 0.5", d, err)
+	}
+	if _, err := KthNeighborDistance(results, 4); !errors.Is(err, ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK, got %v", err)
+	}
+}
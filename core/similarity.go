@@ -0,0 +1,95 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// ScoredNeighbor holds a neighbor's id and its similarity score to the query.
+type ScoredNeighbor struct {
+	ID    int     // the identifier of the neighbor.
+	Score float64 // the similarity to the query (higher is more similar).
+}
+
+// inverseDistance maps a non-negative distance to 1 / (1 + distance), which lies in (0, 1].
+func inverseDistance(distance float64) float64 {
+	return 1 / (1 + distance)
+}
+
+var (
+	conversionsMu sync.RWMutex
+	conversions   = map[string]func(distance float64) float64{ // metric name to its similarity conversion
+		"cosine":            func(distance float64) float64 { return 1 - distance },
+		"euclidean":         inverseDistance,
+		"squared_euclidean": inverseDistance,
+		"manhattan":         inverseDistance,
+	}
+)
+
+// RegisterSimilarityConversion makes DistanceToSimilarity, and with it SearchWithSimilarity, convert
+// distances of the named metric with conv, so that indexes using a custom distance function under that
+// DistanceName can report similarity scores. conv should decrease as the distance grows.
+// Only DistanceToSimilarity uses the conversion: SimilarityToDistance, and the searches built on it
+// such as HNSW's SearchAboveSimilarity, still support only the built-in metrics.
+// It panics if the metric already has a conversion, including the built-in ones.
+func RegisterSimilarityConversion(metricName string, conv func(distance float64) float64) {
+	conversionsMu.Lock()
+	defer conversionsMu.Unlock()
+	if _, exists := conversions[metricName]; exists {
+		panic(fmt.Sprintf("similarity conversion for distance %q is already registered", metricName))
+	}
+	conversions[metricName] = conv
+}
+
+// DistanceToSimilarity converts a distance computed with the named metric into a similarity score.
+// Cosine distances are mapped to 1 - distance; the other built-in metrics are mapped to
+// 1 / (1 + distance), which lies in (0, 1]. Other metrics use the conversion registered with
+// RegisterSimilarityConversion. It returns an error for metrics without a conversion.
+func DistanceToSimilarity(metric string, distance float64) (float64, error) {
+	conversionsMu.RLock()
+	conv, ok := conversions[metric]
+	conversionsMu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("no similarity conversion for distance %q", metric)
+	}
+	return conv(distance), nil
+}
+
+// SimilarityToDistance converts a similarity score into a distance of the named metric, the inverse of
+// DistanceToSimilarity. For the metrics mapped to 1 / (1 + distance), similarities at or below 0 are
+// below every score and map to an infinite distance. It returns an error for unknown metrics.
+func SimilarityToDistance(metric string, similarity float64) (float64, error) {
+	switch metric {
+	case "cosine":
+		return 1 - similarity, nil
+	case "euclidean", "squared_euclidean", "manhattan":
+		if similarity <= 0 {
+			return math.Inf(1), nil
+		}
+		return 1/similarity - 1, nil
+	default:
+		return 0, fmt.Errorf("no similarity conversion for distance %q", metric)
+	}
+}
+
+// SearchWithSimilarity searches the index and returns the k nearest neighbors with similarity scores
+// instead of distances. The conversion is selected by the distance name reported by the index's Stats,
+// which is the index's DistanceName, among the built-in and registered conversions.
+// Results are ordered by descending score.
+func SearchWithSimilarity(index Index, query []float32, k int) ([]ScoredNeighbor, error) {
+	metric := index.Stats().Distance
+	neighbors, err := index.Search(query, k)
+	if err != nil {
+		return nil, err
+	}
+	scored := make([]ScoredNeighbor, len(neighbors))
+	for i, n := range neighbors {
+		score, err := DistanceToSimilarity(metric, n.Distance)
+		if err != nil {
+			return nil, err
+		}
+		scored[i] = ScoredNeighbor{ID: n.ID, Score: score}
+	}
+	return scored, nil
+}
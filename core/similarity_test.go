@@ -0,0 +1,47 @@
+package core_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+func TestRegisterSimilarityConversion(t *testing.T) {
+	// Chebyshev distance, with similarity decaying exponentially in the distance.
+	chebyshev := func(a, b []float32) float64 {
+		var d float64
+		for i := range a {
+			d = math.Max(d, math.Abs(float64(a[i]-b[i])))
+		}
+		return d
+	}
+	// Conversions can't be unregistered, so a repeated run (-count) finds it registered already.
+	if _, err := core.DistanceToSimilarity("chebyshev", 1); err != nil {
+		core.RegisterSimilarityConversion("chebyshev", func(distance float64) float64 { return math.Exp(-distance) })
+	}
+
+	index := hnsw.NewHNSW(2, 4, 10, chebyshev, "chebyshev")
+	vectors := map[int][]float32{1: {0, 0}, 2: {1, 3}, 3: {2, -1}, 4: {-4, 0}}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	scored, err := core.SearchWithSimilarity(index, []float32{0, 0}, 4)
+	if err != nil {
+		t.Fatalf("SearchWithSimilarity failed: %v", err)
+	}
+	want := []core.ScoredNeighbor{{ID: 1, Score: 1}, {ID: 3, Score: math.Exp(-2)}, {ID: 2, Score: math.Exp(-3)},
+		{ID: 4, Score: math.Exp(-4)}}
+	if !reflect.DeepEqual(scored, want) {
+		t.Errorf("expected %v, got %v", want, scored)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a built-in metric again to panic")
+		}
+	}()
+	core.RegisterSimilarityConversion("euclidean", func(distance float64) float64 { return -distance })
+}
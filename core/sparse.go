@@ -0,0 +1,184 @@
+package core
+
+import (
+	"fmt"
+	"math"
+)
+
+// SparseVector holds the non-zero entries of a vector. Indices must be strictly increasing and
+// Values holds the entry for each index.
+type SparseVector struct {
+	Indices []int
+	Values  []float32
+}
+
+// NewSparseVector returns the sparse form of a dense vector, keeping only its non-zero entries.
+func NewSparseVector(dense []float32) SparseVector {
+	var s SparseVector
+	for i, v := range dense {
+		if v != 0 {
+			s.Indices = append(s.Indices, i)
+			s.Values = append(s.Values, v)
+		}
+	}
+	return s
+}
+
+// Dense returns the vector as a dense vector of the given dimension.
+// It returns an error if the indices are not strictly increasing or fall outside the dimension.
+func (s SparseVector) Dense(dim int) ([]float32, error) {
+	if len(s.Indices) != len(s.Values) {
+		return nil, fmt.Errorf("sparse vector has %d indices but %d values", len(s.Indices), len(s.Values))
+	}
+	dense := make([]float32, dim)
+	prev := -1
+	for i, idx := range s.Indices {
+		if idx <= prev {
+			return nil, fmt.Errorf("sparse vector indices must be strictly increasing, got %d after %d", idx, prev)
+		}
+		if idx >= dim {
+			return nil, fmt.Errorf("%w: sparse index %d is out of range for dimension %d",
+				ErrDimensionMismatch, idx, dim)
+		}
+		dense[idx] = s.Values[i]
+		prev = idx
+	}
+	return dense, nil
+}
+
+// checkLengths panics if the vector doesn't hold one value per index, which the distance functions
+// can't report as an error.
+func (s SparseVector) checkLengths() {
+	if len(s.Indices) != len(s.Values) {
+		panic(fmt.Sprintf("sparse vector has %d indices but %d values", len(s.Indices), len(s.Values)))
+	}
+}
+
+// sparseDot returns the dot product of a and b and their squared norms, walking both index lists once.
+func sparseDot(a, b SparseVector) (dot, normA, normB float64) {
+	a.checkLengths()
+	b.checkLengths()
+	i, j := 0, 0
+	for i < len(a.Indices) && j < len(b.Indices) {
+		switch {
+		case a.Indices[i] == b.Indices[j]:
+			dot += float64(a.Values[i]) * float64(b.Values[j])
+			normA += float64(a.Values[i]) * float64(a.Values[i])
+			normB += float64(b.Values[j]) * float64(b.Values[j])
+			i++
+			j++
+		case a.Indices[i] < b.Indices[j]:
+			normA += float64(a.Values[i]) * float64(a.Values[i])
+			i++
+		default:
+			normB += float64(b.Values[j]) * float64(b.Values[j])
+			j++
+		}
+	}
+	for ; i < len(a.Values); i++ {
+		normA += float64(a.Values[i]) * float64(a.Values[i])
+	}
+	for ; j < len(b.Values); j++ {
+		normB += float64(b.Values[j]) * float64(b.Values[j])
+	}
+	return dot, normA, normB
+}
+
+// SparseEuclidean computes the Euclidean distance between two sparse vectors.
+// It sums the squared differences in index order, so it gives the same result as Euclidean on their
+// dense forms. It panics if a vector doesn't have as many values as indices.
+func SparseEuclidean(a, b SparseVector) float64 {
+	a.checkLengths()
+	b.checkLengths()
+	sum := 0.0
+	i, j := 0, 0
+	for i < len(a.Indices) && j < len(b.Indices) {
+		switch {
+		case a.Indices[i] == b.Indices[j]:
+			d := float64(a.Values[i] - b.Values[j])
+			sum += d * d
+			i++
+			j++
+		case a.Indices[i] < b.Indices[j]:
+			sum += float64(a.Values[i]) * float64(a.Values[i])
+			i++
+		default:
+			sum += float64(b.Values[j]) * float64(b.Values[j])
+			j++
+		}
+	}
+	for ; i < len(a.Values); i++ {
+		sum += float64(a.Values[i]) * float64(a.Values[i])
+	}
+	for ; j < len(b.Values); j++ {
+		sum += float64(b.Values[j]) * float64(b.Values[j])
+	}
+	return math.Sqrt(sum)
+}
+
+// SparseCosine computes the cosine distance (1 - cosine similarity) between two sparse vectors.
+// If either vector has zero norm, the distance is 1. It panics if a vector doesn't have as many values
+// as indices.
+func SparseCosine(a, b SparseVector) float64 {
+	dot, normA, normB := sparseDot(a, b)
+	if normA == 0 || normB == 0 {
+		return 1
+	}
+	return 1 - dot/(math.Sqrt(normA)*math.Sqrt(normB))
+}
+
+// SparseIndex adapts an index so that it can be built and queried with sparse vectors.
+// Sparse vectors are densified to the dimension of the wrapped index before they are passed on.
+type SparseIndex struct {
+	Index
+}
+
+// NewSparseIndex wraps an index so that it accepts sparse vectors.
+func NewSparseIndex(index Index) *SparseIndex {
+	return &SparseIndex{Index: index}
+}
+
+// dense converts a sparse vector to the dimension of the wrapped index.
+func (s *SparseIndex) dense(vector SparseVector) ([]float32, error) {
+	return vector.Dense(s.Index.Stats().Dimension)
+}
+
+// AddSparse inserts a sparse vector with the given id.
+func (s *SparseIndex) AddSparse(id int, vector SparseVector) error {
+	dense, err := s.dense(vector)
+	if err != nil {
+		return fmt.Errorf("id %d: %w", id, err)
+	}
+	return s.Index.Add(id, dense)
+}
+
+// BulkAddSparse inserts multiple sparse vectors.
+func (s *SparseIndex) BulkAddSparse(vectors map[int]SparseVector) error {
+	dense := make(map[int][]float32, len(vectors))
+	for id, vector := range vectors {
+		d, err := s.dense(vector)
+		if err != nil {
+			return fmt.Errorf("id %d: %w", id, err)
+		}
+		dense[id] = d
+	}
+	return s.Index.BulkAdd(dense)
+}
+
+// UpdateSparse replaces the vector of the given id with a sparse vector.
+func (s *SparseIndex) UpdateSparse(id int, vector SparseVector) error {
+	dense, err := s.dense(vector)
+	if err != nil {
+		return fmt.Errorf("id %d: %w", id, err)
+	}
+	return s.Index.Update(id, dense)
+}
+
+// SearchSparse returns the k nearest neighbors of a sparse query vector.
+func (s *SparseIndex) SearchSparse(query SparseVector, k int) ([]Neighbor, error) {
+	dense, err := s.dense(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.Index.Search(dense, k)
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestSparseDistancesMatchDense(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	dim := 50
+	randomSparse := func() []float32 {
+		v := make([]float32, dim)
+		for i := range v {
+			if r.Float32() < 0.1 {
+				v[i] = r.Float32()*2 - 1
+			}
+		}
+		return v
+	}
+	for trial := 0; trial < 100; trial++ {
+		a, b := randomSparse(), randomSparse()
+		sa, sb := NewSparseVector(a), NewSparseVector(b)
+		if got, want := SparseEuclidean(sa, sb), Euclidean(a, b); math.Abs(got-want) > 1e-6 {
+			t.Errorf("SparseEuclidean = %f; want %f", got, want)
+		}
+		if got, want := SparseCosine(sa, sb), Cosine(a, b); math.Abs(got-want) > 1e-6 {
+			t.Errorf("SparseCosine = %f; want %f", got, want)
+		}
+	}
+	if d := SparseEuclidean(SparseVector{}, SparseVector{}); d != 0 {
+		t.Errorf("SparseEuclidean of empty vectors = %f; want 0", d)
+	}
+	if d := SparseCosine(SparseVector{}, NewSparseVector([]float32{1})); d != 1 {
+		t.Errorf("SparseCosine with a zero vector = %f; want 1", d)
+	}
+}
+
+func TestSparseVector_Dense(t *testing.T) {
+	s := SparseVector{Indices: []int{1, 3}, Values: []float32{2, 4}}
+	dense, err := s.Dense(5)
+	if err != nil {
+		t.Fatalf("Dense failed: %v", err)
+	}
+	want := []float32{0, 2, 0, 4, 0}
+	for i := range want {
+		if dense[i] != want[i] {
+			t.Fatalf("Dense = %v; want %v", dense, want)
+		}
+	}
+	if _, err := s.Dense(3); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch for out of range index, got %v", err)
+	}
+	unsorted := SparseVector{Indices: []int{3, 1}, Values: []float32{4, 2}}
+	if _, err := unsorted.Dense(5); err == nil {
+		t.Error("expected error for unsorted indices, got none")
+	}
+}
+
+func TestSparseEuclidean_NearbyVectors(t *testing.T) {
+	// Expanding the distance into norms and a dot product loses the small differences next to a large
+	// shared component to rounding.
+	a := []float32{1e6, 0.001, 0}
+	b := []float32{1e6, 0.002, 0.003}
+	if got, want := SparseEuclidean(NewSparseVector(a), NewSparseVector(b)), Euclidean(a, b); got != want {
+		t.Errorf("SparseEuclidean = %g; want %g", got, want)
+	}
+}
+
+func TestSparseDistances_MismatchedLengths(t *testing.T) {
+	bad := SparseVector{Indices: []int{0}, Values: []float32{1, 2, 3}}
+	good := NewSparseVector([]float32{1, 2, 3})
+	for name, distance := range map[string]func(a, b SparseVector) float64{
+		"SparseEuclidean": SparseEuclidean,
+		"SparseCosine":    SparseCosine,
+	} {
+		func() {
+			defer func() {
+				if msg, ok := recover().(string); !ok || !strings.Contains(msg, "1 indices but 3 values") {
+					t.Errorf("%s: expected a panic for mismatched indices and values, got %v", name, msg)
+				}
+			}()
+			distance(good, bad)
+		}()
+	}
+}
@@ -0,0 +1,84 @@
+package core
+
+import (
+	"math"
+	"sync"
+)
+
+// Stats accumulates the running per-dimension mean and variance of a stream of vectors using
+// Welford's algorithm, so it never stores the vectors themselves. It is safe for concurrent use.
+// Indexes update it on insertion when one is assigned to their VectorStats field.
+type Stats struct {
+	mu    sync.Mutex
+	count int
+	mean  []float64
+	m2    []float64 // sum of squared deviations from the mean
+}
+
+// NewStats creates an empty accumulator for vectors of the given dimension.
+func NewStats(dimension int) *Stats {
+	return &Stats{
+		mean: make([]float64, dimension),
+		m2:   make([]float64, dimension),
+	}
+}
+
+// Update adds a vector to the running statistics. Vectors whose length differs from the
+// accumulator dimension are ignored, since the indexes reject them anyway.
+func (s *Stats) Update(vec []float32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(vec) != len(s.mean) {
+		return
+	}
+	s.count++
+	n := float64(s.count)
+	for i, v := range vec {
+		x := float64(v)
+		delta := x - s.mean[i]
+		s.mean[i] += delta / n
+		s.m2[i] += delta * (x - s.mean[i])
+	}
+}
+
+// Count returns the number of vectors seen.
+func (s *Stats) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Mean returns the per-dimension mean of the vectors seen, or zeros if none were seen.
+func (s *Stats) Mean() []float32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mean := make([]float32, len(s.mean))
+	for i, m := range s.mean {
+		mean[i] = float32(m)
+	}
+	return mean
+}
+
+// Variance returns the per-dimension population variance of the vectors seen,
+// or zeros if no vectors were seen.
+func (s *Stats) Variance() []float32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	variance := make([]float32, len(s.m2))
+	if s.count == 0 {
+		return variance
+	}
+	for i, m2 := range s.m2 {
+		variance[i] = float32(m2 / float64(s.count))
+	}
+	return variance
+}
+
+// StdDev returns the per-dimension population standard deviation of the vectors seen.
+func (s *Stats) StdDev() []float32 {
+	stddev := s.Variance()
+	for i, v := range stddev {
+		stddev[i] = float32(math.Sqrt(float64(v)))
+	}
+	return stddev
+}
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	s := NewStats(2)
+	for _, vec := range [][]float32{{1, 10}, {2, 20}, {3, 30}, {6, 40}} {
+		s.Update(vec)
+	}
+	s.Update([]float32{1, 2, 3}) // wrong dimension, ignored
+
+	if s.Count() != 4 {
+		t.Fatalf("expected count 4, got %d", s.Count())
+	}
+	// Dimension 0: mean 3, squared deviations 4+1+0+9 = 14, variance 3.5.
+	// Dimension 1: mean 25, squared deviations 225+25+25+225 = 500, variance 125.
+	wantMean := []float64{3, 25}
+	wantVar := []float64{3.5, 125}
+	mean, variance, stddev := s.Mean(), s.Variance(), s.StdDev()
+	for i := range wantMean {
+		if math.Abs(float64(mean[i])-wantMean[i]) > 1e-6 {
+			t.Errorf("dim %d: expected mean %v, got %v", i, wantMean[i], mean[i])
+		}
+		if math.Abs(float64(variance[i])-wantVar[i]) > 1e-5 {
+			t.Errorf("dim %d: expected variance %v, got %v", i, wantVar[i], variance[i])
+		}
+		if math.Abs(float64(stddev[i])-math.Sqrt(wantVar[i])) > 1e-5 {
+			t.Errorf("dim %d: expected stddev %v, got %v", i, math.Sqrt(wantVar[i]), stddev[i])
+		}
+	}
+}
+
+func TestStats_Empty(t *testing.T) {
+	s := NewStats(3)
+	for i, v := range s.StdDev() {
+		if v != 0 {
+			t.Errorf("dim %d: expected zero stddev for empty stats, got %v", i, v)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package core
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+)
+
+// QuerySeed derives a tie-break seed from a query vector, so the same query always gets the same seed.
+func QuerySeed(query []float32) uint64 {
+	h := fnv.New64a()
+	var buf [4]byte
+	for _, v := range query {
+		bits := math.Float32bits(v)
+		buf[0], buf[1], buf[2], buf[3] = byte(bits), byte(bits>>8), byte(bits>>16), byte(bits>>24)
+		h.Write(buf[:])
+	}
+	return h.Sum64()
+}
+
+// TieRank returns the pseudo-random rank of an id for a seed. Ordering equal-distance neighbors by
+// their rank instead of their id gives an order that is stable for a seed but not biased toward low ids.
+func TieRank(seed uint64, id int) uint64 {
+	// SplitMix64 finalizer.
+	z := seed ^ uint64(id)
+	z += 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// ShuffleTies sorts results by distance and orders neighbors with equal distances by TieRank for the
+// seed, for example QuerySeed of the query, instead of by id.
+func ShuffleTies(results []Neighbor, seed uint64) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance == results[j].Distance {
+			return TieRank(seed, results[i].ID) < TieRank(seed, results[j].ID)
+		}
+		return results[i].Distance < results[j].Distance
+	})
+}
@@ -0,0 +1,42 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShuffleTies(t *testing.T) {
+	results := make([]Neighbor, 0, 21)
+	for id := 0; id < 20; id++ {
+		results = append(results, Neighbor{ID: id, Distance: 1})
+	}
+	results = append(results, Neighbor{ID: 99, Distance: 0.5})
+
+	seed := QuerySeed([]float32{0.1, 0.2})
+	ShuffleTies(results, seed)
+	if results[0].ID != 99 {
+		t.Fatalf("expected the nearest neighbor first, got %+v", results[0])
+	}
+	ascending := true
+	for i := 2; i < len(results); i++ {
+		if results[i].ID < results[i-1].ID {
+			ascending = false
+		}
+	}
+	if ascending {
+		t.Error("expected ties in a shuffled order, got ascending ids")
+	}
+
+	again := make([]Neighbor, len(results))
+	copy(again, results)
+	ShuffleTies(again, seed)
+	if !reflect.DeepEqual(again, results) {
+		t.Errorf("expected the same order for the same seed, got %v and %v", again, results)
+	}
+	if QuerySeed([]float32{0.1, 0.2}) != seed {
+		t.Error("expected the same seed for the same query")
+	}
+	if QuerySeed([]float32{0.1, 0.3}) == seed {
+		t.Error("expected different seeds for different queries")
+	}
+}
@@ -0,0 +1,296 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Operations recorded in the write-ahead log.
+const (
+	walAdd    byte = 1
+	walDelete byte = 2
+	walUpdate byte = 3
+)
+
+// walHeaderSize is the size of a record header: the payload length and its CRC-32 checksum.
+const walHeaderSize = 8
+
+// WALIndex wraps an index and appends a record to a write-ahead log for every Add, Delete, and Update,
+// so that changes made since the last snapshot survive a crash. Bulk operations log one record per vector.
+// A record is appended after the change has been applied to the wrapped index.
+// The wrapped index should only be modified through the WALIndex so that the log stays complete.
+type WALIndex struct {
+	Index
+	SnapshotPath string // file Checkpoint saves the wrapped index to
+	SyncWrites   bool   // sync the log to disk after every operation, not only on Checkpoint and Close
+
+	mu   sync.Mutex
+	file *os.File // open log, or nil if the log is disabled
+}
+
+// NewWALIndex wraps an index whose snapshots are saved to snapshotPath. Logging starts with EnableWAL.
+func NewWALIndex(index Index, snapshotPath string) *WALIndex {
+	return &WALIndex{Index: index, SnapshotPath: snapshotPath}
+}
+
+// EnableWAL opens the log at path, creating it if needed, and appends all further changes to it.
+func (w *WALIndex) EnableWAL(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file = f
+	return nil
+}
+
+// encodeWALRecord appends a record for one operation to buf.
+func encodeWALRecord(buf *bytes.Buffer, op byte, id int, vector []float32) {
+	payload := make([]byte, 1+8+4+4*len(vector))
+	payload[0] = op
+	binary.LittleEndian.PutUint64(payload[1:], uint64(int64(id)))
+	binary.LittleEndian.PutUint32(payload[9:], uint32(len(vector)))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(payload[13+4*i:], math.Float32bits(v))
+	}
+	var header [walHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+	buf.Write(header[:])
+	buf.Write(payload)
+}
+
+// appendRecords writes the records in buf to the log in a single write. The caller must hold mu.
+func (w *WALIndex) appendRecords(buf *bytes.Buffer) error {
+	if w.file == nil || buf.Len() == 0 {
+		return nil
+	}
+	if _, err := w.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to append to write-ahead log: %w", err)
+	}
+	if w.SyncWrites {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync write-ahead log: %w", err)
+		}
+	}
+	return nil
+}
+
+// logVectors logs one record per vector in ascending id order. The caller must hold mu.
+func (w *WALIndex) logVectors(op byte, vectors map[int][]float32) error {
+	var buf bytes.Buffer
+	for _, id := range sortedKeys(vectors) {
+		encodeWALRecord(&buf, op, id, vectors[id])
+	}
+	return w.appendRecords(&buf)
+}
+
+// sortedKeys returns the ids of vectors in ascending order.
+func sortedKeys(vectors map[int][]float32) []int {
+	ids := make([]int, 0, len(vectors))
+	for id := range vectors {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Add inserts a vector and logs it.
+func (w *WALIndex) Add(id int, vector []float32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.Index.Add(id, vector); err != nil {
+		return err
+	}
+	return w.logVectors(walAdd, map[int][]float32{id: vector})
+}
+
+// BulkAdd inserts vectors and logs them.
+func (w *WALIndex) BulkAdd(vectors map[int][]float32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.Index.BulkAdd(vectors); err != nil {
+		return err
+	}
+	return w.logVectors(walAdd, vectors)
+}
+
+// Delete removes a vector and logs the deletion.
+func (w *WALIndex) Delete(id int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.Index.Delete(id); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	encodeWALRecord(&buf, walDelete, id, nil)
+	return w.appendRecords(&buf)
+}
+
+// BulkDelete removes vectors and logs the deletions.
+func (w *WALIndex) BulkDelete(ids []int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.Index.BulkDelete(ids); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, id := range ids {
+		encodeWALRecord(&buf, walDelete, id, nil)
+	}
+	return w.appendRecords(&buf)
+}
+
+// Update modifies a vector and logs the new vector.
+func (w *WALIndex) Update(id int, vector []float32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.Index.Update(id, vector); err != nil {
+		return err
+	}
+	return w.logVectors(walUpdate, map[int][]float32{id: vector})
+}
+
+// BulkUpdate modifies vectors and logs the new vectors.
+func (w *WALIndex) BulkUpdate(updates map[int][]float32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.Index.BulkUpdate(updates); err != nil {
+		return err
+	}
+	return w.logVectors(walUpdate, updates)
+}
+
+// ReplayWAL applies the records of the log at path to the wrapped index without logging them again.
+// It is meant to be called on startup after loading the last snapshot. Replay is idempotent: an added
+// vector that already exists is updated, and deleting or updating a missing vector is skipped, so a log
+// that was not truncated after its snapshot was saved replays cleanly. A partially written record at
+// the end of the log, left by a crash during an append, is ignored.
+func (w *WALIndex) ReplayWAL(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open write-ahead log: %w", err)
+	}
+	defer f.Close()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	r := bufio.NewReader(f)
+	var header [walHeaderSize]byte
+	for n := 0; ; n++ {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				log.Warn().Msgf("Ignoring partial record %d at the end of the write-ahead log", n)
+				return nil
+			}
+			return fmt.Errorf("failed to read write-ahead log record %d: %w", n, err)
+		}
+		payload := make([]byte, binary.LittleEndian.Uint32(header[0:]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				log.Warn().Msgf("Ignoring partial record %d at the end of the write-ahead log", n)
+				return nil
+			}
+			return fmt.Errorf("failed to read write-ahead log record %d: %w", n, err)
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:]) {
+			return fmt.Errorf("write-ahead log record %d is corrupt: checksum mismatch", n)
+		}
+		if err := w.applyRecord(payload); err != nil {
+			return fmt.Errorf("failed to replay write-ahead log record %d: %w", n, err)
+		}
+	}
+}
+
+// applyRecord applies one decoded log record to the wrapped index. The caller must hold mu.
+func (w *WALIndex) applyRecord(payload []byte) error {
+	if len(payload) < 13 {
+		return fmt.Errorf("record of %d bytes is too short", len(payload))
+	}
+	op := payload[0]
+	id := int(int64(binary.LittleEndian.Uint64(payload[1:])))
+	dim := int(binary.LittleEndian.Uint32(payload[9:]))
+	if len(payload) != 13+4*dim {
+		return fmt.Errorf("record of %d bytes does not hold a vector of dimension %d", len(payload), dim)
+	}
+	vector := make([]float32, dim)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(payload[13+4*i:]))
+	}
+
+	switch op {
+	case walAdd:
+		err := w.Index.Add(id, vector)
+		if errors.Is(err, ErrDuplicateID) {
+			err = w.Index.Update(id, vector)
+		}
+		return err
+	case walDelete:
+		if err := w.Index.Delete(id); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	case walUpdate:
+		if err := w.Index.Update(id, vector); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operation %d", op)
+}
+
+// Checkpoint saves a snapshot of the wrapped index to SnapshotPath and truncates the log,
+// since all logged changes are now part of the snapshot.
+func (w *WALIndex) Checkpoint() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := SaveFile(w.Index, w.SnapshotPath); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	if w.file == nil {
+		return nil
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate write-ahead log: %w", err)
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// Close syncs and closes the log and then closes the wrapped index.
+func (w *WALIndex) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		syncErr := w.file.Sync()
+		closeErr := w.file.Close()
+		w.file = nil
+		if syncErr != nil {
+			return fmt.Errorf("failed to sync write-ahead log: %w", syncErr)
+		}
+		if closeErr != nil {
+			return fmt.Errorf("failed to close write-ahead log: %w", closeErr)
+		}
+	}
+	return w.Index.Close()
+}
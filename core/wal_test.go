@@ -0,0 +1,90 @@
+package core_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+func TestWALIndex_ReplayAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	walPath := filepath.Join(dir, "index.wal")
+	snapshotPath := filepath.Join(dir, "index.gob")
+	dim := 4
+	newIndex := func() *core.WALIndex {
+		return core.NewWALIndex(hnsw.NewHNSW(dim, 5, 10, core.Euclidean, "euclidean"), snapshotPath)
+	}
+
+	index := newIndex()
+	if err := index.EnableWAL(walPath); err != nil {
+		t.Fatalf("EnableWAL failed: %v", err)
+	}
+	if err := index.BulkAdd(map[int][]float32{1: {1, 0, 0, 0}, 2: {0, 1, 0, 0}, 3: {0, 0, 1, 0}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if err := index.Checkpoint(); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+	if info, err := os.Stat(walPath); err != nil || info.Size() != 0 {
+		t.Fatalf("expected an empty log after Checkpoint, got %v, %v", info, err)
+	}
+	if err := index.Add(4, []float32{0, 0, 0, 1}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := index.Update(1, []float32{2, 0, 0, 0}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := index.Delete(2); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	want, err := index.Export()
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	// Simulate a crash: no snapshot after the last changes, and a torn record at the end of the log.
+	f, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	if _, err := f.Write([]byte{20, 0, 0}); err != nil {
+		t.Fatalf("failed to write torn record: %v", err)
+	}
+	f.Close()
+
+	recovered := newIndex()
+	if err := core.LoadFile(recovered, snapshotPath); err != nil {
+		t.Fatalf("LoadFile failed: %v", err)
+	}
+	if err := recovered.ReplayWAL(walPath); err != nil {
+		t.Fatalf("ReplayWAL failed: %v", err)
+	}
+	got, err := recovered.Export()
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d vectors after replay, got %d", len(want), len(got))
+	}
+	for id, vec := range want {
+		for i := range vec {
+			if got[id] == nil || got[id][i] != vec[i] {
+				t.Fatalf("vector %d: expected %v after replay, got %v", id, vec, got[id])
+			}
+		}
+	}
+
+	// Replaying the same log again leaves the index unchanged.
+	if err := recovered.ReplayWAL(walPath); err != nil {
+		t.Fatalf("second ReplayWAL failed: %v", err)
+	}
+	if stats := recovered.Stats(); stats.Count != len(want) {
+		t.Errorf("expected %d vectors after second replay, got %d", len(want), stats.Count)
+	}
+	if err := index.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package example
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/rs/zerolog/log"
+)
+
+// Dataset holds the vectors and ground truth of a benchmark dataset in memory.
+type Dataset struct {
+	Train     map[int][]float32 // vectors to add to the index, keyed by id
+	Test      [][]float32       // query vectors
+	Neighbors [][]int           // expected neighbor ids per query
+	Distances [][]float64       // expected neighbor distances per query
+}
+
+// LoadBenchmarkDataset loads a dataset directory in the layout described by LoadDataset into memory.
+func LoadBenchmarkDataset(dir string) (Dataset, error) {
+	train, err := LoadTrainingVectors(dir)
+	if err != nil {
+		return Dataset{}, err
+	}
+	test, neighbors, distances, err := LoadTestDataset(dir)
+	if err != nil {
+		return Dataset{}, err
+	}
+	return Dataset{Train: train, Test: test, Neighbors: neighbors, Distances: distances}, nil
+}
+
+// BenchResult holds the structured results of a benchmark run.
+type BenchResult struct {
+	Queries     int           // number of queries run
+	BuildTime   time.Duration // time to add all training vectors
+	MeanRecall  float64       // mean Recall@k over all queries
+	MeanLatency time.Duration // mean query latency
+	P50         time.Duration // median query latency
+	P95         time.Duration // 95th percentile query latency
+	P99         time.Duration // 99th percentile query latency
+	Max         time.Duration // slowest query latency
+	QPS         float64       // queries per second over the whole query phase
+}
+
+// Benchmark builds an index with the factory, adds the training vectors of the dataset, and runs
+// every test query, returning recall and latency statistics instead of printing them.
+// Queries run on the number of worker threads given by the HANN_BENCH_NTRD environment variable.
+func Benchmark(factory IndexFactory, data Dataset, k int) (BenchResult, error) {
+	if k <= 0 {
+		return BenchResult{}, fmt.Errorf("%w: k must be positive, got %d", core.ErrInvalidK, k)
+	}
+	if len(data.Test) == 0 {
+		return BenchResult{}, errors.New("dataset has no test queries")
+	}
+	if len(data.Neighbors) < len(data.Test) {
+		return BenchResult{}, fmt.Errorf("dataset has ground truth for %d of %d queries",
+			len(data.Neighbors), len(data.Test))
+	}
+
+	index := factory()
+	start := time.Now()
+	if err := index.BulkAdd(data.Train); err != nil {
+		return BenchResult{}, fmt.Errorf("add training vectors: %w", err)
+	}
+	result := BenchResult{Queries: len(data.Test), BuildTime: time.Since(start)}
+
+	start = time.Now()
+	durations, recalls, err := runQueries(index, data.Test, data.Neighbors, k, benchThreads(), nil)
+	if err != nil {
+		return BenchResult{}, err
+	}
+	elapsed := time.Since(start)
+
+	var totalRecall float64
+	var totalLatency time.Duration
+	for i := range durations {
+		totalRecall += recalls[i]
+		totalLatency += durations[i]
+	}
+	result.MeanRecall = totalRecall / float64(len(recalls))
+	result.MeanLatency = totalLatency / time.Duration(len(durations))
+	result.P50, result.P95, result.P99, result.Max = latencyPercentiles(durations)
+	if elapsed > 0 {
+		result.QPS = float64(len(durations)) / elapsed.Seconds()
+	}
+	return result, nil
+}
+
+// latencyPercentiles sorts durations in place and returns their p50, p95, p99, and maximum.
+func latencyPercentiles(durations []time.Duration) (p50, p95, p99, maxLatency time.Duration) {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	if len(durations) > 0 {
+		maxLatency = durations[len(durations)-1]
+	}
+	return percentile(durations, 50), percentile(durations, 95), percentile(durations, 99), maxLatency
+}
+
+// percentile returns the p-th percentile (nearest rank) of durations sorted in ascending order.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// benchThreads returns the number of query worker threads from HANN_BENCH_NTRD, defaulting to 1.
+func benchThreads() int {
+	if env := os.Getenv("HANN_BENCH_NTRD"); env != "" {
+		if t, err := strconv.Atoi(env); err == nil && t > 0 {
+			log.Info().Msgf("Using %d threads used for benchmarking", t)
+			return t
+		}
+	}
+	return 1
+}
+
+// runQueries searches the index for every query on the given number of worker threads and returns
+// the latency and Recall@k of each query, in query order. If onQuery is not nil it is called after
+// each query, possibly concurrently. The first search error stops the run and is returned.
+func runQueries(index core.Index, queries [][]float32, neighbors [][]int, k, threads int,
+	onQuery func(idx int, res []core.Neighbor, recall float64, duration time.Duration)) (
+	[]time.Duration, []float64, error) {
+
+	durations := make([]time.Duration, len(queries))
+	recalls := make([]float64, len(queries))
+	tasks := make(chan int, len(queries))
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	failed := make(chan struct{})
+
+	worker := func() {
+		defer wg.Done()
+		for idx := range tasks {
+			select {
+			case <-failed:
+				return
+			default:
+			}
+			startQuery := time.Now()
+			res, err := index.Search(queries[idx], k)
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("search error on query %d: %w", idx, err)
+					close(failed)
+				})
+				return
+			}
+			durations[idx] = time.Since(startQuery)
+			recalls[idx] = RecallAtK(res, neighbors[idx], k)
+			if onQuery != nil {
+				onQuery(idx, res, recalls[idx], durations[idx])
+			}
+		}
+	}
+
+	wg.Add(threads)
+	for i := 0; i < threads; i++ {
+		go worker()
+	}
+	for i := range queries {
+		tasks <- i
+	}
+	close(tasks)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, nil, firstErr
+	}
+	return durations, recalls, nil
+}
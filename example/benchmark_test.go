@@ -0,0 +1,97 @@
+package example
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+// tinyDataset builds a random dataset with exact ground truth.
+func tinyDataset(n, queries, dim, k int) Dataset {
+	rng := rand.New(rand.NewSource(1))
+	randomVector := func() []float32 {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		return vec
+	}
+	data := Dataset{Train: make(map[int][]float32, n)}
+	for i := 0; i < n; i++ {
+		data.Train[i] = randomVector()
+	}
+	for q := 0; q < queries; q++ {
+		query := randomVector()
+		ids := make([]int, n)
+		for i := range ids {
+			ids[i] = i
+		}
+		sort.Slice(ids, func(a, b int) bool {
+			return core.Euclidean(query, data.Train[ids[a]]) < core.Euclidean(query, data.Train[ids[b]])
+		})
+		distances := make([]float64, k)
+		for i := range distances {
+			distances[i] = core.Euclidean(query, data.Train[ids[i]])
+		}
+		data.Test = append(data.Test, query)
+		data.Neighbors = append(data.Neighbors, ids[:k])
+		data.Distances = append(data.Distances, distances)
+	}
+	return data
+}
+
+func TestBenchmark(t *testing.T) {
+	const k = 5
+	data := tinyDataset(200, 20, 4, k)
+	factory := func() core.Index { return hnsw.NewHNSW(4, 8, 50, core.Euclidean, "euclidean") }
+
+	result, err := Benchmark(factory, data, k)
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+	if result.Queries != 20 {
+		t.Errorf("expected 20 queries, got %d", result.Queries)
+	}
+	if result.MeanRecall <= 0.5 || result.MeanRecall > 1 {
+		t.Errorf("expected mean recall in (0.5, 1], got %v", result.MeanRecall)
+	}
+	if result.BuildTime <= 0 || result.MeanLatency <= 0 || result.QPS <= 0 {
+		t.Errorf("expected positive build time, latency, and QPS, got %+v", result)
+	}
+	if result.P50 <= 0 || result.P50 > result.P95 || result.P95 > result.P99 || result.P99 > result.Max {
+		t.Errorf("expected 0 < p50 <= p95 <= p99 <= max, got %+v", result)
+	}
+
+	if _, err := Benchmark(factory, Dataset{}, k); err == nil {
+		t.Error("expected error for a dataset without queries, got none")
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	// 1ms..100ms in shuffled order.
+	durations := make([]time.Duration, 100)
+	for i := range durations {
+		durations[i] = time.Duration(i+1) * time.Millisecond
+	}
+	rand.New(rand.NewSource(2)).Shuffle(len(durations), func(i, j int) {
+		durations[i], durations[j] = durations[j], durations[i]
+	})
+	p50, p95, p99, maxLatency := latencyPercentiles(durations)
+	if p50 != 50*time.Millisecond || p95 != 95*time.Millisecond || p99 != 99*time.Millisecond ||
+		maxLatency != 100*time.Millisecond {
+		t.Errorf("expected 50ms/95ms/99ms/100ms, got %v/%v/%v/%v", p50, p95, p99, maxLatency)
+	}
+
+	// With few samples the nearest rank rounds up.
+	p50, p95, p99, maxLatency = latencyPercentiles([]time.Duration{3, 1, 2})
+	if p50 != 2 || p95 != 3 || p99 != 3 || maxLatency != 3 {
+		t.Errorf("expected 2/3/3/3, got %v/%v/%v/%v", p50, p95, p99, maxLatency)
+	}
+	if p50, _, _, maxLatency := latencyPercentiles(nil); p50 != 0 || maxLatency != 0 {
+		t.Errorf("expected zeros for no durations, got %v and %v", p50, maxLatency)
+	}
+}
@@ -83,26 +83,16 @@ func main() {
 	// Save the index to disk.
 	filePath := "hnsw_index.gob"
 	fmt.Println("Saving index to file:", filePath)
-	saveFile, err := os.Create(filePath)
-	if err != nil {
-		log.Fatal().Msgf("failed to create file: %v", err)
-	}
-	if err := index.Save(saveFile); err != nil {
+	if err := core.SaveFile(index, filePath); err != nil {
 		log.Fatal().Msgf("Save failed: %v", err)
 	}
-	saveFile.Close()
 
-	// Create a new index and load the saved state using the io.Reader.
+	// Create a new index and load the saved state from the file.
 	fmt.Println("Loading index from file:", filePath)
-	loadFile, err := os.Open(filePath)
-	if err != nil {
-		log.Fatal().Msgf("failed to open file: %v", err)
-	}
 	newIndex := hnsw.NewHNSW(dim, m, ef, core.Distances[distanceName], distanceName)
-	if err := newIndex.Load(loadFile); err != nil {
+	if err := core.LoadFile(newIndex, filePath); err != nil {
 		log.Fatal().Msgf("Load failed: %v", err)
 	}
-	loadFile.Close()
 	fmt.Printf("Index stats after Load: %+v\n", newIndex.Stats())
 
 	// Search in the loaded index.
@@ -0,0 +1,107 @@
+package example
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/patrikhermansson/hann/core"
+)
+
+// columnarMagic identifies the columnar export format and its version.
+const columnarMagic = "HANNCOL1"
+
+// ExportColumnar writes all vectors of the index to w in a simple self-describing columnar layout,
+// which is easy to load into Arrow, Parquet, or NumPy without extra dependencies.
+// All numbers are little-endian:
+//
+//	magic      8 bytes, "HANNCOL1"
+//	count      uint64, number of vectors (n)
+//	dimension  uint32, number of components per vector (d)
+//	ids        n × int64, in ascending order
+//	columns    d × n × float32, component j of every vector (in id order) for j = 0..d-1
+//
+// For indexes that store approximations (for example, PQIVF) the exported values are reconstructions.
+func ExportColumnar(index core.Index, w io.Writer) error {
+	vectors, err := index.Export()
+	if err != nil {
+		return fmt.Errorf("export vectors: %w", err)
+	}
+	dimension := index.Stats().Dimension
+	ids := make([]int, 0, len(vectors))
+	for id, vec := range vectors {
+		if len(vec) != dimension {
+			return fmt.Errorf("vector %d has dimension %d, expected %d", id, len(vec), dimension)
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(columnarMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, uint64(len(ids))); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, uint32(dimension)); err != nil {
+		return err
+	}
+	idColumn := make([]int64, len(ids))
+	for i, id := range ids {
+		idColumn[i] = int64(id)
+	}
+	if err := binary.Write(bw, binary.LittleEndian, idColumn); err != nil {
+		return err
+	}
+	column := make([]float32, len(ids))
+	for j := 0; j < dimension; j++ {
+		for i, id := range ids {
+			column[i] = vectors[id][j]
+		}
+		if err := binary.Write(bw, binary.LittleEndian, column); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadColumnar reads data written by ExportColumnar. It returns the ids in ascending order and one
+// column per dimension, where columns[j][i] is component j of the vector with id ids[i].
+func ReadColumnar(r io.Reader) (ids []int, columns [][]float32, err error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(columnarMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, nil, fmt.Errorf("read header: %w", err)
+	}
+	if string(magic) != columnarMagic {
+		return nil, nil, errors.New("not a columnar export (bad magic)")
+	}
+	var count uint64
+	var dimension uint32
+	if err := binary.Read(br, binary.LittleEndian, &count); err != nil {
+		return nil, nil, fmt.Errorf("read count: %w", err)
+	}
+	if err := binary.Read(br, binary.LittleEndian, &dimension); err != nil {
+		return nil, nil, fmt.Errorf("read dimension: %w", err)
+	}
+	idColumn := make([]int64, count)
+	if err := binary.Read(br, binary.LittleEndian, idColumn); err != nil {
+		return nil, nil, fmt.Errorf("read ids: %w", err)
+	}
+	ids = make([]int, count)
+	for i, id := range idColumn {
+		ids[i] = int(id)
+	}
+	columns = make([][]float32, dimension)
+	for j := range columns {
+		columns[j] = make([]float32, count)
+		if err := binary.Read(br, binary.LittleEndian, columns[j]); err != nil {
+			return nil, nil, fmt.Errorf("read column %d: %w", j, err)
+		}
+	}
+	return ids, columns, nil
+}
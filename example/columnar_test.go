@@ -0,0 +1,48 @@
+package example
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+func TestExportColumnar(t *testing.T) {
+	index := hnsw.NewHNSW(3, 5, 10, core.Euclidean, "euclidean")
+	vectors := map[int][]float32{
+		7:  {1, 2, 3},
+		-1: {4, 5, 6},
+		42: {0.5, -0.25, 1e6},
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := ExportColumnar(index, &buf); err != nil {
+		t.Fatalf("ExportColumnar failed: %v", err)
+	}
+	ids, columns, err := ReadColumnar(&buf)
+	if err != nil {
+		t.Fatalf("ReadColumnar failed: %v", err)
+	}
+	wantIDs := []int{-1, 7, 42}
+	if len(ids) != len(wantIDs) || len(columns) != 3 {
+		t.Fatalf("expected %d ids and 3 columns, got %v and %d columns", len(wantIDs), ids, len(columns))
+	}
+	for i, id := range wantIDs {
+		if ids[i] != id {
+			t.Fatalf("expected ids %v, got %v", wantIDs, ids)
+		}
+		for j := range columns {
+			if columns[j][i] != vectors[id][j] {
+				t.Errorf("id %d component %d: expected %v, got %v", id, j, vectors[id][j], columns[j][i])
+			}
+		}
+	}
+
+	if _, _, err := ReadColumnar(bytes.NewReader([]byte("NOTMAGIC"))); err == nil {
+		t.Error("expected error for bad magic, got none")
+	}
+}
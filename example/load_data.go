@@ -1,6 +1,7 @@
 package example
 
 import (
+	"compress/gzip"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -68,6 +69,7 @@ func LoadDataset(index core.Index, dir string) (
 }
 
 // LoadCSV reads float32 vectors from a CSV file and adds them to the index.
+// A file whose name ends in ".gz" is decompressed with gzip while it is read.
 func LoadCSV(index core.Index, path string, skipHeader bool) error {
 	log.Info().Msgf("Loading CSV file into index: %s", path)
 	vectors, err := readCSV[float32](path, skipHeader)
@@ -84,7 +86,44 @@ func LoadCSV(index core.Index, path string, skipHeader bool) error {
 	return nil
 }
 
+// StreamCSV reads vectors from r one row at a time and adds each to the index with consecutive
+// ids starting at startID. Unlike LoadCSV it never holds more than the current row in memory.
+// It returns the number of vectors added; on error that count covers the rows added before the
+// failing line, which is reported by number.
+func StreamCSV(index core.Index, r io.Reader, startID int) (int, error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	added := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return added, fmt.Errorf("read error: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		// The index may keep the slice it is given, so each row needs its own vector.
+		vec := make([]float32, len(record))
+		for i, val := range record {
+			parsed, err := parseValue[float32](val)
+			if err != nil {
+				return added, fmt.Errorf("parse error at line %d, col %d: %w", line, i, err)
+			}
+			vec[i] = parsed
+		}
+		id := startID + added
+		if err := index.Add(id, vec); err != nil {
+			return added, fmt.Errorf("failed to add vector %d at line %d: %w", id, line, err)
+		}
+		added++
+	}
+	log.Debug().Msgf("Streamed %d vectors into index", added)
+	return added, nil
+}
+
 // readCSV is a generic CSV reader for types: int, float32, and float64.
+// Files ending in ".gz" are gzip-compressed CSV files and are decompressed transparently.
 func readCSV[T int | float32 | float64](path string, skipHeader bool) ([][]T, error) {
 	log.Debug().Msgf("Opening CSV file: %s", path)
 	file, err := os.Open(path)
@@ -93,7 +132,16 @@ func readCSV[T int | float32 | float64](path string, skipHeader bool) ([][]T, er
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
+	var in io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip stream in %s: %w", path, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+	reader := csv.NewReader(in)
 	var result [][]T
 
 	for {
@@ -0,0 +1,100 @@
+package example
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+	"github.com/patrikhermansson/hann/hnsw"
+)
+
+func TestStreamCSV(t *testing.T) {
+	index := hnsw.NewHNSW(3, 5, 10, core.Euclidean, "euclidean")
+	input := "1,0,0\n0,1,0\n0,0,1\n"
+	added, err := StreamCSV(index, strings.NewReader(input), 100)
+	if err != nil {
+		t.Fatalf("StreamCSV failed: %v", err)
+	}
+	if added != 3 {
+		t.Fatalf("expected 3 vectors added, got %d", added)
+	}
+	want := map[int][]float32{100: {1, 0, 0}, 101: {0, 1, 0}, 102: {0, 0, 1}}
+	for id, vec := range want {
+		got, err := index.GetVector(id)
+		if err != nil {
+			t.Fatalf("GetVector(%d) failed: %v", id, err)
+		}
+		for i := range vec {
+			if got[i] != vec[i] {
+				t.Errorf("id %d: expected %v, got %v", id, vec, got)
+				break
+			}
+		}
+	}
+}
+
+func TestStreamCSV_ParseError(t *testing.T) {
+	index := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	added, err := StreamCSV(index, strings.NewReader("1,2\n3,x\n5,6\n"), 0)
+	if err == nil {
+		t.Fatal("expected parse error, got none")
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error to name line 2, got %v", err)
+	}
+	if added != 1 {
+		t.Errorf("expected 1 vector added before the error, got %d", added)
+	}
+}
+
+func TestReadCSV_Gzip(t *testing.T) {
+	dir := t.TempDir()
+	content := "x,y,z\n1,0.5,-2\n3,4.25,5\n"
+	plainPath := filepath.Join(dir, "train.csv")
+	if err := os.WriteFile(plainPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+	gzPath := filepath.Join(dir, "train.csv.gz")
+	f, err := os.Create(gzPath)
+	if err != nil {
+		t.Fatalf("failed to create gzip file: %v", err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatalf("failed to write gzip data: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("failed to close gzip file: %v", err)
+	}
+
+	plain, err := readCSV[float32](plainPath, true)
+	if err != nil {
+		t.Fatalf("readCSV failed on plain file: %v", err)
+	}
+	compressed, err := readCSV[float32](gzPath, true)
+	if err != nil {
+		t.Fatalf("readCSV failed on gzip file: %v", err)
+	}
+	if !reflect.DeepEqual(plain, compressed) {
+		t.Errorf("expected gzip file to parse as %v, got %v", plain, compressed)
+	}
+	if len(compressed) != 2 {
+		t.Errorf("expected 2 rows, got %d", len(compressed))
+	}
+
+	// A .gz file that is not gzip-compressed is reported instead of parsed as CSV.
+	bogusPath := filepath.Join(dir, "bogus.csv.gz")
+	if err := os.WriteFile(bogusPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := readCSV[float32](bogusPath, true); err == nil {
+		t.Error("expected error for a .gz file that is not gzip-compressed, got none")
+	}
+}
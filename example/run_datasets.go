@@ -2,10 +2,7 @@ package example
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
-	"strconv"
-	"sync"
 	"time"
 
 	"github.com/patrikhermansson/hann/core"
@@ -29,9 +26,11 @@ type QueryResult struct {
 // and runs kNN queries on a subset of test queries. If numQueries is negative
 // or exceeds the number of available test vectors, all test vectors are used.
 // It prints predicted results, ground-truth (if not benchmarking), and computes
-// Recall@k along with per-query response times, average response time, and overall runtime.
+// Recall@k along with per-query response times, average and p50/p95/p99/max response times, and
+// overall runtime.
 // When benchmarking, a progress bar is displayed.
 // The number of worker threads is read from the HANN_BENCH_NTRD environment variable.
+// It exits on errors; use Benchmark to get structured results and errors instead.
 func RunDataset(factory IndexFactory, dataset, root string, k, numQueries, maxResults int) {
 	datasetPath := filepath.Join(root, dataset)
 	fmt.Printf("Loading dataset: %s\n", dataset)
@@ -41,43 +40,28 @@ func RunDataset(factory IndexFactory, dataset, root string, k, numQueries, maxRe
 	index := factory()
 	fmt.Printf("Created index: %T\n", index)
 
-	// Load training vectors and add them to the index.
-	trainingVectors, err := LoadTrainingVectors(datasetPath)
+	// Load the dataset and add the training vectors to the index.
+	data, err := LoadBenchmarkDataset(datasetPath)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load training vectors")
+		log.Fatal().Err(err).Msg("Failed to load dataset")
 	}
-	log.Info().Msgf("Loaded %d training vectors", len(trainingVectors))
-	if err := index.BulkAdd(trainingVectors); err != nil {
+	log.Info().Msgf("Loaded %d training vectors and %d test vectors", len(data.Train), len(data.Test))
+	if err := index.BulkAdd(data.Train); err != nil {
 		log.Fatal().Err(err).Msg("BulkAdd failed")
 	}
 
-	// Load test dataset.
-	testVectors, gtNeighbors, gtDistances, err := LoadTestDataset(datasetPath)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load test dataset")
-	}
-	log.Info().Msgf("Loaded %d test vectors", len(testVectors))
-
 	stats := index.Stats()
 	fmt.Printf("Indexed %d vectors (%d dimensions) in %.2fs; distance: %s\n",
 		stats.Count, stats.Dimension, time.Since(overallStart).Seconds(), stats.Distance)
 
 	// Activate benchmark mode if numQueries is negative or too high.
 	benchmarkMode := false
-	if numQueries < 0 || numQueries > len(testVectors) {
-		numQueries = len(testVectors)
+	if numQueries < 0 || numQueries > len(data.Test) {
+		numQueries = len(data.Test)
 		benchmarkMode = true
 	}
 
-	// Get the number of threads from HANN_BENCH_NTRD.
-	threads := 1
-	if env := os.Getenv("HANN_BENCH_NTRD"); env != "" {
-		if t, err := strconv.Atoi(env); err == nil && t > 0 {
-			threads = t
-			log.Info().Msgf("Using %d threads used for benchmarking", threads)
-		}
-	}
-
+	threads := benchThreads()
 	fmt.Printf("Running kNN queries (k=%d) on %d test vectors using %d threads\n", k, numQueries, threads)
 
 	var totalRecall float64
@@ -92,29 +76,13 @@ func RunDataset(factory IndexFactory, dataset, root string, k, numQueries, maxRe
 		bar = progressbar.Default(int64(numQueries))
 	}
 
-	// Create a channel to feed query indices.
-	tasks := make(chan int, numQueries)
-	var wg sync.WaitGroup
-
-	// Worker function: processes queries from the task channel.
-	worker := func() {
-		defer wg.Done()
-		for idx := range tasks {
-			query := testVectors[idx]
-			startQuery := time.Now()
-			res, err := index.Search(query, k)
-			if err != nil {
-				log.Fatal().Err(err).Msgf("Search error on query %d", idx)
-			}
-			duration := time.Since(startQuery)
-			recall := RecallAtK(res, gtNeighbors[idx], k)
-
+	durations, _, err := runQueries(index, data.Test[:numQueries], data.Neighbors, k, threads,
+		func(idx int, res []core.Neighbor, recall float64, duration time.Duration) {
 			var predicted, groundTruth string
 			if !benchmarkMode {
 				predicted = FormatResults(res, maxResults)
-				groundTruth = FormatGroundTruth(gtNeighbors[idx], gtDistances[idx], k, maxResults)
+				groundTruth = FormatGroundTruth(data.Neighbors[idx], data.Distances[idx], k, maxResults)
 			}
-
 			resultsSlice[idx] = QueryResult{
 				idx:         idx,
 				recall:      recall,
@@ -122,28 +90,13 @@ func RunDataset(factory IndexFactory, dataset, root string, k, numQueries, maxRe
 				predicted:   predicted,
 				groundTruth: groundTruth,
 			}
-
-			if benchmarkMode && bar != nil {
-				err := bar.Add(1)
-				if err != nil {
-					return
-				}
+			if bar != nil {
+				_ = bar.Add(1)
 			}
-		}
-	}
-
-	// Start worker goroutines.
-	wg.Add(threads)
-	for i := 0; i < threads; i++ {
-		go worker()
-	}
-
-	// Feed query indices into the task channel.
-	for i := 0; i < numQueries; i++ {
-		tasks <- i
+		})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Query run failed")
 	}
-	close(tasks)
-	wg.Wait()
 
 	// Aggregate the results.
 	for _, res := range resultsSlice {
@@ -166,5 +119,7 @@ func RunDataset(factory IndexFactory, dataset, root string, k, numQueries, maxRe
 
 	fmt.Printf("Average Recall@%d over %d queries: %.2f\n", k, numQueries, avgRecall)
 	fmt.Printf("Average query response time: %v\n", avgResponseTime)
+	p50, p95, p99, maxLatency := latencyPercentiles(durations)
+	fmt.Printf("Query latency p50: %v, p95: %v, p99: %v, max: %v\n", p50, p95, p99, maxLatency)
 	fmt.Printf("Overall runtime: %v\n", time.Since(overallStart))
 }
@@ -2,6 +2,7 @@ package example
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/patrikhermansson/hann/core"
 )
@@ -59,3 +60,46 @@ func RecallAtK(predicted []core.Neighbor, groundTruth []int, k int) float64 {
 	}
 	return float64(correct) / float64(len(groundTruth))
 }
+
+// tieTolerance is the relative tolerance used by RecallAtKWithDistances to decide that a predicted
+// distance ties with the k-th ground-truth distance.
+const tieTolerance = 1e-5
+
+// RecallAtKWithDistances computes Recall@k like RecallAtK over the top k ground-truth items, but also
+// counts a predicted neighbor as correct if its distance is not larger than the k-th ground-truth
+// distance (within a small tolerance), even if its id differs. When several vectors sit at the same
+// distance, any of them is then accepted as a valid answer.
+func RecallAtKWithDistances(predicted []core.Neighbor, gtIDs []int, gtDists []float64, k int) float64 {
+	if k <= 0 || len(gtIDs) == 0 || len(gtDists) < len(gtIDs) {
+		return 0.0
+	}
+	if len(gtIDs) > k {
+		gtIDs = gtIDs[:k]
+	}
+	kth := gtDists[len(gtIDs)-1]
+	threshold := kth + tieTolerance*math.Max(1, math.Abs(kth))
+	gtSet := make(map[int]struct{}, len(gtIDs))
+	for _, id := range gtIDs {
+		gtSet[id] = struct{}{}
+	}
+
+	limit := k
+	if len(predicted) < k {
+		limit = len(predicted)
+	}
+	correct := 0
+	seen := make(map[int]struct{}, limit)
+	for _, n := range predicted[:limit] {
+		if _, dup := seen[n.ID]; dup {
+			continue
+		}
+		seen[n.ID] = struct{}{}
+		if _, ok := gtSet[n.ID]; ok || n.Distance <= threshold {
+			correct++
+		}
+	}
+	if correct > len(gtIDs) {
+		correct = len(gtIDs)
+	}
+	return float64(correct) / float64(len(gtIDs))
+}
@@ -0,0 +1,33 @@
+package example
+
+import (
+	"testing"
+
+	"github.com/patrikhermansson/hann/core"
+)
+
+func TestRecallAtKWithDistances(t *testing.T) {
+	// Ids 3, 4, and 5 all sit at distance 2, so any two of them complete a valid top 3.
+	gtIDs := []int{1, 3, 4}
+	gtDists := []float64{1, 2, 2}
+	predicted := []core.Neighbor{{ID: 1, Distance: 1}, {ID: 5, Distance: 2}, {ID: 3, Distance: 2}}
+
+	if r := RecallAtK(predicted, gtIDs, 3); r >= 1 {
+		t.Errorf("RecallAtK = %f; want less than 1 for a tied answer with a different id", r)
+	}
+	if r := RecallAtKWithDistances(predicted, gtIDs, gtDists, 3); r != 1 {
+		t.Errorf("RecallAtKWithDistances = %f; want 1", r)
+	}
+
+	// A neighbor farther than the k-th ground-truth distance is still wrong.
+	predicted[1] = core.Neighbor{ID: 6, Distance: 2.5}
+	if r := RecallAtKWithDistances(predicted, gtIDs, gtDists, 3); r != 2.0/3.0 {
+		t.Errorf("RecallAtKWithDistances = %f; want 2/3", r)
+	}
+
+	// Duplicate predictions are counted once.
+	dup := []core.Neighbor{{ID: 1, Distance: 1}, {ID: 1, Distance: 1}, {ID: 1, Distance: 1}}
+	if r := RecallAtKWithDistances(dup, gtIDs, gtDists, 3); r != 1.0/3.0 {
+		t.Errorf("RecallAtKWithDistances with duplicates = %f; want 1/3", r)
+	}
+}
@@ -0,0 +1,35 @@
+package hnsw
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// FallbackIDs returns the ids chosen by the brute-force search fallback, ordered by distance and id, for tests.
+func (h *HNSWIndex) FallbackIDs(query []float32, exclude []int, size int) []int {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	excluded := make(map[int]bool, len(exclude))
+	for _, id := range exclude {
+		excluded[id] = true
+	}
+	candidates := h.fallbackCandidates(h.prepareVector(query), excluded, size)
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].dist == candidates[j].dist {
+			return candidates[i].node.ID < candidates[j].node.ID
+		}
+		return candidates[i].dist < candidates[j].dist
+	})
+	ids := make([]int, len(candidates))
+	for i, c := range candidates {
+		ids[i] = c.node.ID
+	}
+	return ids
+}
+
+// ResetLevelSeed reseeds the random generator used for node levels, for tests.
+func ResetLevelSeed(seed int64) {
+	seededRandMu.Lock()
+	defer seededRandMu.Unlock()
+	seededRand = rand.New(rand.NewSource(seed))
+}
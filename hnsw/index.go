@@ -9,9 +9,9 @@ import (
 	"io"
 	"math"
 	"math/rand"
-	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/patrikhermansson/hann/core"
 	"github.com/rs/zerolog/log"
@@ -25,6 +25,17 @@ var seededRandMu sync.Mutex
 // maxLevelCap is the upper bound for a node's level.
 const maxLevelCap = 32
 
+// Smallest parameters NewHNSW accepts. With M below 2 every node is placed on level 0 and each node keeps
+// at most one link, and an ef below 1 leaves the layer search without a beam.
+const (
+	minM  = 2
+	minEf = 1
+)
+
+// defaultRerankFactor is the number of candidates per requested neighbor re-ranked by SearchWithMetric
+// when RerankFactor is not set.
+const defaultRerankFactor = 4
+
 // candidate represents a potential neighbor with its distance.
 type candidate struct {
 	node *Node   // reference to the candidate node
@@ -75,6 +86,8 @@ func (h *candidateMaxHeap) Pop() interface{} {
 type Node struct {
 	ID           int             // unique identifier of the node
 	Vector       []float32       // vector data
+	Vector16     []uint16        // half-precision vector data, used instead of Vector in float16 mode
+	Vector8      []int8          // int8-quantized vector data, used instead of Vector in int8 mode
 	Level        int             // node level in the hierarchy
 	Links        map[int][]*Node // links to neighbors at each level
 	ReverseLinks map[int][]*Node // reverse links from neighbors
@@ -92,12 +105,68 @@ type HNSWIndex struct {
 	Distance         core.DistanceFunc // function to calculate distance between vectors
 	DistanceName     string            // name of the distance metric
 	ExhaustiveSearch bool              // flag for performing exhaustive search during searchLayer
+	Float16          bool              // store vectors as half precision to roughly halve memory
+	Int8             bool              // store vectors quantized to int8, using a quarter of the memory
+	Int8Scale        float32           // quantization step in int8 mode (0 means learned from the first bulk insert)
+	FixedEf          bool              // search with exactly Ef even when k is larger, instead of raising it to k
+	RandomTieBreak   bool              // order equal-distance results by core.TieRank of the query seed, not by id
+	Normalize        bool              // normalize vectors and queries with NormMode for any distance
+	NormMode         core.NormMode     // norm used when Normalize is set (cosine always uses L2 otherwise)
+	VectorStats      *core.Stats       // optional running per-dimension statistics of inserted vectors (not saved)
+	RerankFactor     int               // candidates per requested neighbor re-ranked by SearchWithMetric (0 means 4)
+	MaxParallelism   int               // maximum goroutines of the brute-force fallback (0 means core.MaxParallelism())
+	ExactThreshold   int               // scan all vectors instead of searching the graph when there are at most this many (0 disables)
+	// EarlyStop abandons distance computations during layer search once they exceed the distance of the
+	// worst result kept so far, which saves time for high-dimensional vectors without changing results.
+	// It applies to float32 storage with the built-in Euclidean, squared Euclidean, and Manhattan distances.
+	EarlyStop bool
+	// SkipReverseLinks stops storing Node.ReverseLinks, which let deletes only touch the nodes linking to
+	// the deleted node. Skipping them saves memory, but each Delete or Update scans the links of every node
+	// instead. It should be set before any vectors are added, and it is saved with the index.
+	SkipReverseLinks bool
+	// DisableFallback makes searches return only the candidates the layer search found, even if they are
+	// fewer than k, instead of completing them with a brute-force scan. This bounds the search latency for
+	// serving, at the cost of recall and of returning fewer than k results when Ef or the graph's
+	// connectivity is too small.
+	DisableFallback bool
+
+	fallbacks      atomic.Int64                    // number of searches that fell back to a brute-force scan
+	buildDistances atomic.Int64                    // distance computations made while linking nodes, see BuildStats
+	building       bool                            // set while nodes are linked, so their distance computations are counted
+	frozen         atomic.Bool                     // set by Freeze to reject modifications
+	pinnedEntry    *Node                           // node searches start from instead of EntryPoint, set by SetEntryPoint
+	vectorBytes    int                             // bytes held by the vectors of the nodes in Nodes, see Stats
+	queryCache     atomic.Pointer[core.QueryCache] // cache of Search results, set by EnableQueryCache
+}
+
+// FallbackCount returns the number of searches that fell back to a brute-force scan because the layer
+// search found fewer than k candidates.
+func (h *HNSWIndex) FallbackCount() int64 {
+	return h.fallbacks.Load()
+}
+
+// BuildStats returns the cumulative number of distance computations made while linking nodes into the
+// graph by Add, BulkAdd, Update, and the other operations that insert nodes, which measures how
+// expensive construction is for the chosen M and Ef. Searches are not counted.
+func (h *HNSWIndex) BuildStats() core.BuildStats {
+	return core.BuildStats{DistanceEvaluations: h.buildDistances.Load()}
 }
 
 // NewHNSW creates a new HNSW index given the dimension, M, ef, and distance function.
+// An M below 2 or an ef below 1 is raised to that minimum with a logged warning.
 func NewHNSW(dimension int, M int, ef int, distance core.DistanceFunc, distanceName string) *HNSWIndex {
-	log.Info().Msgf("Creating new HNSW index with dimension=%d, M=%d, ef=%d, distance=%s",
-		dimension, M, ef, distanceName)
+	if M < minM {
+		log.Warn().Msgf("HNSW M=%d is too small, using M=%d", M, minM)
+		M = minM
+	}
+	if ef < minEf {
+		log.Warn().Msgf("HNSW ef=%d is too small, using ef=%d", ef, minEf)
+		ef = minEf
+	}
+	if e := log.Info(); e.Enabled() {
+		e.Msgf("Creating new HNSW index with dimension=%d, M=%d, ef=%d, distance=%s",
+			dimension, M, ef, distanceName)
+	}
 	return &HNSWIndex{
 		Dimension:    dimension,
 		Nodes:        make(map[int]*Node),
@@ -106,7 +175,214 @@ func NewHNSW(dimension int, M int, ef int, distance core.DistanceFunc, distanceN
 		Ef:           ef,
 		Distance:     distance,
 		DistanceName: distanceName,
+		EarlyStop:    true,
+	}
+}
+
+// dist computes the distance between a query and a stored vector using the index's metric.
+// All distance computations in the graph go through this method so the metric is applied identically
+// during insertion, layer search, and the brute-force fallback.
+func (h *HNSWIndex) dist(query, vec []float32) float64 {
+	return h.Distance(query, vec)
+}
+
+// vector returns the stored vector of a node as float32, decoding it in float16 and int8 modes.
+func (h *HNSWIndex) vector(n *Node) []float32 {
+	if n.Vector8 != nil {
+		return core.DequantizeInt8(nil, n.Vector8, h.Int8Scale)
+	}
+	if n.Vector16 != nil {
+		return core.DecodeFloat16(nil, n.Vector16)
+	}
+	return n.Vector
+}
+
+// float32Pool holds scratch buffers used to decode half-precision vectors for distance computation.
+var float32Pool = sync.Pool{New: func() interface{} { return new([]float32) }}
+
+// vectorBytes returns the number of bytes held by the stored vector of a node.
+func vectorBytes(n *Node) int {
+	return 4*len(n.Vector) + 2*len(n.Vector16) + len(n.Vector8)
+}
+
+// nodeDist computes the distance between a query and the stored vector of a node.
+// While the graph is being built, the computation is counted in BuildStats.
+func (h *HNSWIndex) nodeDist(query []float32, n *Node) float64 {
+	if h.building {
+		h.buildDistances.Add(1)
+	}
+	if n.Vector8 != nil {
+		if d, ok := h.int8Dist(query, n.Vector8); ok {
+			return d
+		}
+	} else if n.Vector16 == nil {
+		return h.dist(query, n.Vector)
+	}
+	buf := float32Pool.Get().(*[]float32)
+	if n.Vector8 != nil {
+		*buf = core.DequantizeInt8(*buf, n.Vector8, h.Int8Scale)
+	} else {
+		*buf = core.DecodeFloat16(*buf, n.Vector16)
+	}
+	d := h.dist(query, *buf)
+	float32Pool.Put(buf)
+	return d
+}
+
+// int8Dist computes the distance between a query and an int8-quantized vector for the built-in metrics
+// directly on the quantized values, applying the scale once per distance instead of decoding the vector.
+// It returns false for other metrics, which need the decoded vector.
+func (h *HNSWIndex) int8Dist(query []float32, q []int8) (float64, bool) {
+	scale := float64(h.Int8Scale)
+	switch h.DistanceName {
+	case "euclidean", "squared_euclidean":
+		// sum (x - s*c)^2 = s^2 * sum (x/s - c)^2
+		inv := 1 / scale
+		var sum float64
+		for i, c := range q {
+			d := float64(query[i])*inv - float64(c)
+			sum += d * d
+		}
+		sum *= scale * scale
+		if h.DistanceName == "euclidean" {
+			return math.Sqrt(sum), true
+		}
+		return sum, true
+	case "manhattan":
+		inv := 1 / scale
+		var sum float64
+		for i, c := range q {
+			sum += math.Abs(float64(query[i])*inv - float64(c))
+		}
+		return sum * scale, true
+	case "cosine":
+		// The cosine distance does not depend on the scale.
+		var dot, normQ, normC float64
+		for i, c := range q {
+			dot += float64(query[i]) * float64(c)
+			normQ += float64(query[i]) * float64(query[i])
+			normC += float64(c) * float64(c)
+		}
+		if normQ == 0 || normC == 0 {
+			return 1, true
+		}
+		return 1 - dot/(math.Sqrt(normQ)*math.Sqrt(normC)), true
+	}
+	return 0, false
+}
+
+// errInt8ScaleUnset is returned when a single vector is stored in int8 mode before the scale is known.
+var errInt8ScaleUnset = errors.New("int8 mode needs Int8Scale set or a first batch added with BulkAdd")
+
+// learnInt8Scale sets Int8Scale from the given vectors in int8 mode if it has not been set yet.
+// Vectors of the wrong dimension are ignored.
+func (h *HNSWIndex) learnInt8Scale(vectors map[int][]float32) {
+	if !h.Int8 || h.Int8Scale != 0 {
+		return
+	}
+	prepared := make([][]float32, 0, len(vectors))
+	for _, vec := range vectors {
+		if len(vec) == h.Dimension {
+			prepared = append(prepared, h.prepareVector(vec))
+		}
+	}
+	if len(prepared) == 0 {
+		return
+	}
+	h.Int8Scale = core.Int8ScaleFor(prepared...)
+	log.Debug().Msgf("Learned int8 quantization scale %g from %d vectors", h.Int8Scale, len(prepared))
+}
+
+// setVector stores a vector on a node, converting it to int8 in int8 mode or to half precision in
+// float16 mode. Int8 mode takes precedence if both are set.
+func (h *HNSWIndex) setVector(n *Node, vec []float32) {
+	h.storeVector(n, h.prepareVector(vec))
+}
+
+// storeVector is like setVector for a vector that prepareVector or prepareVectors has already prepared.
+// In int8 mode, Int8Scale must be set; values beyond 127 steps are clipped and a warning is logged.
+func (h *HNSWIndex) storeVector(n *Node, vec []float32) {
+	switch {
+	case h.Int8:
+		clipped := 0
+		for _, v := range vec {
+			if math.Abs(math.Round(float64(v/h.Int8Scale))) > 127 {
+				clipped++
+			}
+		}
+		if clipped > 0 {
+			log.Warn().Msgf("id %d: %d of %d values clipped to 127 steps of the int8 scale %g",
+				n.ID, clipped, len(vec), h.Int8Scale)
+		}
+		n.Vector = nil
+		n.Vector16 = nil
+		n.Vector8 = core.QuantizeInt8(vec, h.Int8Scale)
+	case h.Float16:
+		n.Vector = nil
+		n.Vector16 = core.EncodeFloat16(vec)
+		n.Vector8 = nil
+	default:
+		n.Vector = vec
+		n.Vector16 = nil
+		n.Vector8 = nil
+	}
+}
+
+// newNode creates an unlinked node holding the given vector. prepared is the vector as prepareVector
+// returns it, or nil to prepare it here.
+func (h *HNSWIndex) newNode(id int, vec, prepared []float32, level int) *Node {
+	if h.VectorStats != nil {
+		h.VectorStats.Update(vec)
+	}
+	n := &Node{
+		ID:           id,
+		Level:        level,
+		Links:        make(map[int][]*Node),
+		ReverseLinks: make(map[int][]*Node),
+	}
+	if prepared == nil {
+		prepared = h.prepareVector(vec)
+	}
+	h.storeVector(n, prepared)
+	return n
+}
+
+// prepareVector returns the vector in the form stored in (or compared against) the graph.
+// If Normalize is set, it returns a copy normalized with NormMode; otherwise, for the cosine distance,
+// it returns an L2-normalized copy. The caller's slice is left untouched.
+func (h *HNSWIndex) prepareVector(vec []float32) []float32 {
+	if !h.Normalize && h.DistanceName != "cosine" {
+		return vec
 	}
+	mode := core.NormL2
+	if h.Normalize {
+		mode = h.NormMode
+	}
+	normalized := make([]float32, len(vec))
+	copy(normalized, vec)
+	core.NormalizeVectorMode(normalized, mode)
+	return normalized
+}
+
+// prepareVectors returns the vectors of ids as prepareVector would, in the order of ids. Large batches
+// are normalized by core.NormalizeBatch, in chunks across at most MaxParallelism goroutines.
+func (h *HNSWIndex) prepareVectors(vectors map[int][]float32, ids []int) [][]float32 {
+	prepared := make([][]float32, len(ids))
+	if !h.Normalize && h.DistanceName != "cosine" {
+		for i, id := range ids {
+			prepared[i] = vectors[id]
+		}
+		return prepared
+	}
+	mode := core.NormL2
+	if h.Normalize {
+		mode = h.NormMode
+	}
+	for i, id := range ids {
+		prepared[i] = append([]float32(nil), vectors[id]...)
+	}
+	core.NormalizeBatch(prepared, mode, 0, h.MaxParallelism)
+	return prepared
 }
 
 // randomLevel computes a random level for a new node based on an exponential distribution.
@@ -121,16 +397,17 @@ func (h *HNSWIndex) randomLevel() int {
 	if level > maxLevelCap {
 		level = maxLevelCap
 	}
-	log.Debug().Msgf("Generated random level %d", level)
 	return level
 }
 
 // serializedNode is used to store a Node during gob encoding/decoding.
 type serializedNode struct {
-	ID     int           // node id
-	Vector []float32     // vector data
-	Level  int           // node level
-	Links  map[int][]int // neighbor ids at each level
+	ID       int           // node id
+	Vector   []float32     // vector data
+	Vector16 []uint16      // half-precision vector data
+	Vector8  []int8        // int8-quantized vector data
+	Level    int           // node level
+	Links    map[int][]int // neighbor ids at each level
 }
 
 // serializedIndex is the serializable version of the HNSWIndex.
@@ -142,6 +419,15 @@ type serializedIndex struct {
 	EntryPoint   int                    // id of the entry point node
 	MaxLevel     int                    // maximum level in the graph
 	DistanceName string                 // name of the distance metric
+	Float16      bool                   // whether vectors are stored as half precision
+	Int8         bool                   // whether vectors are stored quantized to int8
+	Int8Scale    float32                // quantization step of int8 vectors
+	Normalize    bool                   // whether vectors are normalized with NormMode
+	NormMode     core.NormMode          // norm used for normalization
+	Pinned       bool                   // whether searches start from PinnedEntry
+	PinnedEntry  int                    // id of the node pinned by SetEntryPoint
+	SkipReverse  bool                   // whether reverse links are not stored
+	Precise      bool                   // whether the distance is the precise variant of DistanceName
 }
 
 // GobEncode serializes the HNSWIndex using the gob encoder.
@@ -156,13 +442,22 @@ func (h *HNSWIndex) GobEncode() ([]byte, error) {
 		EntryPoint:   0,
 		MaxLevel:     h.MaxLevel,
 		DistanceName: h.DistanceName,
+		Precise:      core.IsPrecise(h.DistanceName, h.Distance),
+		Float16:      h.Float16,
+		Int8:         h.Int8,
+		Int8Scale:    h.Int8Scale,
+		Normalize:    h.Normalize,
+		NormMode:     h.NormMode,
+		SkipReverse:  h.SkipReverseLinks,
 	}
 	for id, node := range h.Nodes {
 		sn := serializedNode{
-			ID:     node.ID,
-			Vector: node.Vector,
-			Level:  node.Level,
-			Links:  make(map[int][]int),
+			ID:       node.ID,
+			Vector:   node.Vector,
+			Vector16: node.Vector16,
+			Vector8:  node.Vector8,
+			Level:    node.Level,
+			Links:    make(map[int][]int),
 		}
 		// Store neighbor ids for each level.
 		for level, neighbors := range node.Links {
@@ -175,6 +470,10 @@ func (h *HNSWIndex) GobEncode() ([]byte, error) {
 	if h.EntryPoint != nil {
 		si.EntryPoint = h.EntryPoint.ID
 	}
+	if h.pinnedEntry != nil {
+		si.Pinned = true
+		si.PinnedEntry = h.pinnedEntry.ID
+	}
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 	if err := enc.Encode(si); err != nil {
@@ -193,21 +492,42 @@ func (h *HNSWIndex) GobDecode(data []byte) error {
 		log.Error().Err(err).Msg("Failed to decode HNSWIndex")
 		return err
 	}
+	// The distance function isn't serialized, so resolve it from its name.
+	distances := core.Distances
+	if si.Precise {
+		distances = core.PreciseDistances
+	}
+	distance, ok := distances[si.DistanceName]
+	if !ok {
+		return fmt.Errorf("unknown distance %q in saved index; add it to core.Distances before loading",
+			si.DistanceName)
+	}
+	h.Distance = distance
 	h.Dimension = si.Dimension
 	h.M = si.M
 	h.Ef = si.Ef
 	h.MaxLevel = si.MaxLevel
 	h.DistanceName = si.DistanceName
+	h.Float16 = si.Float16
+	h.Int8 = si.Int8
+	h.Int8Scale = si.Int8Scale
+	h.Normalize = si.Normalize
+	h.NormMode = si.NormMode
+	h.SkipReverseLinks = si.SkipReverse
 	h.Nodes = make(map[int]*Node)
+	h.vectorBytes = 0
 	// Recreate nodes from the serialized data.
 	for id, sn := range si.Nodes {
 		h.Nodes[id] = &Node{
 			ID:           sn.ID,
 			Vector:       sn.Vector,
+			Vector16:     sn.Vector16,
+			Vector8:      sn.Vector8,
 			Level:        sn.Level,
 			Links:        make(map[int][]*Node),
 			ReverseLinks: make(map[int][]*Node),
 		}
+		h.vectorBytes += vectorBytes(h.Nodes[id])
 	}
 	// Restore neighbor pointers.
 	for id, sn := range si.Nodes {
@@ -221,17 +541,24 @@ func (h *HNSWIndex) GobDecode(data []byte) error {
 		}
 	}
 	// Rebuild reverse links.
-	for _, node := range h.Nodes {
-		for level, neighbors := range node.Links {
-			for _, nb := range neighbors {
-				nb.ReverseLinks[level] = append(nb.ReverseLinks[level], node)
+	if !h.SkipReverseLinks {
+		for _, node := range h.Nodes {
+			for level, neighbors := range node.Links {
+				for _, nb := range neighbors {
+					nb.ReverseLinks[level] = append(nb.ReverseLinks[level], node)
+				}
 			}
 		}
 	}
-	if si.EntryPoint != 0 {
-		h.EntryPoint = h.Nodes[si.EntryPoint]
-	} else {
-		h.EntryPoint = nil
+	// Restore the entry point. An empty index has none; if the saved entry point is missing,
+	// fall back to the node with the highest level.
+	h.EntryPoint = h.Nodes[si.EntryPoint]
+	if h.EntryPoint == nil {
+		h.resetEntryPoint()
+	}
+	h.pinnedEntry = nil
+	if si.Pinned {
+		h.pinnedEntry = h.Nodes[si.PinnedEntry]
 	}
 	return nil
 }
@@ -250,26 +577,55 @@ func selectM(candidates []candidate, M int) []candidate {
 	return candidates
 }
 
-// selectNodes selects up to M nodes from a list based on their distance to vec.
-func selectNodes(nodes []*Node, vec []float32, M int, distance func([]float32, []float32) float64) []*Node {
-	// Create a temporary array with nodes and their distances.
-	type nodeWithDist struct {
-		node *Node
-		dist float64
+// selectLinks chooses up to M of the candidates closest to the node owner to link it to. Candidates at
+// equal distances are ordered by core.TieRank seeded with the owner's id instead of by id, and at most
+// half of the links go to candidates at any one distance while others are left. Otherwise, with many
+// identical vectors, every node would link to the same few lowest ids, turning them into hubs, and the
+// duplicates would crowd out the links to any other node, leaving it unreachable.
+func selectLinks(candidates []candidate, M int, owner int) []candidate {
+	seed := uint64(owner)
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].dist == candidates[j].dist {
+			return core.TieRank(seed, candidates[i].node.ID) < core.TieRank(seed, candidates[j].node.ID)
+		}
+		return candidates[i].dist < candidates[j].dist
+	})
+	if len(candidates) <= M {
+		return candidates
+	}
+	maxTied := max(1, M/2)
+	selected := make([]candidate, 0, M)
+	var deferred []candidate
+	tied := 0
+	for i, c := range candidates {
+		if i > 0 && c.dist != candidates[i-1].dist {
+			tied = 0
+		}
+		tied++
+		if tied > maxTied {
+			deferred = append(deferred, c)
+			continue
+		}
+		selected = append(selected, c)
+		if len(selected) == M {
+			return selected
+		}
 	}
-	arr := make([]nodeWithDist, len(nodes))
+	// Too few candidates at other distances remain, so the closest deferred ties fill the free links.
+	return append(selected, deferred[:M-len(selected)]...)
+}
+
+// selectNodes selects up to M of the nodes closest to vec, the vector of the node owner, to link it to,
+// as selectLinks does.
+func selectNodes(nodes []*Node, vec []float32, M int, owner int, distance func([]float32, *Node) float64) []*Node {
+	candidates := make([]candidate, len(nodes))
 	for i, n := range nodes {
-		arr[i] = nodeWithDist{n, distance(vec, n.Vector)}
+		candidates[i] = candidate{n, distance(vec, n)}
 	}
-	sort.Slice(arr, func(i, j int) bool {
-		if arr[i].dist == arr[j].dist {
-			return arr[i].node.ID < arr[j].node.ID
-		}
-		return arr[i].dist < arr[j].dist
-	})
-	selected := make([]*Node, minInt(len(arr), M))
-	for i := range selected {
-		selected[i] = arr[i].node
+	selectedCands := selectLinks(candidates, M, owner)
+	selected := make([]*Node, len(selectedCands))
+	for i, c := range selectedCands {
+		selected[i] = c.node
 	}
 	return selected
 }
@@ -301,18 +657,34 @@ func difference(a, b []*Node) []*Node {
 }
 
 // trimNeighborLinks reduces a node's neighbors at a level to the best M based on distance.
-func trimNeighborLinks(n *Node, level, M int, distance func([]float32, []float32) float64) {
+func (h *HNSWIndex) trimNeighborLinks(n *Node, level, M int) {
 	original := n.Links[level]
-	trimmed := selectNodes(original, n.Vector, M, distance)
-	removed := difference(original, trimmed)
-	for _, r := range removed {
-		r.ReverseLinks[level] = removeFromSlice(r.ReverseLinks[level], n)
+	trimmed := selectNodes(original, h.vector(n), M, n.ID, h.nodeDist)
+	if !h.SkipReverseLinks {
+		removed := difference(original, trimmed)
+		for _, r := range removed {
+			r.ReverseLinks[level] = removeFromSlice(r.ReverseLinks[level], n)
+		}
 	}
 	n.Links[level] = trimmed
 }
 
 // removeNodeLinks removes all links of a node from the graph.
+// Without reverse links, the links of every node at the node's levels are scanned.
 func (h *HNSWIndex) removeNodeLinks(n *Node) {
+	if h.SkipReverseLinks {
+		for _, other := range h.Nodes {
+			for level := 0; level <= n.Level && level <= other.Level; level++ {
+				if containsNode(other.Links[level], n) {
+					other.Links[level] = removeFromSlice(other.Links[level], n)
+				}
+			}
+		}
+		for level := range n.Links {
+			n.Links[level] = nil
+		}
+		return
+	}
 	for level, neighbors := range n.ReverseLinks {
 		for _, neighbor := range neighbors {
 			neighbor.Links[level] = removeFromSlice(neighbor.Links[level], n)
@@ -327,6 +699,81 @@ func (h *HNSWIndex) removeNodeLinks(n *Node) {
 	}
 }
 
+// resetEntryPoint makes the node with the highest level the entry point.
+// The entry point is nil and MaxLevel is -1 if the index is empty.
+func (h *HNSWIndex) resetEntryPoint() {
+	h.EntryPoint = nil
+	h.MaxLevel = -1
+	for _, n := range h.Nodes {
+		if h.EntryPoint == nil || n.Level > h.EntryPoint.Level {
+			h.EntryPoint = n
+		}
+	}
+	if h.EntryPoint != nil {
+		h.MaxLevel = h.EntryPoint.Level
+	}
+}
+
+// SetEntryPoint pins the node with the given id as the starting point of all searches, routing them
+// through a known node. Unlike EntryPoint, which always tracks the node with the highest level, the
+// pinned node is kept across inserts and deletes until ClearEntryPoint is called or the node itself
+// is deleted. Insertion still starts from EntryPoint so the graph is built as usual.
+// Searches start from the pinned node's own level, so a low-level or poorly connected node skips the
+// upper layers and can hurt recall.
+func (h *HNSWIndex) SetEntryPoint(id int) error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
+	node, exists := h.Nodes[id]
+	if !exists {
+		return fmt.Errorf("id %d: %w", id, core.ErrNotFound)
+	}
+	h.pinnedEntry = node
+	return nil
+}
+
+// ClearEntryPoint removes the entry point pinned by SetEntryPoint, so searches start from EntryPoint again.
+func (h *HNSWIndex) ClearEntryPoint() error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
+	h.pinnedEntry = nil
+	return nil
+}
+
+// PinnedEntryPoint returns the id of the entry point pinned by SetEntryPoint and whether one is pinned.
+func (h *HNSWIndex) PinnedEntryPoint() (int, bool) {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	if h.pinnedEntry == nil {
+		return 0, false
+	}
+	return h.pinnedEntry.ID, true
+}
+
+// searchEntryPoint returns the node searches start from and the level to start at.
+func (h *HNSWIndex) searchEntryPoint() (*Node, int) {
+	if h.pinnedEntry != nil {
+		return h.pinnedEntry, h.pinnedEntry.Level
+	}
+	return h.EntryPoint, h.MaxLevel
+}
+
+// unpinDeletedEntryPoint clears the pinned entry point if its node is no longer in the index.
+func (h *HNSWIndex) unpinDeletedEntryPoint() {
+	if h.pinnedEntry != nil && h.Nodes[h.pinnedEntry.ID] != h.pinnedEntry {
+		log.Warn().Msgf("Pinned entry point %d was deleted; searches start from the default entry point",
+			h.pinnedEntry.ID)
+		h.pinnedEntry = nil
+	}
+}
+
 // minInt returns the smaller of two integers.
 func minInt(a, b int) int {
 	if a < b {
@@ -337,25 +784,36 @@ func minInt(a, b int) int {
 
 // insertNode adds a node into the HNSW graph, updating links as needed.
 func (h *HNSWIndex) insertNode(n *Node, searchEf int) {
+	h.insertNodeAbove(n, searchEf, 0)
+}
+
+// insertNodeAbove adds a node into the HNSW graph like insertNode, but only links it at levels
+// minLevel and above.
+func (h *HNSWIndex) insertNodeAbove(n *Node, searchEf int, minLevel int) {
+	h.building = true
+	defer func() { h.building = false }()
 	// If index is empty, set this node as entry point.
 	if h.EntryPoint == nil {
 		h.EntryPoint = n
 		h.MaxLevel = n.Level
 		return
 	}
-	// Update entry point if the new node has a higher level.
+	current := h.EntryPoint
+	maxLevel := h.MaxLevel
+	// Update entry point if the new node has a higher level. This is done up front, but the
+	// node is linked starting from the previous entry point so it stays connected to the graph.
 	if n.Level > h.MaxLevel {
 		h.EntryPoint = n
 		h.MaxLevel = n.Level
 	}
-	current := h.EntryPoint
+	vec := h.vector(n)
 	// Navigate the graph from the top level down to the node's level.
-	for L := h.MaxLevel; L > n.Level; L-- {
+	for L := maxLevel; L > n.Level; L-- {
 		changed := true
 		for changed {
 			changed = false
 			for _, neighbor := range current.Links[L] {
-				if h.Distance(n.Vector, neighbor.Vector) < h.Distance(n.Vector, current.Vector) {
+				if h.nodeDist(vec, neighbor) < h.nodeDist(vec, current) {
 					current = neighbor
 					changed = true
 				}
@@ -363,9 +821,9 @@ func (h *HNSWIndex) insertNode(n *Node, searchEf int) {
 		}
 	}
 	// For each level where the new node will be inserted.
-	for L := minInt(n.Level, h.MaxLevel); L >= 0; L-- {
-		candList := h.searchLayer(n.Vector, current, L, searchEf, h.Distance)
-		selectedCands := selectM(candList, h.M)
+	for L := minInt(n.Level, maxLevel); L >= minLevel; L-- {
+		candList := h.searchLayer(vec, current, L, searchEf)
+		selectedCands := selectLinks(candList, h.M, n.ID)
 		selectedNodes := make([]*Node, len(selectedCands))
 		for i, cand := range selectedCands {
 			selectedNodes[i] = cand.node
@@ -374,9 +832,12 @@ func (h *HNSWIndex) insertNode(n *Node, searchEf int) {
 		// Update neighbor links to include the new node.
 		for _, neighbor := range selectedNodes {
 			neighbor.Links[L] = append(neighbor.Links[L], n)
-			neighbor.ReverseLinks[L] = append(neighbor.ReverseLinks[L], n)
+			if !h.SkipReverseLinks {
+				neighbor.ReverseLinks[L] = append(neighbor.ReverseLinks[L], n)
+				n.ReverseLinks[L] = append(n.ReverseLinks[L], neighbor)
+			}
 			if len(neighbor.Links[L]) > h.M {
-				trimNeighborLinks(neighbor, L, h.M, h.Distance)
+				h.trimNeighborLinks(neighbor, L, h.M)
 			}
 		}
 		// Move the current pointer for the next level.
@@ -386,15 +847,119 @@ func (h *HNSWIndex) insertNode(n *Node, searchEf int) {
 	}
 }
 
+// fallbackHeap is a max-heap of candidates ordered by distance and then id, so its top is always the
+// worst candidate and ties are resolved the same way regardless of the order nodes are visited in.
+type fallbackHeap []candidate
+
+func (h fallbackHeap) Len() int { return len(h) }
+func (h fallbackHeap) Less(i, j int) bool {
+	if h[i].dist == h[j].dist {
+		return h[i].node.ID > h[j].node.ID
+	}
+	return h[i].dist > h[j].dist
+}
+func (h fallbackHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *fallbackHeap) Push(x interface{}) { *h = append(*h, x.(candidate)) }
+func (h *fallbackHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// offer adds a candidate if the heap holds fewer than size candidates or it beats the worst one.
+func (h *fallbackHeap) offer(c candidate, size int) {
+	if h.Len() < size {
+		heap.Push(h, c)
+		return
+	}
+	top := (*h)[0]
+	if c.dist < top.dist || (c.dist == top.dist && c.node.ID < top.node.ID) {
+		(*h)[0] = c
+		heap.Fix(h, 0)
+	}
+}
+
+// fallbackCandidates returns the size nodes closest to the query that are not in exclude, found by a
+// parallel brute-force scan. Each worker keeps a bounded heap of its best nodes, and the heaps are
+// merged at the end, so the cost is linear in the number of nodes without sorting them. Ties are
+// broken by smaller id. The result is in unspecified order.
+func (h *HNSWIndex) fallbackCandidates(query []float32, exclude map[int]bool, size int) []candidate {
+	if size <= 0 {
+		return nil
+	}
+	nodesSlice := make([]*Node, 0, len(h.Nodes))
+	for _, node := range h.Nodes {
+		if !exclude[node.ID] {
+			nodesSlice = append(nodesSlice, node)
+		}
+	}
+	if len(nodesSlice) == 0 {
+		return nil
+	}
+
+	numWorkers := core.Workers(h.MaxParallelism, len(nodesSlice))
+	chunkSize := (len(nodesSlice) + numWorkers - 1) / numWorkers
+	partials := make([]fallbackHeap, numWorkers)
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		start := i * chunkSize
+		end := start + chunkSize
+		if end > len(nodesSlice) {
+			end = len(nodesSlice)
+		}
+		if start >= end {
+			// Rounding the chunk size up can leave the last workers without nodes.
+			break
+		}
+		wg.Add(1)
+		go func(i int, nodesChunk []*Node) {
+			defer wg.Done()
+			local := make(fallbackHeap, 0, size)
+			for _, node := range nodesChunk {
+				local.offer(candidate{node, h.nodeDist(query, node)}, size)
+			}
+			partials[i] = local
+		}(i, nodesSlice[start:end])
+	}
+	wg.Wait()
+
+	// Merge results from all workers.
+	final := make(fallbackHeap, 0, size)
+	for _, partial := range partials {
+		for _, c := range partial {
+			final.offer(c, size)
+		}
+	}
+	return final
+}
+
 // searchLayer performs a search in the graph at a given level.
-func (h *HNSWIndex) searchLayer(query []float32, entrypoint *Node, level int, ef int, distance func([]float32, []float32) float64) []candidate {
+func (h *HNSWIndex) searchLayer(query []float32, entrypoint *Node, level int, ef int) []candidate {
+	return h.searchLayerTrace(query, entrypoint, level, ef, nil)
+}
+
+// searchLayerTrace is searchLayer that also appends every node whose distance it computes to trace,
+// if trace is not nil.
+func (h *HNSWIndex) searchLayerTrace(query []float32, entrypoint *Node, level int, ef int,
+	trace *[]core.Neighbor) []candidate {
 	visited := map[int]bool{entrypoint.ID: true}
-	d0 := distance(query, entrypoint.Vector)
+	d0 := h.nodeDist(query, entrypoint)
+	if trace != nil {
+		*trace = append(*trace, core.Neighbor{ID: entrypoint.ID, Distance: d0})
+	}
 	candQueue := candidateMinHeap{{entrypoint, d0}}
 	heap.Init(&candQueue)
 	resultQueue := candidateMaxHeap{{entrypoint, d0}}
 	heap.Init(&resultQueue)
+	// Early termination would record partial distances in the trace, so it is only used without one.
+	var early core.EarlyStopDistanceFunc
+	if h.EarlyStop && trace == nil {
+		early = core.EarlyStopFor(h.DistanceName, h.Distance)
+	}
 	// Explore candidates while there are promising ones.
+	ties := 0
 	for candQueue.Len() > 0 {
 		current := candQueue[0]
 		worstResult := resultQueue[0]
@@ -407,7 +972,19 @@ func (h *HNSWIndex) searchLayer(query []float32, entrypoint *Node, level int, ef
 				continue
 			}
 			visited[neighbor.ID] = true
-			d := distance(query, neighbor.Vector)
+			var d float64
+			if early != nil && neighbor.Vector != nil && resultQueue.Len() >= ef {
+				// A neighbor farther than the worst result is discarded, so its exact distance isn't needed.
+				d = early(query, neighbor.Vector, resultQueue[0].dist)
+				if h.building {
+					h.buildDistances.Add(1)
+				}
+			} else {
+				d = h.nodeDist(query, neighbor)
+			}
+			if trace != nil {
+				*trace = append(*trace, core.Neighbor{ID: neighbor.ID, Distance: d})
+			}
 			if resultQueue.Len() < ef || d < resultQueue[0].dist {
 				newCand := candidate{neighbor, d}
 				heap.Push(&candQueue, newCand)
@@ -415,6 +992,13 @@ func (h *HNSWIndex) searchLayer(query []float32, entrypoint *Node, level int, ef
 				if resultQueue.Len() > ef {
 					heap.Pop(&resultQueue)
 				}
+			} else if d == resultQueue[0].dist && ties < ef {
+				// A neighbor tied with the worst result doesn't improve the results, but it is explored so
+				// that a plateau of equal distances, such as many identical vectors filling the beam, doesn't
+				// end the search before the nodes linked from the rest of the plateau are reached. At most ef
+				// ties are explored per search, so a large plateau isn't walked in full on every insert.
+				ties++
+				heap.Push(&candQueue, candidate{neighbor, d})
 			}
 		}
 	}
@@ -434,147 +1018,409 @@ func (h *HNSWIndex) searchLayer(query []float32, entrypoint *Node, level int, ef
 
 // Add inserts a new vector into the index with a unique id.
 func (h *HNSWIndex) Add(id int, vector []float32) error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
 	h.Mu.Lock()
 	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
 	if len(vector) != h.Dimension {
-		return fmt.Errorf("vector dimension %d does not match index dimension %d",
-			len(vector), h.Dimension)
+		return fmt.Errorf("%w: vector dimension %d does not match index dimension %d",
+			core.ErrDimensionMismatch, len(vector), h.Dimension)
 	}
 
 	if _, exists := h.Nodes[id]; exists {
-		return fmt.Errorf("id %d already exists", id)
+		return fmt.Errorf("id %d: %w", id, core.ErrDuplicateID)
 	}
-	level := h.randomLevel()
-	newNode := &Node{
-		ID:           id,
-		Vector:       vector,
-		Level:        level,
-		Links:        make(map[int][]*Node),
-		ReverseLinks: make(map[int][]*Node),
+	// A single vector says nothing about the range of the ones that follow, so the scale is only
+	// learned from bulk inserts.
+	if h.Int8 && h.Int8Scale == 0 {
+		return errInt8ScaleUnset
 	}
+	newNode := h.newNode(id, vector, nil, h.randomLevel())
 	h.Nodes[id] = newNode
+	h.vectorBytes += vectorBytes(newNode)
 	h.insertNode(newNode, h.Ef)
 	return nil
 }
 
 // Delete removes a vector from the index by its id.
 func (h *HNSWIndex) Delete(id int) error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
 	h.Mu.Lock()
 	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
 	node, exists := h.Nodes[id]
 	if !exists {
-		return fmt.Errorf("id %d not found", id)
+		return fmt.Errorf("id %d: %w", id, core.ErrNotFound)
 	}
 	h.removeNodeLinks(node)
 	delete(h.Nodes, id)
+	h.vectorBytes -= vectorBytes(node)
 	// Update the entry point if necessary.
 	if h.EntryPoint != nil && h.EntryPoint.ID == id {
-		h.EntryPoint = nil
-		for _, n := range h.Nodes {
-			if h.EntryPoint == nil || n.Level > h.EntryPoint.Level {
-				h.EntryPoint = n
-			}
-		}
+		h.resetEntryPoint()
 	}
+	h.unpinDeletedEntryPoint()
 	return nil
 }
 
 // Update changes the vector for an existing node and re-inserts it in the graph.
 func (h *HNSWIndex) Update(id int, vector []float32) error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
 	h.Mu.Lock()
 	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
 	node, exists := h.Nodes[id]
 	if !exists {
-		return fmt.Errorf("id %d not found", id)
+		return fmt.Errorf("id %d: %w", id, core.ErrNotFound)
 	}
 	if len(vector) != h.Dimension {
-		return fmt.Errorf("vector dimension %d does not match index dimension %d",
-			len(vector), h.Dimension)
+		return fmt.Errorf("%w: vector dimension %d does not match index dimension %d",
+			core.ErrDimensionMismatch, len(vector), h.Dimension)
+	}
+	if h.Int8 && h.Int8Scale == 0 {
+		return errInt8ScaleUnset
 	}
 
 	h.removeNodeLinks(node)
-	node.Vector = vector
+	h.vectorBytes -= vectorBytes(node)
+	h.setVector(node, vector)
+	h.vectorBytes += vectorBytes(node)
 	node.Links = make(map[int][]*Node)
 	node.ReverseLinks = make(map[int][]*Node)
+	// A node can't be inserted starting from itself, so pick another entry point first.
+	if h.EntryPoint == node {
+		delete(h.Nodes, id)
+		h.resetEntryPoint()
+		h.Nodes[id] = node
+	}
 	h.insertNode(node, h.Ef)
 	return nil
 }
 
+// Reserve pre-sizes the node map for n additional nodes to avoid rehashing during a large BulkAdd.
+// It is only a hint and can be called on a non-empty index.
+func (h *HNSWIndex) Reserve(n int) {
+	if n <= 0 {
+		return
+	}
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+	nodes := make(map[int]*Node, len(h.Nodes)+n)
+	for id, node := range h.Nodes {
+		nodes[id] = node
+	}
+	h.Nodes = nodes
+}
+
 // BulkAdd inserts multiple vectors into the index at once.
 func (h *HNSWIndex) BulkAdd(vectors map[int][]float32) error {
-
-	nodesSlice := make([]*Node, 0, len(vectors))
-	for id, vector := range vectors {
-		if len(vector) != h.Dimension {
-			return fmt.Errorf("vector dimension %d does not match index dimension %d for id %d",
-				len(vector), h.Dimension, id)
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
+	ids := sortedIDs(vectors)
+	for _, id := range ids {
+		if vector := vectors[id]; len(vector) != h.Dimension {
+			return fmt.Errorf("%w: vector dimension %d does not match index dimension %d for id %d",
+				core.ErrDimensionMismatch, len(vector), h.Dimension, id)
 		}
 		if _, exists := h.Nodes[id]; exists {
-			return fmt.Errorf("id %d already exists", id)
+			return fmt.Errorf("id %d: %w", id, core.ErrDuplicateID)
 		}
-		level := h.randomLevel()
-		newNode := &Node{
-			ID:           id,
-			Vector:       vector,
-			Level:        level,
-			Links:        make(map[int][]*Node),
-			ReverseLinks: make(map[int][]*Node),
+	}
+	// The scale is learned only once the batch is known to be accepted, and under the lock, so that
+	// every vector is quantized with the scale that stays set.
+	h.learnInt8Scale(vectors)
+	prepared := h.prepareVectors(vectors, ids)
+	nodesSlice := make([]*Node, 0, len(vectors))
+	for i, id := range ids {
+		nodesSlice = append(nodesSlice, h.newNode(id, vectors[id], prepared[i], h.randomLevel()))
+	}
+	return h.insertBulk(nodesSlice)
+}
+
+// BulkAddLenient inserts multiple vectors into the index, skipping vectors that cannot be added.
+// Vectors with a mismatched dimension or an id that already exists are not inserted; the reason is
+// recorded in the returned failures map keyed by id. It returns the number of vectors added.
+func (h *HNSWIndex) BulkAddLenient(vectors map[int][]float32) (int, map[int]error) {
+	if h.frozen.Load() {
+		failures := make(map[int]error, len(vectors))
+		for id := range vectors {
+			failures[id] = core.ErrFrozen
 		}
-		nodesSlice = append(nodesSlice, newNode)
+		return 0, failures
 	}
-	// Sort nodes by level descending.
-	sort.Slice(nodesSlice, func(i, j int) bool {
-		return nodesSlice[i].Level > nodesSlice[j].Level
-	})
-	bulkEf := h.Ef
 	h.Mu.Lock()
 	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
 
-	// Initialize progress bar with a newline after finish.
-	bar := progressbar.NewOptions(len(nodesSlice),
-		progressbar.OptionOnCompletion(func() { fmt.Print("\n") }),
-	)
-
-	for _, newNode := range nodesSlice {
-		h.Nodes[newNode.ID] = newNode
-		if h.EntryPoint == nil {
-			h.EntryPoint = newNode
-			h.MaxLevel = newNode.Level
-		} else {
-			if newNode.Level > h.MaxLevel {
-				h.EntryPoint = newNode
-				h.MaxLevel = newNode.Level
-			}
-			h.insertNode(newNode, bulkEf)
+	failures := make(map[int]error)
+	accepted := make(map[int][]float32, len(vectors))
+	for _, id := range sortedIDs(vectors) {
+		vector := vectors[id]
+		if len(vector) != h.Dimension {
+			failures[id] = fmt.Errorf("%w: vector dimension %d does not match index dimension %d for id %d",
+				core.ErrDimensionMismatch, len(vector), h.Dimension, id)
+			continue
 		}
-		err := bar.Add(1)
-		if err != nil {
-			return err
+		if _, exists := h.Nodes[id]; exists {
+			failures[id] = fmt.Errorf("id %d: %w", id, core.ErrDuplicateID)
+			continue
 		}
+		accepted[id] = vector
 	}
-	return nil
+	h.learnInt8Scale(accepted)
+	nodesSlice := make([]*Node, 0, len(accepted))
+	for _, id := range sortedIDs(accepted) {
+		nodesSlice = append(nodesSlice, h.newNode(id, accepted[id], nil, h.randomLevel()))
+	}
+	if err := h.insertBulk(nodesSlice); err != nil {
+		// Only the progress bar can fail here, and all nodes are inserted before it reports.
+		log.Warn().Err(err).Msg("Progress bar failed during lenient bulk insertion")
+	}
+	return len(nodesSlice), failures
 }
 
-// BulkDelete removes multiple nodes from the index.
-func (h *HNSWIndex) BulkDelete(ids []int) error {
+// BuildFromKNN builds the graph of an empty index from vectors and a precomputed k-nearest-neighbor
+// graph, such as an exact one, instead of searching for the neighbors of every node.
+// Levels are assigned randomly as in BulkAdd and the upper levels are built by normal insertion, while
+// the level-0 links of each node are taken from its list in knn, keeping the M closest. Each such link
+// is also added in the opposite direction if the neighbor has fewer than M links, which keeps the graph
+// navigable where the kNN graph is not symmetric. Ids in knn must be keys of vectors.
+func (h *HNSWIndex) BuildFromKNN(vectors map[int][]float32, knn map[int][]int) error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
 	h.Mu.Lock()
 	defer h.Mu.Unlock()
-
-	// Initialize progress bar with newline on completion.
-	bar := progressbar.NewOptions(len(ids),
-		progressbar.OptionOnCompletion(func() { fmt.Print("\n") }),
-	)
-	for _, id := range ids {
-		node, exists := h.Nodes[id]
-		if !exists {
-			err := bar.Add(1)
-			if err != nil {
-				return err
+	defer h.queryCache.Load().Invalidate()
+	if len(h.Nodes) > 0 {
+		return fmt.Errorf("BuildFromKNN requires an empty index, it holds %d vectors", len(h.Nodes))
+	}
+	for id, neighbors := range knn {
+		if _, exists := vectors[id]; !exists {
+			return fmt.Errorf("knn graph lists id %d: %w", id, core.ErrNotFound)
+		}
+		for _, nb := range neighbors {
+			if _, exists := vectors[nb]; !exists {
+				return fmt.Errorf("knn graph of id %d lists id %d: %w", id, nb, core.ErrNotFound)
 			}
-			continue
 		}
-		h.removeNodeLinks(node)
+	}
+	ids := sortedIDs(vectors)
+	for _, id := range ids {
+		if vector := vectors[id]; len(vector) != h.Dimension {
+			return fmt.Errorf("%w: vector dimension %d does not match index dimension %d for id %d",
+				core.ErrDimensionMismatch, len(vector), h.Dimension, id)
+		}
+	}
+	h.learnInt8Scale(vectors)
+	nodesSlice := make([]*Node, 0, len(vectors))
+	for _, id := range ids {
+		nodesSlice = append(nodesSlice, h.newNode(id, vectors[id], nil, h.randomLevel()))
+	}
+
+	// Build the upper levels by inserting the nodes that reach them, highest levels first.
+	sortByLevel(nodesSlice)
+	for _, n := range nodesSlice {
+		h.Nodes[n.ID] = n
+		h.vectorBytes += vectorBytes(n)
+		if n.Level > 0 || h.EntryPoint == nil {
+			h.insertNodeAbove(n, h.Ef, 1)
+		}
+	}
+
+	// Seed level 0 from the kNN graph.
+	h.building = true
+	defer func() { h.building = false }()
+	for _, n := range nodesSlice {
+		neighbors := make([]*Node, 0, len(knn[n.ID]))
+		for _, id := range knn[n.ID] {
+			if nb := h.Nodes[id]; nb != n && !containsNode(neighbors, nb) {
+				neighbors = append(neighbors, nb)
+			}
+		}
+		n.Links[0] = selectNodes(neighbors, h.vector(n), h.M, n.ID, h.nodeDist)
+	}
+	for _, n := range nodesSlice {
+		for _, nb := range n.Links[0] {
+			if len(nb.Links[0]) < h.M && !containsNode(nb.Links[0], n) {
+				nb.Links[0] = append(nb.Links[0], n)
+			}
+		}
+	}
+	if !h.SkipReverseLinks {
+		for _, n := range nodesSlice {
+			for _, nb := range n.Links[0] {
+				nb.ReverseLinks[0] = append(nb.ReverseLinks[0], n)
+			}
+		}
+	}
+	log.Info().Msgf("Built HNSW index from a kNN graph with %d vectors", len(nodesSlice))
+	return nil
+}
+
+// RebuildUpperLayers draws new levels for all nodes and rebuilds the links at levels 1 and above by
+// insertion, keeping the level-0 links as they are. It refreshes the navigation layers and the entry
+// point, for example when searches take many hops to reach the right region, at a fraction of the cost
+// of a full rebuild, since only the nodes that reach level 1 (about 1/M of them) are inserted again.
+func (h *HNSWIndex) RebuildUpperLayers() error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
+	ids := make([]int, 0, len(h.Nodes))
+	for id := range h.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	nodesSlice := make([]*Node, 0, len(ids))
+	for _, id := range ids {
+		n := h.Nodes[id]
+		for level := range n.Links {
+			if level > 0 {
+				delete(n.Links, level)
+			}
+		}
+		for level := range n.ReverseLinks {
+			if level > 0 {
+				delete(n.ReverseLinks, level)
+			}
+		}
+		n.Level = h.randomLevel()
+		nodesSlice = append(nodesSlice, n)
+	}
+
+	h.EntryPoint = nil
+	h.MaxLevel = -1
+	sortByLevel(nodesSlice)
+	for _, n := range nodesSlice {
+		if n.Level > 0 || h.EntryPoint == nil {
+			h.insertNodeAbove(n, h.Ef, 1)
+		}
+	}
+	log.Info().Msgf("Rebuilt the upper layers of the HNSW index, max level %d", h.MaxLevel)
+	return nil
+}
+
+// ResetLinks drops the links of every node and rebuilds the graph by inserting all nodes again, keeping
+// the nodes, their vectors, and their levels. It restores connectivity when the links are corrupt but
+// the set of nodes is fine, for example after Validate reports a broken link.
+func (h *HNSWIndex) ResetLinks() error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
+	ids := make([]int, 0, len(h.Nodes))
+	for id := range h.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	nodesSlice := make([]*Node, 0, len(ids))
+	for _, id := range ids {
+		n := h.Nodes[id]
+		n.Links = make(map[int][]*Node)
+		n.ReverseLinks = make(map[int][]*Node)
+		nodesSlice = append(nodesSlice, n)
+	}
+
+	h.EntryPoint = nil
+	h.MaxLevel = -1
+	sortByLevel(nodesSlice)
+	for _, n := range nodesSlice {
+		h.insertNode(n, h.Ef)
+	}
+	log.Info().Msgf("Reset the links of the HNSW index, %d nodes reinserted", len(nodesSlice))
+	return nil
+}
+
+// sortedIDs returns the ids of vectors in ascending order, so that levels are drawn from the
+// random generator in the same order for a given input map.
+func sortedIDs(vectors map[int][]float32) []int {
+	ids := make([]int, 0, len(vectors))
+	for id := range vectors {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// sortByLevel sorts nodes by level descending, breaking ties by ascending id so that the
+// insertion order does not depend on map iteration order.
+func sortByLevel(nodes []*Node) {
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Level != nodes[j].Level {
+			return nodes[i].Level > nodes[j].Level
+		}
+		return nodes[i].ID < nodes[j].ID
+	})
+}
+
+// insertBulk inserts prepared nodes into the graph, highest levels first.
+// The caller must hold the write lock.
+func (h *HNSWIndex) insertBulk(nodesSlice []*Node) error {
+	sortByLevel(nodesSlice)
+	bulkEf := h.Ef
+
+	// Initialize progress bar with a newline after finish.
+	bar := progressbar.NewOptions(len(nodesSlice),
+		progressbar.OptionOnCompletion(func() { fmt.Print("\n") }),
+	)
+
+	// A failing progress bar doesn't stop the insertion: every node is inserted before the first
+	// error of the bar is returned.
+	var barErr error
+	for _, newNode := range nodesSlice {
+		h.Nodes[newNode.ID] = newNode
+		h.vectorBytes += vectorBytes(newNode)
+		h.insertNode(newNode, bulkEf)
+		if err := bar.Add(1); err != nil && barErr == nil {
+			barErr = err
+		}
+	}
+	return barErr
+}
+
+// BulkDelete removes multiple nodes from the index.
+func (h *HNSWIndex) BulkDelete(ids []int) error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
+	h.Mu.Lock()
+	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
+
+	// Initialize progress bar with newline on completion.
+	bar := progressbar.NewOptions(len(ids),
+		progressbar.OptionOnCompletion(func() { fmt.Print("\n") }),
+	)
+	for _, id := range ids {
+		node, exists := h.Nodes[id]
+		if !exists {
+			err := bar.Add(1)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// Without reverse links, the sweep below removes the links to deleted nodes in a single pass.
+		if !h.SkipReverseLinks {
+			h.removeNodeLinks(node)
+		}
 		delete(h.Nodes, id)
+		h.vectorBytes -= vectorBytes(node)
 		err := bar.Add(1)
 		if err != nil {
 			return err
@@ -594,26 +1440,30 @@ func (h *HNSWIndex) BulkDelete(ids []int) error {
 		}
 	}
 	// Update the entry point.
-	h.EntryPoint = nil
-	for _, n := range h.Nodes {
-		if h.EntryPoint == nil || n.Level > h.EntryPoint.Level {
-			h.EntryPoint = n
-		}
-	}
+	h.resetEntryPoint()
+	h.unpinDeletedEntryPoint()
 	return nil
 }
 
 // BulkUpdate updates multiple nodes with new vectors.
 func (h *HNSWIndex) BulkUpdate(updates map[int][]float32) error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
 
 	h.Mu.Lock()
 	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
 
 	// Progress bar for processing updates with newline on finish.
 	bar := progressbar.NewOptions(len(updates),
 		progressbar.OptionOnCompletion(func() { fmt.Print("\n") }),
 	)
-	for id, vector := range updates {
+	ids := sortedIDs(updates)
+	h.learnInt8Scale(updates)
+	prepared := h.prepareVectors(updates, ids)
+	for i, id := range ids {
+		vector := updates[id]
 		node, exists := h.Nodes[id]
 		if !exists {
 			err := bar.Add(1)
@@ -623,27 +1473,26 @@ func (h *HNSWIndex) BulkUpdate(updates map[int][]float32) error {
 			continue
 		}
 		if len(vector) != h.Dimension {
-			return fmt.Errorf("vector dimension %d does not match index dimension %d for id %d",
-				len(vector), h.Dimension, id)
+			return fmt.Errorf("%w: vector dimension %d does not match index dimension %d for id %d",
+				core.ErrDimensionMismatch, len(vector), h.Dimension, id)
 		}
-		h.removeNodeLinks(node)
-		node.Vector = vector
-		node.Links = make(map[int][]*Node)
-		node.ReverseLinks = make(map[int][]*Node)
+		h.vectorBytes -= vectorBytes(node)
+		h.storeVector(node, prepared[i])
+		h.vectorBytes += vectorBytes(node)
 		err := bar.Add(1)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Reinsert all nodes to rebuild links.
+	// Reinsert all nodes to rebuild links, starting from an empty graph.
 	allNodes := make([]*Node, 0, len(h.Nodes))
 	for _, node := range h.Nodes {
+		node.Links = make(map[int][]*Node)
+		node.ReverseLinks = make(map[int][]*Node)
 		allNodes = append(allNodes, node)
 	}
-	sort.Slice(allNodes, func(i, j int) bool {
-		return allNodes[i].Level > allNodes[j].Level
-	})
+	sortByLevel(allNodes)
 	h.EntryPoint = nil
 	h.MaxLevel = -1
 
@@ -652,10 +1501,6 @@ func (h *HNSWIndex) BulkUpdate(updates map[int][]float32) error {
 		progressbar.OptionOnCompletion(func() { fmt.Print("\n") }),
 	)
 	for _, node := range allNodes {
-		if h.EntryPoint == nil || node.Level > h.MaxLevel {
-			h.EntryPoint = node
-			h.MaxLevel = node.Level
-		}
 		h.insertNode(node, h.Ef)
 		err := bar.Add(1)
 		if err != nil {
@@ -666,118 +1511,256 @@ func (h *HNSWIndex) BulkUpdate(updates map[int][]float32) error {
 }
 
 // Search finds the k-nearest neighbors of a given query vector.
+// With the query cache enabled, repeated searches are answered from the cache, see EnableQueryCache.
 func (h *HNSWIndex) Search(query []float32, k int) ([]core.Neighbor, error) {
+	if cache := h.queryCache.Load(); cache != nil {
+		return cache.Search(query, k, func() ([]core.Neighbor, error) { return h.SearchInto(query, k, nil) })
+	}
+	return h.SearchInto(query, k, nil)
+}
+
+// SearchInto is like Search but writes the results into buf, reslicing it when its capacity suffices.
+// The returned slice aliases buf in that case, so buf must not be reused while the results are needed.
+func (h *HNSWIndex) SearchInto(query []float32, k int, buf []core.Neighbor) ([]core.Neighbor, error) {
+	return h.search(query, k, 0, buf, true, nil)
+}
+
+// SearchUnsorted returns the same k-nearest neighbors as Search, but in unspecified order.
+// It skips the final sort of the merged candidates when the brute-force fallback is used.
+func (h *HNSWIndex) SearchUnsorted(query []float32, k int) ([]core.Neighbor, error) {
+	return h.search(query, k, 0, nil, false, nil)
+}
+
+// SearchWith searches like Search with per-call options. Ef sets the beam width of the base layer search
+// for this call; Filter, ExcludeIDs, and RankMetric are applied to the candidates as described by
+// core.SearchWithOptions. NProbe does not apply to HNSW and is ignored.
+func (h *HNSWIndex) SearchWith(query []float32, k int, opts core.SearchOptions) ([]core.Neighbor, error) {
+	h.Mu.RLock()
+	count := len(h.Nodes)
+	h.Mu.RUnlock()
+	search := func(n int) ([]core.Neighbor, error) {
+		return h.search(query, n, opts.Ef, nil, true, nil)
+	}
+	return core.SearchWithOptions(query, k, opts, count, search, h.GetStoredVector)
+}
+
+// Explain runs a search like Search and returns how it got there: the entry point, the greedy
+// descent through the upper levels, the nodes evaluated on level 0, and the returned neighbors.
+// Recording the path costs extra allocations, so Explain is meant for debugging, not serving.
+func (h *HNSWIndex) Explain(query []float32, k int) (core.SearchExplanation, error) {
+	var explanation core.SearchExplanation
+	results, err := h.search(query, k, 0, nil, true, &explanation)
+	if err != nil {
+		return core.SearchExplanation{}, err
+	}
+	explanation.Results = results
+	return explanation, nil
+}
+
+// SearchWithMetric traverses the graph with the index metric, then re-ranks the nearest k*RerankFactor
+// candidates with rank and returns the k best by that metric. This allows, for example, finding the
+// neighborhood with Euclidean distance and ordering the final results by cosine distance. Results
+// outside the candidate set are never considered, so the larger the disagreement between the two
+// metrics, the larger RerankFactor should be. The query is normalized like the stored vectors before it
+// is passed to rank, so both are on the same scale.
+func (h *HNSWIndex) SearchWithMetric(query []float32, k int, rank core.DistanceFunc) ([]core.Neighbor, error) {
+	if rank == nil {
+		return nil, errors.New("rank distance function is nil")
+	}
+	factor := h.RerankFactor
+	if factor <= 0 {
+		factor = defaultRerankFactor
+	}
+	h.Mu.RLock()
+	numCandidates := k * factor
+	if numCandidates > len(h.Nodes) {
+		numCandidates = len(h.Nodes)
+	}
+	h.Mu.RUnlock()
+	if k <= 0 || numCandidates == 0 {
+		// Let search report the invalid k or the empty index.
+		numCandidates = k
+	}
+	candidates, err := h.search(query, numCandidates, 0, nil, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	h.Mu.RLock()
+	prepared := h.prepareVector(query)
+	results := make([]core.Neighbor, 0, len(candidates))
+	for _, c := range candidates {
+		node, exists := h.Nodes[c.ID]
+		if !exists {
+			// Deleted after the search returned it.
+			continue
+		}
+		results = append(results, core.Neighbor{ID: c.ID, Distance: rank(prepared, h.vector(node))})
+	}
+	h.Mu.RUnlock()
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance == results[j].Distance {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].Distance < results[j].Distance
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// dedupCandidates removes repeated node ids from candidates in place, keeping the closest occurrence of
+// each id in the position of its first one. The layer search never yields an id twice, but merging its
+// candidates with the fallback scan can in an inconsistent graph, for example one where two nodes share
+// an id, and a neighbor must never be returned twice.
+func dedupCandidates(candidates []candidate) []candidate {
+	seen := make(map[int]int, len(candidates)) // node id to its position in deduped
+	deduped := candidates[:0]
+	for _, c := range candidates {
+		if i, ok := seen[c.node.ID]; ok {
+			if c.dist < deduped[i].dist {
+				deduped[i] = c
+			}
+			continue
+		}
+		seen[c.node.ID] = len(deduped)
+		deduped = append(deduped, c)
+	}
+	return deduped
+}
+
+// SearchAboveSimilarity returns up to maxResults neighbors whose similarity to the query, as given by
+// core.DistanceToSimilarity for the index metric, exceeds minSim, sorted by distance. Instead of
+// gathering a fixed number of candidates, the base layer search stops as soon as its frontier is
+// farther than the distance minSim corresponds to, so a strict threshold ends the search early.
+// Like Search it is approximate: a vector above the threshold that the graph search does not reach
+// is missed.
+func (h *HNSWIndex) SearchAboveSimilarity(query []float32, minSim float64, maxResults int) ([]core.Neighbor, error) {
+	if maxResults <= 0 {
+		return nil, fmt.Errorf("%w: maxResults must be positive, got %d", core.ErrInvalidK, maxResults)
+	}
+	maxDist, err := core.SimilarityToDistance(h.DistanceName, minSim)
+	if err != nil {
+		return nil, err
+	}
 	h.Mu.RLock()
 	defer h.Mu.RUnlock()
 	if len(query) != h.Dimension {
-		return nil, fmt.Errorf("query dimension %d does not match index dimension %d",
-			len(query), h.Dimension)
+		return nil, fmt.Errorf("%w: query dimension %d does not match index dimension %d",
+			core.ErrDimensionMismatch, len(query), h.Dimension)
 	}
 	if h.EntryPoint == nil {
-		return nil, errors.New("index is empty")
+		return nil, core.ErrEmptyIndex
 	}
+	query = h.prepareVector(query)
 
 	// Greedy search down from the top layer.
-	current := h.EntryPoint
-	for L := h.MaxLevel; L > 0; L-- {
+	current, topLevel := h.searchEntryPoint()
+	for L := topLevel; L > 0; L-- {
 		changed := true
 		for changed {
 			changed = false
 			for _, neighbor := range current.Links[L] {
-				if h.Distance(query, neighbor.Vector) < h.Distance(query, current.Vector) {
+				if h.nodeDist(query, neighbor) < h.nodeDist(query, current) {
 					current = neighbor
 					changed = true
 				}
 			}
 		}
 	}
-	// Search in the base layer (level 0) for candidates.
-	candidates := h.searchLayer(query, current, 0, h.Ef, h.Distance)
-	if len(candidates) < k {
-		// Use fallback to gather more candidates if needed.
 
-		// Log that fallback is triggered.
-		log.Warn().Msgf("Fallback search triggered: insufficient candidates from"+
-			" searchLayer; only %d found", len(candidates))
-
-		candidateIDs := make(map[int]bool)
-		for _, c := range candidates {
-			candidateIDs[c.node.ID] = true
-		}
-
-		fallbackSize := k - len(candidates)
-		var keys []int
-		for id := range h.Nodes {
-			keys = append(keys, id)
+	// Search the base layer like searchLayer, but once the beam is full, stop at the first frontier
+	// node beyond the threshold: every node left in the frontier is at least as far.
+	ef := h.Ef
+	if maxResults > ef {
+		ef = maxResults
+	}
+	visited := map[int]bool{current.ID: true}
+	d0 := h.nodeDist(query, current)
+	candQueue := candidateMinHeap{{current, d0}}
+	resultQueue := candidateMaxHeap{{current, d0}}
+	for candQueue.Len() > 0 {
+		closest := candQueue[0]
+		if resultQueue.Len() >= ef && (closest.dist > resultQueue[0].dist || closest.dist > maxDist) {
+			break
 		}
-		sort.Ints(keys)
-		nodesSlice := make([]*Node, 0, len(h.Nodes))
-		for _, id := range keys {
-			node := h.Nodes[id]
-			if candidateIDs[node.ID] {
+		heap.Pop(&candQueue)
+		for _, neighbor := range closest.node.Links[0] {
+			if visited[neighbor.ID] {
 				continue
 			}
-			nodesSlice = append(nodesSlice, node)
+			visited[neighbor.ID] = true
+			d := h.nodeDist(query, neighbor)
+			if resultQueue.Len() < ef || d < resultQueue[0].dist {
+				heap.Push(&candQueue, candidate{neighbor, d})
+				heap.Push(&resultQueue, candidate{neighbor, d})
+				if resultQueue.Len() > ef {
+					heap.Pop(&resultQueue)
+				}
+			}
 		}
+	}
 
-		numWorkers := runtime.NumCPU()
-		if numWorkers > len(nodesSlice) {
-			numWorkers = len(nodesSlice)
+	results := make([]core.Neighbor, 0, resultQueue.Len())
+	for _, c := range resultQueue {
+		// The similarity is compared directly, so rounding in the threshold distance cannot admit a
+		// neighbor at or below minSim.
+		if sim, _ := core.DistanceToSimilarity(h.DistanceName, c.dist); sim > minSim {
+			results = append(results, core.Neighbor{ID: c.node.ID, Distance: c.dist})
 		}
-		chunkSize := (len(nodesSlice) + numWorkers - 1) / numWorkers
-		resultsCh := make(chan candidateMaxHeap, numWorkers)
-		var wg sync.WaitGroup
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance == results[j].Distance {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].Distance < results[j].Distance
+	})
+	if len(results) > maxResults {
+		results = results[:maxResults]
+	}
+	return results, nil
+}
 
-		// Run parallel fallback search.
-		for i := 0; i < numWorkers; i++ {
-			start := i * chunkSize
-			end := start + chunkSize
-			if end > len(nodesSlice) {
-				end = len(nodesSlice)
-			}
-			wg.Add(1)
-			go func(nodesChunk []*Node) {
-				defer wg.Done()
-				localHeap := candidateMaxHeap{}
-				heap.Init(&localHeap)
-				for _, node := range nodesChunk {
-					d := h.Distance(query, node.Vector)
-					cand := candidate{node, d}
-					if localHeap.Len() < fallbackSize {
-						heap.Push(&localHeap, cand)
-					} else if localHeap.Len() > 0 && d < localHeap[0].dist {
-						heap.Pop(&localHeap)
-						heap.Push(&localHeap, cand)
-					}
-				}
-				resultsCh <- localHeap
-			}(nodesSlice[start:end])
-		}
-		wg.Wait()
-		close(resultsCh)
-
-		finalHeap := candidateMaxHeap{}
-		heap.Init(&finalHeap)
-		// Merge results from all workers.
-		for partialHeap := range resultsCh {
-			for partialHeap.Len() > 0 {
-				cand := heap.Pop(&partialHeap).(candidate)
-				if finalHeap.Len() < fallbackSize {
-					heap.Push(&finalHeap, cand)
-				} else if finalHeap.Len() > 0 && cand.dist < finalHeap[0].dist {
-					heap.Pop(&finalHeap)
-					heap.Push(&finalHeap, cand)
-				}
-			}
+// search finds the k-nearest neighbors and writes them into buf, sorted by distance if sorted is true.
+// ef is the beam width of the base layer search, or 0 for the index's Ef.
+func (h *HNSWIndex) search(query []float32, k, ef int, buf []core.Neighbor, sorted bool,
+	explain *core.SearchExplanation) ([]core.Neighbor, error) {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	if k <= 0 {
+		return nil, fmt.Errorf("%w: k must be positive, got %d", core.ErrInvalidK, k)
+	}
+	if len(query) != h.Dimension {
+		return nil, fmt.Errorf("%w: query dimension %d does not match index dimension %d",
+			core.ErrDimensionMismatch, len(query), h.Dimension)
+	}
+	if h.EntryPoint == nil {
+		return nil, core.ErrEmptyIndex
+	}
+	query = h.prepareVector(query)
+
+	var candidates []candidate
+	if len(h.Nodes) <= h.ExactThreshold {
+		// A scan of a small index is cheaper than the graph search and finds the exact neighbors.
+		if explain != nil {
+			explain.Exact = true
 		}
-		fallbackCandidates := make([]candidate, finalHeap.Len())
-		for i := range fallbackCandidates {
-			fallbackCandidates[i] = heap.Pop(&finalHeap).(candidate)
+		candidates = h.fallbackCandidates(query, nil, k)
+		if sorted {
+			// The scan returns at most k candidates, so selectM only sorts them.
+			candidates = selectM(candidates, k)
 		}
-		candidates = append(candidates, fallbackCandidates...)
+	} else {
+		candidates = h.layerCandidates(query, k, ef, sorted, explain)
+	}
+
+	if h.RandomTieBreak {
+		// Reorder ties among all candidates, so that the cut at k doesn't favor low ids either.
+		seed := core.QuerySeed(query)
 		sort.Slice(candidates, func(i, j int) bool {
 			if candidates[i].dist == candidates[j].dist {
-				return candidates[i].node.ID < candidates[j].node.ID
+				return core.TieRank(seed, candidates[i].node.ID) < core.TieRank(seed, candidates[j].node.ID)
 			}
 			return candidates[i].dist < candidates[j].dist
 		})
@@ -785,22 +1768,341 @@ func (h *HNSWIndex) Search(query []float32, k int) ([]core.Neighbor, error) {
 	if k > len(candidates) {
 		k = len(candidates)
 	}
-	results := make([]core.Neighbor, k)
+	if cap(buf) < k {
+		buf = make([]core.Neighbor, k)
+	}
+	results := buf[:k]
 	for i := 0; i < k; i++ {
 		results[i] = core.Neighbor{ID: candidates[i].node.ID, Distance: candidates[i].dist}
 	}
 	return results, nil
 }
 
+// layerCandidates finds the candidates of a search by descending the graph and searching the base layer,
+// completed by the brute-force fallback if they are fewer than k. They are sorted if sorted is true.
+func (h *HNSWIndex) layerCandidates(query []float32, k, ef int, sorted bool,
+	explain *core.SearchExplanation) []candidate {
+	// Greedy search down from the top layer.
+	current, topLevel := h.searchEntryPoint()
+	if explain != nil {
+		explain.EntryPoint = current.ID
+	}
+	for L := topLevel; L > 0; L-- {
+		var path *[]core.Neighbor
+		if explain != nil {
+			start := core.Neighbor{ID: current.ID, Distance: h.nodeDist(query, current)}
+			explain.Path = append(explain.Path, core.LevelVisit{Level: L, Visited: []core.Neighbor{start}})
+			path = &explain.Path[len(explain.Path)-1].Visited
+		}
+		changed := true
+		for changed {
+			changed = false
+			for _, neighbor := range current.Links[L] {
+				if d := h.nodeDist(query, neighbor); d < h.nodeDist(query, current) {
+					current = neighbor
+					changed = true
+					if path != nil {
+						*path = append(*path, core.Neighbor{ID: neighbor.ID, Distance: d})
+					}
+				}
+			}
+		}
+	}
+	// Search in the base layer (level 0) for candidates. A beam narrower than k cannot return k results,
+	// so unless FixedEf is set the beam is widened to k to avoid the brute-force fallback.
+	if ef <= 0 {
+		ef = h.Ef
+	}
+	if k > ef && !h.FixedEf {
+		// Guarded so that searches don't pay for formatting the arguments when debug logging is off.
+		if e := log.Debug(); e.Enabled() {
+			e.Msgf("Raising search ef from %d to k=%d", ef, k)
+		}
+		ef = k
+	}
+	var trace *[]core.Neighbor
+	if explain != nil {
+		explain.Path = append(explain.Path, core.LevelVisit{Level: 0})
+		trace = &explain.Path[len(explain.Path)-1].Visited
+	}
+	candidates := h.searchLayerTrace(query, current, 0, ef, trace)
+	// With k above the number of nodes, a layer search that reached every node has found all there is.
+	if len(candidates) < k && len(candidates) < len(h.Nodes) && !h.DisableFallback {
+		// Use fallback to gather more candidates if needed.
+		h.fallbacks.Add(1)
+		if explain != nil {
+			explain.Fallback = true
+		}
+
+		// Log that fallback is triggered.
+		log.Warn().Msgf("Fallback search triggered: insufficient candidates from"+
+			" searchLayer; only %d found", len(candidates))
+
+		candidateIDs := make(map[int]bool, len(candidates))
+		for _, c := range candidates {
+			candidateIDs[c.node.ID] = true
+		}
+		fallbackCandidates := h.fallbackCandidates(query, candidateIDs, k-len(candidates))
+		candidates = dedupCandidates(append(candidates, fallbackCandidates...))
+		// The merged candidates are at most k, so they only need sorting for ordered results.
+		if sorted {
+			sort.Slice(candidates, func(i, j int) bool {
+				if candidates[i].dist == candidates[j].dist {
+					return candidates[i].node.ID < candidates[j].node.ID
+				}
+				return candidates[i].dist < candidates[j].dist
+			})
+		}
+	}
+	return candidates
+}
+
+// SearchByID finds the k-nearest neighbors of the vector stored for id, excluding id itself.
+func (h *HNSWIndex) SearchByID(id int, k int) ([]core.Neighbor, error) {
+	return core.SearchByID(h, id, k)
+}
+
+// KthDistance returns the distance to the k-th nearest neighbor of the query, as found by Search.
+func (h *HNSWIndex) KthDistance(query []float32, k int) (float64, error) {
+	results, err := h.SearchUnsorted(query, k)
+	if err != nil {
+		return 0, err
+	}
+	return core.KthNeighborDistance(results, k)
+}
+
+// SearchPage returns the neighbors of the query ranked [offset, offset+limit) in distance order.
+// See core.SearchPage.
+func (h *HNSWIndex) SearchPage(query []float32, offset, limit int) ([]core.Neighbor, error) {
+	return core.SearchPage(h, query, offset, limit)
+}
+
+// SearchFarthest returns the k vectors farthest from the query, farthest first. It scans every
+// vector in the index. See core.SearchFarthest.
+func (h *HNSWIndex) SearchFarthest(query []float32, k int) ([]core.Neighbor, error) {
+	return core.SearchFarthest(h, query, k)
+}
+
+// MeasureQuery returns the query normalized like the stored vectors, and the distance of the index.
+// It implements core.QueryMeasurer.
+func (h *HNSWIndex) MeasureQuery(query []float32) ([]float32, core.DistanceFunc) {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	return h.prepareVector(query), h.Distance
+}
+
+// AllKNNStream computes the k nearest neighbors of every stored vector, excluding the vector itself,
+// and passes them to emit in ascending id order without buffering them. See core.AllKNNStream.
+func (h *HNSWIndex) AllKNNStream(k int, emit func(id int, neighbors []core.Neighbor) error) error {
+	return core.AllKNNStream(h, h.ids(), k, emit)
+}
+
+// AllKNN returns the k nearest neighbors of every stored vector keyed by id. See core.AllKNN.
+func (h *HNSWIndex) AllKNN(k int) (map[int][]core.Neighbor, error) {
+	return core.AllKNN(h, h.ids(), k)
+}
+
+// ids returns the ids of all stored vectors in ascending order.
+func (h *HNSWIndex) ids() []int {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	ids := make([]int, 0, len(h.Nodes))
+	for id := range h.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// SearchMultiQuery returns the k nearest neighbors of the weighted centroid of several query vectors.
+// See core.SearchMultiQuery.
+func (h *HNSWIndex) SearchMultiQuery(queries [][]float32, weights []float32, k int) ([]core.Neighbor, error) {
+	return core.SearchMultiQuery(h, queries, weights, k)
+}
+
+// SearchBucketed returns the k nearest neighbors of the query grouped into distance buckets of width
+// bucketWidth. See core.SearchBucketed.
+func (h *HNSWIndex) SearchBucketed(query []float32, k int, bucketWidth float64) (map[int][]core.Neighbor, error) {
+	return core.SearchBucketed(h, query, k, bucketWidth)
+}
+
+// SearchDiverse returns k neighbors of the query that balance closeness to the query against
+// dissimilarity to each other, controlled by lambda. See core.SearchDiverse.
+func (h *HNSWIndex) SearchDiverse(query []float32, k int, lambda float64) ([]core.Neighbor, error) {
+	return core.SearchDiverse(h, query, k, lambda)
+}
+
+// Export returns copies of all vectors stored in the index keyed by id.
+func (h *HNSWIndex) Export() (map[int][]float32, error) {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	out := make(map[int][]float32, len(h.Nodes))
+	for id, node := range h.Nodes {
+		if node.Vector == nil {
+			out[id] = h.vector(node)
+			continue
+		}
+		vec := make([]float32, len(node.Vector))
+		copy(vec, node.Vector)
+		out[id] = vec
+	}
+	return out, nil
+}
+
+// ForEach calls fn for every stored vector under the read lock and stops at the first error fn returns.
+// The vectors are the ones GetStoredVector returns; in float16 and int8 modes each is decoded into a
+// fresh slice. fn must not modify the index or the vector passed to it.
+func (h *HNSWIndex) ForEach(fn func(id int, vec []float32) error) error {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	for id, node := range h.Nodes {
+		vec := node.Vector
+		if vec == nil {
+			vec = h.vector(node)
+		}
+		if err := fn(id, vec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetVector returns a copy of the vector stored for the given id, the same as GetStoredVector.
+// For the cosine distance, or with Normalize set, this is the normalized vector.
+func (h *HNSWIndex) GetVector(id int) ([]float32, error) {
+	return h.GetStoredVector(id)
+}
+
+// GetStoredVector returns a copy of the vector the index stores for the given id, which is the vector
+// distances are computed against. For the cosine distance, or with Normalize set, this is the
+// normalized vector: the original vector is not retained, so applications that need it must keep it
+// in their own store. In float16 and int8 modes it is the decoded stored vector.
+func (h *HNSWIndex) GetStoredVector(id int) ([]float32, error) {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	node, exists := h.Nodes[id]
+	if !exists {
+		return nil, fmt.Errorf("id %d: %w", id, core.ErrNotFound)
+	}
+	if node.Vector == nil {
+		return h.vector(node), nil
+	}
+	vec := make([]float32, len(node.Vector))
+	copy(vec, node.Vector)
+	return vec, nil
+}
+
+// Neighbors returns the ids of the nodes an id links to at a level, in link order. The result is a copy,
+// so changing it does not affect the graph. It returns an error if the id is not found or the level is
+// outside the node's levels 0 to Level.
+func (h *HNSWIndex) Neighbors(id, level int) ([]int, error) {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	node, exists := h.Nodes[id]
+	if !exists {
+		return nil, fmt.Errorf("id %d: %w", id, core.ErrNotFound)
+	}
+	if level < 0 || level > node.Level {
+		return nil, fmt.Errorf("id %d has levels 0 to %d, got level %d", id, node.Level, level)
+	}
+	links := node.Links[level]
+	ids := make([]int, len(links))
+	for i, neighbor := range links {
+		ids[i] = neighbor.ID
+	}
+	return ids, nil
+}
+
+// Validate checks the invariants of the graph and returns a descriptive error for the first broken one.
+// It checks that the entry point is a node with the maximum level, that nodes only link to nodes in the
+// index at levels both nodes have, and that links and reverse links match each other.
+func (h *HNSWIndex) Validate() error {
+	h.Mu.RLock()
+	defer h.Mu.RUnlock()
+	if len(h.Nodes) == 0 {
+		if h.EntryPoint != nil {
+			return fmt.Errorf("empty index has entry point %d", h.EntryPoint.ID)
+		}
+		return nil
+	}
+	if h.EntryPoint == nil {
+		return errors.New("non-empty index has no entry point")
+	}
+	if h.Nodes[h.EntryPoint.ID] != h.EntryPoint {
+		return fmt.Errorf("entry point %d is not in the index", h.EntryPoint.ID)
+	}
+	if h.MaxLevel != h.EntryPoint.Level {
+		return fmt.Errorf("max level %d does not match entry point level %d", h.MaxLevel, h.EntryPoint.Level)
+	}
+	if h.pinnedEntry != nil && h.Nodes[h.pinnedEntry.ID] != h.pinnedEntry {
+		return fmt.Errorf("pinned entry point %d is not in the index", h.pinnedEntry.ID)
+	}
+
+	ids := make([]int, 0, len(h.Nodes))
+	for id := range h.Nodes {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		node := h.Nodes[id]
+		if node.ID != id {
+			return fmt.Errorf("node stored under id %d has id %d", id, node.ID)
+		}
+		if node.Level > h.EntryPoint.Level {
+			return fmt.Errorf("node %d has level %d above entry point level %d", id, node.Level, h.EntryPoint.Level)
+		}
+		for level, neighbors := range node.Links {
+			for _, nb := range neighbors {
+				if h.Nodes[nb.ID] != nb {
+					return fmt.Errorf("node %d links to node %d at level %d, which is not in the index", id, nb.ID, level)
+				}
+				if level > node.Level || level > nb.Level {
+					return fmt.Errorf("node %d links to node %d at level %d above one of their levels", id, nb.ID, level)
+				}
+				if !h.SkipReverseLinks && !containsNode(nb.ReverseLinks[level], node) {
+					return fmt.Errorf("link from node %d to node %d at level %d has no reverse link", id, nb.ID, level)
+				}
+			}
+		}
+		for level, neighbors := range node.ReverseLinks {
+			for _, nb := range neighbors {
+				if !containsNode(nb.Links[level], node) {
+					return fmt.Errorf("reverse link from node %d to node %d at level %d has no matching link",
+						id, nb.ID, level)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// containsNode reports whether target is in the slice.
+func containsNode(nodes []*Node, target *Node) bool {
+	for _, n := range nodes {
+		if n == target {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRebuild always returns false because the HNSW graph is updated eagerly on every mutation.
+func (h *HNSWIndex) NeedsRebuild() bool {
+	return false
+}
+
 // Stats returns simple statistics about the index.
 func (h *HNSWIndex) Stats() core.IndexStats {
 	h.Mu.RLock()
 	defer h.Mu.RUnlock()
-	count := len(h.Nodes)
 	stats := core.IndexStats{
-		Count:     count,
+		Count:     len(h.Nodes),
 		Dimension: h.Dimension,
 		Distance:  h.DistanceName,
+		Size:      h.vectorBytes,
+	}
+	if h.Int8 {
+		stats.QuantizationScale = h.Int8Scale
 	}
 	return stats
 }
@@ -809,6 +2111,9 @@ func (h *HNSWIndex) Stats() core.IndexStats {
 func (h *HNSWIndex) Save(w io.Writer) error {
 	h.Mu.RLock()
 	defer h.Mu.RUnlock()
+	if err := core.WriteHeader(w, core.FormatHNSW); err != nil {
+		return err
+	}
 	enc := gob.NewEncoder(w)
 	if err := enc.Encode(h); err != nil {
 		return err
@@ -818,22 +2123,121 @@ func (h *HNSWIndex) Save(w io.Writer) error {
 }
 
 // Load reads the index from the given reader using gob decoding.
+// The loaded dimension and distance replace the configured ones; a warning is logged if they differ.
 func (h *HNSWIndex) Load(r io.Reader) error {
+	if h.frozen.Load() {
+		return core.ErrFrozen
+	}
 	h.Mu.Lock()
 	defer h.Mu.Unlock()
+	defer h.queryCache.Load().Invalidate()
+	dimension, distanceName := h.Dimension, h.DistanceName
+	r, err := core.ReadHeader(r, core.FormatHNSW)
+	if err != nil {
+		return err
+	}
 	dec := gob.NewDecoder(r)
 	if err := dec.Decode(h); err != nil {
 		return err
 	}
+	if dimension != 0 && (dimension != h.Dimension || distanceName != h.DistanceName) {
+		log.Warn().Msgf("Loaded index with dimension=%d, distance=%s replaces configured dimension=%d, distance=%s",
+			h.Dimension, h.DistanceName, dimension, distanceName)
+	}
 	log.Info().Msg("Index loaded")
 	return nil
 }
 
+// SaveCompressed saves the index to w as a gzip-compressed gob stream. See core.SaveCompressed.
+func (h *HNSWIndex) SaveCompressed(w io.Writer) error {
+	return core.SaveCompressed(h, w)
+}
+
+// LoadCompressed loads the index from a stream written by SaveCompressed. See core.LoadCompressed.
+func (h *HNSWIndex) LoadCompressed(r io.Reader) error {
+	return core.LoadCompressed(h, r)
+}
+
+// Freeze makes the index read-only: further Adds, Deletes, and Updates return core.ErrFrozen, and so do
+// Load, LoadCompressed, SetEntryPoint, ClearEntryPoint, RebuildUpperLayers, and ResetLinks.
+func (h *HNSWIndex) Freeze() {
+	h.frozen.Store(true)
+}
+
+// Frozen reports whether Freeze has been called.
+func (h *HNSWIndex) Frozen() bool {
+	return h.frozen.Load()
+}
+
+// EnableQueryCache caches the results of up to size distinct searches by Search, keyed by the query and k,
+// and evicts the least recently used ones beyond that. Every modification of the index clears the cache,
+// but changing search options such as fields of the index does not, so call EnableQueryCache again after
+// changing them. Calling it again replaces the cache with an empty one, and a size of 0 or less disables it.
+func (h *HNSWIndex) EnableQueryCache(size int) {
+	if size <= 0 {
+		h.queryCache.Store(nil)
+		return
+	}
+	h.queryCache.Store(core.NewQueryCache(size))
+}
+
+// QueryCache returns the query cache enabled by EnableQueryCache, for example to read its hit count, or nil.
+func (h *HNSWIndex) QueryCache() *core.QueryCache {
+	return h.queryCache.Load()
+}
+
+// Prefetch loads vectors into memory ahead of a burst of searches. The index is kept entirely in memory,
+// so there is nothing to load and Prefetch always returns nil.
+func (h *HNSWIndex) Prefetch(ids []int) error {
+	return nil
+}
+
+// Close releases resources held by the index. The index is kept entirely in memory, so there is
+// nothing to release and Close always returns nil.
+func (h *HNSWIndex) Close() error {
+	return nil
+}
+
 // Check interface compliance at compile time.
 var _ core.Index = (*HNSWIndex)(nil)
+var _ core.QueryMeasurer = (*HNSWIndex)(nil)
 
-// init registers types for gob encoding.
+// newFromConfig creates an HNSW index from a configuration map.
+// Required parameters: "dimension", "m", and "ef". Optional: "distance" (default "euclidean") and
+// "normalize" ("l2", "l1", or "max"), which sets Normalize and NormMode.
+func newFromConfig(cfg map[string]any) (core.Index, error) {
+	dimension, err := core.IntParam(cfg, "dimension")
+	if err != nil {
+		return nil, err
+	}
+	m, err := core.IntParam(cfg, "m")
+	if err != nil {
+		return nil, err
+	}
+	ef, err := core.IntParam(cfg, "ef")
+	if err != nil {
+		return nil, err
+	}
+	distance, distanceName, err := core.DistanceParam(cfg, "distance")
+	if err != nil {
+		return nil, err
+	}
+	mode, normalize, err := core.NormModeParam(cfg, "normalize")
+	if err != nil {
+		return nil, err
+	}
+	h := NewHNSW(dimension, m, ef, distance, distanceName)
+	h.Normalize = normalize
+	h.NormMode = mode
+	return h, nil
+}
+
+// init registers types for gob encoding and the constructor for core.NewIndex.
 func init() {
+	core.RegisterIndex("hnsw", newFromConfig)
+	core.RegisterFormat(core.FormatHNSW, "hnsw", func() core.Index {
+		return NewHNSW(0, minM, minEf, core.Euclidean, "euclidean")
+	})
 	gob.Register(serializedIndex{})
 	gob.Register(serializedNode{})
 	gob.Register(&HNSWIndex{})
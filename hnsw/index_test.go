@@ -1,12 +1,24 @@
 package hnsw_test
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
 	"os"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/patrikhermansson/hann/core"
 	"github.com/patrikhermansson/hann/hnsw"
+	"github.com/patrikhermansson/hann/pqivf"
+	"github.com/patrikhermansson/hann/rpt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
 func TestHNSWIndex_AddAndStats(t *testing.T) {
@@ -104,6 +116,53 @@ func TestHNSWIndex_Update(t *testing.T) {
 	}
 }
 
+func TestHNSWIndex_EntryPointChanges(t *testing.T) {
+	dim := 4
+	index := hnsw.NewHNSW(dim, 8, 100, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(1))
+	vectors := make(map[int][]float32)
+	for id := 0; id < 300; id++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[id] = vec
+		if err := index.Add(id, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	// Nodes that raised the entry point must have been linked to the existing graph, and updating the
+	// entry point itself must not cut it off, or the earlier nodes become unreachable.
+	entry := index.EntryPoint.ID
+	vectors[entry] = []float32{2, 2, 2, 2}
+	if err := index.Update(entry, vectors[entry]); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	found := 0
+	for id, vec := range vectors {
+		neighbors, err := index.Search(vec, 1)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(neighbors) > 0 && neighbors[0].ID == id {
+			found++
+		}
+	}
+	// The search is approximate, so an odd node may be missed, but not the graph below an entry point.
+	if found < len(vectors)*95/100 {
+		t.Errorf("expected nearly all %d vectors to be found by searching for themselves, found %d", len(vectors), found)
+	}
+
+	// Deleting the entry point keeps MaxLevel at the level of the new one.
+	if err := index.Delete(index.EntryPoint.ID); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if index.MaxLevel != index.EntryPoint.Level {
+		t.Errorf("expected MaxLevel %d to match the entry point level %d", index.MaxLevel, index.EntryPoint.Level)
+	}
+}
+
 func TestHNSWIndex_BulkAdd(t *testing.T) {
 	dim := 6
 	index := hnsw.NewHNSW(dim, 5, 10, core.Euclidean, "euclidean")
@@ -129,6 +188,675 @@ func TestHNSWIndex_BulkAdd(t *testing.T) {
 	}
 }
 
+func TestHNSWIndex_BulkAddDeterministic(t *testing.T) {
+	dim := 8
+	r := rand.New(rand.NewSource(3))
+	vectors := make(map[int][]float32, 300)
+	for id := 0; id < 300; id++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = r.Float32()
+		}
+		vectors[id] = vec
+	}
+
+	build := func() *hnsw.HNSWIndex {
+		hnsw.ResetLevelSeed(42)
+		index := hnsw.NewHNSW(dim, 4, 20, core.Euclidean, "euclidean")
+		if err := index.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		return index
+	}
+	first, second := build(), build()
+
+	if first.EntryPoint.ID != second.EntryPoint.ID {
+		t.Errorf("entry points differ: %d vs %d", first.EntryPoint.ID, second.EntryPoint.ID)
+	}
+	for id, a := range first.Nodes {
+		b := second.Nodes[id]
+		if a.Level != b.Level {
+			t.Fatalf("node %d: levels differ: %d vs %d", id, a.Level, b.Level)
+		}
+		for level := 0; level <= a.Level; level++ {
+			if len(a.Links[level]) != len(b.Links[level]) {
+				t.Fatalf("node %d level %d: %d vs %d links", id, level, len(a.Links[level]), len(b.Links[level]))
+			}
+			for i := range a.Links[level] {
+				if a.Links[level][i].ID != b.Links[level][i].ID {
+					t.Fatalf("node %d level %d: neighbor lists differ", id, level)
+				}
+			}
+		}
+	}
+}
+
+func TestHNSWIndex_WithoutReverseLinks(t *testing.T) {
+	dim := 8
+	r := rand.New(rand.NewSource(5))
+	vectors := make(map[int][]float32, 300)
+	for id := 0; id < 300; id++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = r.Float32()
+		}
+		vectors[id] = vec
+	}
+	build := func(maintain bool) *hnsw.HNSWIndex {
+		hnsw.ResetLevelSeed(7)
+		index := hnsw.NewHNSW(dim, 4, 20, core.Euclidean, "euclidean")
+		index.SkipReverseLinks = !maintain
+		if err := index.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		return index
+	}
+	withLinks, withoutLinks := build(true), build(false)
+	for _, node := range withoutLinks.Nodes {
+		for level, reverse := range node.ReverseLinks {
+			if len(reverse) > 0 {
+				t.Fatalf("node %d has %d reverse links at level %d", node.ID, len(reverse), level)
+			}
+		}
+	}
+
+	compare := func() {
+		t.Helper()
+		for q := 0; q < 20; q++ {
+			query := vectors[q*7]
+			a, err := withLinks.Search(query, 5)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			b, err := withoutLinks.Search(query, 5)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			for i := range a {
+				if a[i].ID != b[i].ID {
+					t.Fatalf("query %d: results differ: %v vs %v", q, a, b)
+				}
+			}
+		}
+	}
+	compare()
+
+	for _, index := range []*hnsw.HNSWIndex{withLinks, withoutLinks} {
+		for id := 1; id < 300; id += 10 {
+			if err := index.Delete(id); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+		}
+		if err := index.BulkDelete([]int{2, 12, 22}); err != nil {
+			t.Fatalf("BulkDelete failed: %v", err)
+		}
+		if err := index.Validate(); err != nil {
+			t.Fatalf("Validate failed after deletes: %v", err)
+		}
+	}
+	compare()
+
+	// The setting survives a save, and a zero-value handle keeps reverse links by default.
+	for _, index := range []*hnsw.HNSWIndex{withLinks, withoutLinks} {
+		var buf bytes.Buffer
+		if err := index.Save(&buf); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+		loaded := &hnsw.HNSWIndex{}
+		if err := loaded.Load(&buf); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		if loaded.SkipReverseLinks != index.SkipReverseLinks {
+			t.Errorf("expected SkipReverseLinks %v after load, got %v", index.SkipReverseLinks, loaded.SkipReverseLinks)
+		}
+		reverse := 0
+		for _, node := range loaded.Nodes {
+			for _, links := range node.ReverseLinks {
+				reverse += len(links)
+			}
+		}
+		if (reverse > 0) == loaded.SkipReverseLinks {
+			t.Errorf("SkipReverseLinks=%v: loaded index has %d reverse links", loaded.SkipReverseLinks, reverse)
+		}
+	}
+}
+
+func TestHNSWIndex_RandomTieBreak(t *testing.T) {
+	// All permutations and sign changes of (1, 2, 2) are at distance 3 from the origin.
+	vectors := make(map[int][]float32)
+	for _, base := range [][]float32{{1, 2, 2}, {2, 1, 2}, {2, 2, 1}} {
+		for signs := 0; signs < 8; signs++ {
+			vec := make([]float32, 3)
+			for j := range vec {
+				vec[j] = base[j]
+				if signs&(1<<j) != 0 {
+					vec[j] = -vec[j]
+				}
+			}
+			vectors[len(vectors)] = vec
+		}
+	}
+	ids := func(results []core.Neighbor) []int {
+		out := make([]int, len(results))
+		for i, n := range results {
+			out[i] = n.ID
+		}
+		return out
+	}
+	idx := hnsw.NewHNSW(3, 16, 50, core.Euclidean, "euclidean")
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := []float32{0, 0, 0}
+	byID, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(ids(byID), want) {
+		t.Errorf("expected ties broken by ascending id %v, got %v", want, ids(byID))
+	}
+
+	idx.RandomTieBreak = true
+	shuffled, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if reflect.DeepEqual(ids(shuffled), ids(byID)) {
+		t.Errorf("expected a different order than ascending ids, got %v", ids(shuffled))
+	}
+	for _, n := range shuffled {
+		if n.Distance != 3 {
+			t.Errorf("expected distance 3 for id %d, got %v", n.ID, n.Distance)
+		}
+	}
+	again, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if !reflect.DeepEqual(again, shuffled) {
+		t.Errorf("expected the same order for the same query, got %v and %v", ids(again), ids(shuffled))
+	}
+}
+
+func TestHNSWIndex_RebuildUpperLayers(t *testing.T) {
+	hnsw.ResetLevelSeed(5)
+	idx := hnsw.NewHNSW(4, 8, 50, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(5))
+	vectors := make(map[int][]float32, 400)
+	for i := 0; i < 400; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	linkIDs := func(nodes []*hnsw.Node) []int {
+		ids := make([]int, len(nodes))
+		for i, n := range nodes {
+			ids[i] = n.ID
+		}
+		return ids
+	}
+	level0 := make(map[int][]int, len(idx.Nodes))
+	levels := make(map[int]int, len(idx.Nodes))
+	for id, n := range idx.Nodes {
+		level0[id] = linkIDs(n.Links[0])
+		levels[id] = n.Level
+	}
+
+	if err := idx.RebuildUpperLayers(); err != nil {
+		t.Fatalf("RebuildUpperLayers failed: %v", err)
+	}
+	if err := idx.Validate(); err != nil {
+		t.Fatalf("Validate failed after RebuildUpperLayers: %v", err)
+	}
+	changed := 0
+	for id, n := range idx.Nodes {
+		if got := linkIDs(n.Links[0]); !reflect.DeepEqual(got, level0[id]) {
+			t.Errorf("level-0 links of node %d changed from %v to %v", id, level0[id], got)
+		}
+		if n.Level != levels[id] {
+			changed++
+		}
+	}
+	if changed == 0 {
+		t.Error("expected RebuildUpperLayers to assign new levels")
+	}
+	if idx.EntryPoint.Level != idx.MaxLevel {
+		t.Errorf("expected the entry point to have the max level %d, got %d", idx.MaxLevel, idx.EntryPoint.Level)
+	}
+	found := 0
+	for id, vec := range vectors {
+		results, err := idx.Search(vec, 1)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if results[0].ID == id {
+			found++
+		}
+	}
+	if found < len(vectors)*95/100 {
+		t.Errorf("expected searches for stored vectors to find them, found %d of %d", found, len(vectors))
+	}
+}
+
+func TestHNSWIndex_ResetLinks(t *testing.T) {
+	idx := hnsw.NewHNSW(4, 8, 50, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(8))
+	vectors := make(map[int][]float32, 300)
+	for i := 0; i < 300; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	levels := make(map[int]int, len(idx.Nodes))
+	for id, n := range idx.Nodes {
+		levels[id] = n.Level
+	}
+
+	// Corrupt the graph: cut every even node off at level 0 and point odd nodes at a node that is gone.
+	stray := &hnsw.Node{ID: -1, Vector: []float32{9, 9, 9, 9}}
+	for id, n := range idx.Nodes {
+		if id%2 == 0 {
+			n.Links[0] = nil
+		} else {
+			n.Links[0] = append(n.Links[0], stray)
+		}
+	}
+	if err := idx.Validate(); err == nil {
+		t.Fatal("expected Validate to report the corrupt links, got no error")
+	}
+
+	if err := idx.ResetLinks(); err != nil {
+		t.Fatalf("ResetLinks failed: %v", err)
+	}
+	if err := idx.Validate(); err != nil {
+		t.Fatalf("Validate failed after ResetLinks: %v", err)
+	}
+	for id, n := range idx.Nodes {
+		if n.Level != levels[id] {
+			t.Errorf("node %d changed level from %d to %d", id, levels[id], n.Level)
+		}
+	}
+	fallbacks := idx.FallbackCount()
+	found := 0
+	for id, vec := range vectors {
+		results, err := idx.Search(vec, 1)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if results[0].ID == id {
+			found++
+		}
+	}
+	if found < len(vectors)*95/100 {
+		t.Errorf("expected searches for stored vectors to find them, found %d of %d", found, len(vectors))
+	}
+	if n := idx.FallbackCount() - fallbacks; n != 0 {
+		t.Errorf("expected a connected graph to need no fallback, got %d", n)
+	}
+}
+
+func TestHNSWIndex_BulkNormalization(t *testing.T) {
+	rng := rand.New(rand.NewSource(6))
+	vectors := make(map[int][]float32, 3000)
+	for i := 0; i < 3000; i++ {
+		vectors[i] = []float32{rng.Float32() * 10, rng.Float32() - 0.5, rng.Float32()}
+	}
+	vectors[7] = []float32{0, 0, 0}
+	single := hnsw.NewHNSW(3, 4, 10, core.Cosine, "cosine")
+	for id := 0; id < 50; id++ {
+		if err := single.Add(id, vectors[id]); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	before := append([]float32(nil), vectors[3]...)
+	bulk := hnsw.NewHNSW(3, 4, 10, core.Cosine, "cosine")
+	if err := bulk.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	for id := 0; id < 50; id++ {
+		want, _ := single.GetStoredVector(id)
+		if got, _ := bulk.GetStoredVector(id); !reflect.DeepEqual(got, want) {
+			t.Errorf("id %d: BulkAdd stored %v, Add stored %v", id, got, want)
+		}
+	}
+	if !reflect.DeepEqual(vectors[3], before) {
+		t.Errorf("expected BulkAdd to normalize a copy, but the caller's vector changed to %v", vectors[3])
+	}
+
+	updates := map[int][]float32{1: {0, 3, 4}, 7: {5, 0, 0}, 8: {0, 0, 0}}
+	if err := bulk.BulkUpdate(updates); err != nil {
+		t.Fatalf("BulkUpdate failed: %v", err)
+	}
+	want := map[int][]float32{1: {0, 0.6, 0.8}, 7: {1, 0, 0}, 8: {0, 0, 0}}
+	for id, vec := range want {
+		if got, _ := bulk.GetStoredVector(id); !reflect.DeepEqual(got, vec) {
+			t.Errorf("id %d: expected %v after BulkUpdate, got %v", id, vec, got)
+		}
+	}
+}
+
+func TestHNSWIndex_DisableFallback(t *testing.T) {
+	t.Setenv("HANN_SEED", "2")
+	rng := rand.New(rand.NewSource(2))
+	idx := hnsw.NewHNSW(2, 4, 1, core.Euclidean, "euclidean")
+	idx.FixedEf = true
+	idx.DisableFallback = true
+	vectors := make(map[int][]float32, 200)
+	for i := 0; i < 200; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := []float32{0.5, 0.5}
+	results, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	// A beam of width 1 finds a single candidate, and without the fallback that is all Search returns.
+	if len(results) == 0 || len(results) >= 10 {
+		t.Fatalf("expected between 1 and 9 partial results, got %d", len(results))
+	}
+	if n := idx.FallbackCount(); n != 0 {
+		t.Errorf("expected no fallback, got %d", n)
+	}
+	for _, r := range results {
+		if want := core.Euclidean(query, vectors[r.ID]); math.Abs(r.Distance-want) > 1e-9 {
+			t.Errorf("id %d: expected distance %v, got %v", r.ID, want, r.Distance)
+		}
+	}
+
+	idx.DisableFallback = false
+	if results, err = idx.Search(query, 10); err != nil || len(results) != 10 {
+		t.Fatalf("expected 10 results with the fallback enabled, got %d (%v)", len(results), err)
+	}
+	if idx.FallbackCount() != 1 {
+		t.Errorf("expected one fallback with the fallback enabled, got %d", idx.FallbackCount())
+	}
+}
+
+func TestHNSWIndex_GetStoredVector(t *testing.T) {
+	idx := hnsw.NewHNSW(3, 4, 10, core.Cosine, "cosine")
+	original := []float32{3, 0, 4}
+	if err := idx.Add(1, original); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	stored, err := idx.GetStoredVector(1)
+	if err != nil {
+		t.Fatalf("GetStoredVector failed: %v", err)
+	}
+	var norm float64
+	for _, v := range stored {
+		norm += float64(v) * float64(v)
+	}
+	if math.Abs(math.Sqrt(norm)-1) > 1e-6 {
+		t.Errorf("expected a unit vector, got %v with norm %f", stored, math.Sqrt(norm))
+	}
+	if want := []float32{0.6, 0, 0.8}; !reflect.DeepEqual(stored, want) {
+		t.Errorf("expected %v, got %v", want, stored)
+	}
+	if !reflect.DeepEqual(original, []float32{3, 0, 4}) {
+		t.Errorf("expected the caller's vector to be left as is, got %v", original)
+	}
+	// The result is a copy.
+	stored[0] = 9
+	if again, _ := idx.GetStoredVector(1); again[0] == 9 {
+		t.Error("expected GetStoredVector to return a copy")
+	}
+	if _, err := idx.GetStoredVector(2); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestHNSWIndex_Neighbors(t *testing.T) {
+	idx := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	vectors := map[int][]float32{1: {0, 0}, 2: {1, 0}, 3: {0, 1}, 4: {5, 5}}
+	for id := 1; id <= 4; id++ {
+		if err := idx.Add(id, vectors[id]); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	// With M above the number of other nodes, every node links to all others on level 0, which are the
+	// nodes a search for its vector returns besides itself.
+	for id, vec := range vectors {
+		neighbors, err := idx.Neighbors(id, 0)
+		if err != nil {
+			t.Fatalf("Neighbors failed: %v", err)
+		}
+		results, err := idx.Search(vec, len(vectors))
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		var want []int
+		for _, r := range results {
+			if r.ID != id {
+				want = append(want, r.ID)
+			}
+		}
+		got := append([]int{}, neighbors...)
+		sort.Ints(got)
+		sort.Ints(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("id %d: expected neighbors %v, got %v", id, want, got)
+		}
+		// The result is a copy.
+		neighbors[0] = -1
+		if again, _ := idx.Neighbors(id, 0); again[0] == -1 {
+			t.Errorf("id %d: expected Neighbors to return a copy", id)
+		}
+		if _, err := idx.Neighbors(id, idx.Nodes[id].Level+1); err == nil {
+			t.Errorf("id %d: expected an error for a level above the node's level", id)
+		}
+	}
+	if _, err := idx.Neighbors(9, 0); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := idx.Neighbors(1, -1); err == nil {
+		t.Error("expected an error for a negative level")
+	}
+}
+
+func TestHNSWIndex_DegenerateParameters(t *testing.T) {
+	idx := hnsw.NewHNSW(2, 0, 0, core.Euclidean, "euclidean")
+	if idx.M != 2 || idx.Ef != 1 {
+		t.Fatalf("expected M and ef to be raised to 2 and 1, got %d and %d", idx.M, idx.Ef)
+	}
+	if idx = hnsw.NewHNSW(2, 1, -5, core.Euclidean, "euclidean"); idx.M != 2 || idx.Ef != 1 {
+		t.Fatalf("expected M and ef to be raised to 2 and 1, got %d and %d", idx.M, idx.Ef)
+	}
+	for i := 0; i < 50; i++ {
+		if err := idx.Add(i, []float32{float32(i), 0}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	results, err := idx.Search([]float32{20.2, 0}, 3)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 3 || results[0].ID != 20 {
+		t.Errorf("expected 3 results starting with id 20, got %v", results)
+	}
+}
+
+func TestHNSWIndex_IdenticalVectors(t *testing.T) {
+	// Node levels come from a shared generator, so it is reseeded to not depend on the tests run before.
+	hnsw.ResetLevelSeed(3)
+	idx := hnsw.NewHNSW(2, 4, 20, core.Euclidean, "euclidean")
+	for id := 0; id < 100; id++ {
+		if err := idx.Add(id, []float32{1, 1}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	distinct := map[int][]float32{100: {5, 0}, 101: {0, 5}, 102: {-3, -3}, 103: {8, 8}}
+	for id := 100; id <= 103; id++ {
+		if err := idx.Add(id, distinct[id]); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	for id, vec := range distinct {
+		results, err := idx.Search(vec, 1)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if results[0].ID != id {
+			t.Errorf("expected id %d to be found, got %v", id, results)
+		}
+	}
+	if n := idx.FallbackCount(); n != 0 {
+		t.Errorf("expected the graph search to find the distinct vectors without a fallback, got %d fallbacks", n)
+	}
+
+	// Ties between the identical vectors must not all be resolved toward the same few low ids, which would
+	// make them hubs that every other node links to.
+	inDegree := make(map[int]int)
+	for id := range idx.Nodes {
+		neighbors, err := idx.Neighbors(id, 0)
+		if err != nil {
+			t.Fatalf("Neighbors failed: %v", err)
+		}
+		for _, nb := range neighbors {
+			inDegree[nb]++
+		}
+	}
+	maxIn := 0
+	for _, d := range inDegree {
+		maxIn = max(maxIn, d)
+	}
+	if maxIn > 20 {
+		t.Errorf("expected links spread over the identical vectors, got a node with %d incoming links", maxIn)
+	}
+}
+
+func TestHNSWIndex_MaxParallelism(t *testing.T) {
+	defer core.SetMaxParallelism(0)
+	var active, peak atomic.Int64
+	tracking := func(a, b []float32) float64 {
+		cur := active.Add(1)
+		for {
+			p := peak.Load()
+			if cur <= p || peak.CompareAndSwap(p, cur) {
+				break
+			}
+		}
+		defer active.Add(-1)
+		return core.Euclidean(a, b)
+	}
+	idx := hnsw.NewHNSW(4, 4, 4, tracking, "euclidean")
+	// A fixed beam of 4 cannot gather 50 candidates, so every search runs the brute-force fallback.
+	idx.FixedEf = true
+	rng := rand.New(rand.NewSource(12))
+	for i := 0; i < 300; i++ {
+		if err := idx.Add(i, []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	query := []float32{0.5, 0.5, 0.5, 0.5}
+	want, err := idx.Search(query, 50)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	for _, limit := range []struct{ global, index, max int }{{1, 0, 1}, {8, 2, 2}} {
+		core.SetMaxParallelism(limit.global)
+		idx.MaxParallelism = limit.index
+		peak.Store(0)
+		fallbacks := idx.FallbackCount()
+		got, err := idx.Search(query, 50)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if idx.FallbackCount() == fallbacks {
+			t.Fatal("expected the search to use the fallback")
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("limit %+v: results differ from the default parallelism", limit)
+		}
+		if p := peak.Load(); p > int64(limit.max) {
+			t.Errorf("limit %+v: %d distance computations ran at once", limit, p)
+		}
+	}
+}
+
+func TestHNSWIndex_BuildFromKNN(t *testing.T) {
+	dim, n, k := 8, 300, 5
+	rng := rand.New(rand.NewSource(21))
+	vectors := make(map[int][]float32, n)
+	for id := 0; id < n; id++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[id] = vec
+	}
+	// exact returns the ids of the count nearest vectors to the query, optionally excluding one id.
+	exact := func(query []float32, count, exclude int) []int {
+		ids := make([]int, 0, n)
+		for id := range vectors {
+			if id != exclude {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(a, b int) bool {
+			da, db := core.Euclidean(query, vectors[ids[a]]), core.Euclidean(query, vectors[ids[b]])
+			if da == db {
+				return ids[a] < ids[b]
+			}
+			return da < db
+		})
+		return ids[:count]
+	}
+	knn := make(map[int][]int, n)
+	for id, vec := range vectors {
+		knn[id] = exact(vec, 10, id)
+	}
+
+	index := hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean")
+	if err := index.BuildFromKNN(vectors, knn); err != nil {
+		t.Fatalf("BuildFromKNN failed: %v", err)
+	}
+	if err := index.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if stats := index.Stats(); stats.Count != n {
+		t.Fatalf("expected %d vectors, got %d", n, stats.Count)
+	}
+
+	var recall float64
+	queries := 30
+	for q := 0; q < queries; q++ {
+		query := make([]float32, dim)
+		for j := range query {
+			query[j] = rng.Float32()
+		}
+		truth := make(map[int]bool, k)
+		for _, id := range exact(query, k, -1) {
+			truth[id] = true
+		}
+		results, err := index.Search(query, k)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		for _, r := range results {
+			if truth[r.ID] {
+				recall += 1 / float64(k*queries)
+			}
+		}
+	}
+	if recall < 0.9 {
+		t.Errorf("expected recall of at least 0.9 from the kNN graph, got %.3f", recall)
+	}
+
+	if err := index.BuildFromKNN(vectors, knn); err == nil {
+		t.Error("expected error when building a non-empty index, got none")
+	}
+	empty := hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean")
+	if err := empty.BuildFromKNN(vectors, map[int][]int{0: {n + 1}}); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown neighbor id, got %v", err)
+	}
+}
+
 func TestHNSWIndex_BulkDelete(t *testing.T) {
 	dim := 6
 	index := hnsw.NewHNSW(dim, 5, 10, core.Euclidean, "euclidean")
@@ -317,3 +1045,2278 @@ func TestHNSWIndex_ConcurrentBulkOperations(t *testing.T) {
 			stats.Count)
 	}
 }
+
+func TestHNSWIndex_BulkAddLenient(t *testing.T) {
+	dim := 6
+	index := hnsw.NewHNSW(dim, 5, 10, core.Euclidean, "euclidean")
+	if err := index.Add(1, []float32{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	vectors := map[int][]float32{
+		1: {6, 5, 4, 3, 2, 1}, // duplicate id
+		2: {1, 1, 1, 1, 1, 1},
+		3: {1, 2, 3}, // wrong dimension
+		4: {2, 2, 2, 2, 2, 2},
+	}
+	added, failures := index.BulkAddLenient(vectors)
+	if added != 2 {
+		t.Errorf("expected 2 vectors added, got %d", added)
+	}
+	if len(failures) != 2 || failures[1] == nil || failures[3] == nil {
+		t.Errorf("expected failures for ids 1 and 3, got %v", failures)
+	}
+	if stats := index.Stats(); stats.Count != 3 {
+		t.Errorf("expected count 3 after BulkAddLenient, got %d", stats.Count)
+	}
+}
+
+func TestHNSWIndex_BulkAddLenientProgressBarFails(t *testing.T) {
+	// The progress bar writes to stdout, so a closed stdout makes every update fail.
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe failed: %v", err)
+	}
+	r.Close()
+	w.Close()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	index := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	vectors := make(map[int][]float32, 50)
+	for i := 0; i < 50; i++ {
+		vectors[i] = []float32{float32(i), float32(i % 7)}
+	}
+	added, failures := index.BulkAddLenient(vectors)
+	if added != 50 || len(failures) != 0 {
+		t.Errorf("expected 50 vectors added without failures, got %d, %v", added, failures)
+	}
+	if n := index.Stats().Count; n != 50 {
+		t.Errorf("expected all 50 vectors inserted, got %d", n)
+	}
+	if err := index.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestHNSWIndex_FallbackDistancesMatchMetric(t *testing.T) {
+	dim := 6
+	// A tiny ef forces the brute-force fallback when k exceeds the candidates found.
+	index := hnsw.NewHNSW(dim, 5, 1, core.Euclidean, "euclidean")
+	vectors := map[int][]float32{
+		1: {1, 2, 3, 4, 5, 6},
+		2: {6, 5, 4, 3, 2, 1},
+		3: {1, 1, 1, 1, 1, 1},
+		4: {2, 2, 2, 2, 2, 2},
+		5: {3, 3, 3, 3, 3, 3},
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	query := []float32{1, 2, 3, 4, 5, 5}
+	for _, k := range []int{1, len(vectors)} {
+		neighbors, err := index.Search(query, k)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(neighbors) != k {
+			t.Fatalf("expected %d neighbors, got %d", k, len(neighbors))
+		}
+		for _, n := range neighbors {
+			want := core.Euclidean(query, vectors[n.ID])
+			if n.Distance != want {
+				t.Errorf("k=%d: distance for id %d is %f, want %f", k, n.ID, n.Distance, want)
+			}
+		}
+	}
+}
+
+func TestHNSWIndex_ExportIntoRPT(t *testing.T) {
+	dim := 6
+	index := hnsw.NewHNSW(dim, 5, 10, core.Euclidean, "euclidean")
+	vectors := map[int][]float32{
+		1: {1, 2, 3, 4, 5, 6},
+		2: {6, 5, 4, 3, 2, 1},
+		3: {1, 1, 1, 1, 1, 1},
+		4: {2, 2, 2, 2, 2, 2},
+		5: {3, 3, 3, 3, 3, 3},
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	exported, err := index.Export()
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(exported) != len(vectors) {
+		t.Fatalf("expected %d exported vectors, got %d", len(vectors), len(exported))
+	}
+	// The exported vectors must be copies.
+	exported[1][0] = 100
+	if v, _ := index.Export(); v[1][0] != 1 {
+		t.Error("modifying an exported vector changed the index")
+	}
+	exported[1][0] = 1
+
+	target := rpt.NewRPTIndex(dim, 10, 3, 100, 0.15)
+	if err := target.BulkAdd(exported); err != nil {
+		t.Fatalf("BulkAdd into RPT failed: %v", err)
+	}
+
+	query := []float32{2, 2, 2, 2, 2, 3}
+	want, err := index.Search(query, 3)
+	if err != nil {
+		t.Fatalf("HNSW search failed: %v", err)
+	}
+	got, err := target.Search(query, 3)
+	if err != nil {
+		t.Fatalf("RPT search failed: %v", err)
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID {
+			t.Errorf("result %d: RPT returned id %d, HNSW returned id %d", i, got[i].ID, want[i].ID)
+		}
+	}
+}
+
+func TestSearch_KLargerThanLen(t *testing.T) {
+	indexes := map[string]core.Index{
+		"hnsw":  hnsw.NewHNSW(2, 4, 2, core.Euclidean, "euclidean"),
+		"pqivf": pqivf.NewPQIVFIndex(2, 2, 1, 4, 10),
+		"rpt":   rpt.NewRPTIndex(2, 1, 3, 100, 0.2),
+	}
+	// Ids 2 and 3 are equally far from the query, so they must come back in id order.
+	vectors := map[int][]float32{1: {0, 0}, 2: {3, 0}, 3: {0, 3}}
+	want := []core.Neighbor{{ID: 1, Distance: 0}, {ID: 2, Distance: 3}, {ID: 3, Distance: 3}}
+	for name, idx := range indexes {
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("%s: BulkAdd failed: %v", name, err)
+		}
+		results, err := idx.Search([]float32{0, 0}, 10)
+		if err != nil {
+			t.Fatalf("%s: Search with k=10 failed: %v", name, err)
+		}
+		if !reflect.DeepEqual(results, want) {
+			t.Errorf("%s: expected %v, got %v", name, want, results)
+		}
+	}
+	if n := indexes["hnsw"].(*hnsw.HNSWIndex).FallbackCount(); n != 0 {
+		t.Errorf("expected no HNSW fallback when the graph holds fewer than k nodes, got %d", n)
+	}
+}
+
+func TestSearch_ExactThreshold(t *testing.T) {
+	t.Setenv("HANN_SEED", "6")
+	rng := rand.New(rand.NewSource(6))
+	vectors := make(map[int][]float32, 500)
+	for i := 0; i < 500; i++ {
+		vec := make([]float32, 8)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	queries := make([][]float32, 20)
+	for i := range queries {
+		queries[i] = make([]float32, 8)
+		for j := range queries[i] {
+			queries[i][j] = rng.Float32()
+		}
+	}
+	const k = 10
+
+	// Settings under which each approximate search misses neighbors.
+	h := hnsw.NewHNSW(8, 2, 1, core.Euclidean, "euclidean")
+	h.FixedEf = true
+	h.DisableFallback = true
+	pq := pqivf.NewPQIVFIndex(8, 8, 2, 2, 5)
+	r := rpt.NewRPTIndex(8, 2, 1, 100, 1)
+	r.MaxCandidates = k
+	indexes := map[string]core.Index{"hnsw": h, "pqivf": pq, "rpt": r}
+	for name, idx := range indexes {
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("%s: BulkAdd failed: %v", name, err)
+		}
+	}
+	if err := pq.Train(); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	setThreshold := func(n int) {
+		h.ExactThreshold, pq.ExactThreshold, r.ExactThreshold = n, n, n
+	}
+
+	for name, idx := range indexes {
+		var exactMatches, approxMatches int
+		for _, q := range queries {
+			want := make([]core.Neighbor, 0, len(vectors))
+			for id, vec := range vectors {
+				want = append(want, core.Neighbor{ID: id, Distance: core.Euclidean(q, vec)})
+			}
+			sort.Slice(want, func(i, j int) bool { return want[i].Distance < want[j].Distance })
+			want = want[:k]
+
+			setThreshold(0)
+			approx, err := idx.Search(q, k)
+			if err != nil {
+				t.Fatalf("%s: Search failed: %v", name, err)
+			}
+			if reflect.DeepEqual(approx, want) {
+				approxMatches++
+			}
+			setThreshold(len(vectors))
+			exact, err := idx.Search(q, k)
+			if err != nil {
+				t.Fatalf("%s: Search failed: %v", name, err)
+			}
+			if reflect.DeepEqual(exact, want) {
+				exactMatches++
+			}
+		}
+		if approxMatches == len(queries) {
+			t.Errorf("%s: expected the approximate search to miss for some queries", name)
+		}
+		if exactMatches != len(queries) {
+			t.Errorf("%s: expected exact results below the threshold for all %d queries, got %d",
+				name, len(queries), exactMatches)
+		}
+	}
+
+	fresh := rpt.NewRPTIndex(8, 2, 1, 100, 1)
+	fresh.ExactThreshold = len(vectors)
+	if err := fresh.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if _, err := fresh.Search(queries[0], k); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if !fresh.NeedsRebuild() {
+		t.Error("expected a search below the threshold not to build the RPT tree")
+	}
+}
+
+func TestQueryCache(t *testing.T) {
+	indexes := map[string]core.Index{
+		"hnsw":  hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean"),
+		"pqivf": pqivf.NewPQIVFIndex(2, 2, 1, 4, 10),
+		"rpt":   rpt.NewRPTIndex(2, 10, 3, 100, 0.2),
+	}
+	vectors := map[int][]float32{1: {0, 0}, 2: {3, 0}, 3: {0, 4}, 4: {5, 5}}
+	query := []float32{1, 1}
+	for name, idx := range indexes {
+		cached := idx.(interface {
+			EnableQueryCache(size int)
+			QueryCache() *core.QueryCache
+		})
+		if cached.QueryCache() != nil {
+			t.Fatalf("%s: expected the query cache to be disabled by default", name)
+		}
+		cached.EnableQueryCache(16)
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("%s: BulkAdd failed: %v", name, err)
+		}
+		first, err := idx.Search(query, 2)
+		if err != nil {
+			t.Fatalf("%s: Search failed: %v", name, err)
+		}
+		second, err := idx.Search(query, 2)
+		if err != nil {
+			t.Fatalf("%s: Search failed: %v", name, err)
+		}
+		cache := cached.QueryCache()
+		if cache.Hits() != 1 || !reflect.DeepEqual(first, second) {
+			t.Errorf("%s: expected the repeated search to hit the cache with the same results, got %d hits and %v after %v",
+				name, cache.Hits(), second, first)
+		}
+
+		if err := idx.Add(5, []float32{1, 1}); err != nil {
+			t.Fatalf("%s: Add failed: %v", name, err)
+		}
+		third, err := idx.Search(query, 2)
+		if err != nil {
+			t.Fatalf("%s: Search failed: %v", name, err)
+		}
+		if cache.Hits() != 1 || third[0].ID != 5 {
+			t.Errorf("%s: expected Add to invalidate the cache and the new vector to be found, got %d hits and %v",
+				name, cache.Hits(), third)
+		}
+		if err := idx.Delete(5); err != nil {
+			t.Fatalf("%s: Delete failed: %v", name, err)
+		}
+		fourth, err := idx.Search(query, 2)
+		if err != nil {
+			t.Fatalf("%s: Search failed: %v", name, err)
+		}
+		if cache.Hits() != 1 || !reflect.DeepEqual(fourth, first) {
+			t.Errorf("%s: expected Delete to invalidate the cache, got %d hits and %v", name, cache.Hits(), fourth)
+		}
+
+		cached.EnableQueryCache(0)
+		if cached.QueryCache() != nil {
+			t.Errorf("%s: expected a size of 0 to disable the query cache", name)
+		}
+	}
+}
+
+func TestForEach(t *testing.T) {
+	indexes := map[string]core.Index{
+		"hnsw":  hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean"),
+		"pqivf": pqivf.NewPQIVFIndex(2, 2, 1, 4, 10),
+		"rpt":   rpt.NewRPTIndex(2, 2, 3, 100, 0.2),
+	}
+	vectors := make(map[int][]float32, 50)
+	for i := 0; i < 50; i++ {
+		vectors[i*3] = []float32{float32(i), float32(i % 7)}
+	}
+	for name, idx := range indexes {
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("%s: BulkAdd failed: %v", name, err)
+		}
+		exported, err := idx.Export()
+		if err != nil {
+			t.Fatalf("%s: Export failed: %v", name, err)
+		}
+		var want int
+		for id := range exported {
+			want += id
+		}
+		var sum, visited int
+		err = idx.ForEach(func(id int, vec []float32) error {
+			sum += id
+			visited++
+			if !reflect.DeepEqual(vec, exported[id]) {
+				t.Errorf("%s: id %d: expected vector %v, got %v", name, id, exported[id], vec)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: ForEach failed: %v", name, err)
+		}
+		if visited != len(exported) || sum != want {
+			t.Errorf("%s: expected %d ids summing to %d, got %d summing to %d", name, len(exported), want, visited, sum)
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+		err = idx.ForEach(func(id int, vec []float32) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) || calls != 1 {
+			t.Errorf("%s: expected ForEach to stop with the callback's error after 1 call, got %v after %d", name, err, calls)
+		}
+	}
+}
+
+func TestSearchBucketed(t *testing.T) {
+	idx := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	xs := map[int]float32{1: 0, 2: 0.5, 3: 1.2, 4: 1.9, 5: 2.5, 6: 7}
+	for id, x := range xs {
+		if err := idx.Add(id, []float32{x, 0}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	buckets, err := idx.SearchBucketed([]float32{0, 0}, 5, 1)
+	if err != nil {
+		t.Fatalf("SearchBucketed failed: %v", err)
+	}
+	want := map[int][]int{0: {1, 2}, 1: {3, 4}, 2: {5}}
+	if len(buckets) != len(want) {
+		t.Fatalf("expected buckets %v, got %v", want, buckets)
+	}
+	for b, ids := range want {
+		got := buckets[b]
+		if len(got) != len(ids) {
+			t.Fatalf("bucket %d: expected ids %v, got %v", b, ids, got)
+		}
+		for i, id := range ids {
+			if got[i].ID != id || got[i].Distance != float64(xs[id]) {
+				t.Errorf("bucket %d position %d: expected id %d at distance %v, got %v", b, i, id, xs[id], got[i])
+			}
+		}
+	}
+	for _, width := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		if _, err := idx.SearchBucketed([]float32{0, 0}, 5, width); err == nil {
+			t.Errorf("expected an error for bucket width %v", width)
+		}
+	}
+}
+
+func TestHNSWIndex_SearchWithSimilarity(t *testing.T) {
+	dim := 3
+	index := hnsw.NewHNSW(dim, 5, 10, core.Cosine, "cosine")
+	vectors := map[int][]float32{
+		1: {1, 0, 0},
+		2: {1, 1, 0},
+		3: {0, 1, 0},
+		4: {0, 0, 1},
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	query := []float32{1, 0.2, 0}
+	scored, err := core.SearchWithSimilarity(index, query, 4)
+	if err != nil {
+		t.Fatalf("SearchWithSimilarity failed: %v", err)
+	}
+	neighbors, err := index.Search(query, 4)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(scored) != len(neighbors) {
+		t.Fatalf("expected %d scored neighbors, got %d", len(neighbors), len(scored))
+	}
+	for i := range scored {
+		if i > 0 && scored[i].Score > scored[i-1].Score {
+			t.Errorf("scores are not descending at %d: %v", i, scored)
+		}
+		if scored[i].ID != neighbors[i].ID || scored[i].Score != 1-neighbors[i].Distance {
+			t.Errorf("result %d: got %+v, want id %d with score %f",
+				i, scored[i], neighbors[i].ID, 1-neighbors[i].Distance)
+		}
+	}
+	if scored[0].ID != 1 {
+		t.Errorf("expected id 1 as most similar, got %d", scored[0].ID)
+	}
+}
+
+func TestHNSWIndex_SaveLoadEmpty(t *testing.T) {
+	dim := 6
+	index := hnsw.NewHNSW(dim, 5, 10, core.Euclidean, "euclidean")
+
+	var buf bytes.Buffer
+	if err := index.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded := hnsw.NewHNSW(dim, 5, 10, core.Euclidean, "euclidean")
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if stats := loaded.Stats(); stats.Count != 0 {
+		t.Errorf("expected count 0 after loading an empty index, got %d", stats.Count)
+	}
+
+	// The loaded index must be usable. Id 0 also checks that it can become the entry point.
+	if err := loaded.Add(0, []float32{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := loaded.Add(1, []float32{6, 5, 4, 3, 2, 1}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	neighbors, err := loaded.Search([]float32{1, 2, 3, 4, 5, 6}, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(neighbors) != 1 || neighbors[0].ID != 0 {
+		t.Errorf("expected id 0 as nearest neighbor, got %v", neighbors)
+	}
+
+	// Round-trip again with data to make sure the entry point survives.
+	buf.Reset()
+	if err := loaded.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	reloaded := hnsw.NewHNSW(dim, 5, 10, core.Euclidean, "euclidean")
+	if err := reloaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if _, err := reloaded.Search([]float32{1, 2, 3, 4, 5, 6}, 2); err != nil {
+		t.Errorf("Search after reload failed: %v", err)
+	}
+}
+
+func TestHNSWIndex_NewIndexFactory(t *testing.T) {
+	index, err := core.NewIndex("hnsw", map[string]any{
+		"dimension": 3, "m": 5, "ef": 10, "distance": "cosine",
+	})
+	if err != nil {
+		t.Fatalf("NewIndex failed: %v", err)
+	}
+	if err := index.Add(1, []float32{1, 0, 0}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := index.Add(2, []float32{0, 1, 0}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	neighbors, err := index.Search([]float32{1, 0.1, 0}, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(neighbors) != 1 || neighbors[0].ID != 1 {
+		t.Errorf("expected id 1 as nearest neighbor, got %v", neighbors)
+	}
+	if stats := index.Stats(); stats.Distance != "cosine" {
+		t.Errorf("expected cosine distance, got %s", stats.Distance)
+	}
+
+	if _, err := core.NewIndex("hnsw", map[string]any{"dimension": 3, "m": 5}); err == nil {
+		t.Error("expected error for missing ef, got none")
+	}
+	if _, err := core.NewIndex("hnsw", map[string]any{
+		"dimension": 3, "m": 5, "ef": 10, "distance": "unknown",
+	}); err == nil {
+		t.Error("expected error for unknown distance, got none")
+	}
+}
+
+func TestHNSWIndex_NormMode(t *testing.T) {
+	index, err := core.NewIndex("hnsw", map[string]any{
+		"dimension": 3, "m": 5, "ef": 10, "distance": "manhattan", "normalize": "l1",
+	})
+	if err != nil {
+		t.Fatalf("NewIndex failed: %v", err)
+	}
+	if err := index.BulkAdd(map[int][]float32{1: {1, 1, 2}, 2: {0, 4, 0}, 3: {3, 0, 1}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	vec, err := index.GetVector(1)
+	if err != nil {
+		t.Fatalf("GetVector failed: %v", err)
+	}
+	want := []float32{0.25, 0.25, 0.5}
+	for i := range want {
+		if math.Abs(float64(vec[i]-want[i])) > 1e-6 {
+			t.Fatalf("expected L1-normalized vector %v, got %v", want, vec)
+		}
+	}
+	// Queries are normalized too, so a scaled copy of a stored vector is at distance zero.
+	results, err := index.Search([]float32{10, 10, 20}, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != 1 || results[0].Distance > 1e-6 {
+		t.Errorf("expected id 1 at distance 0, got %v", results)
+	}
+
+	if _, err := core.NewIndex("hnsw", map[string]any{
+		"dimension": 3, "m": 5, "ef": 10, "normalize": "l3",
+	}); err == nil {
+		t.Error("expected error for unknown normalization mode, got none")
+	}
+}
+
+func TestHNSWIndex_Float16(t *testing.T) {
+	dim, n, k := 16, 500, 10
+	rng := rand.New(rand.NewSource(42))
+	vectors := make(map[int][]float32, n)
+	for i := 0; i < n; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()*2 - 1
+		}
+		vectors[i] = vec
+	}
+
+	full := hnsw.NewHNSW(dim, 8, 64, core.Cosine, "cosine")
+	half := hnsw.NewHNSW(dim, 8, 64, core.Cosine, "cosine")
+	half.Float16 = true
+	for i := 0; i < n; i++ {
+		if err := full.Add(i, vectors[i]); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+		if err := half.Add(i, vectors[i]); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	// recall measures how many of the exact k nearest neighbors an index finds.
+	recall := func(index *hnsw.HNSWIndex, query []float32) float64 {
+		ids := make([]int, 0, n)
+		for id := range vectors {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(a, b int) bool {
+			return core.Cosine(query, vectors[ids[a]]) < core.Cosine(query, vectors[ids[b]])
+		})
+		truth := make(map[int]bool, k)
+		for _, id := range ids[:k] {
+			truth[id] = true
+		}
+		results, err := index.Search(query, k)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		hits := 0
+		for _, r := range results {
+			if truth[r.ID] {
+				hits++
+			}
+		}
+		return float64(hits) / float64(k)
+	}
+
+	var fullRecall, halfRecall float64
+	queries := 20
+	for q := 0; q < queries; q++ {
+		query := make([]float32, dim)
+		for j := range query {
+			query[j] = rng.Float32()*2 - 1
+		}
+		fullRecall += recall(full, query) / float64(queries)
+		halfRecall += recall(half, query) / float64(queries)
+	}
+	if halfRecall < fullRecall-0.05 {
+		t.Errorf("float16 recall %.3f is much lower than float32 recall %.3f", halfRecall, fullRecall)
+	}
+
+	fullSize, halfSize := full.Stats().Size, half.Stats().Size
+	if fullSize != 4*n*dim || halfSize*2 != fullSize {
+		t.Errorf("expected float16 size to be half of %d bytes, got %d", fullSize, halfSize)
+	}
+
+	// The storage mode survives a save and load.
+	var buf bytes.Buffer
+	if err := half.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded := hnsw.NewHNSW(dim, 8, 64, core.Cosine, "cosine")
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !loaded.Float16 || loaded.Stats().Size != halfSize {
+		t.Errorf("expected loaded index to keep float16 storage, got Float16=%v size=%d",
+			loaded.Float16, loaded.Stats().Size)
+	}
+}
+
+func TestHNSWIndex_Int8(t *testing.T) {
+	dim, n, k := 16, 500, 10
+	rng := rand.New(rand.NewSource(43))
+	vectors := make(map[int][]float32, n)
+	for i := 0; i < n; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()*4 - 2
+		}
+		vectors[i] = vec
+	}
+
+	full := hnsw.NewHNSW(dim, 8, 64, core.Euclidean, "euclidean")
+	quantized := hnsw.NewHNSW(dim, 8, 64, core.Euclidean, "euclidean")
+	quantized.Int8 = true
+	for _, index := range []*hnsw.HNSWIndex{full, quantized} {
+		if err := index.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+	}
+	scale := quantized.Stats().QuantizationScale
+	if scale <= 0 || scale > 2.0/127+1e-6 {
+		t.Fatalf("expected a learned scale of at most 2/127, got %g", scale)
+	}
+	if full.Stats().QuantizationScale != 0 {
+		t.Errorf("expected no quantization scale for float32 storage")
+	}
+
+	recall := func(index *hnsw.HNSWIndex, query []float32) float64 {
+		ids := make([]int, 0, n)
+		for id := range vectors {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(a, b int) bool {
+			return core.Euclidean(query, vectors[ids[a]]) < core.Euclidean(query, vectors[ids[b]])
+		})
+		truth := make(map[int]bool, k)
+		for _, id := range ids[:k] {
+			truth[id] = true
+		}
+		results, err := index.Search(query, k)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		hits := 0
+		for _, r := range results {
+			if truth[r.ID] {
+				hits++
+			}
+		}
+		return float64(hits) / float64(k)
+	}
+
+	var fullRecall, int8Recall float64
+	queries := 20
+	for q := 0; q < queries; q++ {
+		query := make([]float32, dim)
+		for j := range query {
+			query[j] = rng.Float32()*4 - 2
+		}
+		fullRecall += recall(full, query) / float64(queries)
+		int8Recall += recall(quantized, query) / float64(queries)
+	}
+	if int8Recall < fullRecall-0.05 {
+		t.Errorf("int8 recall %.3f is much lower than float32 recall %.3f", int8Recall, fullRecall)
+	}
+
+	fullSize, int8Size := full.Stats().Size, quantized.Stats().Size
+	if fullSize != 4*n*dim || int8Size*4 != fullSize {
+		t.Errorf("expected int8 size to be a quarter of %d bytes, got %d", fullSize, int8Size)
+	}
+
+	// Stored vectors decode to within half a quantization step.
+	got, err := quantized.GetVector(7)
+	if err != nil {
+		t.Fatalf("GetVector failed: %v", err)
+	}
+	for j := range got {
+		if d := got[j] - vectors[7][j]; d > scale/2+1e-6 || d < -scale/2-1e-6 {
+			t.Fatalf("decoded vector %v is too far from %v", got, vectors[7])
+		}
+	}
+
+	// The storage mode and scale survive a save and load.
+	var buf bytes.Buffer
+	if err := quantized.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded := hnsw.NewHNSW(dim, 8, 64, core.Euclidean, "euclidean")
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if !loaded.Int8 || loaded.Stats().QuantizationScale != scale || loaded.Stats().Size != int8Size {
+		t.Errorf("expected loaded index to keep int8 storage, got Int8=%v scale=%g size=%d",
+			loaded.Int8, loaded.Stats().QuantizationScale, loaded.Stats().Size)
+	}
+}
+
+func TestHNSWIndex_SearchInto(t *testing.T) {
+	dim := 6
+	index := hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean")
+	for i := 0; i < 200; i++ {
+		vec := []float32{
+			float32(i % 7), float32(i % 11), float32(i % 13),
+			float32(i % 5), float32(i % 3), float32(i % 17),
+		}
+		if err := index.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	query := []float32{3, 5, 7, 2, 1, 8}
+	want, err := index.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	buf := make([]core.Neighbor, 0, 10)
+	got, err := index.SearchInto(query, 10, buf)
+	if err != nil {
+		t.Fatalf("SearchInto failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if &got[0] != &buf[:1][0] {
+		t.Error("expected results to reuse the provided buffer")
+	}
+}
+
+func BenchmarkHNSWIndex_SearchInto(b *testing.B) {
+	dim := 16
+	index := hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(1))
+	vectors := make(map[int][]float32, 2000)
+	for i := 0; i < 2000; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		b.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := vectors[0]
+
+	b.Run("Search", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := index.Search(query, 10); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("SearchInto", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]core.Neighbor, 0, 10)
+		for i := 0; i < b.N; i++ {
+			if _, err := index.SearchInto(query, 10, buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestHNSWIndex_SearchByID(t *testing.T) {
+	index := hnsw.NewHNSW(2, 4, 20, core.Euclidean, "euclidean")
+	vectors := map[int][]float32{
+		1: {0, 0},
+		2: {1, 0},
+		3: {0, 2},
+		4: {3, 3},
+		5: {10, 10},
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	results, err := index.SearchByID(1, 3)
+	if err != nil {
+		t.Fatalf("SearchByID failed: %v", err)
+	}
+	wantIDs := []int{2, 3, 4}
+	if len(results) != len(wantIDs) {
+		t.Fatalf("expected %d results, got %d", len(wantIDs), len(results))
+	}
+	for i, id := range wantIDs {
+		if results[i].ID != id {
+			t.Errorf("result %d: expected id %d, got %d", i, id, results[i].ID)
+		}
+	}
+
+	if _, err := index.SearchByID(99, 3); err == nil {
+		t.Error("expected error for unknown id, got none")
+	}
+}
+
+func TestHNSWIndex_LoadRestoresDistance(t *testing.T) {
+	index := hnsw.NewHNSW(3, 4, 10, core.Cosine, "cosine")
+	vectors := map[int][]float32{1: {1, 0, 0}, 2: {0, 1, 0}, 3: {1, 1, 0}}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := index.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Load into a handle configured with a different dimension and metric.
+	loaded := hnsw.NewHNSW(784, 4, 10, core.Euclidean, "euclidean")
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if loaded.Distance == nil || loaded.DistanceName != "cosine" || loaded.Dimension != 3 {
+		t.Fatalf("expected a 3-dim cosine index after load, got dimension=%d distance=%s",
+			loaded.Dimension, loaded.DistanceName)
+	}
+	query := []float32{2, 0, 0}
+	results, err := loaded.Search(query, 3)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	for _, r := range results {
+		want := core.Cosine(query, vectors[r.ID])
+		if diff := r.Distance - want; diff > 1e-6 || diff < -1e-6 {
+			t.Errorf("id %d: expected cosine distance %f, got %f", r.ID, want, r.Distance)
+		}
+	}
+
+	// A metric that isn't registered can't be restored.
+	custom := hnsw.NewHNSW(3, 4, 10, core.Euclidean, "custom")
+	if err := custom.Add(1, []float32{1, 2, 3}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	buf.Reset()
+	if err := custom.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := hnsw.NewHNSW(3, 4, 10, core.Euclidean, "euclidean").Load(&buf); err == nil {
+		t.Error("expected error loading an index with an unknown distance, got none")
+	}
+}
+
+func TestHNSWIndex_LoadRestoresPreciseDistance(t *testing.T) {
+	for name, precise := range core.PreciseDistances {
+		index := hnsw.NewHNSW(2, 4, 10, precise, name)
+		if err := index.BulkAdd(map[int][]float32{1: {1e6, 0.1}, 2: {0.3, 1e-3}, 3: {-2, 5}}); err != nil {
+			t.Fatalf("%s: BulkAdd failed: %v", name, err)
+		}
+		var buf bytes.Buffer
+		if err := index.Save(&buf); err != nil {
+			t.Fatalf("%s: Save failed: %v", name, err)
+		}
+		loaded := hnsw.NewHNSW(2, 4, 10, core.Distances[name], name)
+		if err := loaded.Load(&buf); err != nil {
+			t.Fatalf("%s: Load failed: %v", name, err)
+		}
+		if !core.IsPrecise(name, loaded.Distance) {
+			t.Errorf("%s: expected the precise variant after load", name)
+		}
+	}
+
+	// The built-in distances stay built-in.
+	index := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	if err := index.Add(1, []float32{1, 2}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := index.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded := hnsw.NewHNSW(2, 4, 10, core.EuclideanPrecise, "euclidean")
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if core.IsPrecise("euclidean", loaded.Distance) {
+		t.Error("expected the built-in euclidean distance after load")
+	}
+}
+
+func TestHNSWIndex_LoadIntoFreshHandle(t *testing.T) {
+	index := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	if err := index.BulkAdd(map[int][]float32{1: {0, 0}, 2: {3, 4}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := index.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// A zero-value handle has no distance function until Load restores it.
+	loaded := &hnsw.HNSWIndex{}
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	results, err := loaded.Search([]float32{0, 0}, 2)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != 1 || results[1].Distance != 5 {
+		t.Errorf("unexpected results after load: %+v", results)
+	}
+}
+
+func TestHNSWIndex_SearchUnsorted(t *testing.T) {
+	dim := 4
+	index := hnsw.NewHNSW(dim, 4, 8, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(9))
+	for i := 0; i < 300; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		if err := index.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	query := []float32{0.5, 0.5, 0.5, 0.5}
+	// A k larger than ef also exercises the fallback path.
+	for _, k := range []int{5, 50} {
+		want, err := index.Search(query, k)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		got, err := index.SearchUnsorted(query, k)
+		if err != nil {
+			t.Fatalf("SearchUnsorted failed: %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("k=%d: expected %d results, got %d", k, len(want), len(got))
+		}
+		wantSet := make(map[int]bool, len(want))
+		for _, n := range want {
+			wantSet[n.ID] = true
+		}
+		for _, n := range got {
+			if !wantSet[n.ID] {
+				t.Errorf("k=%d: unexpected id %d in unsorted results", k, n.ID)
+			}
+		}
+	}
+}
+
+func TestHNSWIndex_Validate(t *testing.T) {
+	dim := 4
+	index := hnsw.NewHNSW(dim, 4, 20, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(3))
+	randomVector := func() []float32 {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		return vec
+	}
+	vectors := make(map[int][]float32, 200)
+	for i := 0; i < 200; i++ {
+		vectors[i] = randomVector()
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if err := index.Validate(); err != nil {
+		t.Fatalf("expected valid index after BulkAdd, got: %v", err)
+	}
+
+	// Every mutation keeps the graph valid.
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"Add", func() error { return index.Add(200, randomVector()) }},
+		{"Delete", func() error { return index.Delete(index.EntryPoint.ID) }},
+		{"Update", func() error { return index.Update(10, randomVector()) }},
+		{"BulkDelete", func() error { return index.BulkDelete([]int{1, 2, 3, 4, 5}) }},
+		{"BulkUpdate", func() error {
+			return index.BulkUpdate(map[int][]float32{20: randomVector(), 21: randomVector()})
+		}},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s failed: %v", step.name, err)
+		}
+		if err := index.Validate(); err != nil {
+			t.Fatalf("expected valid index after %s, got: %v", step.name, err)
+		}
+	}
+
+	// Find a node with a neighbor at level 0 to corrupt.
+	var node *hnsw.Node
+	for _, n := range index.Nodes {
+		if len(n.Links[0]) > 0 {
+			node = n
+			break
+		}
+	}
+	if node == nil {
+		t.Fatal("expected a node with neighbors")
+	}
+	neighbor := node.Links[0][0]
+
+	// A missing reverse link is detected.
+	reverse := neighbor.ReverseLinks[0]
+	neighbor.ReverseLinks[0] = nil
+	if err := index.Validate(); err == nil {
+		t.Error("expected error for missing reverse link, got none")
+	}
+	neighbor.ReverseLinks[0] = reverse
+
+	// A link to a node that is no longer in the index is detected.
+	delete(index.Nodes, neighbor.ID)
+	if err := index.Validate(); err == nil {
+		t.Error("expected error for link to deleted node, got none")
+	}
+	index.Nodes[neighbor.ID] = neighbor
+
+	// An entry point below the maximum level is detected.
+	entry := index.EntryPoint
+	for _, n := range index.Nodes {
+		if n.Level < entry.Level {
+			index.EntryPoint = n
+			break
+		}
+	}
+	if index.EntryPoint == entry {
+		t.Fatal("expected a node below the entry point level")
+	}
+	if err := index.Validate(); err == nil {
+		t.Error("expected error for entry point below the maximum level, got none")
+	}
+	index.EntryPoint = entry
+	if err := index.Validate(); err != nil {
+		t.Errorf("expected valid index after restoring state, got: %v", err)
+	}
+}
+
+func TestHNSWIndex_FallbackDedup(t *testing.T) {
+	index := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	if err := index.BulkAdd(map[int][]float32{1: {0, 0}, 2: {1, 0}, 3: {0, 1}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	// Corrupt the graph with two unlinked nodes that share an id, so the layer search cannot reach
+	// them and the fallback scan finds both.
+	for key, vec := range map[int][]float32{100: {5, 5}, 101: {5, 6}} {
+		index.Nodes[key] = &hnsw.Node{
+			ID:           100,
+			Vector:       vec,
+			Links:        map[int][]*hnsw.Node{0: nil},
+			ReverseLinks: map[int][]*hnsw.Node{0: nil},
+		}
+	}
+
+	results, err := index.Search([]float32{5, 5}, 5)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	seen := make(map[int]bool)
+	for _, r := range results {
+		if seen[r.ID] {
+			t.Fatalf("id %d returned twice: %v", r.ID, results)
+		}
+		seen[r.ID] = true
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 distinct neighbors, got %v", results)
+	}
+	if results[0].ID != 100 || results[0].Distance != 0 {
+		t.Errorf("expected id 100 at distance 0 first, got %v", results[0])
+	}
+}
+
+func TestHNSWIndex_SearchAboveSimilarity(t *testing.T) {
+	index := hnsw.NewHNSW(8, 16, 100, core.Cosine, "cosine")
+	rng := rand.New(rand.NewSource(23))
+	vectors := make(map[int][]float32, 300)
+	for i := 0; i < 300; i++ {
+		vec := make([]float32, 8)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := vectors[0]
+	const minSim = 0.9
+	expected := make(map[int]bool)
+	for id, vec := range vectors {
+		if 1-core.Cosine(query, vec) > minSim {
+			expected[id] = true
+		}
+	}
+	if len(expected) < 5 || len(expected) > 250 {
+		t.Fatalf("expected a selective threshold, %d of 300 vectors are above it", len(expected))
+	}
+
+	results, err := index.SearchAboveSimilarity(query, minSim, 300)
+	if err != nil {
+		t.Fatalf("SearchAboveSimilarity failed: %v", err)
+	}
+	for i, r := range results {
+		if !expected[r.ID] {
+			t.Errorf("id %d with similarity %f is not above %f", r.ID, 1-r.Distance, minSim)
+		}
+		if i > 0 && r.Distance < results[i-1].Distance {
+			t.Errorf("results not sorted by distance: %v", results)
+		}
+	}
+	if len(results) < len(expected)*9/10 {
+		t.Errorf("expected most of the %d vectors above the threshold, got %d", len(expected), len(results))
+	}
+
+	limited, err := index.SearchAboveSimilarity(query, minSim, 3)
+	if err != nil {
+		t.Fatalf("SearchAboveSimilarity failed: %v", err)
+	}
+	if !reflect.DeepEqual(limited, results[:3]) {
+		t.Errorf("expected the 3 nearest of %v, got %v", results[:3], limited)
+	}
+
+	if results, err := index.SearchAboveSimilarity(query, 1.5, 10); err != nil || len(results) != 0 {
+		t.Errorf("expected no results above an unreachable similarity, got %v, %v", results, err)
+	}
+	if _, err := index.SearchAboveSimilarity(query, minSim, 0); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK for maxResults 0, got %v", err)
+	}
+}
+
+func TestHNSWIndex_AutoEf(t *testing.T) {
+	dim := 4
+	index := hnsw.NewHNSW(dim, 8, 10, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(3))
+	for i := 0; i < 500; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		if err := index.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	query := []float32{0.5, 0.5, 0.5, 0.5}
+	results, err := index.Search(query, 50)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 50 {
+		t.Fatalf("expected 50 results, got %d", len(results))
+	}
+	if n := index.FallbackCount(); n != 0 {
+		t.Errorf("expected no fallback with k > ef, got %d", n)
+	}
+
+	index.FixedEf = true
+	if _, err := index.Search(query, 50); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if n := index.FallbackCount(); n != 1 {
+		t.Errorf("expected one fallback with FixedEf, got %d", n)
+	}
+}
+
+func TestHNSWIndex_SentinelErrors(t *testing.T) {
+	index := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	if _, err := index.Search([]float32{0, 0}, 1); !errors.Is(err, core.ErrEmptyIndex) {
+		t.Errorf("expected ErrEmptyIndex, got %v", err)
+	}
+	if err := index.Add(1, []float32{1, 1}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := index.Delete(99); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := index.Add(1, []float32{2, 2}); !errors.Is(err, core.ErrDuplicateID) {
+		t.Errorf("expected ErrDuplicateID, got %v", err)
+	}
+	if err := index.Add(2, []float32{1, 2, 3}); !errors.Is(err, core.ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch, got %v", err)
+	}
+	if _, err := index.Search([]float32{0, 0}, 0); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK, got %v", err)
+	}
+}
+
+func TestHNSWIndex_VectorStats(t *testing.T) {
+	index := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	index.VectorStats = core.NewStats(2)
+	if err := index.Add(1, []float32{1, 4}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := index.BulkAdd(map[int][]float32{2: {3, 8}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if n := index.VectorStats.Count(); n != 2 {
+		t.Fatalf("expected 2 vectors in stats, got %d", n)
+	}
+	mean := index.VectorStats.Mean()
+	if mean[0] != 2 || mean[1] != 6 {
+		t.Errorf("expected mean [2 6], got %v", mean)
+	}
+}
+
+func TestSparseIndex_SearchSparse(t *testing.T) {
+	index := core.NewSparseIndex(hnsw.NewHNSW(10, 5, 10, core.Euclidean, "euclidean"))
+	vectors := map[int]core.SparseVector{
+		1: {Indices: []int{0}, Values: []float32{1}},
+		2: {Indices: []int{4, 9}, Values: []float32{1, 1}},
+		3: {Indices: []int{9}, Values: []float32{5}},
+	}
+	if err := index.BulkAddSparse(vectors); err != nil {
+		t.Fatalf("BulkAddSparse failed: %v", err)
+	}
+	if err := index.AddSparse(4, core.SparseVector{Indices: []int{10}, Values: []float32{1}}); err == nil {
+		t.Error("expected error for out of range sparse index, got none")
+	}
+	results, err := index.SearchSparse(core.SparseVector{Indices: []int{4, 9}, Values: []float32{1, 1.2}}, 1)
+	if err != nil {
+		t.Fatalf("SearchSparse failed: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != 2 {
+		t.Errorf("expected nearest neighbor 2, got %v", results)
+	}
+}
+
+func TestHNSWIndex_SearchWithMetric(t *testing.T) {
+	index := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	// Id 2 is closer to the query by Euclidean distance, id 1 by angle.
+	vectors := map[int][]float32{1: {10, 0.5}, 2: {1, 0.3}, 3: {0, 5}, 4: {-3, -3}}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := []float32{1, 0}
+	results, err := index.SearchWithMetric(query, 2, core.Cosine)
+	if err != nil {
+		t.Fatalf("SearchWithMetric failed: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != 1 || results[1].ID != 2 {
+		t.Fatalf("expected ids [1 2] ranked by cosine, got %+v", results)
+	}
+	if want := core.Cosine(query, vectors[1]); results[0].Distance != want {
+		t.Errorf("expected cosine distance %v, got %v", want, results[0].Distance)
+	}
+
+	// Re-ranking with the index metric must give the same neighborhood as Search.
+	rng := rand.New(rand.NewSource(4))
+	large := hnsw.NewHNSW(8, 8, 50, core.Euclidean, "euclidean")
+	for i := 0; i < 300; i++ {
+		vec := make([]float32, 8)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		if err := large.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	query = []float32{0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5}
+	want, err := large.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	got, err := large.SearchWithMetric(query, 10, core.Euclidean)
+	if err != nil {
+		t.Fatalf("SearchWithMetric failed: %v", err)
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID {
+			t.Fatalf("expected ids %+v, got %+v", want, got)
+		}
+	}
+
+	// With normalization, rank compares the normalized query with the normalized stored vectors, so
+	// re-ranking with the index metric reproduces the distances of Search.
+	normalized := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	normalized.Normalize = true
+	if err := normalized.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query = []float32{10, 0}
+	want, err = normalized.Search(query, 4)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	got, err = normalized.SearchWithMetric(query, 4, core.Euclidean)
+	if err != nil {
+		t.Fatalf("SearchWithMetric failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestHNSWIndex_SetEntryPoint(t *testing.T) {
+	dim := 4
+	index := hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(6))
+	randomVector := func() []float32 {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		return vec
+	}
+	for i := 0; i < 200; i++ {
+		if err := index.Add(i, randomVector()); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	if err := index.SetEntryPoint(999); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a missing id, got %v", err)
+	}
+	if err := index.SetEntryPoint(17); err != nil {
+		t.Fatalf("SetEntryPoint failed: %v", err)
+	}
+	// Inserts and deletes of other nodes must not move the pinned entry point.
+	for i := 200; i < 300; i++ {
+		if err := index.Add(i, randomVector()); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	deleted := index.EntryPoint.ID
+	if deleted == 17 {
+		deleted = 18
+	}
+	if err := index.Delete(deleted); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if id, ok := index.PinnedEntryPoint(); !ok || id != 17 {
+		t.Fatalf("expected pinned entry point 17, got %d (pinned %v)", id, ok)
+	}
+	if err := index.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+
+	// Searches starting from the pinned node still find the stored vectors.
+	vec, err := index.GetVector(250)
+	if err != nil {
+		t.Fatalf("GetVector failed: %v", err)
+	}
+	results, err := index.Search(vec, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if results[0].ID != 250 {
+		t.Errorf("expected id 250 as nearest neighbor, got %d", results[0].ID)
+	}
+
+	// The pin survives a save and load.
+	var buf bytes.Buffer
+	if err := index.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded := hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean")
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if id, ok := loaded.PinnedEntryPoint(); !ok || id != 17 {
+		t.Errorf("expected pinned entry point 17 after load, got %d (pinned %v)", id, ok)
+	}
+
+	if err := index.ClearEntryPoint(); err != nil {
+		t.Fatalf("ClearEntryPoint failed: %v", err)
+	}
+	if _, ok := index.PinnedEntryPoint(); ok {
+		t.Error("expected no pinned entry point after ClearEntryPoint")
+	}
+
+	// Deleting the pinned node unpins it.
+	if err := loaded.Delete(17); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, ok := loaded.PinnedEntryPoint(); ok {
+		t.Error("expected deleting the pinned node to unpin it")
+	}
+}
+
+func TestHNSWIndex_SearchDiverse(t *testing.T) {
+	index := hnsw.NewHNSW(2, 5, 20, core.Euclidean, "euclidean")
+	// Three clusters of five near-duplicates each.
+	centers := [][]float32{{0, 0}, {3, 0}, {0, 4}}
+	vectors := make(map[int][]float32)
+	for c, center := range centers {
+		for i := 0; i < 5; i++ {
+			vectors[c*10+i] = []float32{center[0] + 0.001*float32(i), center[1]}
+		}
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	cluster := func(id int) int { return id / 10 }
+	query := []float32{0.1, 0}
+
+	plain, err := index.Search(query, 3)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	for _, n := range plain {
+		if cluster(n.ID) != 0 {
+			t.Fatalf("expected plain search to return only the nearest cluster, got %+v", plain)
+		}
+	}
+
+	diverse, err := index.SearchDiverse(query, 3, 0.5)
+	if err != nil {
+		t.Fatalf("SearchDiverse failed: %v", err)
+	}
+	seen := make(map[int]bool)
+	for _, n := range diverse {
+		seen[cluster(n.ID)] = true
+	}
+	if len(diverse) != 3 || len(seen) != 3 {
+		t.Errorf("expected one result from each cluster, got %+v", diverse)
+	}
+	if cluster(diverse[0].ID) != 0 {
+		t.Errorf("expected the most relevant result first, got %+v", diverse)
+	}
+
+	if _, err := index.SearchDiverse(query, 3, 1.5); err == nil {
+		t.Error("expected error for lambda outside [0, 1], got none")
+	}
+}
+
+func TestHNSWIndex_FallbackMatchesExactScan(t *testing.T) {
+	dim := 4
+	index := hnsw.NewHNSW(dim, 8, 20, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(8))
+	vectors := make(map[int][]float32)
+	for i := 0; i < 400; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			// Coarse values produce many equal distances, which must be broken by id.
+			vec[j] = float32(rng.Intn(4))
+		}
+		vectors[i] = vec
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	query := []float32{1, 2, 1, 2}
+	exclude := []int{3, 5, 8}
+	excluded := map[int]bool{3: true, 5: true, 8: true}
+	// Reference: sort all remaining ids by distance, then id, and take the first ones.
+	var ids []int
+	for id := range vectors {
+		if !excluded[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		di, dj := core.Euclidean(query, vectors[ids[i]]), core.Euclidean(query, vectors[ids[j]])
+		if di == dj {
+			return ids[i] < ids[j]
+		}
+		return di < dj
+	})
+	for _, size := range []int{1, 25, 100, 397, 1000} {
+		want := ids
+		if size < len(want) {
+			want = ids[:size]
+		}
+		got := index.FallbackIDs(query, exclude, size)
+		if len(got) != len(want) {
+			t.Fatalf("size %d: expected %d ids, got %d", size, len(want), len(got))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("size %d: expected %v, got %v", size, want, got)
+			}
+		}
+	}
+}
+
+func BenchmarkHNSWIndex_SearchFallback(b *testing.B) {
+	dim, n := 16, 10000
+	index := hnsw.NewHNSW(dim, 8, 10, core.Euclidean, "euclidean")
+	index.FixedEf = true
+	rng := rand.New(rand.NewSource(1))
+	vectors := make(map[int][]float32, n)
+	for i := 0; i < n; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		b.Fatal(err)
+	}
+	query := vectors[0]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// k > Ef with FixedEf always takes the fallback path.
+		if _, err := index.Search(query, 50); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestHNSWIndex_EarlyStop(t *testing.T) {
+	dim, n := 64, 1000
+	rng := rand.New(rand.NewSource(12))
+	vectors := make(map[int][]float32, n)
+	for i := 0; i < n; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	for _, name := range []string{"euclidean", "squared_euclidean", "manhattan"} {
+		index := hnsw.NewHNSW(dim, 8, 40, core.Distances[name], name)
+		if err := index.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		for q := 0; q < 20; q++ {
+			query := vectors[q*31]
+			index.EarlyStop = true
+			withEarlyStop, err := index.Search(query, 10)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			index.EarlyStop = false
+			without, err := index.Search(query, 10)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			for i := range without {
+				if withEarlyStop[i] != without[i] {
+					t.Fatalf("%s: results differ with early stopping: %v vs %v", name, withEarlyStop, without)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkHNSWIndex_SearchEarlyStop compares layer search at 960 dimensions with and without early
+// termination of distance computations.
+func BenchmarkHNSWIndex_SearchEarlyStop(b *testing.B) {
+	dim, n := 960, 2000
+	index := hnsw.NewHNSW(dim, 32, 20, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(1))
+	// Points are spread around a few cluster centers, as real embeddings tend to be.
+	centers := make([][]float32, 20)
+	for c := range centers {
+		centers[c] = make([]float32, dim)
+		for j := range centers[c] {
+			centers[c][j] = rng.Float32()
+		}
+	}
+	vectors := make(map[int][]float32, n)
+	for i := 0; i < n; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = centers[i%len(centers)][j] + 0.1*float32(rng.NormFloat64())
+		}
+		vectors[i] = vec
+	}
+	if err := index.BulkAdd(vectors); err != nil {
+		b.Fatal(err)
+	}
+	query := make([]float32, dim)
+	for j := range query {
+		query[j] = centers[0][j] + 0.1*float32(rng.NormFloat64())
+	}
+	for _, earlyStop := range []bool{false, true} {
+		name := "full"
+		if earlyStop {
+			name = "early_stop"
+		}
+		b.Run(name, func(b *testing.B) {
+			index.EarlyStop = earlyStop
+			for i := 0; i < b.N; i++ {
+				if _, err := index.Search(query, 10); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkHNSWIndex_BulkAddLogging compares building an index with debug logging enabled, writing to a
+// discarded output, against logging disabled, which should not pay for formatting per-node messages.
+func BenchmarkHNSWIndex_BulkAddLogging(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	vectors := make(map[int][]float32, 2000)
+	for i := 0; i < 2000; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	logger, level := log.Logger, zerolog.GlobalLevel()
+	defer func() {
+		log.Logger = logger
+		zerolog.SetGlobalLevel(level)
+	}()
+	log.Logger = zerolog.New(io.Discard)
+	for _, tc := range []struct {
+		name  string
+		level zerolog.Level
+	}{
+		{"debug", zerolog.DebugLevel},
+		{"disabled", zerolog.Disabled},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			zerolog.SetGlobalLevel(tc.level)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				index := hnsw.NewHNSW(4, 8, 20, core.Euclidean, "euclidean")
+				if err := index.BulkAdd(vectors); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestHNSWIndex_Close(t *testing.T) {
+	index := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	if err := index.Add(1, []float32{1, 1}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := index.Close(); err != nil {
+			t.Errorf("Close call %d failed: %v", i+1, err)
+		}
+	}
+}
+
+func TestHNSWIndex_BuildStats(t *testing.T) {
+	rng := rand.New(rand.NewSource(31))
+	var counts []int64
+	for _, n := range []int{200, 800} {
+		vectors := make(map[int][]float32, n)
+		for i := 0; i < n; i++ {
+			vec := make([]float32, 8)
+			for j := range vec {
+				vec[j] = rng.Float32()
+			}
+			vectors[i] = vec
+		}
+		idx := hnsw.NewHNSW(8, 8, 50, core.Euclidean, "euclidean")
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		before := idx.BuildStats().DistanceEvaluations
+		if before == 0 {
+			t.Fatalf("expected distance evaluations after building %d vectors, got none", n)
+		}
+		if _, err := idx.Search(vectors[0], 10); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if after := idx.BuildStats().DistanceEvaluations; after != before {
+			t.Errorf("expected Search not to count build distances, got %d then %d", before, after)
+		}
+		counts = append(counts, before)
+	}
+	if counts[1] < 2*counts[0] {
+		t.Errorf("expected 4x the vectors to take at least 2x the distance evaluations, got %d and %d",
+			counts[0], counts[1])
+	}
+}
+
+func TestHNSWIndex_Freeze(t *testing.T) {
+	idx := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	if err := idx.BulkAdd(map[int][]float32{1: {0, 0}, 2: {1, 0}, 3: {5, 5}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if idx.Frozen() {
+		t.Fatal("expected a new index not to be frozen")
+	}
+	other := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	if err := other.Add(7, []float32{3, 3}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	var saved, compressed bytes.Buffer
+	if err := other.Save(&saved); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := other.SaveCompressed(&compressed); err != nil {
+		t.Fatalf("SaveCompressed failed: %v", err)
+	}
+	idx.Freeze()
+	if !idx.Frozen() {
+		t.Fatal("expected the index to be frozen after Freeze")
+	}
+	mutations := map[string]func() error{
+		"Add":                func() error { return idx.Add(4, []float32{2, 2}) },
+		"BulkAdd":            func() error { return idx.BulkAdd(map[int][]float32{4: {2, 2}}) },
+		"Delete":             func() error { return idx.Delete(1) },
+		"BulkDelete":         func() error { return idx.BulkDelete([]int{1, 2}) },
+		"Update":             func() error { return idx.Update(1, []float32{9, 9}) },
+		"BulkUpdate":         func() error { return idx.BulkUpdate(map[int][]float32{1: {9, 9}}) },
+		"Load":               func() error { return idx.Load(&saved) },
+		"LoadCompressed":     func() error { return idx.LoadCompressed(&compressed) },
+		"SetEntryPoint":      func() error { return idx.SetEntryPoint(3) },
+		"ClearEntryPoint":    idx.ClearEntryPoint,
+		"RebuildUpperLayers": idx.RebuildUpperLayers,
+		"ResetLinks":         idx.ResetLinks,
+	}
+	for name, mutate := range mutations {
+		if err := mutate(); !errors.Is(err, core.ErrFrozen) {
+			t.Errorf("%s: expected ErrFrozen, got %v", name, err)
+		}
+	}
+	if n, failures := idx.BulkAddLenient(map[int][]float32{4: {2, 2}}); n != 0 || !errors.Is(failures[4], core.ErrFrozen) {
+		t.Errorf("BulkAddLenient: expected no additions and ErrFrozen, got %d, %v", n, failures)
+	}
+	if n := idx.Stats().Count; n != 3 {
+		t.Errorf("expected 3 vectors after rejected mutations, got %d", n)
+	}
+	if vec, err := idx.GetVector(1); err != nil || !reflect.DeepEqual(vec, []float32{0, 0}) {
+		t.Errorf("expected vector 1 unchanged, got %v, %v", vec, err)
+	}
+	results, err := idx.Search([]float32{0.9, 0}, 1)
+	if err != nil {
+		t.Fatalf("Search on frozen index failed: %v", err)
+	}
+	if results[0].ID != 2 {
+		t.Errorf("expected id 2, got %d", results[0].ID)
+	}
+}
+
+func TestHNSWIndex_Prefetch(t *testing.T) {
+	idx := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	vectors := map[int][]float32{1: {0, 0}, 2: {1, 0}, 3: {5, 5}, 4: {9, 9}}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	before, err := idx.Search([]float32{0.9, 0}, 2)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	for _, ids := range [][]int{nil, {1, 2}} {
+		if err := idx.Prefetch(ids); err != nil {
+			t.Fatalf("Prefetch(%v) failed: %v", ids, err)
+		}
+	}
+	after, err := idx.Search([]float32{0.9, 0}, 2)
+	if err != nil {
+		t.Fatalf("Search after Prefetch failed: %v", err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("expected the same results after Prefetch, got %v, want %v", after, before)
+	}
+}
+
+func TestHNSWIndex_KthDistance(t *testing.T) {
+	idx := hnsw.NewHNSW(4, 8, 50, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(8))
+	vectors := make(map[int][]float32, 100)
+	for i := 0; i < 100; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := []float32{0.5, 0.5, 0.5, 0.5}
+	for _, k := range []int{1, 5, 10} {
+		results, err := idx.Search(query, k)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		got, err := idx.KthDistance(query, k)
+		if err != nil {
+			t.Fatalf("KthDistance failed: %v", err)
+		}
+		if got != results[k-1].Distance {
+			t.Errorf("k=%d: KthDistance = %f; want %f", k, got, results[k-1].Distance)
+		}
+	}
+	if _, err := idx.KthDistance(query, 101); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK for k above the index size, got %v", err)
+	}
+	if _, err := idx.KthDistance(query, 0); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK for k=0, got %v", err)
+	}
+}
+
+func TestHNSWIndex_SearchFarthest(t *testing.T) {
+	idx := hnsw.NewHNSW(4, 8, 50, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(12))
+	vectors := make(map[int][]float32, 60)
+	for i := 0; i < 60; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := []float32{0.1, 0.9, 0.4, 0.2}
+	want := make([]core.Neighbor, 0, len(vectors))
+	for id, vec := range vectors {
+		want = append(want, core.Neighbor{ID: id, Distance: core.Euclidean(query, vec)})
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].Distance > want[j].Distance })
+
+	got, err := idx.SearchFarthest(query, 5)
+	if err != nil {
+		t.Fatalf("SearchFarthest failed: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 results, got %d", len(got))
+	}
+	for i := range got {
+		if got[i].ID != want[i].ID || math.Abs(got[i].Distance-want[i].Distance) > 1e-6 {
+			t.Errorf("result %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if _, err := idx.SearchFarthest(query, 0); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK, got %v", err)
+	}
+
+	// With normalization, the query is normalized like the stored vectors, so the distances are the
+	// ones Search reports.
+	normalized := hnsw.NewHNSW(2, 8, 50, core.Euclidean, "euclidean")
+	normalized.Normalize = true
+	if err := normalized.BulkAdd(map[int][]float32{1: {1, 0}, 2: {0, 3}, 3: {-2, 0}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	farthest, err := normalized.SearchFarthest([]float32{10, 0}, 3)
+	if err != nil {
+		t.Fatalf("SearchFarthest failed: %v", err)
+	}
+	nearest, err := normalized.Search([]float32{10, 0}, 3)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	for i := range farthest {
+		if want := nearest[len(nearest)-1-i]; farthest[i] != want {
+			t.Errorf("result %d: got %+v, want %+v as reported by Search", i, farthest[i], want)
+		}
+	}
+}
+
+func TestHNSWIndex_AllKNNStream(t *testing.T) {
+	idx := hnsw.NewHNSW(4, 8, 50, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(13))
+	vectors := make(map[int][]float32, 100)
+	for i := 0; i < 100; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	want, err := idx.AllKNN(5)
+	if err != nil {
+		t.Fatalf("AllKNN failed: %v", err)
+	}
+	if len(want) != len(vectors) {
+		t.Fatalf("expected neighbors for %d ids, got %d", len(vectors), len(want))
+	}
+
+	got := make(map[int][]core.Neighbor, len(vectors))
+	prev := -1
+	err = idx.AllKNNStream(5, func(id int, neighbors []core.Neighbor) error {
+		if id <= prev {
+			t.Errorf("expected ascending ids, got %d after %d", id, prev)
+		}
+		prev = id
+		got[id] = neighbors
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AllKNNStream failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("expected the streamed neighbors to match AllKNN")
+	}
+	for id, neighbors := range got {
+		if len(neighbors) != 5 {
+			t.Errorf("expected 5 neighbors for id %d, got %d", id, len(neighbors))
+		}
+		for _, n := range neighbors {
+			if n.ID == id {
+				t.Errorf("expected id %d to be excluded from its own neighbors", id)
+			}
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = idx.AllKNNStream(5, func(id int, neighbors []core.Neighbor) error {
+		calls++
+		if calls == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || calls != 3 {
+		t.Errorf("expected the callback error to stop after 3 calls, got %v after %d calls", err, calls)
+	}
+}
+
+func TestHNSWIndex_SearchMultiQuery(t *testing.T) {
+	idx := hnsw.NewHNSW(2, 5, 10, core.Euclidean, "euclidean")
+	vectors := map[int][]float32{
+		1: {0, 0},
+		2: {10, 0},
+		3: {5, 0.5},
+		4: {2.5, 0.5},
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	queries := [][]float32{{0, 0}, {10, 0}}
+	for _, tc := range []struct {
+		weights []float32
+		want    int
+	}{
+		{[]float32{1, 1}, 3},
+		{[]float32{3, 1}, 4},
+		{[]float32{1, 0}, 1},
+	} {
+		results, err := idx.SearchMultiQuery(queries, tc.weights, 1)
+		if err != nil {
+			t.Fatalf("SearchMultiQuery failed: %v", err)
+		}
+		if results[0].ID != tc.want {
+			t.Errorf("weights %v: expected id %d, got %d", tc.weights, tc.want, results[0].ID)
+		}
+	}
+
+	if _, err := idx.SearchMultiQuery(queries, []float32{1}, 1); err == nil {
+		t.Error("expected error for mismatched weights, got none")
+	}
+	if _, err := idx.SearchMultiQuery(queries, []float32{0, 0}, 1); err == nil {
+		t.Error("expected error for zero weights, got none")
+	}
+	if _, err := idx.SearchMultiQuery([][]float32{{0, 0}, {1}}, []float32{1, 1}, 1); !errors.Is(err, core.ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch, got %v", err)
+	}
+}
+
+func TestHNSWIndex_SearchPage(t *testing.T) {
+	idx := hnsw.NewHNSW(4, 8, 50, core.Euclidean, "euclidean")
+	rng := rand.New(rand.NewSource(9))
+	vectors := make(map[int][]float32, 200)
+	for i := 0; i < 200; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := []float32{0.3, 0.6, 0.2, 0.9}
+	want, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	first, err := idx.SearchPage(query, 0, 5)
+	if err != nil {
+		t.Fatalf("SearchPage failed: %v", err)
+	}
+	second, err := idx.SearchPage(query, 5, 5)
+	if err != nil {
+		t.Fatalf("SearchPage failed: %v", err)
+	}
+	got := append(append([]core.Neighbor{}, first...), second...)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d results over two pages, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if page, err := idx.SearchPage(query, 200, 5); err != nil || len(page) != 0 {
+		t.Errorf("expected an empty page past the end, got %v, %v", page, err)
+	}
+	if _, err := idx.SearchPage(query, -1, 5); err == nil {
+		t.Error("expected error for negative offset, got none")
+	}
+}
+
+func TestHNSWIndex_Explain(t *testing.T) {
+	index := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	for i := 0; i < 30; i++ {
+		if err := index.Add(i, []float32{float32(i % 6), float32(i / 6)}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	query := []float32{2.2, 3.1}
+	explanation, err := index.Explain(query, 3)
+	if err != nil {
+		t.Fatalf("Explain failed: %v", err)
+	}
+	want, err := index.Search(query, 3)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(explanation.Results) != len(want) {
+		t.Fatalf("expected %d results, got %+v", len(want), explanation.Results)
+	}
+	for i := range want {
+		if explanation.Results[i] != want[i] {
+			t.Fatalf("expected results %+v, got %+v", want, explanation.Results)
+		}
+	}
+
+	if explanation.EntryPoint != index.EntryPoint.ID {
+		t.Errorf("expected entry point %d, got %d", index.EntryPoint.ID, explanation.EntryPoint)
+	}
+	if len(explanation.Path) != index.MaxLevel+1 {
+		t.Fatalf("expected a visit per level (%d), got %d", index.MaxLevel+1, len(explanation.Path))
+	}
+	if first := explanation.Path[0]; first.Level != index.MaxLevel || first.Visited[0].ID != explanation.EntryPoint {
+		t.Errorf("expected the path to start at the entry point on level %d, got %+v", index.MaxLevel, first)
+	}
+	base := explanation.Path[len(explanation.Path)-1]
+	if base.Level != 0 {
+		t.Fatalf("expected the path to end on level 0, got level %d", base.Level)
+	}
+	visited := make(map[int]bool)
+	for _, v := range base.Visited {
+		visited[v.ID] = true
+	}
+	for _, n := range explanation.Results {
+		if !visited[n.ID] {
+			t.Errorf("expected result %d among the nodes visited on level 0", n.ID)
+		}
+	}
+}
+
+func TestHNSWIndex_SearchWith(t *testing.T) {
+	t.Setenv("HANN_SEED", "3")
+	rng := rand.New(rand.NewSource(3))
+	idx := hnsw.NewHNSW(2, 8, 50, core.Euclidean, "euclidean")
+	for i := 0; i < 200; i++ {
+		if err := idx.Add(i, []float32{rng.Float32(), rng.Float32()}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	query := []float32{0.5, 0.5}
+	plain, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	t.Run("Zero", func(t *testing.T) {
+		results, err := idx.SearchWith(query, 10, core.SearchOptions{})
+		if err != nil {
+			t.Fatalf("SearchWith failed: %v", err)
+		}
+		if !reflect.DeepEqual(results, plain) {
+			t.Errorf("expected zero options to search like Search: got %v, want %v", results, plain)
+		}
+	})
+
+	t.Run("Ef", func(t *testing.T) {
+		narrow := hnsw.NewHNSW(2, 8, 1, core.Euclidean, "euclidean")
+		narrow.FixedEf = true
+		for i := 0; i < 200; i++ {
+			vec, _ := idx.GetVector(i)
+			if err := narrow.Add(i, vec); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		fallbacks := narrow.FallbackCount()
+		if _, err := narrow.Search(query, 10); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if narrow.FallbackCount() == fallbacks {
+			t.Fatal("expected a search with ef 1 to fall back")
+		}
+		fallbacks = narrow.FallbackCount()
+		results, err := narrow.SearchWith(query, 10, core.SearchOptions{Ef: 50})
+		if err != nil {
+			t.Fatalf("SearchWith failed: %v", err)
+		}
+		if len(results) != 10 {
+			t.Fatalf("expected 10 results, got %d", len(results))
+		}
+		if n := narrow.FallbackCount() - fallbacks; n != 0 {
+			t.Errorf("expected Ef 50 to find 10 candidates without a fallback, got %d fallbacks", n)
+		}
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		even := func(id int) bool { return id%2 == 0 }
+		results, err := idx.SearchWith(query, 10, core.SearchOptions{Filter: even})
+		if err != nil {
+			t.Fatalf("SearchWith failed: %v", err)
+		}
+		if len(results) != 10 {
+			t.Fatalf("expected 10 results, got %d", len(results))
+		}
+		for _, r := range results {
+			if !even(r.ID) {
+				t.Errorf("expected only even ids, got %d", r.ID)
+			}
+		}
+		// A filter that rejects nearly everything forces the search to widen to the whole index.
+		results, err = idx.SearchWith(query, 3, core.SearchOptions{Filter: func(id int) bool { return id >= 198 }})
+		if err != nil {
+			t.Fatalf("SearchWith failed: %v", err)
+		}
+		if len(results) != 2 || results[0].ID+results[1].ID != 198+199 {
+			t.Errorf("expected ids 198 and 199, got %v", results)
+		}
+	})
+
+	t.Run("ExcludeIDs", func(t *testing.T) {
+		results, err := idx.SearchWith(query, 9, core.SearchOptions{ExcludeIDs: []int{plain[0].ID}})
+		if err != nil {
+			t.Fatalf("SearchWith failed: %v", err)
+		}
+		if !reflect.DeepEqual(results, plain[1:]) {
+			t.Errorf("expected the plain results without the nearest: got %v, want %v", results, plain[1:])
+		}
+	})
+
+	t.Run("RankMetric", func(t *testing.T) {
+		results, err := idx.SearchWith(query, 5, core.SearchOptions{RankMetric: core.Manhattan})
+		if err != nil {
+			t.Fatalf("SearchWith failed: %v", err)
+		}
+		want, err := idx.SearchWithMetric(query, 5, core.Manhattan)
+		if err != nil {
+			t.Fatalf("SearchWithMetric failed: %v", err)
+		}
+		if !reflect.DeepEqual(results, want) {
+			t.Errorf("expected the SearchWithMetric results: got %v, want %v", results, want)
+		}
+	})
+
+	if _, err := idx.SearchWith(query, 0, core.SearchOptions{}); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK for k 0, got %v", err)
+	}
+}
+
+func TestHNSWIndex_StatsSize(t *testing.T) {
+	dim := 4
+	index := hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean")
+	vec := func(v float32) []float32 { return []float32{v, v + 1, v + 2, v + 3} }
+	if err := index.Add(0, vec(0)); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := index.BulkAdd(map[int][]float32{1: vec(1), 2: vec(2), 3: vec(3)}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if size := index.Stats().Size; size != 4*4*dim {
+		t.Errorf("expected size %d after adding 4 vectors, got %d", 4*4*dim, size)
+	}
+
+	// Vectors updated after switching to float16 take half the space, while the others keep theirs.
+	index.Float16 = true
+	if err := index.Update(0, vec(5)); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := index.BulkUpdate(map[int][]float32{1: vec(6)}); err != nil {
+		t.Fatalf("BulkUpdate failed: %v", err)
+	}
+	if size := index.Stats().Size; size != 2*2*dim+2*4*dim {
+		t.Errorf("expected size %d after updating 2 vectors to float16, got %d", 2*2*dim+2*4*dim, size)
+	}
+	if err := index.Delete(0); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := index.BulkDelete([]int{2}); err != nil {
+		t.Fatalf("BulkDelete failed: %v", err)
+	}
+	want := 2*dim + 4*dim
+	if size := index.Stats().Size; size != want {
+		t.Errorf("expected size %d after deleting, got %d", want, size)
+	}
+
+	var buf bytes.Buffer
+	if err := index.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded := hnsw.NewHNSW(dim, 8, 50, core.Euclidean, "euclidean")
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if size := loaded.Stats().Size; size != want {
+		t.Errorf("expected size %d after loading, got %d", want, size)
+	}
+}
+
+func TestHNSWIndex_Int8ScaleOfRejectedBatch(t *testing.T) {
+	index := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	index.Int8 = true
+	// A batch rejected for a bad vector must not leave the scale learned from its valid vectors behind.
+	if err := index.BulkAdd(map[int][]float32{1: {100, 100}, 2: {1}}); !errors.Is(err, core.ErrDimensionMismatch) {
+		t.Fatalf("expected ErrDimensionMismatch, got %v", err)
+	}
+	if scale := index.Stats().QuantizationScale; scale != 0 {
+		t.Fatalf("expected no scale after a rejected batch, got %g", scale)
+	}
+	if err := index.BulkAdd(map[int][]float32{1: {1, -1}, 2: {0.5, 0.5}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if scale := index.Stats().QuantizationScale; math.Abs(float64(scale)-1.0/127) > 1e-6 {
+		t.Errorf("expected the scale to be learned from the accepted batch, got %g", scale)
+	}
+}
+
+func TestHNSWIndex_Int8AddOneByOne(t *testing.T) {
+	vectors := map[int][]float32{1: {0.01, 0.01}, 2: {1, 1}, 3: {5, 5}}
+
+	// A single vector can't set the scale for the ones that follow.
+	index := hnsw.NewHNSW(2, 4, 10, core.Euclidean, "euclidean")
+	index.Int8 = true
+	if err := index.Add(1, vectors[1]); err == nil {
+		t.Fatal("expected Add to fail in int8 mode without a scale, got no error")
+	}
+	if n := index.Stats().Count; n != 0 {
+		t.Fatalf("expected no vectors after the failed Add, got %d", n)
+	}
+
+	// With the scale set, vectors added one by one keep their values.
+	index.Int8Scale = 5.0 / 127
+	for id := 1; id <= 3; id++ {
+		if err := index.Add(id, vectors[id]); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	for id := 2; id <= 3; id++ {
+		vec, err := index.GetVector(id)
+		if err != nil {
+			t.Fatalf("GetVector failed: %v", err)
+		}
+		if d := core.Euclidean(vec, vectors[id]); d > 0.05 {
+			t.Errorf("id %d: expected about %v, got %v", id, vectors[id], vec)
+		}
+	}
+	results, err := index.Search([]float32{5, 5}, 3)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if results[0].ID != 3 || results[1].ID != 2 || results[2].ID != 1 {
+		t.Errorf("expected ids [3 2 1], got %+v", results)
+	}
+}
@@ -0,0 +1,125 @@
+package pqivf
+
+import (
+	"math"
+	"sync/atomic"
+
+	"github.com/patrikhermansson/hann/core"
+)
+
+// CandidateClusters exposes the number of clusters a search for k neighbors visits, for tests.
+func (pq *PQIVFIndex) CandidateClusters(query []float32, k int) int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	_, visited := pq.candidateEntries(query, k, 0)
+	return visited
+}
+
+// EncodeDecode encodes a vector with the trained codebooks and reconstructs it, for tests.
+func (pq *PQIVFIndex) EncodeDecode(vector []float32) ([]float32, error) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	cluster, _ := pq.nearestCentroid(vector)
+	codes, err := pq.encodeVector(vector, cluster, nil)
+	if err != nil {
+		return nil, err
+	}
+	residual, err := pq.decodePQCode(codes)
+	if err != nil {
+		return nil, err
+	}
+	return vectorAdd(pq.coarseCentroids[cluster], residual)
+}
+
+// NearestCentroid returns the coarse cluster a vector is assigned to, for tests.
+func (pq *PQIVFIndex) NearestCentroid(vector []float32) int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	cluster, _ := pq.nearestCentroid(vector)
+	return cluster
+}
+
+// NearestCentroidLinear returns the nearest coarse cluster found by a plain linear scan, for tests.
+func (pq *PQIVFIndex) NearestCentroidLinear(vector []float32) int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	best := -1
+	bestDist := math.MaxFloat64
+	for i, centroid := range pq.coarseCentroids {
+		if d := pq.Distance(vector, centroid); d < bestDist {
+			bestDist = d
+			best = i
+		}
+	}
+	return best
+}
+
+// CorruptIDToCluster points an id at the wrong cluster without moving its entry, for tests.
+func (pq *PQIVFIndex) CorruptIDToCluster(id, cluster int) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	pq.idToCluster[id] = cluster
+}
+
+// randomInit picks k distinct data points as initial centroids, the seeding k-means++ is compared with.
+func randomInit(data [][]float32, k int, _ *atomic.Int64) [][]float32 {
+	centroids := make([][]float32, k)
+	seededRandMu.Lock()
+	perm := seededRand.Perm(len(data))
+	seededRandMu.Unlock()
+	for i := 0; i < k; i++ {
+		centroids[i] = make([]float32, len(data[0]))
+		copy(centroids[i], data[perm[i]])
+	}
+	return centroids
+}
+
+// TrainSubquantizer trains a codebook with k-means++ or random seeding and returns its mean squared
+// quantization error over the data, for tests.
+func TrainSubquantizer(data [][]float32, k, iterations int, kMeansPlusPlus bool) (float64, error) {
+	init := randomInit
+	if kMeansPlusPlus {
+		init = kMeansPlusPlusInit
+	}
+	centroids, err := trainSubquantizerWith(data, k, iterations, init, nil)
+	if err != nil {
+		return 0, err
+	}
+	var total float64
+	for _, point := range data {
+		best := math.MaxFloat64
+		for _, c := range centroids {
+			best = math.Min(best, core.SquaredEuclidean(point, c))
+		}
+		total += best
+	}
+	return total / float64(len(data)), nil
+}
+
+// ClusterOf returns the coarse cluster an id is assigned to, for tests.
+func (pq *PQIVFIndex) ClusterOf(id int) int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	return pq.idToCluster[id]
+}
+
+// ADCPrecomputations returns how often the codeword norms for UseADC were computed, for tests.
+func (pq *PQIVFIndex) ADCPrecomputations() int64 {
+	return pq.adcPrecomputations.Load()
+}
+
+// CoarseCentroids returns copies of the coarse centroids, for tests.
+func (pq *PQIVFIndex) CoarseCentroids() [][]float32 {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	centroids := make([][]float32, len(pq.coarseCentroids))
+	for i, c := range pq.coarseCentroids {
+		centroids[i] = append([]float32(nil), c...)
+	}
+	return centroids
+}
+
+// NumSubquantizers returns the number of subquantizers, for tests.
+func (pq *PQIVFIndex) NumSubquantizers() int {
+	return pq.numSubquantizers
+}
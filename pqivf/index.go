@@ -9,11 +9,17 @@ import (
 	"math/rand"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/patrikhermansson/hann/core"
+	"github.com/rs/zerolog/log"
 	"github.com/schollz/progressbar/v3"
 )
 
+// minSubquantizers is the smallest number of subquantizers NewPQIVFIndex accepts. Without one, vectors
+// can't be split for product quantization.
+const minSubquantizers = 1
+
 // seededRand is a global random number generator for random operations (e.g. during k-means).
 var seededRand = rand.New(rand.NewSource(core.GetSeed()))
 var seededRandMu sync.Mutex
@@ -41,6 +47,27 @@ type PQIVFIndex struct {
 	idToCluster          map[int]int       // mapping from vector id to its cluster assignment
 	Distance             core.DistanceFunc // function to compute distance between vectors
 	numCandidateClusters int               // number of candidate clusters to consider during search
+	changes              int               // number of vectors added or deleted since the last Train
+	trainedCount         int               // number of vectors in the index at the last Train
+	RetrainThreshold     float64           // fraction of vectors changed since the last Train that triggers a retrain (0 disables)
+	ExpansionFactor      float64           // search gathers at least ExpansionFactor*k candidates (values below 1 mean 1)
+	ClusterPenalty       float64           // diversifies results across clusters by penalizing repeats (0 disables)
+	ExactThreshold       int               // scan all vectors instead of the nearest clusters when there are at most this many (0 disables)
+	UseADC               bool              // compute distances to encoded entries from lookup tables (Euclidean only), see PrepareADC
+	VectorStats          *core.Stats       // optional running per-dimension statistics of inserted vectors
+	// WarmupSize buffers the first WarmupSize vectors in a single cluster and then initializes all coarse
+	// centroids at once with k-means over them, instead of seeding one centroid from each of the first
+	// coarseK vectors, so the initial clustering does not depend on insertion order. A bulk insert that
+	// fills the buffer is clustered together with the vectors buffered before it. It must be set before
+	// any vectors are added; 0 disables the warm-up.
+	WarmupSize int
+
+	warmedUp           bool                            // set once the warm-up has initialized the coarse centroids
+	buildDistances     atomic.Int64                    // distance computations made while adding and training, see BuildStats
+	frozen             atomic.Bool                     // set by Freeze to reject modifications
+	codewordNorms      [][]float64                     // squared norm of every codeword for UseADC, or nil until computed
+	adcPrecomputations atomic.Int64                    // number of times codewordNorms was computed
+	queryCache         atomic.Pointer[core.QueryCache] // cache of Search results, set by EnableQueryCache
 }
 
 // recalcCentroid recalculates the centroid for a given cluster based on its current entries.
@@ -61,10 +88,20 @@ func (pq *PQIVFIndex) recalcCentroid(cluster int) {
 	pq.coarseCentroids[cluster] = newCentroid
 }
 
-// NewPQIVFIndex creates a new PQIVF index. It panics if the dimension is not divisible by numSubquantizers.
+// NewPQIVFIndex creates a new PQIVF index.
+// The dimension doesn't need to be divisible by numSubquantizers; the last subquantizer covers the remainder.
+// A numSubquantizers below 1 is raised to 1, and one above the dimension, which would leave subquantizers
+// without any components, is lowered to the dimension, with a logged warning.
 func NewPQIVFIndex(dimension, coarseK, numSubquantizers, pqK, kMeansIters int) *PQIVFIndex {
-	if dimension%numSubquantizers != 0 {
-		panic(fmt.Sprintf("dimension (%d) must be divisible by numSubquantizers (%d)", dimension, numSubquantizers))
+	if numSubquantizers < minSubquantizers {
+		log.Warn().Msgf("PQIVF numSubquantizers=%d is too small, using numSubquantizers=%d",
+			numSubquantizers, minSubquantizers)
+		numSubquantizers = minSubquantizers
+	}
+	if dimension > 0 && numSubquantizers > dimension {
+		log.Warn().Msgf("PQIVF numSubquantizers=%d exceeds dimension %d, using numSubquantizers=%d",
+			numSubquantizers, dimension, dimension)
+		numSubquantizers = dimension
 	}
 	return &PQIVFIndex{
 		dimension:            dimension,
@@ -83,11 +120,21 @@ func NewPQIVFIndex(dimension, coarseK, numSubquantizers, pqK, kMeansIters int) *
 }
 
 // nearestCentroid finds the closest coarse centroid to the vector and returns its index and distance.
+// It uses the index's Distance like nearestCentroids, so a vector is assigned to the cluster that
+// searches and NearestClusters rank first. With the built-in Euclidean distance the scan abandons a
+// centroid as soon as its partial distance exceeds the best one found so far, which skips most of the
+// arithmetic when there are many centroids.
 func (pq *PQIVFIndex) nearestCentroid(vector []float32) (int, float64) {
+	early := core.EarlyStopFor("euclidean", pq.Distance)
 	best := -1
 	bestDist := math.MaxFloat64
 	for i, centroid := range pq.coarseCentroids {
-		d := pq.Distance(vector, centroid)
+		var d float64
+		if early != nil {
+			d = early(vector, centroid, bestDist)
+		} else {
+			d = pq.Distance(vector, centroid)
+		}
 		if d < bestDist {
 			bestDist = d
 			best = i
@@ -113,6 +160,9 @@ func (pq *PQIVFIndex) nearestCentroids(vector []float32) []struct {
 		}{cluster: i, dist: d})
 	}
 	sort.Slice(res, func(i, j int) bool {
+		if res[i].dist == res[j].dist {
+			return res[i].cluster < res[j].cluster
+		}
 		return res[i].dist < res[j].dist
 	})
 	return res
@@ -120,19 +170,48 @@ func (pq *PQIVFIndex) nearestCentroids(vector []float32) []struct {
 
 // Add inserts a new vector with an id into the index.
 func (pq *PQIVFIndex) Add(id int, vector []float32) error {
+	if pq.frozen.Load() {
+		return core.ErrFrozen
+	}
 	pq.mu.Lock()
 	defer pq.mu.Unlock()
+	defer pq.queryCache.Load().Invalidate()
 
 	if len(vector) != pq.dimension {
-		return fmt.Errorf("vector dimension %d does not match index dimension %d", len(vector), pq.dimension)
+		return fmt.Errorf("%w: vector dimension %d does not match index dimension %d",
+			core.ErrDimensionMismatch, len(vector), pq.dimension)
 	}
 	if _, exists := pq.idToCluster[id]; exists {
-		return fmt.Errorf("id %d already exists", id)
+		return fmt.Errorf("id %d: %w", id, core.ErrDuplicateID)
 	}
 
+	cluster, err := pq.insertEntry(id, vector)
+	if err != nil {
+		return err
+	}
+	pq.recalcCentroid(cluster)
+	pq.endWarmupIfFull()
+	return nil
+}
+
+// insertEntry assigns a validated vector to a coarse cluster and appends it to the inverted list.
+// It returns the assigned cluster; the caller is responsible for recalculating its centroid.
+// The caller must hold the write lock.
+func (pq *PQIVFIndex) insertEntry(id int, vector []float32) (int, error) {
+	if pq.VectorStats != nil {
+		pq.VectorStats.Update(vector)
+	}
 	var cluster int
-	// If there aren't enough centroids yet, create a new one.
-	if len(pq.coarseCentroids) < pq.coarseK {
+	if pq.warmingUp() {
+		// During the warm-up every vector is buffered in cluster 0 until endWarmup clusters them.
+		if len(pq.coarseCentroids) == 0 {
+			centroid := make([]float32, pq.dimension)
+			copy(centroid, vector)
+			pq.coarseCentroids = append(pq.coarseCentroids, centroid)
+		}
+		pq.clusterCounts[cluster]++
+	} else if len(pq.coarseCentroids) < pq.coarseK {
+		// If there aren't enough centroids yet, create a new one.
 		cluster = len(pq.coarseCentroids)
 		centroid := make([]float32, pq.dimension)
 		copy(centroid, vector)
@@ -141,28 +220,113 @@ func (pq *PQIVFIndex) Add(id int, vector []float32) error {
 	} else {
 		// Otherwise, assign to the nearest centroid.
 		cluster, _ = pq.nearestCentroid(vector)
+		pq.buildDistances.Add(int64(len(pq.coarseCentroids)))
 		pq.clusterCounts[cluster]++
 	}
 
-	pq.idToCluster[id] = cluster
 	entry := pqEntry{ID: id, Vector: vector, Cluster: cluster}
 	// If codebooks are available, encode the vector.
 	if pq.codebooks != nil {
-		codes, err := pq.encodeVector(vector, cluster)
+		codes, err := pq.encodeVector(vector, cluster, &pq.buildDistances)
 		if err != nil {
-			return err
+			pq.clusterCounts[cluster]--
+			return 0, err
 		}
 		entry.Codes = codes
 	}
+	pq.idToCluster[id] = cluster
 	pq.invertedLists[cluster] = append(pq.invertedLists[cluster], entry)
-	pq.recalcCentroid(cluster)
-	return nil
+	pq.changes++
+	return cluster, nil
+}
+
+// warmingUp reports whether vectors are still being buffered for the warm-up. An index that already has
+// several clusters, for example one loaded from a file saved without a warm-up, never warms up.
+// The caller must hold the lock.
+func (pq *PQIVFIndex) warmingUp() bool {
+	return pq.WarmupSize > 0 && !pq.warmedUp && len(pq.coarseCentroids) <= 1
+}
+
+// endWarmupIfFull ends the warm-up once WarmupSize vectors are buffered: it runs k-means over them to
+// initialize up to coarseK centroids and reassigns every vector to its nearest centroid, re-encoding it
+// if the codebooks are trained. The vectors are clustered in id order with a generator seeded by
+// core.GetSeed, so with a fixed HANN_SEED the result does not depend on the order they were added in.
+// If clustering or encoding fails, a warning is logged and the index is left as it was, with the vectors
+// still buffered, so the vectors stay added and the warm-up is tried again on the next insert.
+// The caller must hold the write lock.
+func (pq *PQIVFIndex) endWarmupIfFull() {
+	if !pq.warmingUp() || len(pq.idToCluster) < pq.WarmupSize {
+		return
+	}
+	entries := append([]pqEntry(nil), pq.invertedLists[0]...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	data := make([][]float32, len(entries))
+	for i, entry := range entries {
+		data[i] = entry.Vector
+	}
+	rnd := rand.New(rand.NewSource(core.GetSeed()))
+	seeds := func(data [][]float32, k int, evals *atomic.Int64) [][]float32 {
+		return kMeansPlusPlusSeeds(data, k, rnd, evals)
+	}
+	centroids, err := trainSubquantizerWith(data, pq.coarseK, pq.kMeansIters, seeds, &pq.buildDistances)
+	if err != nil {
+		log.Warn().Msgf("Ending the PQIVF warm-up failed, vectors stay buffered: %v", err)
+		return
+	}
+
+	// Assign and encode into new lists first, so that a failure leaves the buffered vectors untouched.
+	buffered := pq.coarseCentroids
+	pq.coarseCentroids = centroids
+	counts := make(map[int]int, len(centroids))
+	lists := make(map[int][]pqEntry, len(centroids))
+	for _, entry := range entries {
+		cluster, _ := pq.nearestCentroid(entry.Vector)
+		pq.buildDistances.Add(int64(len(pq.coarseCentroids)))
+		entry.Cluster = cluster
+		if pq.codebooks != nil {
+			if entry.Codes, err = pq.encodeVector(entry.Vector, cluster, &pq.buildDistances); err != nil {
+				pq.coarseCentroids = buffered
+				log.Warn().Msgf("Ending the PQIVF warm-up failed, vectors stay buffered: id %d: %v", entry.ID, err)
+				return
+			}
+		}
+		counts[cluster]++
+		lists[cluster] = append(lists[cluster], entry)
+	}
+	pq.clusterCounts = counts
+	pq.invertedLists = lists
+	for cluster, list := range lists {
+		for _, entry := range list {
+			pq.idToCluster[entry.ID] = cluster
+		}
+		pq.recalcCentroid(cluster)
+	}
+	pq.warmedUp = true
+}
+
+// Reserve pre-sizes the id-to-cluster map for n additional vectors to avoid rehashing during a large BulkAdd.
+// It is only a hint and can be called on a non-empty index.
+func (pq *PQIVFIndex) Reserve(n int) {
+	if n <= 0 {
+		return
+	}
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	idToCluster := make(map[int]int, len(pq.idToCluster)+n)
+	for id, cluster := range pq.idToCluster {
+		idToCluster[id] = cluster
+	}
+	pq.idToCluster = idToCluster
 }
 
 // BulkAdd inserts multiple vectors into the index.
 func (pq *PQIVFIndex) BulkAdd(vectors map[int][]float32) error {
+	if pq.frozen.Load() {
+		return core.ErrFrozen
+	}
 	pq.mu.Lock()
 	defer pq.mu.Unlock()
+	defer pq.queryCache.Load().Invalidate()
 
 	var keys []int
 	for id := range vectors {
@@ -175,43 +339,27 @@ func (pq *PQIVFIndex) BulkAdd(vectors map[int][]float32) error {
 		progressbar.OptionOnCompletion(func() { fmt.Print("\n") }),
 	)
 
-	updatedClusters := make(map[int]bool)
+	// Validate the whole batch first, so that an invalid vector doesn't leave the batch half inserted.
 	for _, id := range keys {
-		vector := vectors[id]
-		if len(vector) != pq.dimension {
-			return fmt.Errorf("vector dimension %d does not match index dimension %d for id %d", len(vector), pq.dimension, id)
+		if vector := vectors[id]; len(vector) != pq.dimension {
+			return fmt.Errorf("%w: vector dimension %d does not match index dimension %d for id %d",
+				core.ErrDimensionMismatch, len(vector), pq.dimension, id)
 		}
 		if _, exists := pq.idToCluster[id]; exists {
-			return fmt.Errorf("id %d already exists", id)
+			return fmt.Errorf("id %d: %w", id, core.ErrDuplicateID)
 		}
+	}
 
-		var cluster int
-		// Create new centroid if needed.
-		if len(pq.coarseCentroids) < pq.coarseK {
-			cluster = len(pq.coarseCentroids)
-			centroid := make([]float32, pq.dimension)
-			copy(centroid, vector)
-			pq.coarseCentroids = append(pq.coarseCentroids, centroid)
-			pq.clusterCounts[cluster] = 1
-		} else {
-			cluster, _ = pq.nearestCentroid(vector)
-			pq.clusterCounts[cluster]++
-		}
-		pq.idToCluster[id] = cluster
-		var codes []int
-		if pq.codebooks != nil {
-			var err error
-			codes, err = pq.encodeVector(vector, cluster)
-			if err != nil {
-				return err
-			}
+	updatedClusters := make(map[int]bool)
+	for _, id := range keys {
+		cluster, err := pq.insertEntry(id, vectors[id])
+		if err != nil {
+			return err
 		}
-		entry := pqEntry{ID: id, Vector: vector, Codes: codes, Cluster: cluster}
-		pq.invertedLists[cluster] = append(pq.invertedLists[cluster], entry)
 		updatedClusters[cluster] = true
 
 		// Update the progress bar.
-		err := bar.Add(1)
+		err = bar.Add(1)
 		if err != nil {
 			return err
 		}
@@ -220,17 +368,73 @@ func (pq *PQIVFIndex) BulkAdd(vectors map[int][]float32) error {
 	for cluster := range updatedClusters {
 		pq.recalcCentroid(cluster)
 	}
+	// A batch that fills the warm-up buffer is clustered as a whole once it is inserted.
+	pq.endWarmupIfFull()
 	return nil
 }
 
+// BulkAddLenient inserts multiple vectors into the index, skipping vectors that cannot be added.
+// Vectors with a mismatched dimension or an id that already exists are not inserted; the reason is
+// recorded in the returned failures map keyed by id. It returns the number of vectors added.
+func (pq *PQIVFIndex) BulkAddLenient(vectors map[int][]float32) (int, map[int]error) {
+	if pq.frozen.Load() {
+		failures := make(map[int]error, len(vectors))
+		for id := range vectors {
+			failures[id] = core.ErrFrozen
+		}
+		return 0, failures
+	}
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	defer pq.queryCache.Load().Invalidate()
+
+	var keys []int
+	for id := range vectors {
+		keys = append(keys, id)
+	}
+	sort.Ints(keys)
+
+	added := 0
+	failures := make(map[int]error)
+	updatedClusters := make(map[int]bool)
+	for _, id := range keys {
+		vector := vectors[id]
+		if len(vector) != pq.dimension {
+			failures[id] = fmt.Errorf("%w: vector dimension %d does not match index dimension %d for id %d",
+				core.ErrDimensionMismatch, len(vector), pq.dimension, id)
+			continue
+		}
+		if _, exists := pq.idToCluster[id]; exists {
+			failures[id] = fmt.Errorf("id %d: %w", id, core.ErrDuplicateID)
+			continue
+		}
+		cluster, err := pq.insertEntry(id, vector)
+		if err != nil {
+			failures[id] = err
+			continue
+		}
+		updatedClusters[cluster] = true
+		added++
+	}
+	for cluster := range updatedClusters {
+		pq.recalcCentroid(cluster)
+	}
+	pq.endWarmupIfFull()
+	return added, failures
+}
+
 // Delete removes an entry by its id.
 func (pq *PQIVFIndex) Delete(id int) error {
+	if pq.frozen.Load() {
+		return core.ErrFrozen
+	}
 	pq.mu.Lock()
 	defer pq.mu.Unlock()
+	defer pq.queryCache.Load().Invalidate()
 
 	cluster, exists := pq.idToCluster[id]
 	if !exists {
-		return fmt.Errorf("id %d not found", id)
+		return fmt.Errorf("id %d: %w", id, core.ErrNotFound)
 	}
 	entries, ok := pq.invertedLists[cluster]
 	if !ok {
@@ -251,6 +455,7 @@ func (pq *PQIVFIndex) Delete(id int) error {
 	}
 	pq.invertedLists[cluster] = newEntries
 	delete(pq.idToCluster, id)
+	pq.changes++
 	if len(newEntries) > 0 {
 		pq.recalcCentroid(cluster)
 	}
@@ -259,8 +464,12 @@ func (pq *PQIVFIndex) Delete(id int) error {
 
 // BulkDelete removes multiple entries from the index.
 func (pq *PQIVFIndex) BulkDelete(ids []int) error {
+	if pq.frozen.Load() {
+		return core.ErrFrozen
+	}
 	pq.mu.Lock()
 	defer pq.mu.Unlock()
+	defer pq.queryCache.Load().Invalidate()
 
 	sort.Ints(ids)
 	// Create a progress bar for deletions.
@@ -295,6 +504,7 @@ func (pq *PQIVFIndex) BulkDelete(ids []int) error {
 		}
 		pq.invertedLists[cluster] = newEntries
 		delete(pq.idToCluster, id)
+		pq.changes++
 		if len(newEntries) > 0 {
 			updatedClusters[cluster] = true
 		}
@@ -312,6 +522,9 @@ func (pq *PQIVFIndex) BulkDelete(ids []int) error {
 
 // Update removes and then re-adds an entry with an updated vector.
 func (pq *PQIVFIndex) Update(id int, vector []float32) error {
+	if pq.frozen.Load() {
+		return core.ErrFrozen
+	}
 	if err := pq.Delete(id); err != nil {
 		return err
 	}
@@ -320,6 +533,9 @@ func (pq *PQIVFIndex) Update(id int, vector []float32) error {
 
 // BulkUpdate updates multiple entries with new vectors.
 func (pq *PQIVFIndex) BulkUpdate(updates map[int][]float32) error {
+	if pq.frozen.Load() {
+		return core.ErrFrozen
+	}
 	var keys []int
 	for id := range updates {
 		keys = append(keys, id)
@@ -344,13 +560,119 @@ func (pq *PQIVFIndex) BulkUpdate(updates map[int][]float32) error {
 
 // Train runs k-means on residuals to train subquantizers (codebooks).
 func (pq *PQIVFIndex) Train() error {
+	if pq.frozen.Load() {
+		return core.ErrFrozen
+	}
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	defer pq.queryCache.Load().Invalidate()
+	return pq.train()
+}
+
+// ChangedFraction returns the number of vectors added or deleted since the last Train
+// relative to the number of vectors in the index at that time. An update counts as a delete and an add.
+func (pq *PQIVFIndex) ChangedFraction() float64 {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	return pq.changedFraction()
+}
+
+// changedFraction is ChangedFraction for callers that hold the lock.
+func (pq *PQIVFIndex) changedFraction() float64 {
+	trained := pq.trainedCount
+	if trained < 1 {
+		trained = 1
+	}
+	return float64(pq.changes) / float64(trained)
+}
+
+// needsRetrain reports whether the codebooks are trained and the changes since then exceed RetrainThreshold.
+// The caller must hold the lock.
+func (pq *PQIVFIndex) needsRetrain() bool {
+	return pq.codebooks != nil && pq.RetrainThreshold > 0 && pq.changedFraction() > pq.RetrainThreshold
+}
+
+// MaybeRetrain retrains the codebooks if the fraction of vectors changed since the last Train
+// exceeds RetrainThreshold. It reports whether the codebooks were retrained.
+// Nothing happens if RetrainThreshold is zero or the index has never been trained.
+func (pq *PQIVFIndex) MaybeRetrain() (bool, error) {
 	pq.mu.Lock()
 	defer pq.mu.Unlock()
+	defer pq.queryCache.Load().Invalidate()
+	if !pq.needsRetrain() {
+		return false, nil
+	}
+	if err := pq.train(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// TrainIncremental refines the trained codebooks with up to maxIters k-means iterations over the
+// residuals of all current entries, including those added since the last Train, and re-encodes the
+// entries. Starting from the existing codebooks converges in far fewer iterations than Train, which
+// retrains from scratch, so it is a cheap way to adapt the codebooks to newly arrived data.
+func (pq *PQIVFIndex) TrainIncremental(maxIters int) error {
+	if pq.frozen.Load() {
+		return core.ErrFrozen
+	}
+	if maxIters <= 0 {
+		return fmt.Errorf("maxIters must be positive, got %d", maxIters)
+	}
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	defer pq.queryCache.Load().Invalidate()
+	if pq.codebooks == nil {
+		return fmt.Errorf("codebooks not trained")
+	}
+	if len(pq.invertedLists) == 0 {
+		return fmt.Errorf("no data to train on")
+	}
+	dataPerSub, err := pq.subquantizerData()
+	if err != nil {
+		return err
+	}
+	codebooks := make([][][]float32, pq.numSubquantizers)
+	for i := 0; i < pq.numSubquantizers; i++ {
+		cb, err := trainSubquantizerWith(dataPerSub[i], pq.pqK, maxIters, warmStartInit(pq.codebooks[i]),
+			&pq.buildDistances)
+		if err != nil {
+			return err
+		}
+		codebooks[i] = cb
+	}
+	pq.codebooks = codebooks
+	pq.codewordNorms = nil
+	return pq.reencode()
+}
 
+// train trains the codebooks and re-encodes all entries. The caller must hold the write lock.
+func (pq *PQIVFIndex) train() error {
 	if len(pq.invertedLists) == 0 {
 		return fmt.Errorf("no data to train on")
 	}
+	dataPerSub, err := pq.subquantizerData()
+	if err != nil {
+		return err
+	}
 
+	// Train a codebook for each subquantizer.
+	codebooks := make([][][]float32, pq.numSubquantizers)
+	for i := 0; i < pq.numSubquantizers; i++ {
+		cb, err := trainSubquantizer(dataPerSub[i], pq.pqK, pq.kMeansIters, &pq.buildDistances)
+		if err != nil {
+			return err
+		}
+		codebooks[i] = cb
+	}
+	pq.codebooks = codebooks
+	pq.codewordNorms = nil
+	return pq.reencode()
+}
+
+// subquantizerData returns the residuals of all entries split into one training set per subquantizer.
+// The caller must hold the lock.
+func (pq *PQIVFIndex) subquantizerData() ([][][]float32, error) {
 	// Prepare data for each subquantizer.
 	dataPerSub := make([][][]float32, pq.numSubquantizers)
 	for i := 0; i < pq.numSubquantizers; i++ {
@@ -363,7 +685,7 @@ func (pq *PQIVFIndex) Train() error {
 		for _, entry := range entries {
 			residual, err := vectorSub(entry.Vector, centroid)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			subVecs := splitVector(residual, pq.numSubquantizers)
 			for i, sub := range subVecs {
@@ -371,22 +693,15 @@ func (pq *PQIVFIndex) Train() error {
 			}
 		}
 	}
+	return dataPerSub, nil
+}
 
-	// Train a codebook for each subquantizer.
-	codebooks := make([][][]float32, pq.numSubquantizers)
-	for i := 0; i < pq.numSubquantizers; i++ {
-		cb, err := trainSubquantizer(dataPerSub[i], pq.pqK, pq.kMeansIters)
-		if err != nil {
-			return err
-		}
-		codebooks[i] = cb
-	}
-	pq.codebooks = codebooks
-
-	// Re-encode all entries using the new codebooks.
+// reencode encodes all entries with the current codebooks and resets the change count.
+// The caller must hold the write lock.
+func (pq *PQIVFIndex) reencode() error {
 	for cluster, entries := range pq.invertedLists {
 		for j, entry := range entries {
-			codes, err := pq.encodeVector(entry.Vector, cluster)
+			codes, err := pq.encodeVector(entry.Vector, cluster, &pq.buildDistances)
 			if err != nil {
 				return err
 			}
@@ -394,12 +709,86 @@ func (pq *PQIVFIndex) Train() error {
 			pq.invertedLists[cluster][j] = entry
 		}
 	}
+	pq.changes = 0
+	pq.trainedCount = len(pq.idToCluster)
 
 	return nil
 }
 
+// QuantizationError returns the mean Euclidean distance between each stored vector and its PQ
+// reconstruction, the coarse centroid of its cluster plus the decoded residual. It measures how much
+// information the product quantizer loses, for example to choose pqK or numSubquantizers.
+func (pq *PQIVFIndex) QuantizationError() (float64, error) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	if pq.codebooks == nil {
+		return 0, fmt.Errorf("codebooks not trained")
+	}
+	if len(pq.idToCluster) == 0 {
+		return 0, core.ErrEmptyIndex
+	}
+	var total float64
+	var count int
+	for cluster, entries := range pq.invertedLists {
+		for _, entry := range entries {
+			codes := entry.Codes
+			if codes == nil {
+				var err error
+				if codes, err = pq.encodeVector(entry.Vector, cluster, nil); err != nil {
+					return 0, err
+				}
+			}
+			residual, err := pq.decodePQCode(codes)
+			if err != nil {
+				return 0, fmt.Errorf("id %d: %w", entry.ID, err)
+			}
+			approx, err := vectorAdd(pq.coarseCentroids[cluster], residual)
+			if err != nil {
+				return 0, fmt.Errorf("id %d: %w", entry.ID, err)
+			}
+			total += core.Euclidean(entry.Vector, approx)
+			count++
+		}
+	}
+	return total / float64(count), nil
+}
+
+// NearestClusters returns the n coarse clusters nearest to the query by the index's distance, nearest
+// first, as neighbors whose ID is the cluster id. Searches scan the inverted lists of these clusters, so
+// callers can use it to inspect the coarse partitioning or to pick their own NProbe. Fewer than n
+// clusters are returned if the index has fewer. It returns core.ErrEmptyIndex if no clusters exist yet.
+func (pq *PQIVFIndex) NearestClusters(query []float32, n int) ([]core.Neighbor, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: n must be positive, got %d", core.ErrInvalidK, n)
+	}
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	if len(query) != pq.dimension {
+		return nil, fmt.Errorf("%w: query dimension %d does not match index dimension %d",
+			core.ErrDimensionMismatch, len(query), pq.dimension)
+	}
+	if len(pq.coarseCentroids) == 0 {
+		return nil, core.ErrEmptyIndex
+	}
+	centroids := pq.nearestCentroids(query)
+	n = min(n, len(centroids))
+	clusters := make([]core.Neighbor, n)
+	for i, c := range centroids[:n] {
+		clusters[i] = core.Neighbor{ID: c.cluster, Distance: c.dist}
+	}
+	return clusters, nil
+}
+
+// BuildStats returns the cumulative number of distance computations made by Add, BulkAdd, Update,
+// Train, TrainIncremental, and retraining to assign vectors to clusters, encode them, and run k-means.
+// Searches and QuantizationError are not counted.
+func (pq *PQIVFIndex) BuildStats() core.BuildStats {
+	return core.BuildStats{DistanceEvaluations: pq.buildDistances.Load()}
+}
+
 // encodeVector computes the PQ codes for a vector given its coarse cluster.
-func (pq *PQIVFIndex) encodeVector(vector []float32, cluster int) ([]int, error) {
+// The distance computations are counted in evals unless it is nil.
+func (pq *PQIVFIndex) encodeVector(vector []float32, cluster int, evals *atomic.Int64) ([]int, error) {
 	if pq.codebooks == nil {
 		return nil, fmt.Errorf("codebooks not trained")
 	}
@@ -413,7 +802,7 @@ func (pq *PQIVFIndex) encodeVector(vector []float32, cluster int) ([]int, error)
 		best := -1
 		bestDist := math.MaxFloat64
 		for j, cent := range pq.codebooks[i] {
-			d := core.Euclidean(sub, cent)
+			d := euclidean(evals, sub, cent)
 			if d < bestDist {
 				bestDist = d
 				best = j
@@ -442,6 +831,91 @@ func (pq *PQIVFIndex) decodePQCode(codes []int) ([]float32, error) {
 	return approx, nil
 }
 
+// PrepareADC precomputes the query-independent part of the asymmetric distance computation used when
+// UseADC is set, the squared norm of every codeword. The first search with UseADC prepares it too, so
+// calling PrepareADC only moves that cost ahead of time. The norms are saved with the index and are only
+// recomputed after the codebooks change. It returns an error if the codebooks are not trained.
+func (pq *PQIVFIndex) PrepareADC() error {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	if pq.codebooks == nil {
+		return fmt.Errorf("codebooks not trained")
+	}
+	pq.prepareADC()
+	return nil
+}
+
+// prepareADC computes the codeword norms unless they are up to date. The caller must hold the write lock.
+func (pq *PQIVFIndex) prepareADC() {
+	if pq.codewordNorms != nil || pq.codebooks == nil {
+		return
+	}
+	norms := make([][]float64, len(pq.codebooks))
+	for i, codebook := range pq.codebooks {
+		norms[i] = make([]float64, len(codebook))
+		for j, codeword := range codebook {
+			for _, v := range codeword {
+				norms[i][j] += float64(v) * float64(v)
+			}
+		}
+	}
+	pq.codewordNorms = norms
+	pq.adcPrecomputations.Add(1)
+}
+
+// adcTable returns, per subquantizer, the squared Euclidean distance between the query's residual to the
+// centroid of cluster and every codeword, using ||q-c||^2 = ||q||^2 + ||c||^2 - 2<q,c> with the
+// precomputed codeword norms. The caller must hold the lock and the norms must be up to date.
+func (pq *PQIVFIndex) adcTable(query []float32, cluster int) [][]float64 {
+	residual, err := vectorSub(query, pq.coarseCentroids[cluster])
+	if err != nil {
+		return nil
+	}
+	table := make([][]float64, pq.numSubquantizers)
+	for i, sub := range splitVector(residual, pq.numSubquantizers) {
+		var subNorm float64
+		for _, v := range sub {
+			subNorm += float64(v) * float64(v)
+		}
+		table[i] = make([]float64, len(pq.codebooks[i]))
+		for j, codeword := range pq.codebooks[i] {
+			var dot float64
+			for d, v := range sub {
+				dot += float64(v) * float64(codeword[d])
+			}
+			table[i][j] = subNorm + pq.codewordNorms[i][j] - 2*dot
+		}
+	}
+	return table
+}
+
+// validNorms reports whether norms holds one norm per codeword of codebooks.
+func validNorms(norms [][]float64, codebooks [][][]float32) bool {
+	if norms == nil || len(norms) != len(codebooks) {
+		return false
+	}
+	for i := range norms {
+		if len(norms[i]) != len(codebooks[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// adcDistance returns the Euclidean distance between the query and the reconstruction of codes from the
+// query's table for their cluster, and false if a code is outside its codebook.
+func adcDistance(table [][]float64, codes []int) (float64, bool) {
+	var sum float64
+	for i, code := range codes {
+		if code >= len(table[i]) {
+			return 0, false
+		}
+		sum += table[i][code]
+	}
+	// Rounding can leave a tiny negative sum for a query equal to the reconstruction.
+	return math.Sqrt(math.Max(sum, 0)), true
+}
+
 // vectorSub computes the element-wise subtraction of two vectors.
 func vectorSub(a, b []float32) ([]float32, error) {
 	if len(a) != len(b) {
@@ -466,7 +940,8 @@ func vectorAdd(a, b []float32) ([]float32, error) {
 	return res, nil
 }
 
-// splitVector splits a vector into numParts equal parts.
+// splitVector splits a vector into numParts parts of equal length, except that the last part
+// also takes the remaining elements if the length isn't divisible by numParts.
 func splitVector(vec []float32, numParts int) [][]float32 {
 	total := len(vec)
 	subDim := total / numParts
@@ -474,28 +949,132 @@ func splitVector(vec []float32, numParts int) [][]float32 {
 	start := 0
 	for i := 0; i < numParts; i++ {
 		end := start + subDim
+		if i == numParts-1 {
+			end = total
+		}
 		parts[i] = vec[start:end]
 		start = end
 	}
 	return parts
 }
 
-// trainSubquantizer trains a codebook for a subquantizer using k-means.
-func trainSubquantizer(data [][]float32, k int, iterations int) ([][]float32, error) {
+// euclidean computes the Euclidean distance between a and b, counting the computation in evals
+// unless it is nil.
+func euclidean(evals *atomic.Int64, a, b []float32) float64 {
+	if evals != nil {
+		evals.Add(1)
+	}
+	return core.Euclidean(a, b)
+}
+
+// squaredEuclidean is euclidean for the squared Euclidean distance.
+func squaredEuclidean(evals *atomic.Int64, a, b []float32) float64 {
+	if evals != nil {
+		evals.Add(1)
+	}
+	return core.SquaredEuclidean(a, b)
+}
+
+// initFunc chooses k initial centroids from data for k-means, counting its distance computations in
+// evals unless it is nil.
+type initFunc func(data [][]float32, k int, evals *atomic.Int64) [][]float32
+
+// kMeansPlusPlusInit picks k initial centroids with k-means++ seeding: the first is a random data point
+// and each further one is a data point chosen with probability proportional to its squared distance
+// from the nearest centroid chosen so far, which spreads the seeds over the data.
+func kMeansPlusPlusInit(data [][]float32, k int, evals *atomic.Int64) [][]float32 {
+	seededRandMu.Lock()
+	defer seededRandMu.Unlock()
+	return kMeansPlusPlusSeeds(data, k, seededRand, evals)
+}
+
+// kMeansPlusPlusSeeds is kMeansPlusPlusInit drawing from rnd.
+func kMeansPlusPlusSeeds(data [][]float32, k int, rnd *rand.Rand, evals *atomic.Int64) [][]float32 {
+	centroids := make([][]float32, 0, k)
+	first := make([]float32, len(data[0]))
+	copy(first, data[rnd.Intn(len(data))])
+	centroids = append(centroids, first)
+
+	minDist := make([]float64, len(data))
+	for i, point := range data {
+		minDist[i] = squaredEuclidean(evals, point, first)
+	}
+	for len(centroids) < k {
+		var total float64
+		for _, d := range minDist {
+			total += d
+		}
+		next := len(data) - 1
+		if total == 0 {
+			// All points coincide with a centroid; any choice is as good as another.
+			next = rnd.Intn(len(data))
+		} else {
+			target := rnd.Float64() * total
+			for i, d := range minDist {
+				target -= d
+				if target < 0 {
+					next = i
+					break
+				}
+			}
+		}
+		centroid := make([]float32, len(data[0]))
+		copy(centroid, data[next])
+		centroids = append(centroids, centroid)
+		for i, point := range data {
+			if d := squaredEuclidean(evals, point, centroid); d < minDist[i] {
+				minDist[i] = d
+			}
+		}
+	}
+	return centroids
+}
+
+// warmStartInit returns an initializer that starts from a copy of an existing codebook. If the codebook
+// has fewer than k centroids, for example because it was trained on fewer than k points, the remaining
+// centroids are random data points.
+func warmStartInit(codebook [][]float32) initFunc {
+	return func(data [][]float32, k int, _ *atomic.Int64) [][]float32 {
+		centroids := make([][]float32, 0, k)
+		for _, c := range codebook {
+			if len(centroids) == k {
+				break
+			}
+			centroid := make([]float32, len(c))
+			copy(centroid, c)
+			centroids = append(centroids, centroid)
+		}
+		if len(centroids) < k {
+			seededRandMu.Lock()
+			perm := seededRand.Perm(len(data))
+			seededRandMu.Unlock()
+			for _, i := range perm[:k-len(centroids)] {
+				centroid := make([]float32, len(data[i]))
+				copy(centroid, data[i])
+				centroids = append(centroids, centroid)
+			}
+		}
+		return centroids
+	}
+}
+
+// trainSubquantizer trains a codebook for a subquantizer using k-means with k-means++ seeding.
+// The distance computations are counted in evals unless it is nil.
+func trainSubquantizer(data [][]float32, k int, iterations int, evals *atomic.Int64) ([][]float32, error) {
+	return trainSubquantizerWith(data, k, iterations, kMeansPlusPlusInit, evals)
+}
+
+// trainSubquantizerWith trains a codebook using k-means starting from the centroids chosen by init.
+// The distance computations are counted in evals unless it is nil.
+func trainSubquantizerWith(data [][]float32, k int, iterations int, init initFunc,
+	evals *atomic.Int64) ([][]float32, error) {
 	if len(data) == 0 {
 		return nil, fmt.Errorf("no data for subquantizer training")
 	}
 	if len(data) < k {
 		k = len(data)
 	}
-	centroids := make([][]float32, k)
-	seededRandMu.Lock()
-	perm := seededRand.Perm(len(data))
-	seededRandMu.Unlock()
-	for i := 0; i < k; i++ {
-		centroids[i] = make([]float32, len(data[0]))
-		copy(centroids[i], data[perm[i]])
-	}
+	centroids := init(data, k, evals)
 	for iter := 0; iter < iterations; iter++ {
 		clusters := make([][][]float32, k)
 		for i := range clusters {
@@ -505,7 +1084,7 @@ func trainSubquantizer(data [][]float32, k int, iterations int) ([][]float32, er
 			best := -1
 			bestDist := math.MaxFloat64
 			for i, cent := range centroids {
-				d := core.Euclidean(point, cent)
+				d := euclidean(evals, point, cent)
 				if d < bestDist {
 					bestDist = d
 					best = i
@@ -539,13 +1118,110 @@ func trainSubquantizer(data [][]float32, k int, iterations int) ([][]float32, er
 	return centroids, nil
 }
 
+// candidateEntries collects the entries of the clusters nearest to the query. It always takes the
+// nprobe nearest clusters, or numCandidateClusters if nprobe is 0, and continues with the next nearest ones until at least
+// ExpansionFactor*k entries are gathered or every cluster has been visited.
+// It returns the entries and the number of clusters visited. The caller must hold the lock.
+func (pq *PQIVFIndex) candidateEntries(query []float32, k, nprobe int) ([]pqEntry, int) {
+	if nprobe <= 0 {
+		nprobe = pq.numCandidateClusters
+	}
+	target := k
+	if pq.ExpansionFactor > 1 {
+		target = int(math.Ceil(pq.ExpansionFactor * float64(k)))
+	}
+	centCandidates := pq.nearestCentroids(query)
+	var entries []pqEntry
+	visited := 0
+	for _, c := range centCandidates {
+		if visited >= nprobe && len(entries) >= target {
+			break
+		}
+		entries = append(entries, pq.invertedLists[c.cluster]...)
+		visited++
+	}
+	return entries, visited
+}
+
+// exactSearch returns the k nearest neighbors of the query by the exact distances to the original vectors
+// of all entries. The caller must hold the lock.
+func (pq *PQIVFIndex) exactSearch(query []float32, k int) []core.Neighbor {
+	entries := make([]pqEntry, 0, len(pq.idToCluster))
+	for _, list := range pq.invertedLists {
+		entries = append(entries, list...)
+	}
+	vector := func(i int) (int, []float32) { return entries[i].ID, entries[i].Vector }
+	return core.ExactSearch(query, k, len(entries), vector, pq.Distance, 0)
+}
+
 // Search finds the k nearest neighbors for the given query vector.
+// With the query cache enabled, repeated searches are answered from the cache, see EnableQueryCache.
 func (pq *PQIVFIndex) Search(query []float32, k int) ([]core.Neighbor, error) {
+	if cache := pq.queryCache.Load(); cache != nil {
+		return cache.Search(query, k, func() ([]core.Neighbor, error) { return pq.SearchInto(query, k, nil) })
+	}
+	return pq.SearchInto(query, k, nil)
+}
+
+// SearchInto is like Search but writes the results into buf, reslicing it when its capacity holds k
+// results. The candidates are collected in pooled scratch space, so a buffer of capacity k is enough.
+// The returned slice aliases buf in that case, so buf must not be reused while the results are needed.
+func (pq *PQIVFIndex) SearchInto(query []float32, k int, buf []core.Neighbor) ([]core.Neighbor, error) {
+	return pq.search(query, k, 0, buf, true)
+}
+
+// SearchUnsorted returns the same k nearest neighbors as Search, but in unspecified order.
+// It skips sorting the candidates, which saves time for large k.
+func (pq *PQIVFIndex) SearchUnsorted(query []float32, k int) ([]core.Neighbor, error) {
+	return pq.search(query, k, 0, nil, false)
+}
+
+// SearchWith searches like Search with per-call options. NProbe sets the number of nearest clusters
+// scanned for this call; Filter, ExcludeIDs, and RankMetric are applied to the candidates as described
+// by core.SearchWithOptions, with RankMetric computed on the exact stored vectors. Ef does not apply to
+// PQIVF and is ignored.
+func (pq *PQIVFIndex) SearchWith(query []float32, k int, opts core.SearchOptions) ([]core.Neighbor, error) {
+	pq.mu.RLock()
+	count := len(pq.idToCluster)
+	pq.mu.RUnlock()
+	search := func(n int) ([]core.Neighbor, error) {
+		return pq.search(query, n, opts.NProbe, nil, true)
+	}
+	return core.SearchWithOptions(query, k, opts, count, search, pq.GetVector)
+}
+
+// neighborPool holds scratch slices that searches collect their candidates in before the k nearest are
+// written to the caller's buffer.
+var neighborPool = sync.Pool{New: func() interface{} { return new([]core.Neighbor) }}
+
+// search writes the k nearest neighbors into buf, sorted by distance if sorted is true.
+// nprobe is the number of nearest clusters to scan, or 0 for the index default.
+func (pq *PQIVFIndex) search(query []float32, k, nprobe int, buf []core.Neighbor, sorted bool) ([]core.Neighbor, error) {
+	// Retrain stale codebooks first if a retrain threshold is set.
+	pq.mu.RLock()
+	stale := pq.needsRetrain()
+	unprepared := pq.UseADC && pq.codebooks != nil && pq.codewordNorms == nil
+	pq.mu.RUnlock()
+	if stale {
+		if _, err := pq.MaybeRetrain(); err != nil {
+			return nil, err
+		}
+	}
+	if unprepared {
+		pq.mu.Lock()
+		pq.prepareADC()
+		pq.mu.Unlock()
+	}
+
 	pq.mu.RLock()
 	defer pq.mu.RUnlock()
 
+	if k <= 0 {
+		return nil, fmt.Errorf("%w: k must be positive, got %d", core.ErrInvalidK, k)
+	}
 	if len(query) != pq.dimension {
-		return nil, fmt.Errorf("query dimension %d does not match index dimension %d", len(query), pq.dimension)
+		return nil, fmt.Errorf("%w: query dimension %d does not match index dimension %d",
+			core.ErrDimensionMismatch, len(query), pq.dimension)
 	}
 	// Copy query to avoid modifying original vector.
 	queryCopy := make([]float32, len(query))
@@ -553,43 +1229,44 @@ func (pq *PQIVFIndex) Search(query []float32, k int) ([]core.Neighbor, error) {
 	query = queryCopy
 
 	if len(pq.invertedLists) == 0 {
-		return nil, fmt.Errorf("index is empty")
+		return nil, core.ErrEmptyIndex
 	}
-
-	// Get nearest coarse centroids as candidate clusters.
-	centCandidates := pq.nearestCentroids(query)
-	numCandidates := pq.numCandidateClusters
-	if numCandidates > len(centCandidates) {
-		numCandidates = len(centCandidates)
+	if len(pq.idToCluster) <= pq.ExactThreshold {
+		return append(buf[:0], pq.exactSearch(query, k)...), nil
 	}
-	var entries []pqEntry
-	// Collect entries from the top candidate clusters.
-	for i := 0; i < numCandidates; i++ {
-		cluster := centCandidates[i].cluster
-		entries = append(entries, pq.invertedLists[cluster]...)
-	}
-	// If not enough entries, add more from further clusters.
-	if len(entries) < k {
-		for i := numCandidates; i < len(centCandidates) && len(entries) < k; i++ {
-			cluster := centCandidates[i].cluster
-			entries = append(entries, pq.invertedLists[cluster]...)
-		}
+
+	entries, _ := pq.candidateEntries(query, k, nprobe)
+
+	scratch := neighborPool.Get().(*[]core.Neighbor)
+	defer neighborPool.Put(scratch)
+	results := (*scratch)[:0]
+	var clusters []int
+	if pq.ClusterPenalty > 0 {
+		clusters = make([]int, 0, len(entries))
 	}
-	// If still not enough, take all available entries.
-	if len(entries) < k {
-		var allEntries []pqEntry
-		for _, list := range pq.invertedLists {
-			allEntries = append(allEntries, list...)
-		}
-		entries = allEntries
+	// With ADC, distances to encoded entries are looked up in a table per visited cluster.
+	var tables map[int][][]float64
+	if pq.UseADC && pq.codewordNorms != nil {
+		tables = make(map[int][][]float64)
 	}
-
-	var results []core.Neighbor
 	// Compute distances for each candidate entry.
 	for _, entry := range entries {
 		var d float64
-		// If PQ codebooks exist, use PQ reconstruction for approximate distance.
-		if pq.codebooks != nil && len(entry.Codes) == pq.numSubquantizers {
+		var looked bool
+		if tables != nil && len(entry.Codes) == pq.numSubquantizers {
+			table, ok := tables[entry.Cluster]
+			if !ok {
+				table = pq.adcTable(query, entry.Cluster)
+				tables[entry.Cluster] = table
+			}
+			if table != nil {
+				d, looked = adcDistance(table, entry.Codes)
+			}
+		}
+		switch {
+		case looked:
+		case pq.codebooks != nil && len(entry.Codes) == pq.numSubquantizers:
+			// If PQ codebooks exist, use PQ reconstruction for approximate distance.
 			approxResidual, err := pq.decodePQCode(entry.Codes)
 			if err != nil {
 				d = pq.Distance(query, entry.Vector)
@@ -601,32 +1278,283 @@ func (pq *PQIVFIndex) Search(query []float32, k int) ([]core.Neighbor, error) {
 					d = pq.Distance(query, approxVec)
 				}
 			}
-		} else {
+		default:
 			d = pq.Distance(query, entry.Vector)
 		}
 		results = append(results, core.Neighbor{ID: entry.ID, Distance: d})
+		if clusters != nil {
+			clusters = append(clusters, entry.Cluster)
+		}
+	}
+	*scratch = results[:0]
+	if clusters != nil {
+		return append(buf[:0], diversifyClusters(results, clusters, k, pq.ClusterPenalty)...), nil
+	}
+	if !sorted {
+		return append(buf[:0], core.SelectK(results, k)...), nil
 	}
 	sort.Slice(results, func(i, j int) bool {
+		if results[i].Distance == results[j].Distance {
+			return results[i].ID < results[j].ID
+		}
 		return results[i].Distance < results[j].Distance
 	})
+	// Fewer than k results means the index holds fewer than k vectors, all of which were scanned.
 	if k > len(results) {
 		k = len(results)
 	}
-	return results[:k], nil
+	return append(buf[:0], results[:k]...), nil
+}
+
+// diversifyClusters selects k of the results, where clusters[i] is the cluster of results[i], preferring
+// clusters that are not yet represented. Each pick minimizes the distance scaled by 1 + penalty times
+// the number of results already picked from the candidate's cluster, so with penalty 1 the second result
+// from a cluster has to be less than half as far as the best result from a new cluster. The selected
+// results keep their true distances and are returned sorted by distance, with ties ordered by id.
+func diversifyClusters(results []core.Neighbor, clusters []int, k int, penalty float64) []core.Neighbor {
+	byCluster := make(map[int][]core.Neighbor)
+	for i, n := range results {
+		byCluster[clusters[i]] = append(byCluster[clusters[i]], n)
+	}
+	order := make([]int, 0, len(byCluster))
+	for cluster, list := range byCluster {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Distance == list[j].Distance {
+				return list[i].ID < list[j].ID
+			}
+			return list[i].Distance < list[j].Distance
+		})
+		order = append(order, cluster)
+	}
+	sort.Ints(order)
+	picked := make(map[int]int, len(byCluster))
+	if k > len(results) {
+		k = len(results)
+	}
+	selected := make([]core.Neighbor, 0, k)
+	for len(selected) < k {
+		best := -1
+		bestScore := math.Inf(1)
+		for _, cluster := range order {
+			list := byCluster[cluster]
+			if picked[cluster] == len(list) {
+				continue
+			}
+			score := list[picked[cluster]].Distance * (1 + penalty*float64(picked[cluster]))
+			if score < bestScore {
+				best, bestScore = cluster, score
+			}
+		}
+		selected = append(selected, byCluster[best][picked[best]])
+		picked[best]++
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].Distance == selected[j].Distance {
+			return selected[i].ID < selected[j].ID
+		}
+		return selected[i].Distance < selected[j].Distance
+	})
+	return selected
+}
+
+// SearchByID finds the k nearest neighbors of the vector stored for id, excluding id itself.
+func (pq *PQIVFIndex) SearchByID(id int, k int) ([]core.Neighbor, error) {
+	return core.SearchByID(pq, id, k)
+}
+
+// KthDistance returns the distance to the k-th nearest neighbor of the query, as found by Search.
+func (pq *PQIVFIndex) KthDistance(query []float32, k int) (float64, error) {
+	results, err := pq.SearchUnsorted(query, k)
+	if err != nil {
+		return 0, err
+	}
+	return core.KthNeighborDistance(results, k)
+}
+
+// SearchPage returns the neighbors of the query ranked [offset, offset+limit) in distance order.
+// See core.SearchPage.
+func (pq *PQIVFIndex) SearchPage(query []float32, offset, limit int) ([]core.Neighbor, error) {
+	return core.SearchPage(pq, query, offset, limit)
+}
+
+// SearchFarthest returns the k vectors farthest from the query, farthest first. It scans every
+// vector in the index. See core.SearchFarthest.
+func (pq *PQIVFIndex) SearchFarthest(query []float32, k int) ([]core.Neighbor, error) {
+	return core.SearchFarthest(pq, query, k)
+}
+
+// MeasureQuery returns the query unchanged, since vectors are stored as given, and the distance of the
+// index. It implements core.QueryMeasurer.
+func (pq *PQIVFIndex) MeasureQuery(query []float32) ([]float32, core.DistanceFunc) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	return query, pq.Distance
+}
+
+// AllKNNStream computes the k nearest neighbors of every stored vector, excluding the vector itself,
+// and passes them to emit in ascending id order without buffering them. See core.AllKNNStream.
+func (pq *PQIVFIndex) AllKNNStream(k int, emit func(id int, neighbors []core.Neighbor) error) error {
+	return core.AllKNNStream(pq, pq.ids(), k, emit)
+}
+
+// AllKNN returns the k nearest neighbors of every stored vector keyed by id. See core.AllKNN.
+func (pq *PQIVFIndex) AllKNN(k int) (map[int][]core.Neighbor, error) {
+	return core.AllKNN(pq, pq.ids(), k)
+}
+
+// ids returns the ids of all stored vectors in ascending order.
+func (pq *PQIVFIndex) ids() []int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	ids := make([]int, 0, len(pq.idToCluster))
+	for id := range pq.idToCluster {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// SearchMultiQuery returns the k nearest neighbors of the weighted centroid of several query vectors.
+// See core.SearchMultiQuery.
+func (pq *PQIVFIndex) SearchMultiQuery(queries [][]float32, weights []float32, k int) ([]core.Neighbor, error) {
+	return core.SearchMultiQuery(pq, queries, weights, k)
+}
+
+// SearchBucketed returns the k nearest neighbors of the query grouped into distance buckets of width
+// bucketWidth. See core.SearchBucketed.
+func (pq *PQIVFIndex) SearchBucketed(query []float32, k int, bucketWidth float64) (map[int][]core.Neighbor, error) {
+	return core.SearchBucketed(pq, query, k, bucketWidth)
+}
+
+// SearchDiverse returns k neighbors of the query that balance closeness to the query against
+// dissimilarity to each other, controlled by lambda. See core.SearchDiverse.
+func (pq *PQIVFIndex) SearchDiverse(query []float32, k int, lambda float64) ([]core.Neighbor, error) {
+	return core.SearchDiverse(pq, query, k, lambda)
+}
+
+// Export returns copies of all vectors stored in the index keyed by id.
+// The original vectors are kept alongside their PQ codes, so the exported vectors are exact.
+func (pq *PQIVFIndex) Export() (map[int][]float32, error) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	out := make(map[int][]float32, len(pq.idToCluster))
+	for _, entries := range pq.invertedLists {
+		for _, entry := range entries {
+			vec := make([]float32, len(entry.Vector))
+			copy(vec, entry.Vector)
+			out[entry.ID] = vec
+		}
+	}
+	return out, nil
+}
+
+// ForEach calls fn for every original vector under the read lock and stops at the first error fn returns.
+// fn must not modify the index or the vector passed to it.
+func (pq *PQIVFIndex) ForEach(fn func(id int, vec []float32) error) error {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	for _, entries := range pq.invertedLists {
+		for _, entry := range entries {
+			if err := fn(entry.ID, entry.Vector); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// GetVector returns a copy of the original vector stored for the given id.
+func (pq *PQIVFIndex) GetVector(id int) ([]float32, error) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	cluster, exists := pq.idToCluster[id]
+	if !exists {
+		return nil, fmt.Errorf("id %d: %w", id, core.ErrNotFound)
+	}
+	for _, entry := range pq.invertedLists[cluster] {
+		if entry.ID == id {
+			vec := make([]float32, len(entry.Vector))
+			copy(vec, entry.Vector)
+			return vec, nil
+		}
+	}
+	return nil, fmt.Errorf("inconsistent state: id %d not found in cluster %d", id, cluster)
+}
+
+// Validate checks that the id-to-cluster mapping, the inverted lists, and the cluster counts are consistent
+// and returns a descriptive error for the first broken invariant.
+func (pq *PQIVFIndex) Validate() error {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	clusters := make([]int, 0, len(pq.invertedLists))
+	for cluster := range pq.invertedLists {
+		clusters = append(clusters, cluster)
+	}
+	sort.Ints(clusters)
+	seen := make(map[int]bool, len(pq.idToCluster))
+	for _, cluster := range clusters {
+		entries := pq.invertedLists[cluster]
+		if cluster < 0 || (len(entries) > 0 && cluster >= len(pq.coarseCentroids)) {
+			return fmt.Errorf("inverted list for cluster %d has no centroid", cluster)
+		}
+		if pq.clusterCounts[cluster] != len(entries) {
+			return fmt.Errorf("cluster %d has count %d but %d entries", cluster, pq.clusterCounts[cluster], len(entries))
+		}
+		for _, entry := range entries {
+			if seen[entry.ID] {
+				return fmt.Errorf("id %d appears more than once in the inverted lists", entry.ID)
+			}
+			seen[entry.ID] = true
+			if entry.Cluster != cluster {
+				return fmt.Errorf("entry %d in cluster %d records cluster %d", entry.ID, cluster, entry.Cluster)
+			}
+			mapped, exists := pq.idToCluster[entry.ID]
+			if !exists {
+				return fmt.Errorf("id %d in cluster %d is missing from the id-to-cluster map", entry.ID, cluster)
+			}
+			if mapped != cluster {
+				return fmt.Errorf("id %d is in cluster %d but mapped to cluster %d", entry.ID, cluster, mapped)
+			}
+			if len(entry.Vector) != pq.dimension {
+				return fmt.Errorf("id %d has dimension %d, expected %d", entry.ID, len(entry.Vector), pq.dimension)
+			}
+		}
+	}
+	if len(seen) != len(pq.idToCluster) {
+		for id, cluster := range pq.idToCluster {
+			if !seen[id] {
+				return fmt.Errorf("id %d is mapped to cluster %d but not in its inverted list", id, cluster)
+			}
+		}
+	}
+	return nil
+}
+
+// NeedsRebuild reports whether the next search will retrain the codebooks because more than
+// RetrainThreshold of the vectors changed since the last Train. The inverted lists themselves are
+// updated eagerly on every mutation.
+func (pq *PQIVFIndex) NeedsRebuild() bool {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+	return pq.needsRetrain()
 }
 
 // Stats returns statistics about the index (e.g. total number of entries).
 func (pq *PQIVFIndex) Stats() core.IndexStats {
 	pq.mu.RLock()
 	defer pq.mu.RUnlock()
-	count := 0
-	for _, entries := range pq.invertedLists {
-		count += len(entries)
+	// Every entry holds its vector, and training encodes all entries, so the size follows from the count
+	// without visiting the entries.
+	count := len(pq.idToCluster)
+	entrySize := 4 * pq.dimension
+	if pq.codebooks != nil {
+		entrySize += 8 * pq.numSubquantizers
 	}
 	return core.IndexStats{
 		Count:     count,
 		Dimension: pq.dimension,
 		Distance:  "euclidean",
+		Size:      count * entrySize,
 	}
 }
 
@@ -641,6 +1569,8 @@ type serializedPQIVF struct {
 	Codebooks        [][][]float32
 	PqK              int
 	KMeansIters      int
+	WarmedUp         bool
+	CodewordNorms    [][]float64
 }
 
 // GobEncode serializes the index into bytes using gob.
@@ -657,6 +1587,8 @@ func (pq *PQIVFIndex) GobEncode() ([]byte, error) {
 		Codebooks:        pq.codebooks,
 		PqK:              pq.pqK,
 		KMeansIters:      pq.kMeansIters,
+		WarmedUp:         pq.warmedUp,
+		CodewordNorms:    pq.codewordNorms,
 	}
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -683,6 +1615,18 @@ func (pq *PQIVFIndex) GobDecode(data []byte) error {
 	pq.codebooks = ser.Codebooks
 	pq.pqK = ser.PqK
 	pq.kMeansIters = ser.KMeansIters
+	pq.warmedUp = ser.WarmedUp
+	pq.codewordNorms = nil
+	if validNorms(ser.CodewordNorms, pq.codebooks) {
+		pq.codewordNorms = ser.CodewordNorms
+	}
+	// Gob omits empty maps, so an empty index decodes with nil maps.
+	if pq.clusterCounts == nil {
+		pq.clusterCounts = make(map[int]int)
+	}
+	if pq.invertedLists == nil {
+		pq.invertedLists = make(map[int][]pqEntry)
+	}
 	pq.idToCluster = make(map[int]int)
 	// Rebuild idToCluster mapping from the inverted lists.
 	for cluster, entries := range pq.invertedLists {
@@ -690,6 +1634,9 @@ func (pq *PQIVFIndex) GobDecode(data []byte) error {
 			pq.idToCluster[entry.ID] = cluster
 		}
 	}
+	// Change tracking starts over from the loaded state.
+	pq.changes = 0
+	pq.trainedCount = len(pq.idToCluster)
 	pq.Distance = core.Euclidean
 	return nil
 }
@@ -698,23 +1645,118 @@ func (pq *PQIVFIndex) GobDecode(data []byte) error {
 func (pq *PQIVFIndex) Save(w io.Writer) error {
 	pq.mu.RLock()
 	defer pq.mu.RUnlock()
+	if err := core.WriteHeader(w, core.FormatPQIVF); err != nil {
+		return err
+	}
 	enc := gob.NewEncoder(w)
 	return enc.Encode(pq)
 }
 
 // Load reads the index from the given reader using gob decoding.
 func (pq *PQIVFIndex) Load(r io.Reader) error {
+	if pq.frozen.Load() {
+		return core.ErrFrozen
+	}
 	pq.mu.Lock()
 	defer pq.mu.Unlock()
+	defer pq.queryCache.Load().Invalidate()
+	r, err := core.ReadHeader(r, core.FormatPQIVF)
+	if err != nil {
+		return err
+	}
 	dec := gob.NewDecoder(r)
 	return dec.Decode(pq)
 }
 
+// SaveCompressed saves the index to w as a gzip-compressed gob stream. See core.SaveCompressed.
+func (pq *PQIVFIndex) SaveCompressed(w io.Writer) error {
+	return core.SaveCompressed(pq, w)
+}
+
+// LoadCompressed loads the index from a stream written by SaveCompressed. See core.LoadCompressed.
+func (pq *PQIVFIndex) LoadCompressed(r io.Reader) error {
+	return core.LoadCompressed(pq, r)
+}
+
+// Freeze makes the index read-only: further Adds, Deletes, and Updates return core.ErrFrozen, and so do
+// Load, LoadCompressed, Train, and TrainIncremental. MaybeRetrain still works, since it only refreshes
+// stale codebooks for the stored vectors, which the next search would otherwise do.
+func (pq *PQIVFIndex) Freeze() {
+	pq.frozen.Store(true)
+}
+
+// Frozen reports whether Freeze has been called.
+func (pq *PQIVFIndex) Frozen() bool {
+	return pq.frozen.Load()
+}
+
+// EnableQueryCache caches the results of up to size distinct searches by Search, keyed by the query and k,
+// and evicts the least recently used ones beyond that. Every modification of the index clears the cache,
+// but changing search options such as fields of the index does not, so call EnableQueryCache again after
+// changing them. Calling it again replaces the cache with an empty one, and a size of 0 or less disables it.
+func (pq *PQIVFIndex) EnableQueryCache(size int) {
+	if size <= 0 {
+		pq.queryCache.Store(nil)
+		return
+	}
+	pq.queryCache.Store(core.NewQueryCache(size))
+}
+
+// QueryCache returns the query cache enabled by EnableQueryCache, for example to read its hit count, or nil.
+func (pq *PQIVFIndex) QueryCache() *core.QueryCache {
+	return pq.queryCache.Load()
+}
+
+// Prefetch loads vectors into memory ahead of a burst of searches. The index is kept entirely in memory,
+// so there is nothing to load and Prefetch always returns nil.
+func (pq *PQIVFIndex) Prefetch(ids []int) error {
+	return nil
+}
+
+// Close releases resources held by the index. The index is kept entirely in memory, so there is
+// nothing to release and Close always returns nil.
+func (pq *PQIVFIndex) Close() error {
+	return nil
+}
+
 // Check interface compliance.
 var _ core.Index = (*PQIVFIndex)(nil)
+var _ core.QueryMeasurer = (*PQIVFIndex)(nil)
+
+// newFromConfig creates a PQIVF index from a configuration map.
+// Required parameters: "dimension", "coarse_k", "num_subquantizers", "pq_k", and "kmeans_iters".
+func newFromConfig(cfg map[string]any) (core.Index, error) {
+	dimension, err := core.IntParam(cfg, "dimension")
+	if err != nil {
+		return nil, err
+	}
+	coarseK, err := core.IntParam(cfg, "coarse_k")
+	if err != nil {
+		return nil, err
+	}
+	numSubquantizers, err := core.IntParam(cfg, "num_subquantizers")
+	if err != nil {
+		return nil, err
+	}
+	pqK, err := core.IntParam(cfg, "pq_k")
+	if err != nil {
+		return nil, err
+	}
+	kMeansIters, err := core.IntParam(cfg, "kmeans_iters")
+	if err != nil {
+		return nil, err
+	}
+	if numSubquantizers > dimension {
+		return nil, fmt.Errorf("num_subquantizers (%d) must not exceed dimension (%d)",
+			numSubquantizers, dimension)
+	}
+	return NewPQIVFIndex(dimension, coarseK, numSubquantizers, pqK, kMeansIters), nil
+}
 
-// init registers types for gob encoding.
+// init registers types for gob encoding and the constructor for core.NewIndex.
 func init() {
+	core.RegisterIndex("pqivf", newFromConfig)
+	core.RegisterFormat(core.FormatPQIVF, "pqivf", func() core.Index { return NewPQIVFIndex(0, 0, minSubquantizers, 0, 0) })
 	gob.Register(&PQIVFIndex{})
 	gob.Register(pqEntry{})
 }
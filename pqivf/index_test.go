@@ -2,9 +2,15 @@ package pqivf_test
 
 import (
 	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
 	"sync"
 	"testing"
 
+	"github.com/patrikhermansson/hann/core"
 	"github.com/patrikhermansson/hann/pqivf"
 )
 
@@ -209,3 +215,1248 @@ func TestPQIVF_ConcurrentOperations(t *testing.T) {
 		t.Errorf("expected %d vectors, got %d", numVectors, stats.Count)
 	}
 }
+
+func TestPQIVF_BulkAddLenient(t *testing.T) {
+	idx := pqivf.NewPQIVFIndex(6, 3, 2, 256, 10)
+	if err := idx.Add(1, []float32{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	vectors := map[int][]float32{
+		1: {6, 5, 4, 3, 2, 1}, // duplicate id
+		2: {1, 1, 1, 1, 1, 1},
+		3: {1, 2, 3}, // wrong dimension
+		4: {2, 2, 2, 2, 2, 2},
+	}
+	added, failures := idx.BulkAddLenient(vectors)
+	if added != 2 {
+		t.Errorf("expected 2 vectors added, got %d", added)
+	}
+	if len(failures) != 2 || failures[1] == nil || failures[3] == nil {
+		t.Errorf("expected failures for ids 1 and 3, got %v", failures)
+	}
+	if stats := idx.Stats(); stats.Count != 3 {
+		t.Errorf("expected count 3 after BulkAddLenient, got %d", stats.Count)
+	}
+}
+
+func TestPQIVF_SaveLoadEmpty(t *testing.T) {
+	idx := pqivf.NewPQIVFIndex(6, 3, 2, 256, 10)
+
+	var buf bytes.Buffer
+	if err := idx.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded := pqivf.NewPQIVFIndex(6, 3, 2, 256, 10)
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if stats := loaded.Stats(); stats.Count != 0 {
+		t.Errorf("expected count 0 after loading an empty index, got %d", stats.Count)
+	}
+
+	if err := loaded.Add(1, []float32{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	neighbors, err := loaded.Search([]float32{1, 2, 3, 4, 5, 6}, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(neighbors) != 1 || neighbors[0].ID != 1 {
+		t.Errorf("expected id 1 as nearest neighbor, got %v", neighbors)
+	}
+}
+
+func TestPQIVF_NewIndexFactory(t *testing.T) {
+	index, err := core.NewIndex("pqivf", map[string]any{
+		"dimension": 6, "coarse_k": 3, "num_subquantizers": 2, "pq_k": 256, "kmeans_iters": 10,
+	})
+	if err != nil {
+		t.Fatalf("NewIndex failed: %v", err)
+	}
+	if err := index.Add(1, []float32{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	neighbors, err := index.Search([]float32{1, 2, 3, 4, 5, 6}, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(neighbors) != 1 || neighbors[0].ID != 1 {
+		t.Errorf("expected id 1 as nearest neighbor, got %v", neighbors)
+	}
+
+	if _, err := core.NewIndex("pqivf", map[string]any{
+		"dimension": 6, "coarse_k": 3, "num_subquantizers": 8, "pq_k": 256, "kmeans_iters": 10,
+	}); err == nil {
+		t.Error("expected error for more subquantizers than dimensions, got none")
+	}
+}
+
+func TestPQIVF_SearchInto(t *testing.T) {
+	dim := 6
+	idx := pqivf.NewPQIVFIndex(dim, 3, 2, 256, 10)
+	for i := 0; i < 200; i++ {
+		vec := []float32{float32(i), float32(i % 5), float32(i % 7), 1, 2, float32(i % 3)}
+		if err := idx.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	query := []float32{10, 2, 3, 1, 2, 1}
+	want, err := idx.Search(query, 5)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	// A buffer for k results suffices even though far more candidates are scanned.
+	buf := make([]core.Neighbor, 0, 5)
+	got, err := idx.SearchInto(query, 5, buf)
+	if err != nil {
+		t.Fatalf("SearchInto failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if &got[0] != &buf[:1][0] {
+		t.Error("expected results to reuse the provided buffer")
+	}
+}
+
+func TestPQIVF_MaybeRetrain(t *testing.T) {
+	dim := 4
+	idx := pqivf.NewPQIVFIndex(dim, 2, 2, 8, 10)
+	idx.RetrainThreshold = 0.5
+	rng := rand.New(rand.NewSource(1))
+	randomVector := func(center float32) []float32 {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = center + rng.Float32()
+		}
+		return vec
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := idx.Add(i, randomVector(0)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if retrained, err := idx.MaybeRetrain(); err != nil || retrained {
+		t.Fatalf("expected no retrain before the first Train, got %v, %v", retrained, err)
+	}
+	if err := idx.Train(); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	if f := idx.ChangedFraction(); f != 0 {
+		t.Errorf("expected changed fraction 0 after Train, got %f", f)
+	}
+
+	// Shift the distribution: add vectors far away from the training data.
+	for i := 100; i < 140; i++ {
+		if err := idx.Add(i, randomVector(10)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if idx.NeedsRebuild() {
+		t.Errorf("expected no retrain needed at changed fraction %f", idx.ChangedFraction())
+	}
+	for i := 140; i < 160; i++ {
+		if err := idx.Add(i, randomVector(10)); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if f := idx.ChangedFraction(); f <= idx.RetrainThreshold {
+		t.Fatalf("expected changed fraction above %f, got %f", idx.RetrainThreshold, f)
+	}
+	if !idx.NeedsRebuild() {
+		t.Error("expected a retrain to be needed")
+	}
+
+	// Export bypasses the codes, so compare approximate search distances before and after retraining.
+	query := randomVector(10)
+	idx.RetrainThreshold = 0 // keep Search from retraining lazily
+	before, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	idx.RetrainThreshold = 0.5
+	retrained, err := idx.MaybeRetrain()
+	if err != nil || !retrained {
+		t.Fatalf("expected a retrain, got %v, %v", retrained, err)
+	}
+	if f := idx.ChangedFraction(); f != 0 {
+		t.Errorf("expected changed fraction 0 after retrain, got %f", f)
+	}
+	after, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	changed := false
+	for i := range before {
+		if before[i] != after[i] {
+			changed = true
+		}
+	}
+	if !changed {
+		t.Error("expected retraining to change the codebooks")
+	}
+	if retrained, _ := idx.MaybeRetrain(); retrained {
+		t.Error("expected no second retrain without further changes")
+	}
+}
+
+func TestPQIVF_ExpansionFactor(t *testing.T) {
+	dim, n, k, coarseK := 8, 2000, 10, 32
+	idx := pqivf.NewPQIVFIndex(dim, coarseK, 2, 16, 10)
+	rng := rand.New(rand.NewSource(2))
+	vectors := make(map[int][]float32, n)
+	for i := 0; i < n; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	queries := make([][]float32, 30)
+	for q := range queries {
+		queries[q] = make([]float32, dim)
+		for j := range queries[q] {
+			queries[q][j] = rng.Float32()
+		}
+	}
+	// recall returns the average fraction of the exact k nearest neighbors found.
+	recall := func() float64 {
+		var found float64
+		for _, query := range queries {
+			ids := make([]int, 0, n)
+			for id := range vectors {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(a, b int) bool {
+				return core.Euclidean(query, vectors[ids[a]]) < core.Euclidean(query, vectors[ids[b]])
+			})
+			want := make(map[int]bool, k)
+			for _, id := range ids[:k] {
+				want[id] = true
+			}
+			results, err := idx.Search(query, k)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			for _, r := range results {
+				if want[r.ID] {
+					found++
+				}
+			}
+		}
+		return found / float64(len(queries)*k)
+	}
+
+	var previous float64
+	for _, factor := range []float64{1, 25, 50} {
+		idx.ExpansionFactor = factor
+		r := recall()
+		t.Logf("expansion factor %.0f: recall %.3f", factor, r)
+		if r < previous {
+			t.Errorf("recall dropped from %.3f to %.3f at expansion factor %.0f", previous, r, factor)
+		}
+		previous = r
+		if visited := idx.CandidateClusters(queries[0], k); visited >= coarseK {
+			t.Errorf("expansion factor %.0f visited all %d clusters", factor, visited)
+		}
+	}
+	if previous < 0.9 {
+		t.Errorf("expected high recall with a large expansion factor, got %.3f", previous)
+	}
+
+	// Asking for more candidates than the index holds visits every cluster.
+	idx.ExpansionFactor = 1
+	if visited := idx.CandidateClusters(queries[0], n+1); visited != coarseK {
+		t.Errorf("expected all %d clusters to be visited, got %d", coarseK, visited)
+	}
+}
+
+func TestPQIVF_SearchUnsorted(t *testing.T) {
+	dim := 4
+	idx := pqivf.NewPQIVFIndex(dim, 4, 2, 16, 10)
+	rng := rand.New(rand.NewSource(9))
+	for i := 0; i < 300; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		if err := idx.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	query := []float32{0.5, 0.5, 0.5, 0.5}
+	want, err := idx.Search(query, 20)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	got, err := idx.SearchUnsorted(query, 20)
+	if err != nil {
+		t.Fatalf("SearchUnsorted failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(got))
+	}
+	wantSet := make(map[int]bool, len(want))
+	for _, n := range want {
+		wantSet[n.ID] = true
+	}
+	for _, n := range got {
+		if !wantSet[n.ID] {
+			t.Errorf("unexpected id %d in unsorted results", n.ID)
+		}
+	}
+}
+
+func TestPQIVF_UnevenSubquantizers(t *testing.T) {
+	dim := 25
+	idx := pqivf.NewPQIVFIndex(dim, 2, 4, 256, 10)
+	rng := rand.New(rand.NewSource(4))
+	vectors := make(map[int][]float32, 20)
+	for i := 0; i < 20; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if err := idx.Train(); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+
+	// With more codewords than vectors, every sub-vector is a codeword, so decoding the PQ codes
+	// reconstructs each vector, including the last sub-vector that covers the remaining dimension.
+	for id, vec := range vectors {
+		decoded, err := idx.EncodeDecode(vec)
+		if err != nil {
+			t.Fatalf("encode/decode of id %d failed: %v", id, err)
+		}
+		if len(decoded) != dim {
+			t.Fatalf("expected decoded dimension %d, got %d", dim, len(decoded))
+		}
+		for j := range vec {
+			if diff := decoded[j] - vec[j]; diff > 1e-5 || diff < -1e-5 {
+				t.Errorf("id %d: element %d decoded as %f, want %f", id, j, decoded[j], vec[j])
+			}
+		}
+	}
+}
+
+func TestPQIVF_NearestCentroidMatchesLinearScan(t *testing.T) {
+	dim, coarseK := 32, 512
+	idx := pqivf.NewPQIVFIndex(dim, coarseK, 4, 16, 5)
+	rng := rand.New(rand.NewSource(6))
+	randomVector := func() []float32 {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		return vec
+	}
+	vectors := make(map[int][]float32, 4*coarseK)
+	for i := 0; i < 4*coarseK; i++ {
+		vectors[i] = randomVector()
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	for q := 0; q < 200; q++ {
+		vec := randomVector()
+		if got, want := idx.NearestCentroid(vec), idx.NearestCentroidLinear(vec); got != want {
+			t.Errorf("query %d: assigned to cluster %d, linear scan found %d", q, got, want)
+		}
+	}
+}
+
+func TestPQIVF_Validate(t *testing.T) {
+	dim := 4
+	idx := pqivf.NewPQIVFIndex(dim, 4, 2, 16, 10)
+	if err := idx.Validate(); err != nil {
+		t.Fatalf("expected empty index to validate, got %v", err)
+	}
+	rng := rand.New(rand.NewSource(5))
+	for i := 0; i < 200; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		if err := idx.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	for i := 0; i < 50; i++ {
+		if err := idx.Delete(i); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	if err := idx.Update(60, []float32{0.9, 0.9, 0.9, 0.9}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if err := idx.Validate(); err != nil {
+		t.Fatalf("expected valid index after mutations, got %v", err)
+	}
+
+	cluster := idx.NearestCentroid([]float32{0.9, 0.9, 0.9, 0.9})
+	idx.CorruptIDToCluster(60, (cluster+1)%4)
+	if err := idx.Validate(); err == nil {
+		t.Error("expected Validate to report a mismatched cluster mapping")
+	}
+}
+
+func TestPQIVF_SentinelErrors(t *testing.T) {
+	idx := pqivf.NewPQIVFIndex(2, 2, 1, 4, 10)
+	if _, err := idx.Search([]float32{0, 0}, 1); !errors.Is(err, core.ErrEmptyIndex) {
+		t.Errorf("expected ErrEmptyIndex, got %v", err)
+	}
+	if err := idx.Add(1, []float32{1, 1}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := idx.Delete(99); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := idx.Add(1, []float32{2, 2}); !errors.Is(err, core.ErrDuplicateID) {
+		t.Errorf("expected ErrDuplicateID, got %v", err)
+	}
+	if err := idx.Add(2, []float32{1, 2, 3}); !errors.Is(err, core.ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch, got %v", err)
+	}
+	if _, err := idx.Search([]float32{0, 0}, 0); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK, got %v", err)
+	}
+}
+
+func TestPQIVF_Close(t *testing.T) {
+	idx := pqivf.NewPQIVFIndex(2, 2, 1, 4, 10)
+	if err := idx.Add(1, []float32{1, 1}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := idx.Close(); err != nil {
+			t.Errorf("Close call %d failed: %v", i+1, err)
+		}
+	}
+}
+
+func TestPQIVF_BuildStats(t *testing.T) {
+	rng := rand.New(rand.NewSource(31))
+	var counts []int64
+	for _, n := range []int{200, 800} {
+		vectors := make(map[int][]float32, n)
+		for i := 0; i < n; i++ {
+			vec := make([]float32, 8)
+			for j := range vec {
+				vec[j] = rng.Float32()
+			}
+			vectors[i] = vec
+		}
+		idx := pqivf.NewPQIVFIndex(8, 4, 2, 16, 10)
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		if err := idx.Train(); err != nil {
+			t.Fatalf("Train failed: %v", err)
+		}
+		before := idx.BuildStats().DistanceEvaluations
+		if before == 0 {
+			t.Fatalf("expected distance evaluations after building %d vectors, got none", n)
+		}
+		if _, err := idx.Search(vectors[0], 10); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if after := idx.BuildStats().DistanceEvaluations; after != before {
+			t.Errorf("expected Search not to count build distances, got %d then %d", before, after)
+		}
+		counts = append(counts, before)
+	}
+	if counts[1] < 2*counts[0] {
+		t.Errorf("expected 4x the vectors to take at least 2x the distance evaluations, got %d and %d",
+			counts[0], counts[1])
+	}
+}
+
+func TestPQIVF_Freeze(t *testing.T) {
+	idx := pqivf.NewPQIVFIndex(2, 2, 1, 4, 10)
+	if err := idx.BulkAdd(map[int][]float32{1: {0, 0}, 2: {1, 0}, 3: {5, 5}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if idx.Frozen() {
+		t.Fatal("expected a new index not to be frozen")
+	}
+	other := pqivf.NewPQIVFIndex(2, 2, 1, 4, 10)
+	if err := other.Add(7, []float32{3, 3}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	var saved, compressed bytes.Buffer
+	if err := other.Save(&saved); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := other.SaveCompressed(&compressed); err != nil {
+		t.Fatalf("SaveCompressed failed: %v", err)
+	}
+	idx.Freeze()
+	if !idx.Frozen() {
+		t.Fatal("expected the index to be frozen after Freeze")
+	}
+	mutations := map[string]func() error{
+		"Add":              func() error { return idx.Add(4, []float32{2, 2}) },
+		"BulkAdd":          func() error { return idx.BulkAdd(map[int][]float32{4: {2, 2}}) },
+		"Delete":           func() error { return idx.Delete(1) },
+		"BulkDelete":       func() error { return idx.BulkDelete([]int{1, 2}) },
+		"Update":           func() error { return idx.Update(1, []float32{9, 9}) },
+		"BulkUpdate":       func() error { return idx.BulkUpdate(map[int][]float32{1: {9, 9}}) },
+		"Load":             func() error { return idx.Load(&saved) },
+		"LoadCompressed":   func() error { return idx.LoadCompressed(&compressed) },
+		"Train":            idx.Train,
+		"TrainIncremental": func() error { return idx.TrainIncremental(5) },
+	}
+	for name, mutate := range mutations {
+		if err := mutate(); !errors.Is(err, core.ErrFrozen) {
+			t.Errorf("%s: expected ErrFrozen, got %v", name, err)
+		}
+	}
+	if n, failures := idx.BulkAddLenient(map[int][]float32{4: {2, 2}}); n != 0 || !errors.Is(failures[4], core.ErrFrozen) {
+		t.Errorf("BulkAddLenient: expected no additions and ErrFrozen, got %d, %v", n, failures)
+	}
+	if n := idx.Stats().Count; n != 3 {
+		t.Errorf("expected 3 vectors after rejected mutations, got %d", n)
+	}
+	if vec, err := idx.GetVector(1); err != nil || !reflect.DeepEqual(vec, []float32{0, 0}) {
+		t.Errorf("expected vector 1 unchanged, got %v, %v", vec, err)
+	}
+	results, err := idx.Search([]float32{0.9, 0}, 1)
+	if err != nil {
+		t.Fatalf("Search on frozen index failed: %v", err)
+	}
+	if results[0].ID != 2 {
+		t.Errorf("expected id 2, got %d", results[0].ID)
+	}
+}
+
+func TestPQIVF_Prefetch(t *testing.T) {
+	idx := pqivf.NewPQIVFIndex(2, 2, 1, 4, 10)
+	vectors := map[int][]float32{1: {0, 0}, 2: {1, 0}, 3: {5, 5}, 4: {9, 9}}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	before, err := idx.Search([]float32{0.9, 0}, 2)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	for _, ids := range [][]int{nil, {1, 2}} {
+		if err := idx.Prefetch(ids); err != nil {
+			t.Fatalf("Prefetch(%v) failed: %v", ids, err)
+		}
+	}
+	after, err := idx.Search([]float32{0.9, 0}, 2)
+	if err != nil {
+		t.Fatalf("Search after Prefetch failed: %v", err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("expected the same results after Prefetch, got %v, want %v", after, before)
+	}
+}
+
+func TestPQIVF_KthDistance(t *testing.T) {
+	idx := pqivf.NewPQIVFIndex(4, 4, 2, 16, 10)
+	rng := rand.New(rand.NewSource(8))
+	vectors := make(map[int][]float32, 100)
+	for i := 0; i < 100; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := []float32{0.5, 0.5, 0.5, 0.5}
+	for _, k := range []int{1, 5, 10} {
+		results, err := idx.Search(query, k)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		got, err := idx.KthDistance(query, k)
+		if err != nil {
+			t.Fatalf("KthDistance failed: %v", err)
+		}
+		if got != results[k-1].Distance {
+			t.Errorf("k=%d: KthDistance = %f; want %f", k, got, results[k-1].Distance)
+		}
+	}
+	if _, err := idx.KthDistance(query, 101); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK for k above the index size, got %v", err)
+	}
+	if _, err := idx.KthDistance(query, 0); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK for k=0, got %v", err)
+	}
+}
+
+func TestPQIVF_SearchFarthest(t *testing.T) {
+	idx := pqivf.NewPQIVFIndex(4, 4, 2, 16, 10)
+	rng := rand.New(rand.NewSource(12))
+	vectors := make(map[int][]float32, 60)
+	for i := 0; i < 60; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := []float32{0.1, 0.9, 0.4, 0.2}
+	want := make([]core.Neighbor, 0, len(vectors))
+	for id, vec := range vectors {
+		want = append(want, core.Neighbor{ID: id, Distance: core.Euclidean(query, vec)})
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].Distance > want[j].Distance })
+
+	got, err := idx.SearchFarthest(query, 5)
+	if err != nil {
+		t.Fatalf("SearchFarthest failed: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 results, got %d", len(got))
+	}
+	for i := range got {
+		if got[i].ID != want[i].ID || math.Abs(got[i].Distance-want[i].Distance) > 1e-6 {
+			t.Errorf("result %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if _, err := idx.SearchFarthest(query, 0); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK, got %v", err)
+	}
+}
+
+func TestPQIVF_SearchPage(t *testing.T) {
+	idx := pqivf.NewPQIVFIndex(4, 4, 2, 16, 10)
+	rng := rand.New(rand.NewSource(9))
+	vectors := make(map[int][]float32, 200)
+	for i := 0; i < 200; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := []float32{0.3, 0.6, 0.2, 0.9}
+	want, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	first, err := idx.SearchPage(query, 0, 5)
+	if err != nil {
+		t.Fatalf("SearchPage failed: %v", err)
+	}
+	second, err := idx.SearchPage(query, 5, 5)
+	if err != nil {
+		t.Fatalf("SearchPage failed: %v", err)
+	}
+	got := append(append([]core.Neighbor{}, first...), second...)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d results over two pages, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if page, err := idx.SearchPage(query, 200, 5); err != nil || len(page) != 0 {
+		t.Errorf("expected an empty page past the end, got %v, %v", page, err)
+	}
+	if _, err := idx.SearchPage(query, -1, 5); err == nil {
+		t.Error("expected error for negative offset, got none")
+	}
+}
+
+func TestPQIVF_KMeansPlusPlusSeeding(t *testing.T) {
+	// Tight clusters of sub-vectors: random seeding often places several seeds in one cluster and
+	// leaves others without any, which a couple of k-means iterations can't repair.
+	rng := rand.New(rand.NewSource(3))
+	const clusters = 16
+	var data [][]float32
+	for c := 0; c < clusters; c++ {
+		cx, cy := float32(c%4)*10, float32(c/4)*10
+		for i := 0; i < 50; i++ {
+			data = append(data, []float32{cx + rng.Float32()*0.1, cy + rng.Float32()*0.1})
+		}
+	}
+
+	var randomErr, plusPlusErr float64
+	for run := 0; run < 5; run++ {
+		e, err := pqivf.TrainSubquantizer(data, clusters, 2, false)
+		if err != nil {
+			t.Fatalf("training with random seeding failed: %v", err)
+		}
+		randomErr += e
+		e, err = pqivf.TrainSubquantizer(data, clusters, 2, true)
+		if err != nil {
+			t.Fatalf("training with k-means++ seeding failed: %v", err)
+		}
+		plusPlusErr += e
+	}
+	if plusPlusErr >= randomErr {
+		t.Errorf("expected k-means++ seeding to give a lower quantization error, got %f vs %f for random seeding",
+			plusPlusErr/5, randomErr/5)
+	}
+}
+
+func TestPQIVF_QuantizationError(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+	vectors := make(map[int][]float32, 500)
+	for i := 0; i < 500; i++ {
+		vec := make([]float32, 8)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+
+	untrained := pqivf.NewPQIVFIndex(8, 4, 2, 4, 10)
+	if err := untrained.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if _, err := untrained.QuantizationError(); err == nil {
+		t.Error("expected error before training, got none")
+	}
+
+	var prev float64
+	for i, pqK := range []int{2, 16, 64} {
+		idx := pqivf.NewPQIVFIndex(8, 4, 2, pqK, 15)
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		if err := idx.Train(); err != nil {
+			t.Fatalf("Train failed: %v", err)
+		}
+		qe, err := idx.QuantizationError()
+		if err != nil {
+			t.Fatalf("QuantizationError failed: %v", err)
+		}
+		if i > 0 && qe >= prev {
+			t.Errorf("expected the error to decrease with pqK %d, got %f after %f", pqK, qe, prev)
+		}
+		prev = qe
+	}
+}
+
+func TestPQIVF_NearestClusters(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	vectors := make(map[int][]float32, 300)
+	for i := 0; i < 300; i++ {
+		vec := make([]float32, 8)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	idx := pqivf.NewPQIVFIndex(8, 8, 2, 8, 10)
+	if _, err := idx.NearestClusters(vectors[0], 3); !errors.Is(err, core.ErrEmptyIndex) {
+		t.Errorf("expected ErrEmptyIndex before any clusters exist, got %v", err)
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	centroids := idx.CoarseCentroids()
+
+	query := vectors[42]
+	clusters, err := idx.NearestClusters(query, 4)
+	if err != nil {
+		t.Fatalf("NearestClusters failed: %v", err)
+	}
+	expected := make([]core.Neighbor, len(centroids))
+	for i, c := range centroids {
+		expected[i] = core.Neighbor{ID: i, Distance: core.Euclidean(query, c)}
+	}
+	sort.Slice(expected, func(i, j int) bool {
+		if expected[i].Distance == expected[j].Distance {
+			return expected[i].ID < expected[j].ID
+		}
+		return expected[i].Distance < expected[j].Distance
+	})
+	if !reflect.DeepEqual(clusters, expected[:4]) {
+		t.Errorf("expected clusters %v, got %v", expected[:4], clusters)
+	}
+	if clusters[0].ID != idx.NearestCentroid(query) {
+		t.Errorf("expected the nearest cluster %d to be the assigned one %d", clusters[0].ID, idx.NearestCentroid(query))
+	}
+
+	all, err := idx.NearestClusters(query, 100)
+	if err != nil {
+		t.Fatalf("NearestClusters failed: %v", err)
+	}
+	if len(all) != len(centroids) {
+		t.Errorf("expected all %d clusters, got %d", len(centroids), len(all))
+	}
+	if _, err := idx.NearestClusters(query, 0); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK for n=0, got %v", err)
+	}
+	if _, err := idx.NearestClusters(query[:4], 1); !errors.Is(err, core.ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch, got %v", err)
+	}
+
+	// With another metric, vectors are still assigned to the cluster that NearestClusters ranks first.
+	manhattan := pqivf.NewPQIVFIndex(8, 8, 2, 8, 10)
+	manhattan.Distance = core.Manhattan
+	if err := manhattan.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	for q := 0; q < 200; q++ {
+		vec := make([]float32, 8)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		nearest, err := manhattan.NearestClusters(vec, 1)
+		if err != nil {
+			t.Fatalf("NearestClusters failed: %v", err)
+		}
+		if got := manhattan.NearestCentroid(vec); got != nearest[0].ID {
+			t.Errorf("query %d: assigned to cluster %d, NearestClusters ranks %d first", q, got, nearest[0].ID)
+		}
+	}
+}
+
+func TestPQIVF_TrainIncremental(t *testing.T) {
+	rng := rand.New(rand.NewSource(21))
+	randomVectors := func(firstID, n int, offset float32) map[int][]float32 {
+		vectors := make(map[int][]float32, n)
+		for i := 0; i < n; i++ {
+			vec := make([]float32, 8)
+			for j := range vec {
+				vec[j] = offset + rng.Float32()
+			}
+			vectors[firstID+i] = vec
+		}
+		return vectors
+	}
+	// recall returns the mean recall@10 of searches for the given vectors against exact search.
+	recall := func(idx *pqivf.PQIVFIndex, all, queries map[int][]float32) float64 {
+		var total float64
+		for _, q := range queries {
+			exact := make([]core.Neighbor, 0, len(all))
+			for id, vec := range all {
+				exact = append(exact, core.Neighbor{ID: id, Distance: core.Euclidean(q, vec)})
+			}
+			sort.Slice(exact, func(i, j int) bool { return exact[i].Distance < exact[j].Distance })
+			got, err := idx.Search(q, 10)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			want := make(map[int]bool, 10)
+			for _, n := range exact[:10] {
+				want[n.ID] = true
+			}
+			for _, n := range got {
+				if want[n.ID] {
+					total++
+				}
+			}
+		}
+		return total / float64(10*len(queries))
+	}
+
+	idx := pqivf.NewPQIVFIndex(8, 2, 4, 16, 15)
+	if err := idx.TrainIncremental(3); err == nil {
+		t.Error("expected error before training, got none")
+	}
+	old := randomVectors(0, 400, 0)
+	if err := idx.BulkAdd(old); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if err := idx.Train(); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	fresh := randomVectors(1000, 400, 0.5)
+	for id, vec := range fresh {
+		vec[id%8] += 3
+	}
+	if err := idx.BulkAdd(fresh); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	all := make(map[int][]float32, len(old)+len(fresh))
+	for id, vec := range old {
+		all[id] = vec
+	}
+	for id, vec := range fresh {
+		all[id] = vec
+	}
+	oldBefore, freshBefore := recall(idx, all, old), recall(idx, all, fresh)
+
+	if err := idx.TrainIncremental(3); err != nil {
+		t.Fatalf("TrainIncremental failed: %v", err)
+	}
+	oldAfter, freshAfter := recall(idx, all, old), recall(idx, all, fresh)
+	if freshAfter <= freshBefore {
+		t.Errorf("expected recall on the new data to improve, got %.3f after %.3f", freshAfter, freshBefore)
+	}
+	if oldAfter < oldBefore-0.1 {
+		t.Errorf("expected recall on the existing data to stay stable, got %.3f after %.3f", oldAfter, oldBefore)
+	}
+}
+
+func TestPQIVF_WarmupSize(t *testing.T) {
+	t.Setenv("HANN_SEED", "5")
+	rng := rand.New(rand.NewSource(5))
+	centers := [][]float32{{0, 0}, {10, 0}, {0, 10}, {10, 10}}
+	vectors := make(map[int][]float32, 200)
+	for i := 0; i < 200; i++ {
+		c := centers[i%len(centers)]
+		vectors[i] = []float32{c[0] + rng.Float32(), c[1] + rng.Float32()}
+	}
+	build := func(order []int) *pqivf.PQIVFIndex {
+		idx := pqivf.NewPQIVFIndex(2, 4, 1, 4, 10)
+		idx.WarmupSize = 100
+		for _, id := range order {
+			if err := idx.Add(id, vectors[id]); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		if err := idx.Validate(); err != nil {
+			t.Fatalf("Validate failed: %v", err)
+		}
+		return idx
+	}
+	ascending := make([]int, 200)
+	for i := range ascending {
+		ascending[i] = i
+	}
+	// The same vectors with the first 100 in reverse order and the rest interleaved differently.
+	shuffled := make([]int, 0, 200)
+	for i := 99; i >= 0; i-- {
+		shuffled = append(shuffled, i)
+	}
+	for i := 199; i >= 100; i-- {
+		shuffled = append(shuffled, i)
+	}
+
+	a, b := build(ascending), build(shuffled)
+	clusters := make(map[int]bool)
+	for id := range vectors {
+		if ca, cb := a.ClusterOf(id), b.ClusterOf(id); ca != cb {
+			t.Fatalf("id %d: cluster %d in one insertion order, %d in the other", id, ca, cb)
+		}
+		clusters[a.ClusterOf(id)] = true
+	}
+	if len(clusters) != len(centers) {
+		t.Errorf("expected %d clusters after the warm-up, got %d", len(centers), len(clusters))
+	}
+	// Each blob ends up in a cluster of its own.
+	for i := range centers {
+		for id := i; id < 200; id += len(centers) {
+			if a.ClusterOf(id) != a.ClusterOf(i) {
+				t.Fatalf("ids %d and %d from the same blob are in different clusters", i, id)
+			}
+		}
+	}
+
+	// Vectors buffered during the warm-up can be searched.
+	partial := pqivf.NewPQIVFIndex(2, 4, 1, 4, 10)
+	partial.WarmupSize = 100
+	for id := 0; id < 10; id++ {
+		if err := partial.Add(id, vectors[id]); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	results, err := partial.Search(vectors[3], 1)
+	if err != nil {
+		t.Fatalf("Search during the warm-up failed: %v", err)
+	}
+	if results[0].ID != 3 {
+		t.Errorf("expected id 3 during the warm-up, got %d", results[0].ID)
+	}
+}
+
+func TestPQIVF_WarmupBulkAdd(t *testing.T) {
+	vectors := make(map[int][]float32, 30)
+	for i := 0; i < 30; i++ {
+		vectors[i] = []float32{float32(i%2) * 100, float32(i) / 10}
+	}
+	idx := pqivf.NewPQIVFIndex(2, 2, 1, 4, 10)
+	idx.WarmupSize = 20
+
+	// A batch that would fill the buffer but holds an invalid vector is rejected as a whole.
+	vectors[29] = []float32{1, 2, 3}
+	if err := idx.BulkAdd(vectors); !errors.Is(err, core.ErrDimensionMismatch) {
+		t.Fatalf("expected ErrDimensionMismatch, got %v", err)
+	}
+	if n := idx.Stats().Count; n != 0 {
+		t.Fatalf("expected no vectors after the rejected batch, got %d", n)
+	}
+
+	vectors[29] = []float32{100, 2.9}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if err := idx.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if n := idx.Stats().Count; n != 30 {
+		t.Errorf("expected 30 vectors, got %d", n)
+	}
+	// The whole batch, including the vectors beyond WarmupSize, was clustered at the end of the warm-up.
+	for id := range vectors {
+		if got, want := idx.ClusterOf(id), idx.ClusterOf(id%2); got != want {
+			t.Errorf("id %d: expected cluster %d like id %d, got %d", id, want, id%2, got)
+		}
+	}
+	if idx.ClusterOf(0) == idx.ClusterOf(1) {
+		t.Error("expected the two columns in different clusters")
+	}
+}
+
+func TestPQIVF_ClusterPenalty(t *testing.T) {
+	rng := rand.New(rand.NewSource(14))
+	idx := pqivf.NewPQIVFIndex(2, 4, 1, 8, 10)
+	centers := [][]float32{{0, 0}, {1, 0}, {0, 1}, {1, 1}}
+	vectors := make(map[int][]float32, 200)
+	// Add one point per center first, so that each center seeds its own cluster.
+	for i := 0; i < 200; i++ {
+		c := centers[i%len(centers)]
+		vectors[i] = []float32{c[0] + rng.Float32()*0.5, c[1] + rng.Float32()*0.5}
+	}
+	for i := 0; i < len(centers); i++ {
+		if err := idx.Add(i, vectors[i]); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	rest := make(map[int][]float32, len(vectors))
+	for id, vec := range vectors {
+		if id >= len(centers) {
+			rest[id] = vec
+		}
+	}
+	if err := idx.BulkAdd(rest); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	distinctClusters := func(results []core.Neighbor) int {
+		seen := make(map[int]bool)
+		for _, n := range results {
+			seen[idx.ClusterOf(n.ID)] = true
+		}
+		return len(seen)
+	}
+
+	query := []float32{0.25, 0.25}
+	plain, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	idx.ClusterPenalty = 1
+	diverse, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(diverse) != 10 {
+		t.Fatalf("expected 10 results, got %d", len(diverse))
+	}
+	if distinctClusters(diverse) <= distinctClusters(plain) {
+		t.Errorf("expected results from more clusters with the penalty, got %d vs %d without",
+			distinctClusters(diverse), distinctClusters(plain))
+	}
+	if !sort.SliceIsSorted(diverse, func(i, j int) bool { return diverse[i].Distance < diverse[j].Distance }) {
+		t.Error("expected diversified results sorted by distance")
+	}
+
+	// Ties are ordered by id, and the results are written into the caller's buffer.
+	for id := 1000; id < 1030; id++ {
+		if err := idx.Add(id, []float32{0.25, 0.25}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	buf := make([]core.Neighbor, 0, 40)
+	tied, err := idx.SearchInto(query, 40, buf)
+	if err != nil {
+		t.Fatalf("SearchInto failed: %v", err)
+	}
+	if &tied[0] != &buf[:1][0] {
+		t.Error("expected diversified results to reuse the provided buffer")
+	}
+	for i := 1; i < len(tied); i++ {
+		if tied[i].Distance == tied[i-1].Distance && tied[i].ID < tied[i-1].ID {
+			t.Fatalf("expected equal distances ordered by id, got %v", tied)
+		}
+	}
+}
+
+func TestPQIVF_SearchWith(t *testing.T) {
+	t.Setenv("HANN_SEED", "4")
+	rng := rand.New(rand.NewSource(4))
+	// A tight cluster around the origin and a wide one around (10, 0) whose nearest points are closer to
+	// the query than any point of the tight cluster, although its centroid is farther away.
+	vectors := make(map[int][]float32, 100)
+	for i := 0; i < 50; i++ {
+		vectors[i] = []float32{rng.Float32() - 0.5, rng.Float32() - 0.5}
+		vectors[50+i] = []float32{6 + 8*float32(i)/49, 0}
+	}
+	idx := pqivf.NewPQIVFIndex(2, 2, 1, 4, 10)
+	idx.WarmupSize = 100
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if idx.ClusterOf(0) == idx.ClusterOf(50) {
+		t.Fatal("expected the two groups in different clusters")
+	}
+	query := []float32{4.9, 0}
+
+	t.Run("NProbe", func(t *testing.T) {
+		one, err := idx.SearchWith(query, 3, core.SearchOptions{NProbe: 1})
+		if err != nil {
+			t.Fatalf("SearchWith failed: %v", err)
+		}
+		for _, n := range one {
+			if n.ID >= 50 {
+				t.Errorf("expected NProbe 1 to scan only the nearest cluster, got id %d", n.ID)
+			}
+		}
+		two, err := idx.SearchWith(query, 3, core.SearchOptions{NProbe: 2})
+		if err != nil {
+			t.Fatalf("SearchWith failed: %v", err)
+		}
+		if want := []int{50, 51, 52}; !reflect.DeepEqual(ids(two), want) {
+			t.Errorf("expected NProbe 2 to find %v, got %v", want, ids(two))
+		}
+	})
+
+	t.Run("FilterAndExcludeIDs", func(t *testing.T) {
+		opts := core.SearchOptions{
+			NProbe:     2,
+			Filter:     func(id int) bool { return id%2 == 0 },
+			ExcludeIDs: []int{50},
+		}
+		results, err := idx.SearchWith(query, 3, opts)
+		if err != nil {
+			t.Fatalf("SearchWith failed: %v", err)
+		}
+		if want := []int{52, 54, 56}; !reflect.DeepEqual(ids(results), want) {
+			t.Errorf("expected %v, got %v", want, ids(results))
+		}
+	})
+
+	t.Run("RankMetric", func(t *testing.T) {
+		results, err := idx.SearchWith(query, 2, core.SearchOptions{NProbe: 2, RankMetric: core.Manhattan})
+		if err != nil {
+			t.Fatalf("SearchWith failed: %v", err)
+		}
+		for _, n := range results {
+			if want := core.Manhattan(query, vectors[n.ID]); math.Abs(n.Distance-want) > 1e-9 {
+				t.Errorf("id %d: expected the exact Manhattan distance %v, got %v", n.ID, want, n.Distance)
+			}
+		}
+	})
+}
+
+func ids(neighbors []core.Neighbor) []int {
+	out := make([]int, len(neighbors))
+	for i, n := range neighbors {
+		out[i] = n.ID
+	}
+	return out
+}
+
+func TestPQIVF_ADCPersistence(t *testing.T) {
+	t.Setenv("HANN_SEED", "5")
+	rng := rand.New(rand.NewSource(5))
+	vectors := make(map[int][]float32, 400)
+	for i := 0; i < 400; i++ {
+		vec := make([]float32, 8)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	idx := pqivf.NewPQIVFIndex(8, 4, 4, 16, 10)
+	if err := idx.PrepareADC(); err == nil {
+		t.Error("expected error before training, got none")
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if err := idx.Train(); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	query := vectors[7]
+	exact, err := idx.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	idx.UseADC = true
+	if err := idx.PrepareADC(); err != nil {
+		t.Fatalf("PrepareADC failed: %v", err)
+	}
+	if err := idx.PrepareADC(); err != nil {
+		t.Fatalf("PrepareADC failed: %v", err)
+	}
+	if got := idx.ADCPrecomputations(); got != 1 {
+		t.Errorf("expected 1 precomputation, got %d", got)
+	}
+	var buf bytes.Buffer
+	if err := idx.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded := pqivf.NewPQIVFIndex(8, 4, 4, 16, 10)
+	loaded.UseADC = true
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	results, err := loaded.Search(query, 10)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if got := loaded.ADCPrecomputations(); got != 0 {
+		t.Errorf("expected the first search after Load to reuse the saved precomputation, got %d recomputations", got)
+	}
+	if len(results) != len(exact) {
+		t.Fatalf("expected %d results, got %d", len(exact), len(results))
+	}
+	for i := range results {
+		if math.Abs(results[i].Distance-exact[i].Distance) > 1e-4 {
+			t.Errorf("result %d: expected the ADC distance %v to match the reconstruction distance %v",
+				i, results[i].Distance, exact[i].Distance)
+		}
+	}
+
+	// Retraining changes the codebooks, so the next search prepares the norms again.
+	if err := loaded.Train(); err != nil {
+		t.Fatalf("Train failed: %v", err)
+	}
+	if _, err := loaded.Search(query, 10); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if got := loaded.ADCPrecomputations(); got != 1 {
+		t.Errorf("expected retraining to invalidate the precomputation, got %d recomputations", got)
+	}
+}
+
+func TestPQIVF_DegenerateParameters(t *testing.T) {
+	for _, tc := range []struct{ numSubquantizers, want int }{{0, 1}, {-2, 1}, {10, 4}} {
+		idx := pqivf.NewPQIVFIndex(4, 2, tc.numSubquantizers, 4, 5)
+		if got := idx.NumSubquantizers(); got != tc.want {
+			t.Fatalf("numSubquantizers=%d: expected %d subquantizers, got %d", tc.numSubquantizers, tc.want, got)
+		}
+		for i := 0; i < 50; i++ {
+			if err := idx.Add(i, []float32{float32(i), float32(i % 5), float32(i % 3), 1}); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+		}
+		if err := idx.Train(); err != nil {
+			t.Fatalf("numSubquantizers=%d: Train failed: %v", tc.numSubquantizers, err)
+		}
+		results, err := idx.Search([]float32{20, 0, 2, 1}, 3)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != 3 {
+			t.Errorf("numSubquantizers=%d: expected 3 results, got %v", tc.numSubquantizers, results)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package rpt
+
+import "sort"
+
+// DropPoint removes a point without marking the tree dirty, leaving a stale leaf id, for tests.
+func (r *RPTIndex) DropPoint(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.points.Delete(id)
+}
+
+// ProjectionSplitFraction returns the fraction of internal nodes whose children are separated by the
+// node's projection threshold, as opposed to the even split used when a projection fails, for tests.
+func (r *RPTIndex) ProjectionSplitFraction() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dirty {
+		r.buildTree()
+	}
+	var internal, separated int
+	var collect func(node *treeNode) []int
+	collect = func(node *treeNode) []int {
+		if node.isLeaf {
+			return node.points
+		}
+		left, right := collect(node.left), collect(node.right)
+		internal++
+		ok := true
+		for _, id := range left {
+			if vec, _ := r.points.Get(id); !node.goesLeft(dot(vec, node.projection)) {
+				ok = false
+				break
+			}
+		}
+		for _, id := range right {
+			if vec, _ := r.points.Get(id); !ok || node.goesLeft(dot(vec, node.projection)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			separated++
+		}
+		return append(append([]int{}, left...), right...)
+	}
+	collect(r.tree)
+	if internal == 0 {
+		return 1
+	}
+	return float64(separated) / float64(internal)
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// CandidateIDs returns the candidate ids multi-probe search takes from the tree for the query, for tests.
+func (r *RPTIndex) CandidateIDs(query []float32, k int) []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.needsBuild() {
+		r.buildTree()
+	}
+	return r.candidates(query, k)
+}
+
+// RootSplit returns the threshold of the root split and the ids in its left and right subtrees, for tests.
+func (r *RPTIndex) RootSplit() (threshold float64, left, right []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.needsBuild() {
+		r.buildTree()
+	}
+	if r.tree.isLeaf {
+		return 0, r.tree.points, nil
+	}
+	return r.tree.threshold, leafIDs(r.tree.left), leafIDs(r.tree.right)
+}
+
+// Leaves returns the ids of each leaf, sorted within and across leaves, for tests.
+func (r *RPTIndex) Leaves() [][]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.needsBuild() {
+		r.buildTree()
+	}
+	var leaves [][]int
+	var walk func(node *treeNode)
+	walk = func(node *treeNode) {
+		if node.isLeaf {
+			leaf := append([]int{}, node.points...)
+			sort.Ints(leaf)
+			leaves = append(leaves, leaf)
+			return
+		}
+		walk(node.left)
+		walk(node.right)
+	}
+	walk(r.tree)
+	sort.Slice(leaves, func(i, j int) bool { return leaves[i][0] < leaves[j][0] })
+	return leaves
+}
+
+func leafIDs(node *treeNode) []int {
+	if node.isLeaf {
+		return append([]int{}, node.points...)
+	}
+	return append(leafIDs(node.left), leafIDs(node.right)...)
+}
@@ -7,17 +7,27 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/bits"
 	"math/rand"
-	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/patrikhermansson/hann/core"
+	"github.com/rs/zerolog/log"
 	"github.com/schollz/progressbar/v3"
 )
 
+// Smallest parameters NewRPTIndex accepts. A leaf capacity below 1 can never be met, so the tree would
+// split until every leaf is empty, and without candidate projections there is no split to choose.
+const (
+	minLeafCapacity         = 1
+	minCandidateProjections = 1
+)
+
 // NewRPTIndex creates a new RPT (Random Projection Tree) index.
 // It initializes parameters like dimension, leaf capacity, candidate projections, parallel threshold, and probe margin.
+// A leaf capacity or number of candidate projections below 1 is raised to 1 with a logged warning.
 func NewRPTIndex(
 	dimension int,
 	leafCapacity int,
@@ -25,9 +35,18 @@ func NewRPTIndex(
 	parallelThreshold int,
 	probeMargin float64,
 ) *RPTIndex {
+	if leafCapacity < minLeafCapacity {
+		log.Warn().Msgf("RPT leafCapacity=%d is too small, using leafCapacity=%d", leafCapacity, minLeafCapacity)
+		leafCapacity = minLeafCapacity
+	}
+	if candidateProjections < minCandidateProjections {
+		log.Warn().Msgf("RPT candidateProjections=%d is too small, using candidateProjections=%d",
+			candidateProjections, minCandidateProjections)
+		candidateProjections = minCandidateProjections
+	}
 	return &RPTIndex{
 		dimension:            dimension,
-		points:               make(map[int][]float32),
+		points:               core.NewDenseStore(dimension),
 		dirty:                true, // marks that the tree needs to be rebuilt
 		LeafCapacity:         leafCapacity,
 		CandidateProjections: candidateProjections,
@@ -46,31 +65,56 @@ type treeNode struct {
 	points     []int     // ids of points in the leaf
 	projection []float32 // projection vector used for splitting at this node
 	threshold  float64   // split threshold (median value)
+	tiesLeft   bool      // points projecting exactly onto the threshold belong to the left child
 	left       *treeNode // left child node
 	right      *treeNode // right child node
 }
 
+// goesLeft reports whether a point with the given projection value belongs to the left child.
+func (node *treeNode) goesLeft(dot float64) bool {
+	return dot < node.threshold || (node.tiesLeft && dot == node.threshold)
+}
+
 // RPTIndex is the main structure for the random projection tree index.
 // It holds all points, the tree root, and configuration parameters.
 type RPTIndex struct {
 	mu                   sync.RWMutex      // protects concurrent access
 	dimension            int               // dimension of each vector
-	points               map[int][]float32 // mapping of point id to vector
+	points               *core.DenseStore  // vectors of all points, stored contiguously by id
 	tree                 *treeNode         // root of the random projection tree
 	dirty                bool              // indicates if the tree needs to be rebuilt
 	Distance             core.DistanceFunc // function to compute distance between vectors
 	DistanceName         string            // name of the distance metric
 	LeafCapacity         int               // maximum number of points in a leaf
+	AutoLeafCapacity     bool              // derive the leaf capacity from the number of points, see EffectiveLeafCapacity
 	CandidateProjections int               // number of random projections to try when splitting
 	ParallelThreshold    int               // threshold to trigger parallel tree building
+	BuildWorkers         int               // maximum number of concurrent subtree builds (0 means MaxParallelism)
+	MaxParallelism       int               // maximum goroutines of parallel loops (0 means core.MaxParallelism())
 	ProbeMargin          float64           // margin for multi-probe search
+	Approximate          bool              // rank candidates by a low-dimensional sketch and refine only the best
+	RefineFactor         int               // candidates per requested neighbor refined in approximate mode (0 means 4)
+	ExactThreshold       int               // scan all points, without building the tree, when there are at most this many (0 disables)
+	MaxCandidates        int               // maximum number of candidate ids taken from the tree (0 means no limit)
+	UseExactMedian       bool              // split at the exact median projection without jitter, ties going left
+	VectorStats          *core.Stats       // optional running per-dimension statistics of inserted vectors
+
+	sketch         func([]float32) []float32       // random projection used to compute sketches
+	sketches       *core.DenseStore                // low-dimensional sketches of all points, built in approximate mode
+	frozen         atomic.Bool                     // set by Freeze to reject modifications
+	buildDistances atomic.Int64                    // vector operations made while building the tree, see BuildStats
+	queryCache     atomic.Pointer[core.QueryCache] // cache of Search results, set by EnableQueryCache
 }
 
 // buildTreeRecursive builds the tree recursively using random projections.
 // It splits the given set of point ids based on a randomly chosen projection.
-func buildTreeRecursive(ids []int, points map[int][]float32, dimension int,
-	distance core.DistanceFunc, rnd *rand.Rand,
-	leafCapacity int, candidateProjections int, parallelThreshold int) *treeNode {
+// Subtrees larger than parallelThreshold are built in a new goroutine only if a slot in sem is free,
+// so the number of concurrent builders never exceeds the capacity of sem.
+// Every dot product with a projection and every distance computation is counted in evaluations.
+func buildTreeRecursive(ids []int, points *core.DenseStore, dimension int,
+	distance core.DistanceFunc, evaluations *atomic.Int64, rnd *rand.Rand,
+	leafCapacity int, candidateProjections int, parallelThreshold int, exactMedian bool,
+	sem chan struct{}) *treeNode {
 
 	// If the number of points is small enough, create a leaf node.
 	if len(ids) <= leafCapacity {
@@ -116,13 +160,14 @@ func buildTreeRecursive(ids []int, points map[int][]float32, dimension int,
 		}
 		pairs := make([]pair, len(ids))
 		for i, id := range ids {
-			vec := points[id]
+			vec, _ := points.Get(id)
 			var dot float64
 			for j := 0; j < dimension; j++ {
 				dot += float64(vec[j]) * float64(proj[j])
 			}
 			pairs[i] = pair{id, dot}
 		}
+		evaluations.Add(int64(len(ids)))
 		// Sort points by their projection value.
 		sort.Slice(pairs, func(i, j int) bool {
 			return pairs[i].dot < pairs[j].dot
@@ -130,32 +175,37 @@ func buildTreeRecursive(ids []int, points map[int][]float32, dimension int,
 		// Choose the median as threshold.
 		mid := len(pairs) / 2
 
-		// Choose a random point x and compute the maximum distance to any other point.
-		x := points[ids[rnd.Intn(len(ids))]]
-		var maxDist float64
-		for _, id := range ids {
-			y := points[id]
-			var dist float64
-			for i := 0; i < dimension; i++ {
-				d := float64(x[i] - y[i])
-				dist += d * d
+		var threshold float64
+		if exactMedian {
+			// The exact median, averaging the two middle values for an even count.
+			threshold = pairs[mid].dot
+			if len(pairs)%2 == 0 {
+				threshold = (pairs[mid-1].dot + pairs[mid].dot) / 2
 			}
-			if dist > maxDist {
-				maxDist = dist
+		} else {
+			// Choose a random point x and compute the maximum distance to any other point under the
+			// index metric, which sets the scale of the jitter.
+			x, _ := points.Get(ids[rnd.Intn(len(ids))])
+			var maxDist float64
+			for _, id := range ids {
+				vec, _ := points.Get(id)
+				if dist := distance(x, vec); dist > maxDist {
+					maxDist = dist
+				}
 			}
-		}
-		maxDist = math.Sqrt(maxDist)
+			evaluations.Add(int64(len(ids)))
 
-		// Compute jitter
-		jitter := (rnd.Float64()*2 - 1) * 6 * maxDist / math.Sqrt(float64(dimension))
+			// Compute jitter
+			jitter := (rnd.Float64()*2 - 1) * 6 * maxDist / math.Sqrt(float64(dimension))
 
-		// Median threshold with jitter
-		threshold := pairs[mid].dot + jitter
+			// Median threshold with jitter
+			threshold = pairs[mid].dot + jitter
+		}
 
 		// Split ids into left and right groups.
 		var leftIDs, rightIDs []int
 		for _, p := range pairs {
-			if p.dot < threshold {
+			if p.dot < threshold || (exactMedian && p.dot == threshold) {
 				leftIDs = append(leftIDs, p.id)
 			} else {
 				rightIDs = append(rightIDs, p.id)
@@ -183,30 +233,39 @@ func buildTreeRecursive(ids []int, points map[int][]float32, dimension int,
 		}
 	}
 
+	// Derive the seeds of both subtrees from this node's generator so the tree depends only on the
+	// base seed, not on which subtrees happen to be built in parallel.
+	leftRnd := rand.New(rand.NewSource(rnd.Int63()))
+	rightRnd := rand.New(rand.NewSource(rnd.Int63()))
+
 	var leftChild, rightChild *treeNode
-	// If many points, build subtrees in parallel.
+	// If many points and a worker slot is free, build the left subtree in parallel.
+	parallel := false
 	if len(ids) > parallelThreshold {
+		select {
+		case sem <- struct{}{}:
+			parallel = true
+		default:
+		}
+	}
+	if parallel {
 		var wg sync.WaitGroup
-		wg.Add(2)
-		leftRnd := rand.New(rand.NewSource(core.GetSeed() + 1))
-		rightRnd := rand.New(rand.NewSource(core.GetSeed() + 2))
-		go func() {
-			defer wg.Done()
-			leftChild = buildTreeRecursive(bestCandidate.leftIDs, points, dimension, distance,
-				leftRnd, leafCapacity, candidateProjections, parallelThreshold)
-		}()
+		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			rightChild = buildTreeRecursive(bestCandidate.rightIDs, points, dimension, distance,
-				rightRnd, leafCapacity, candidateProjections, parallelThreshold)
+			defer func() { <-sem }()
+			leftChild = buildTreeRecursive(bestCandidate.leftIDs, points, dimension, distance, evaluations,
+				leftRnd, leafCapacity, candidateProjections, parallelThreshold, exactMedian, sem)
 		}()
+		rightChild = buildTreeRecursive(bestCandidate.rightIDs, points, dimension, distance, evaluations,
+			rightRnd, leafCapacity, candidateProjections, parallelThreshold, exactMedian, sem)
 		wg.Wait()
 	} else {
 		// Otherwise, build recursively in a single thread.
-		leftChild = buildTreeRecursive(bestCandidate.leftIDs, points, dimension, distance, rnd,
-			leafCapacity, candidateProjections, parallelThreshold)
-		rightChild = buildTreeRecursive(bestCandidate.rightIDs, points, dimension, distance, rnd,
-			leafCapacity, candidateProjections, parallelThreshold)
+		leftChild = buildTreeRecursive(bestCandidate.leftIDs, points, dimension, distance, evaluations, leftRnd,
+			leafCapacity, candidateProjections, parallelThreshold, exactMedian, sem)
+		rightChild = buildTreeRecursive(bestCandidate.rightIDs, points, dimension, distance, evaluations, rightRnd,
+			leafCapacity, candidateProjections, parallelThreshold, exactMedian, sem)
 	}
 
 	// Return an internal node with the best projection and split.
@@ -214,29 +273,102 @@ func buildTreeRecursive(ids []int, points map[int][]float32, dimension int,
 		isLeaf:     false,
 		projection: bestCandidate.proj,
 		threshold:  bestCandidate.threshold,
+		tiesLeft:   exactMedian,
 		left:       leftChild,
 		right:      rightChild,
 	}
 }
 
 // buildTree constructs the random projection tree from all stored points.
+// All randomness comes from a single generator seeded with core.GetSeed, so with a fixed HANN_SEED
+// rebuilding the same points produces the same tree.
 func (r *RPTIndex) buildTree() {
-	// Collect all point ids.
-	ids := make([]int, 0, len(r.points))
-	for id := range r.points {
-		ids = append(ids, id)
-	}
+	// Use a new random source for building the tree.
+	localRand := rand.New(rand.NewSource(core.GetSeed()))
+	// Collect all point ids in a fixed order.
+	ids := r.points.IDs()
+	sort.Ints(ids)
 	// Shuffle the ids to avoid bias.
-	rand.Shuffle(len(ids), func(i, j int) {
+	localRand.Shuffle(len(ids), func(i, j int) {
 		ids[i], ids[j] = ids[j], ids[i]
 	})
-	// Use a new random source for building the tree.
-	localRand := rand.New(rand.NewSource(core.GetSeed()))
-	r.tree = buildTreeRecursive(ids, r.points, r.dimension, r.Distance, localRand, r.LeafCapacity,
-		r.CandidateProjections, r.ParallelThreshold)
+	// Limit the number of extra goroutines used for parallel subtree builds.
+	workers := r.BuildWorkers
+	if workers <= 0 {
+		workers = core.Workers(r.MaxParallelism, len(ids))
+	}
+	sem := make(chan struct{}, workers)
+	r.tree = buildTreeRecursive(ids, r.points, r.dimension, r.Distance, &r.buildDistances, localRand,
+		r.leafCapacity(len(ids)), r.CandidateProjections, r.ParallelThreshold, r.UseExactMedian, sem)
+	r.sketches = nil
+	if r.Approximate {
+		r.buildSketches(localRand.Int63())
+	}
 	r.dirty = false // tree is now up to date
 }
 
+// minAutoLeafCapacity is the smallest leaf capacity chosen by AutoLeafCapacity.
+const minAutoLeafCapacity = 10
+
+// leafCapacity returns the leaf capacity of a tree over n points: LeafCapacity, or with AutoLeafCapacity
+// the larger of minAutoLeafCapacity and ceil(log2(n)). Larger datasets get larger leaves, so the depth
+// of the tree, and with it the number of thresholds a query can fall close to, grows more slowly.
+func (r *RPTIndex) leafCapacity(n int) int {
+	if !r.AutoLeafCapacity {
+		return r.LeafCapacity
+	}
+	return max(minAutoLeafCapacity, bits.Len(uint(max(n-1, 0))))
+}
+
+// EffectiveLeafCapacity returns the leaf capacity a tree built over the current points uses, which is
+// LeafCapacity unless AutoLeafCapacity is set.
+func (r *RPTIndex) EffectiveLeafCapacity() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.leafCapacity(r.points.Len())
+}
+
+// sketchDivisor is the factor by which sketches are smaller than the original vectors.
+const sketchDivisor = 4
+
+// buildSketches projects every point to a low-dimensional sketch used to rank candidates in approximate mode.
+func (r *RPTIndex) buildSketches(seed int64) {
+	sketchDim := r.dimension / sketchDivisor
+	if sketchDim < 1 {
+		sketchDim = 1
+	}
+	r.sketch = core.RandomProjection(r.dimension, sketchDim, seed)
+	r.sketches = core.NewDenseStore(sketchDim)
+	r.sketches.Reserve(r.points.Len())
+	for slot := 0; slot < r.points.Len(); slot++ {
+		r.sketches.Set(r.points.ID(slot), r.sketch(r.points.Vector(slot)))
+	}
+}
+
+// needsBuild reports whether the tree (or, in approximate mode, the sketches) must be built before searching.
+// The caller must hold the lock.
+func (r *RPTIndex) needsBuild() bool {
+	return r.dirty || (r.Approximate && r.sketches == nil)
+}
+
+// refineCandidates keeps the candidates whose sketches are closest to the query's sketch, so that exact
+// distances only need to be computed for those. The caller must hold the lock.
+func (r *RPTIndex) refineCandidates(query []float32, ids []int, keep int) []int {
+	if len(ids) <= keep {
+		return ids
+	}
+	ranked := parallelDistances(r.sketch(query), ids, r.sketches, core.SquaredEuclidean,
+		core.Workers(r.MaxParallelism, len(ids)))
+	sort.Slice(ranked, func(i, j int) bool {
+		return ranked[i].Distance < ranked[j].Distance
+	})
+	kept := make([]int, keep)
+	for i := range kept {
+		kept[i] = ranked[i].ID
+	}
+	return kept
+}
+
 // searchTreeMultiProbeWithMargin searches the tree for candidate point ids using multi-probing.
 // It follows both branches if the projection value is close to the threshold (within margin).
 func searchTreeMultiProbeWithMargin(node *treeNode, query []float32, dimension int,
@@ -258,12 +390,65 @@ func searchTreeMultiProbeWithMargin(node *treeNode, query []float32, dimension i
 		leftIDs := searchTreeMultiProbeWithMargin(node.left, query, dimension, distance, margin)
 		rightIDs := searchTreeMultiProbeWithMargin(node.right, query, dimension, distance, margin)
 		return append(leftIDs, rightIDs...)
-	} else if dot < node.threshold {
+	} else if node.goesLeft(dot) {
 		return searchTreeMultiProbeWithMargin(node.left, query, dimension, distance, margin)
 	}
 	return searchTreeMultiProbeWithMargin(node.right, query, dimension, distance, margin)
 }
 
+// probedLeaf is a leaf reached by multi-probe search together with its cost, the largest distance
+// between the query projection and a threshold crossed on the way to the leaf.
+type probedLeaf struct {
+	points []int
+	cost   float64
+}
+
+// collectProbedLeaves appends the leaves reached by multi-probe search with the given margin to leaves.
+// Leaves on the query's own side of every split have cost 0.
+func collectProbedLeaves(node *treeNode, query []float32, dimension int, margin, cost float64,
+	leaves []probedLeaf) []probedLeaf {
+	if node == nil {
+		return leaves
+	}
+	if node.isLeaf {
+		return append(leaves, probedLeaf{points: node.points, cost: cost})
+	}
+	var dot float64
+	for i := 0; i < dimension; i++ {
+		dot += float64(query[i]) * float64(node.projection[i])
+	}
+	near, far := node.right, node.left
+	if node.goesLeft(dot) {
+		near, far = node.left, node.right
+	}
+	leaves = collectProbedLeaves(near, query, dimension, margin, cost, leaves)
+	if gap := math.Abs(dot - node.threshold); gap < margin {
+		leaves = collectProbedLeaves(far, query, dimension, margin, math.Max(cost, gap), leaves)
+	}
+	return leaves
+}
+
+// boundedCandidates returns at most limit distinct ids from the leaves reached by multi-probe search,
+// taking leaves in order of increasing cost so that branches closer to their thresholds are preferred.
+func boundedCandidates(node *treeNode, query []float32, dimension int, margin float64, limit int) []int {
+	leaves := collectProbedLeaves(node, query, dimension, margin, 0, nil)
+	sort.SliceStable(leaves, func(i, j int) bool { return leaves[i].cost < leaves[j].cost })
+	seen := make(map[int]struct{}, limit)
+	ids := make([]int, 0, limit)
+	for _, leaf := range leaves {
+		for _, id := range leaf.points {
+			if len(ids) == limit {
+				return ids
+			}
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
 // unionInts returns the union of two integer slices (removing duplicates).
 func unionInts(a, b []int) []int {
 	m := make(map[int]struct{})
@@ -281,10 +466,16 @@ func unionInts(a, b []int) []int {
 }
 
 // computeDistances calculates the distance from the query to each point id in the list.
-// It does this in parallel across available CPUs.
+// It does this in parallel, with up to MaxParallelism goroutines. The caller must hold the read lock.
 func (r *RPTIndex) computeDistances(query []float32, ids []int) []core.Neighbor {
+	return parallelDistances(query, ids, r.points, r.Distance, core.Workers(r.MaxParallelism, len(ids)))
+}
+
+// parallelDistances calculates the distance from the query to the vector of each id in vectors,
+// splitting the work across numWorkers goroutines.
+func parallelDistances(query []float32, ids []int, vectors *core.DenseStore,
+	distance core.DistanceFunc, numWorkers int) []core.Neighbor {
 	neighbors := make([]core.Neighbor, len(ids))
-	numWorkers := runtime.NumCPU()
 	chunkSize := (len(ids) + numWorkers - 1) / numWorkers
 
 	var wg sync.WaitGroup
@@ -302,8 +493,8 @@ func (r *RPTIndex) computeDistances(query []float32, ids []int) []core.Neighbor
 			defer wg.Done()
 			for j := start; j < end; j++ {
 				id := ids[j]
-				vec := r.points[id]
-				d := r.Distance(query, vec)
+				vec, _ := vectors.Get(id)
+				d := distance(query, vec)
 				neighbors[j] = core.Neighbor{ID: id, Distance: d}
 			}
 		}(start, end)
@@ -314,91 +505,274 @@ func (r *RPTIndex) computeDistances(query []float32, ids []int) []core.Neighbor
 
 // Search returns the k nearest neighbors to the query vector.
 // It rebuilds the tree if needed and uses multi-probe search to get candidate ids.
+// With the query cache enabled, repeated searches are answered from the cache, see EnableQueryCache.
 func (r *RPTIndex) Search(query []float32, k int) ([]core.Neighbor, error) {
+	if cache := r.queryCache.Load(); cache != nil {
+		return cache.Search(query, k, func() ([]core.Neighbor, error) { return r.SearchInto(query, k, nil) })
+	}
+	return r.SearchInto(query, k, nil)
+}
+
+// SearchInto is like Search but copies the results into buf, reslicing it when its capacity suffices.
+// The returned slice aliases buf in that case, so buf must not be reused while the results are needed.
+func (r *RPTIndex) SearchInto(query []float32, k int, buf []core.Neighbor) ([]core.Neighbor, error) {
+	neighbors, err := r.search(query, k, true)
+	if err != nil {
+		return nil, err
+	}
+	if cap(buf) >= len(neighbors) {
+		buf = buf[:len(neighbors)]
+		copy(buf, neighbors)
+		return buf, nil
+	}
+	return neighbors, nil
+}
+
+// SearchUnsorted returns the same k nearest neighbors as Search, but in unspecified order.
+// It skips sorting the candidates, which saves time for large k.
+func (r *RPTIndex) SearchUnsorted(query []float32, k int) ([]core.Neighbor, error) {
+	return r.search(query, k, false)
+}
+
+// SearchWith searches like Search with per-call options. Filter, ExcludeIDs, and RankMetric are applied to
+// the candidates as described by core.SearchWithOptions. Ef and NProbe do not apply to RPT and are ignored.
+func (r *RPTIndex) SearchWith(query []float32, k int, opts core.SearchOptions) ([]core.Neighbor, error) {
+	r.mu.RLock()
+	count := r.points.Len()
+	r.mu.RUnlock()
+	search := func(n int) ([]core.Neighbor, error) {
+		return r.search(query, n, true)
+	}
+	return core.SearchWithOptions(query, k, opts, count, search, r.GetVector)
+}
+
+// candidates returns the candidate ids for the query from multi-probe search of the tree.
+// If fewer than 2*k candidates are found, the probe margin is doubled. With MaxCandidates set, at most
+// max(MaxCandidates, k) ids are returned. The caller must hold the read lock and the tree must be built.
+func (r *RPTIndex) candidates(query []float32, k int) []int {
+	if r.MaxCandidates > 0 {
+		limit := r.MaxCandidates
+		if limit < k {
+			limit = k
+		}
+		ids := boundedCandidates(r.tree, query, r.dimension, r.ProbeMargin, limit)
+		if len(ids) < k*2 && len(ids) < limit {
+			ids = boundedCandidates(r.tree, query, r.dimension, r.ProbeMargin*2, limit)
+		}
+		return ids
+	}
+	// Get candidate ids using multi-probe search.
+	candidateIDs := searchTreeMultiProbeWithMargin(r.tree, query, r.dimension, r.Distance, r.ProbeMargin)
+	// If not enough candidates, try with a larger margin.
+	if len(candidateIDs) < k*2 {
+		candidateIDsAlt := searchTreeMultiProbeWithMargin(r.tree, query, r.dimension, r.Distance, r.ProbeMargin*2)
+		candidateIDs = unionInts(candidateIDs, candidateIDsAlt)
+	}
+	return candidateIDs
+}
+
+// search returns the k nearest neighbors to the query, sorted by distance if sorted is true.
+func (r *RPTIndex) search(query []float32, k int, sorted bool) ([]core.Neighbor, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("%w: k must be positive, got %d", core.ErrInvalidK, k)
+	}
 	r.mu.RLock()
 	if len(query) != r.dimension {
 		r.mu.RUnlock()
-		return nil, fmt.Errorf("query dimension %d does not match index dimension %d",
-			len(query), r.dimension)
+		return nil, fmt.Errorf("%w: query dimension %d does not match index dimension %d",
+			core.ErrDimensionMismatch, len(query), r.dimension)
 	}
-	if len(r.points) == 0 {
+	if r.points.Len() == 0 {
 		r.mu.RUnlock()
-		return nil, errors.New("index is empty")
+		return nil, core.ErrEmptyIndex
 	}
 	// Copy the query to avoid modifying the original.
 	queryCopy := make([]float32, len(query))
 	copy(queryCopy, query)
 	query = queryCopy
 
+	if r.points.Len() <= r.ExactThreshold {
+		vector := func(slot int) (int, []float32) { return r.points.ID(slot), r.points.Vector(slot) }
+		neighbors := core.ExactSearch(query, k, r.points.Len(), vector, r.Distance, r.MaxParallelism)
+		r.mu.RUnlock()
+		return neighbors, nil
+	}
+
 	// If the tree is dirty, rebuild it.
-	if r.dirty {
+	if r.needsBuild() {
 		r.mu.RUnlock()
 		r.mu.Lock()
-		if r.dirty {
+		if r.needsBuild() {
 			r.buildTree()
 		}
 		r.mu.Unlock()
 		r.mu.RLock()
 	}
-	// Get candidate ids using multi-probe search.
-	candidateIDs := searchTreeMultiProbeWithMargin(r.tree, query, r.dimension, r.Distance, r.ProbeMargin)
-	// If not enough candidates, try with a larger margin.
-	if len(candidateIDs) < k*2 {
-		candidateIDsAlt := searchTreeMultiProbeWithMargin(r.tree, query, r.dimension, r.Distance, r.ProbeMargin*2)
-		candidateIDs = unionInts(candidateIDs, candidateIDsAlt)
+	candidateIDs := r.candidates(query, k)
+	// In approximate mode, compute exact distances only for the candidates with the closest sketches.
+	if r.Approximate && r.sketches != nil {
+		refine := r.RefineFactor
+		if refine <= 0 {
+			refine = 4
+		}
+		candidateIDs = r.refineCandidates(query, candidateIDs, refine*k)
 	}
-	r.mu.RUnlock()
 
-	// Compute distances for candidate points.
+	// Compute distances for candidate points. The vectors are read from the store, so the read lock is
+	// held until all distances are computed.
 	neighbors := r.computeDistances(query, candidateIDs)
 	// If still not enough, add extra points.
 	if len(neighbors) < k {
-		r.mu.RLock()
 		candidateSet := make(map[int]struct{}, len(candidateIDs))
 		for _, id := range candidateIDs {
 			candidateSet[id] = struct{}{}
 		}
 		var missingIDs []int
-		for id := range r.points {
+		for slot := 0; slot < r.points.Len(); slot++ {
+			id := r.points.ID(slot)
 			if _, exists := candidateSet[id]; !exists {
 				missingIDs = append(missingIDs, id)
 			}
 		}
-		r.mu.RUnlock()
 		extraNeighbors := r.computeDistances(query, missingIDs)
 		neighbors = append(neighbors, extraNeighbors...)
 	}
-	// Sort by distance.
+	r.mu.RUnlock()
+	if !sorted {
+		return core.SelectK(neighbors, k), nil
+	}
+	// Sort by distance, breaking ties by id.
 	sort.Slice(neighbors, func(i, j int) bool {
+		if neighbors[i].Distance == neighbors[j].Distance {
+			return neighbors[i].ID < neighbors[j].ID
+		}
 		return neighbors[i].Distance < neighbors[j].Distance
 	})
+	// Fewer than k neighbors means the index holds fewer than k vectors, all of which were added above.
 	if k > len(neighbors) {
 		k = len(neighbors)
 	}
 	return neighbors[:k], nil
 }
 
+// SearchByID returns the k nearest neighbors of the point stored for id, excluding id itself.
+func (r *RPTIndex) SearchByID(id int, k int) ([]core.Neighbor, error) {
+	return core.SearchByID(r, id, k)
+}
+
+// KthDistance returns the distance to the k-th nearest neighbor of the query, as found by Search.
+func (r *RPTIndex) KthDistance(query []float32, k int) (float64, error) {
+	results, err := r.SearchUnsorted(query, k)
+	if err != nil {
+		return 0, err
+	}
+	return core.KthNeighborDistance(results, k)
+}
+
+// SearchPage returns the neighbors of the query ranked [offset, offset+limit) in distance order.
+// See core.SearchPage.
+func (r *RPTIndex) SearchPage(query []float32, offset, limit int) ([]core.Neighbor, error) {
+	return core.SearchPage(r, query, offset, limit)
+}
+
+// SearchFarthest returns the k vectors farthest from the query, farthest first. It scans every
+// vector in the index. See core.SearchFarthest.
+func (r *RPTIndex) SearchFarthest(query []float32, k int) ([]core.Neighbor, error) {
+	return core.SearchFarthest(r, query, k)
+}
+
+// MeasureQuery returns the query unchanged, since points are stored as given, and the distance of the
+// index. It implements core.QueryMeasurer.
+func (r *RPTIndex) MeasureQuery(query []float32) ([]float32, core.DistanceFunc) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return query, r.Distance
+}
+
+// AllKNNStream computes the k nearest neighbors of every stored vector, excluding the vector itself,
+// and passes them to emit in ascending id order without buffering them. See core.AllKNNStream.
+func (r *RPTIndex) AllKNNStream(k int, emit func(id int, neighbors []core.Neighbor) error) error {
+	return core.AllKNNStream(r, r.ids(), k, emit)
+}
+
+// AllKNN returns the k nearest neighbors of every stored vector keyed by id. See core.AllKNN.
+func (r *RPTIndex) AllKNN(k int) (map[int][]core.Neighbor, error) {
+	return core.AllKNN(r, r.ids(), k)
+}
+
+// ids returns the ids of all stored vectors in ascending order.
+func (r *RPTIndex) ids() []int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	ids := r.points.IDs()
+	sort.Ints(ids)
+	return ids
+}
+
+// SearchMultiQuery returns the k nearest neighbors of the weighted centroid of several query vectors.
+// See core.SearchMultiQuery.
+func (r *RPTIndex) SearchMultiQuery(queries [][]float32, weights []float32, k int) ([]core.Neighbor, error) {
+	return core.SearchMultiQuery(r, queries, weights, k)
+}
+
+// SearchBucketed returns the k nearest neighbors of the query grouped into distance buckets of width
+// bucketWidth. See core.SearchBucketed.
+func (r *RPTIndex) SearchBucketed(query []float32, k int, bucketWidth float64) (map[int][]core.Neighbor, error) {
+	return core.SearchBucketed(r, query, k, bucketWidth)
+}
+
+// SearchDiverse returns k neighbors of the query that balance closeness to the query against
+// dissimilarity to each other, controlled by lambda. See core.SearchDiverse.
+func (r *RPTIndex) SearchDiverse(query []float32, k int, lambda float64) ([]core.Neighbor, error) {
+	return core.SearchDiverse(r, query, k, lambda)
+}
+
 // Add inserts a new point with the given id and vector into the index.
 // It marks the tree as dirty so it will be rebuilt.
 func (r *RPTIndex) Add(id int, vector []float32) error {
+	if r.frozen.Load() {
+		return core.ErrFrozen
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	defer r.queryCache.Load().Invalidate()
 	if len(vector) != r.dimension {
-		return fmt.Errorf("vector dimension %d does not match index dimension %d",
-			len(vector), r.dimension)
+		return fmt.Errorf("%w: vector dimension %d does not match index dimension %d",
+			core.ErrDimensionMismatch, len(vector), r.dimension)
 	}
-	if _, exists := r.points[id]; exists {
-		return fmt.Errorf("id %d already exists", id)
+	if r.points.Contains(id) {
+		return fmt.Errorf("id %d: %w", id, core.ErrDuplicateID)
 	}
-	r.points[id] = vector
+	r.addPoint(id, vector)
 	r.dirty = true
 	return nil
 }
 
+// addPoint stores a copy of a validated vector and updates VectorStats if set. The caller must hold the
+// write lock.
+func (r *RPTIndex) addPoint(id int, vector []float32) {
+	r.points.Set(id, vector)
+	if r.VectorStats != nil {
+		r.VectorStats.Update(vector)
+	}
+}
+
+// Reserve pre-sizes the point store for n additional points to avoid rehashing and reallocating during
+// a large BulkAdd. It is only a hint and can be called on a non-empty index.
+func (r *RPTIndex) Reserve(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.points.Reserve(n)
+}
+
 // BulkAdd inserts multiple points into the index and marks the tree as dirty.
 func (r *RPTIndex) BulkAdd(vectors map[int][]float32) error {
+	if r.frozen.Load() {
+		return core.ErrFrozen
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	defer r.queryCache.Load().Invalidate()
 
 	// Create a progress bar with a newline on completion.
 	bar := progressbar.NewOptions(len(vectors),
@@ -406,13 +780,13 @@ func (r *RPTIndex) BulkAdd(vectors map[int][]float32) error {
 	)
 	for id, vector := range vectors {
 		if len(vector) != r.dimension {
-			return fmt.Errorf("vector dimension %d does not match index dimension %d for id %d",
-				len(vector), r.dimension, id)
+			return fmt.Errorf("%w: vector dimension %d does not match index dimension %d for id %d",
+				core.ErrDimensionMismatch, len(vector), r.dimension, id)
 		}
-		if _, exists := r.points[id]; exists {
-			return fmt.Errorf("id %d already exists", id)
+		if r.points.Contains(id) {
+			return fmt.Errorf("id %d: %w", id, core.ErrDuplicateID)
 		}
-		r.points[id] = vector
+		r.addPoint(id, vector)
 		err := bar.Add(1)
 		if err != nil {
 			return err
@@ -422,29 +796,72 @@ func (r *RPTIndex) BulkAdd(vectors map[int][]float32) error {
 	return nil
 }
 
+// BulkAddLenient inserts multiple points into the index, skipping points that cannot be added.
+// Points with a mismatched dimension or an id that already exists are not inserted; the reason is
+// recorded in the returned failures map keyed by id. It returns the number of points added.
+func (r *RPTIndex) BulkAddLenient(vectors map[int][]float32) (int, map[int]error) {
+	if r.frozen.Load() {
+		failures := make(map[int]error, len(vectors))
+		for id := range vectors {
+			failures[id] = core.ErrFrozen
+		}
+		return 0, failures
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.queryCache.Load().Invalidate()
+
+	added := 0
+	failures := make(map[int]error)
+	for id, vector := range vectors {
+		if len(vector) != r.dimension {
+			failures[id] = fmt.Errorf("%w: vector dimension %d does not match index dimension %d for id %d",
+				core.ErrDimensionMismatch, len(vector), r.dimension, id)
+			continue
+		}
+		if r.points.Contains(id) {
+			failures[id] = fmt.Errorf("id %d: %w", id, core.ErrDuplicateID)
+			continue
+		}
+		r.addPoint(id, vector)
+		added++
+	}
+	if added > 0 {
+		r.dirty = true
+	}
+	return added, failures
+}
+
 // Delete removes a point by its id and marks the tree as dirty.
 func (r *RPTIndex) Delete(id int) error {
+	if r.frozen.Load() {
+		return core.ErrFrozen
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if _, exists := r.points[id]; !exists {
-		return fmt.Errorf("id %d not found", id)
+	defer r.queryCache.Load().Invalidate()
+	if !r.points.Delete(id) {
+		return fmt.Errorf("id %d: %w", id, core.ErrNotFound)
 	}
-	delete(r.points, id)
 	r.dirty = true
 	return nil
 }
 
 // BulkDelete removes multiple points from the index and marks the tree as dirty.
 func (r *RPTIndex) BulkDelete(ids []int) error {
+	if r.frozen.Load() {
+		return core.ErrFrozen
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	defer r.queryCache.Load().Invalidate()
 
 	// Create a progress bar with a newline on completion.
 	bar := progressbar.NewOptions(len(ids),
 		progressbar.OptionOnCompletion(func() { fmt.Print("\n") }),
 	)
 	for _, id := range ids {
-		delete(r.points, id)
+		r.points.Delete(id)
 		err := bar.Add(1)
 		if err != nil {
 			return err
@@ -456,24 +873,32 @@ func (r *RPTIndex) BulkDelete(ids []int) error {
 
 // Update changes the vector of an existing point and marks the tree as dirty.
 func (r *RPTIndex) Update(id int, vector []float32) error {
+	if r.frozen.Load() {
+		return core.ErrFrozen
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	defer r.queryCache.Load().Invalidate()
 	if len(vector) != r.dimension {
-		return fmt.Errorf("vector dimension %d does not match index dimension %d",
-			len(vector), r.dimension)
+		return fmt.Errorf("%w: vector dimension %d does not match index dimension %d",
+			core.ErrDimensionMismatch, len(vector), r.dimension)
 	}
-	if _, exists := r.points[id]; !exists {
-		return fmt.Errorf("id %d not found", id)
+	if !r.points.Contains(id) {
+		return fmt.Errorf("id %d: %w", id, core.ErrNotFound)
 	}
-	r.points[id] = vector
+	r.points.Set(id, vector)
 	r.dirty = true
 	return nil
 }
 
 // BulkUpdate updates multiple points in the index.
 func (r *RPTIndex) BulkUpdate(updates map[int][]float32) error {
+	if r.frozen.Load() {
+		return core.ErrFrozen
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	defer r.queryCache.Load().Invalidate()
 
 	// Create a progress bar with a newline on completion.
 	bar := progressbar.NewOptions(len(updates),
@@ -481,13 +906,13 @@ func (r *RPTIndex) BulkUpdate(updates map[int][]float32) error {
 	)
 	for id, vector := range updates {
 		if len(vector) != r.dimension {
-			return fmt.Errorf("vector dimension %d does not match index dimension %d for id %d",
-				len(vector), r.dimension, id)
+			return fmt.Errorf("%w: vector dimension %d does not match index dimension %d for id %d",
+				core.ErrDimensionMismatch, len(vector), r.dimension, id)
 		}
-		if _, exists := r.points[id]; !exists {
-			return fmt.Errorf("id %d not found", id)
+		if !r.points.Contains(id) {
+			return fmt.Errorf("id %d: %w", id, core.ErrNotFound)
 		}
-		r.points[id] = vector
+		r.points.Set(id, vector)
 		err := bar.Add(1)
 		if err != nil {
 			return err
@@ -497,15 +922,128 @@ func (r *RPTIndex) BulkUpdate(updates map[int][]float32) error {
 	return nil
 }
 
+// Export returns copies of all points stored in the index keyed by id.
+func (r *RPTIndex) Export() (map[int][]float32, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[int][]float32, r.points.Len())
+	for slot := 0; slot < r.points.Len(); slot++ {
+		out[r.points.ID(slot)] = append([]float32(nil), r.points.Vector(slot)...)
+	}
+	return out, nil
+}
+
+// ForEach calls fn for every point under the read lock and stops at the first error fn returns.
+// fn must not modify the index or the point passed to it.
+func (r *RPTIndex) ForEach(fn func(id int, vec []float32) error) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for slot := 0; slot < r.points.Len(); slot++ {
+		if err := fn(r.points.ID(slot), r.points.Vector(slot)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetVector returns a copy of the point stored for the given id.
+func (r *RPTIndex) GetVector(id int) ([]float32, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	vec, exists := r.points.Get(id)
+	if !exists {
+		return nil, fmt.Errorf("id %d: %w", id, core.ErrNotFound)
+	}
+	cp := make([]float32, len(vec))
+	copy(cp, vec)
+	return cp, nil
+}
+
+// Validate checks, unless the tree is due for a rebuild, that every leaf id exists in the points and
+// every point is in exactly one leaf. It returns a descriptive error for the first broken invariant.
+func (r *RPTIndex) Validate() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	// A dirty tree is stale by design and is rebuilt before the next search.
+	if r.dirty {
+		return nil
+	}
+	if r.tree == nil {
+		if r.points.Len() > 0 {
+			return errors.New("tree is missing for a non-empty index")
+		}
+		return nil
+	}
+	seen := make(map[int]bool, r.points.Len())
+	var check func(node *treeNode) error
+	check = func(node *treeNode) error {
+		if node == nil {
+			return errors.New("internal tree node has a missing child")
+		}
+		if !node.isLeaf {
+			if len(node.projection) != r.dimension {
+				return fmt.Errorf("split projection has dimension %d, expected %d", len(node.projection), r.dimension)
+			}
+			if err := check(node.left); err != nil {
+				return err
+			}
+			return check(node.right)
+		}
+		for _, id := range node.points {
+			if !r.points.Contains(id) {
+				return fmt.Errorf("leaf contains id %d, which is not in the index", id)
+			}
+			if seen[id] {
+				return fmt.Errorf("id %d appears in more than one leaf", id)
+			}
+			seen[id] = true
+		}
+		return nil
+	}
+	if err := check(r.tree); err != nil {
+		return err
+	}
+	if len(seen) != r.points.Len() {
+		return fmt.Errorf("tree holds %d points, index has %d", len(seen), r.points.Len())
+	}
+	return nil
+}
+
+// NeedsRebuild reports whether the tree is dirty and will be rebuilt on the next search.
+func (r *RPTIndex) NeedsRebuild() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.needsBuild()
+}
+
+// Rebuild rebuilds the tree if it is dirty.
+// It can be called ahead of time to keep the rebuild cost off the query path.
+func (r *RPTIndex) Rebuild() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer r.queryCache.Load().Invalidate()
+	if r.needsBuild() {
+		r.buildTree()
+	}
+}
+
+// BuildStats returns the cumulative number of vector operations made while building the tree: the dot
+// products of points with the candidate projections of every split, plus the distances the jittered
+// median splits use to scale their jitter. Searches are not counted.
+func (r *RPTIndex) BuildStats() core.BuildStats {
+	return core.BuildStats{DistanceEvaluations: r.buildDistances.Load()}
+}
+
 // Stats returns some basic statistics about the index.
 func (r *RPTIndex) Stats() core.IndexStats {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	count := len(r.points)
+	count := r.points.Len()
 	return core.IndexStats{
 		Count:     count,
 		Dimension: r.dimension,
 		Distance:  "euclidean",
+		Size:      4 * count * r.dimension,
 	}
 }
 
@@ -514,16 +1052,33 @@ type rptSerialized struct {
 	Dimension    int
 	Points       map[int][]float32
 	DistanceName string
+
+	// Tree parameters, so that an index loaded without a configuration (see core.LoadIndex) can be searched.
+	// Streams saved before they were added decode with zeros, which keep the configured values.
+	LeafCapacity         int
+	AutoLeafCapacity     bool
+	CandidateProjections int
+	ParallelThreshold    int
+	ProbeMargin          float64
 }
 
 // GobEncode serializes the index to bytes using gob.
 func (r *RPTIndex) GobEncode() ([]byte, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
+	points := make(map[int][]float32, r.points.Len())
+	for slot := 0; slot < r.points.Len(); slot++ {
+		points[r.points.ID(slot)] = r.points.Vector(slot)
+	}
 	ser := rptSerialized{
-		Dimension:    r.dimension,
-		Points:       r.points,
-		DistanceName: "euclidean",
+		Dimension:            r.dimension,
+		Points:               points,
+		DistanceName:         "euclidean",
+		LeafCapacity:         r.LeafCapacity,
+		AutoLeafCapacity:     r.AutoLeafCapacity,
+		CandidateProjections: r.CandidateProjections,
+		ParallelThreshold:    r.ParallelThreshold,
+		ProbeMargin:          r.ProbeMargin,
 	}
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
@@ -541,8 +1096,30 @@ func (r *RPTIndex) GobDecode(data []byte) error {
 	if err := dec.Decode(&ser); err != nil {
 		return err
 	}
+	points := core.NewDenseStore(ser.Dimension)
+	points.Reserve(len(ser.Points))
+	// Store the points in id order so that the slots don't depend on map iteration order.
+	ids := make([]int, 0, len(ser.Points))
+	for id := range ser.Points {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if vec := ser.Points[id]; len(vec) != ser.Dimension {
+			return fmt.Errorf("point %d has dimension %d, expected %d", id, len(vec), ser.Dimension)
+		}
+		points.Set(id, ser.Points[id])
+	}
 	r.dimension = ser.Dimension
-	r.points = ser.Points
+	r.points = points
+	if ser.LeafCapacity > 0 {
+		r.LeafCapacity = ser.LeafCapacity
+		r.AutoLeafCapacity = ser.AutoLeafCapacity
+		r.CandidateProjections = ser.CandidateProjections
+		r.ParallelThreshold = ser.ParallelThreshold
+		r.ProbeMargin = ser.ProbeMargin
+	}
+	r.tree = nil
 	r.DistanceName = "euclidean"
 	r.dirty = true // mark tree as dirty so it will be rebuilt
 	return nil
@@ -552,22 +1129,116 @@ func (r *RPTIndex) GobDecode(data []byte) error {
 func (r *RPTIndex) Save(w io.Writer) error {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
+	if err := core.WriteHeader(w, core.FormatRPT); err != nil {
+		return err
+	}
 	enc := gob.NewEncoder(w)
 	return enc.Encode(r)
 }
 
 // Load reads the index from the given reader using gob encoding.
 func (r *RPTIndex) Load(rdr io.Reader) error {
+	if r.frozen.Load() {
+		return core.ErrFrozen
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	defer r.queryCache.Load().Invalidate()
+	rdr, err := core.ReadHeader(rdr, core.FormatRPT)
+	if err != nil {
+		return err
+	}
 	dec := gob.NewDecoder(rdr)
 	return dec.Decode(r)
 }
 
+// SaveCompressed saves the index to w as a gzip-compressed gob stream. See core.SaveCompressed.
+func (r *RPTIndex) SaveCompressed(w io.Writer) error {
+	return core.SaveCompressed(r, w)
+}
+
+// LoadCompressed loads the index from a stream written by SaveCompressed. See core.LoadCompressed.
+func (r *RPTIndex) LoadCompressed(rdr io.Reader) error {
+	return core.LoadCompressed(r, rdr)
+}
+
+// Freeze makes the index read-only: further Adds, Deletes, and Updates return core.ErrFrozen, and so do
+// Load and LoadCompressed. Rebuild still works, since it only brings the tree up to date with the stored
+// vectors, which the next search would otherwise do.
+func (r *RPTIndex) Freeze() {
+	r.frozen.Store(true)
+}
+
+// Frozen reports whether Freeze has been called.
+func (r *RPTIndex) Frozen() bool {
+	return r.frozen.Load()
+}
+
+// EnableQueryCache caches the results of up to size distinct searches by Search, keyed by the query and k,
+// and evicts the least recently used ones beyond that. Every modification of the index clears the cache,
+// but changing search options such as fields of the index does not, so call EnableQueryCache again after
+// changing them. Calling it again replaces the cache with an empty one, and a size of 0 or less disables it.
+func (r *RPTIndex) EnableQueryCache(size int) {
+	if size <= 0 {
+		r.queryCache.Store(nil)
+		return
+	}
+	r.queryCache.Store(core.NewQueryCache(size))
+}
+
+// QueryCache returns the query cache enabled by EnableQueryCache, for example to read its hit count, or nil.
+func (r *RPTIndex) QueryCache() *core.QueryCache {
+	return r.queryCache.Load()
+}
+
+// Prefetch loads vectors into memory ahead of a burst of searches. The index is kept entirely in memory,
+// so there is nothing to load and Prefetch always returns nil.
+func (r *RPTIndex) Prefetch(ids []int) error {
+	return nil
+}
+
+// Close releases resources held by the index. The index is kept entirely in memory, so there is
+// nothing to release and Close always returns nil.
+func (r *RPTIndex) Close() error {
+	return nil
+}
+
 // Check that RPTIndex implements the core.Index interface.
 var _ core.Index = (*RPTIndex)(nil)
+var _ core.QueryMeasurer = (*RPTIndex)(nil)
+
+// newFromConfig creates an RPT index from a configuration map.
+// Required parameters: "dimension", "leaf_capacity", "candidate_projections", "parallel_threshold",
+// and "probe_margin".
+func newFromConfig(cfg map[string]any) (core.Index, error) {
+	dimension, err := core.IntParam(cfg, "dimension")
+	if err != nil {
+		return nil, err
+	}
+	leafCapacity, err := core.IntParam(cfg, "leaf_capacity")
+	if err != nil {
+		return nil, err
+	}
+	candidateProjections, err := core.IntParam(cfg, "candidate_projections")
+	if err != nil {
+		return nil, err
+	}
+	parallelThreshold, err := core.IntParam(cfg, "parallel_threshold")
+	if err != nil {
+		return nil, err
+	}
+	probeMargin, err := core.FloatParam(cfg, "probe_margin")
+	if err != nil {
+		return nil, err
+	}
+	return NewRPTIndex(dimension, leafCapacity, candidateProjections, parallelThreshold, probeMargin), nil
+}
 
-// Register RPTIndex for gob encoding.
+// Register RPTIndex for gob encoding and its constructor for core.NewIndex.
 func init() {
+	core.RegisterIndex("rpt", newFromConfig)
+	core.RegisterFormat(core.FormatRPT, "rpt", func() core.Index {
+		return NewRPTIndex(0, minLeafCapacity, minCandidateProjections, 0, 0)
+	})
 	gob.Register(&RPTIndex{})
 }
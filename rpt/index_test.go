@@ -2,9 +2,18 @@ package rpt_test
 
 import (
 	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/patrikhermansson/hann/core"
 	"github.com/patrikhermansson/hann/rpt"
 )
 
@@ -228,6 +237,56 @@ func TestRPTIndex_ConcurrentOperations(t *testing.T) {
 	}
 }
 
+func TestRPTIndex_SearchDuringDeletes(t *testing.T) {
+	idx := rpt.NewRPTIndex(2, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	rng := rand.New(rand.NewSource(12))
+	vectors := make(map[int][]float32, 200)
+	for i := 0; i < 200; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	// Delete and re-add vectors while searching with k large enough that every vector is scanned, so the
+	// searches read vectors that are being removed from the store.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i = (i + 1) % 100 {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if err := idx.Delete(i); err != nil {
+				t.Errorf("Delete failed: %v", err)
+				return
+			}
+			if err := idx.Add(i, vectors[i]); err != nil {
+				t.Errorf("Add failed: %v", err)
+				return
+			}
+		}
+	}()
+	for n := 0; n < 300; n++ {
+		results, err := idx.Search(vectors[150], 150)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		for _, r := range results {
+			if want := core.Euclidean(vectors[150], vectors[r.ID]); r.Distance != want {
+				t.Fatalf("id %d: expected distance %f, got %f", r.ID, want, r.Distance)
+			}
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
 func TestRPTIndex_ErrorOnWrongVectorDimension(t *testing.T) {
 	dim := 6
 	idx := rpt.NewRPTIndex(dim, defaultLeafCapacity, defaultCandidateProjections,
@@ -248,3 +307,958 @@ func TestRPTIndex_ErrorOnWrongVectorDimension(t *testing.T) {
 		t.Errorf("expected error for wrong vector dimension in BulkAdd, but got none")
 	}
 }
+
+func TestRPTIndex_BulkAddLenient(t *testing.T) {
+	idx := rpt.NewRPTIndex(6, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	if err := idx.Add(1, []float32{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	vectors := map[int][]float32{
+		1: {6, 5, 4, 3, 2, 1}, // duplicate id
+		2: {1, 1, 1, 1, 1, 1},
+		3: {1, 2, 3}, // wrong dimension
+		4: {2, 2, 2, 2, 2, 2},
+	}
+	added, failures := idx.BulkAddLenient(vectors)
+	if added != 2 {
+		t.Errorf("expected 2 vectors added, got %d", added)
+	}
+	if len(failures) != 2 || failures[1] == nil || failures[3] == nil {
+		t.Errorf("expected failures for ids 1 and 3, got %v", failures)
+	}
+	if stats := idx.Stats(); stats.Count != 3 {
+		t.Errorf("expected count 3 after BulkAddLenient, got %d", stats.Count)
+	}
+}
+
+func TestRPTIndex_NeedsRebuild(t *testing.T) {
+	idx := rpt.NewRPTIndex(6, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	if err := idx.Add(1, []float32{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if !idx.NeedsRebuild() {
+		t.Error("expected NeedsRebuild to be true after Add")
+	}
+
+	if _, err := idx.Search([]float32{1, 2, 3, 4, 5, 6}, 1); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if idx.NeedsRebuild() {
+		t.Error("expected NeedsRebuild to be false after Search rebuilt the tree")
+	}
+
+	if err := idx.Add(2, []float32{6, 5, 4, 3, 2, 1}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	idx.Rebuild()
+	if idx.NeedsRebuild() {
+		t.Error("expected NeedsRebuild to be false after Rebuild")
+	}
+}
+
+func TestRPTIndex_SaveLoadEmpty(t *testing.T) {
+	idx := rpt.NewRPTIndex(6, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+
+	var buf bytes.Buffer
+	if err := idx.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded := rpt.NewRPTIndex(6, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	if err := loaded.Load(&buf); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if stats := loaded.Stats(); stats.Count != 0 {
+		t.Errorf("expected count 0 after loading an empty index, got %d", stats.Count)
+	}
+
+	if err := loaded.Add(1, []float32{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	neighbors, err := loaded.Search([]float32{1, 2, 3, 4, 5, 6}, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(neighbors) != 1 || neighbors[0].ID != 1 {
+		t.Errorf("expected id 1 as nearest neighbor, got %v", neighbors)
+	}
+}
+
+func TestRPTIndex_NewIndexFactory(t *testing.T) {
+	index, err := core.NewIndex("rpt", map[string]any{
+		"dimension": 6, "leaf_capacity": 10, "candidate_projections": 3,
+		"parallel_threshold": 100, "probe_margin": 0.15,
+	})
+	if err != nil {
+		t.Fatalf("NewIndex failed: %v", err)
+	}
+	if err := index.Add(1, []float32{1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	neighbors, err := index.Search([]float32{1, 2, 3, 4, 5, 6}, 1)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(neighbors) != 1 || neighbors[0].ID != 1 {
+		t.Errorf("expected id 1 as nearest neighbor, got %v", neighbors)
+	}
+
+	if _, err := core.NewIndex("rpt", map[string]any{"dimension": 6}); err == nil {
+		t.Error("expected error for missing parameters, got none")
+	}
+}
+
+func TestRPTIndex_BuildWorkersBounded(t *testing.T) {
+	dim := 8
+	// A low parallel threshold would spawn goroutines for almost every subtree without a worker limit.
+	idx := rpt.NewRPTIndex(dim, defaultLeafCapacity, defaultCandidateProjections, 20, defaultProbeMargin)
+	idx.BuildWorkers = 2
+	rng := rand.New(rand.NewSource(1))
+	vectors := make(map[int][]float32, 5000)
+	for i := 0; i < 5000; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	// Sample the goroutine count while the tree is built.
+	var peak int64
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if n := int64(runtime.NumGoroutine()); n > atomic.LoadInt64(&peak) {
+				atomic.StoreInt64(&peak, n)
+			}
+			select {
+			case <-stop:
+				return
+			default:
+				runtime.Gosched()
+			}
+		}
+	}()
+	base := runtime.NumGoroutine()
+	idx.Rebuild()
+	close(stop)
+	<-done
+
+	if extra := int(atomic.LoadInt64(&peak)) - base; extra > idx.BuildWorkers {
+		t.Errorf("expected at most %d extra goroutines during build, got %d", idx.BuildWorkers, extra)
+	}
+	if _, err := idx.Search(vectors[0], 5); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+}
+
+func TestRPTIndex_DeterministicBuild(t *testing.T) {
+	t.Setenv("HANN_SEED", "7")
+	dim := 8
+	rng := rand.New(rand.NewSource(3))
+	vectors := make(map[int][]float32, 2000)
+	for i := 0; i < 2000; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	// A low parallel threshold makes sure parallel subtree builds are part of the comparison.
+	build := func() *rpt.RPTIndex {
+		idx := rpt.NewRPTIndex(dim, defaultLeafCapacity, defaultCandidateProjections, 50, 0.01)
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		idx.Rebuild()
+		return idx
+	}
+	first, second := build(), build()
+
+	for q := 0; q < 50; q++ {
+		query := make([]float32, dim)
+		for j := range query {
+			query[j] = rng.Float32()
+		}
+		want, err := first.Search(query, 10)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		got, err := second.Search(query, 10)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("query %d: expected %d results, got %d", q, len(want), len(got))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("query %d: result %d differs between builds: %+v vs %+v", q, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestRPTIndex_SearchInto(t *testing.T) {
+	dim := 6
+	idx := rpt.NewRPTIndex(dim, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	for i := 0; i < 50; i++ {
+		vec := []float32{float32(i), float32(i % 5), float32(i % 7), 1, 2, float32(i % 3)}
+		if err := idx.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	query := []float32{10, 2, 3, 1, 2, 1}
+	want, err := idx.Search(query, 5)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	buf := make([]core.Neighbor, 0, 5)
+	got, err := idx.SearchInto(query, 5, buf)
+	if err != nil {
+		t.Fatalf("SearchInto failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d results, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("result %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if &got[0] != &buf[:1][0] {
+		t.Error("expected results to reuse the provided buffer")
+	}
+}
+
+func TestRPTIndex_Approximate(t *testing.T) {
+	t.Setenv("HANN_SEED", "11")
+	dim, n, k := 256, 3000, 10
+	rng := rand.New(rand.NewSource(5))
+	// Points are spread around a few cluster centers so that nearest neighbors are meaningful.
+	centers := make([][]float32, 20)
+	for c := range centers {
+		centers[c] = make([]float32, dim)
+		for j := range centers[c] {
+			centers[c][j] = rng.Float32() * 10
+		}
+	}
+	vectors := make(map[int][]float32, n)
+	for i := 0; i < n; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = centers[i%len(centers)][j] + float32(rng.NormFloat64())
+		}
+		vectors[i] = vec
+	}
+	exact := rpt.NewRPTIndex(dim, 1000, defaultCandidateProjections, defaultParallelThreshold, defaultProbeMargin)
+	approx := rpt.NewRPTIndex(dim, 1000, defaultCandidateProjections, defaultParallelThreshold, defaultProbeMargin)
+	approx.Approximate = true
+	for _, idx := range []*rpt.RPTIndex{exact, approx} {
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		idx.Rebuild()
+	}
+	if approx.NeedsRebuild() {
+		t.Fatal("expected sketches to be built by Rebuild")
+	}
+
+	// measure returns the average recall against brute force and the total search time.
+	queries := make([][]float32, 50)
+	for q := range queries {
+		queries[q] = vectors[rng.Intn(n)]
+	}
+	measure := func(idx *rpt.RPTIndex) (float64, time.Duration) {
+		var recall float64
+		var elapsed time.Duration
+		for _, query := range queries {
+			truth := make([]core.Neighbor, 0, n)
+			for id, vec := range vectors {
+				truth = append(truth, core.Neighbor{ID: id, Distance: core.Euclidean(query, vec)})
+			}
+			sort.Slice(truth, func(i, j int) bool { return truth[i].Distance < truth[j].Distance })
+			want := make(map[int]bool, k)
+			for _, nb := range truth[:k] {
+				want[nb.ID] = true
+			}
+
+			start := time.Now()
+			results, err := idx.Search(query, k)
+			elapsed += time.Since(start)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			for _, r := range results {
+				if want[r.ID] {
+					recall++
+				}
+				// Returned distances are always exact.
+				if d := core.Euclidean(query, vectors[r.ID]); d != r.Distance {
+					t.Fatalf("expected exact distance %f for id %d, got %f", d, r.ID, r.Distance)
+				}
+			}
+		}
+		return recall / float64(len(queries)*k), elapsed
+	}
+	exactRecall, exactTime := measure(exact)
+	approxRecall, approxTime := measure(approx)
+	t.Logf("exact: recall %.3f in %v; approximate: recall %.3f in %v",
+		exactRecall, exactTime, approxRecall, approxTime)
+	if approxRecall > exactRecall {
+		t.Errorf("approximate recall %.3f should not exceed exact recall %.3f", approxRecall, exactRecall)
+	}
+	if approxRecall < exactRecall/2 {
+		t.Errorf("approximate recall %.3f is too far below exact recall %.3f", approxRecall, exactRecall)
+	}
+}
+
+func TestRPTIndex_SearchByID(t *testing.T) {
+	idx := rpt.NewRPTIndex(2, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	vectors := map[int][]float32{
+		1: {0, 0},
+		2: {1, 0},
+		3: {0, 2},
+		4: {3, 3},
+		5: {10, 10},
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+
+	results, err := idx.SearchByID(1, 2)
+	if err != nil {
+		t.Fatalf("SearchByID failed: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != 2 || results[1].ID != 3 {
+		t.Errorf("expected ids [2 3], got %+v", results)
+	}
+	if _, err := idx.SearchByID(99, 2); err == nil {
+		t.Error("expected error for unknown id, got none")
+	}
+}
+
+func TestRPTIndex_Reserve(t *testing.T) {
+	idx := rpt.NewRPTIndex(2, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	if err := idx.Add(1, []float32{1, 1}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	idx.Reserve(100)
+	if err := idx.BulkAdd(map[int][]float32{2: {2, 2}, 3: {3, 3}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if count := idx.Stats().Count; count != 3 {
+		t.Errorf("expected 3 points after Reserve and BulkAdd, got %d", count)
+	}
+}
+
+func BenchmarkRPTIndex_BulkAddReserve(b *testing.B) {
+	dim, n := 8, 100000
+	vectors := make(map[int][]float32, n)
+	for i := 0; i < n; i++ {
+		vectors[i] = make([]float32, dim)
+	}
+	for _, reserve := range []bool{false, true} {
+		name := "NoReserve"
+		if reserve {
+			name = "Reserve"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				idx := rpt.NewRPTIndex(dim, defaultLeafCapacity, defaultCandidateProjections,
+					defaultParallelThreshold, defaultProbeMargin)
+				if reserve {
+					idx.Reserve(n)
+				}
+				if err := idx.BulkAdd(vectors); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRPTIndex_Validate(t *testing.T) {
+	idx := rpt.NewRPTIndex(4, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	if err := idx.Validate(); err != nil {
+		t.Fatalf("expected empty index to validate, got %v", err)
+	}
+	rng := rand.New(rand.NewSource(5))
+	for i := 0; i < 200; i++ {
+		vec := make([]float32, 4)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		if err := idx.Add(i, vec); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	idx.Rebuild()
+	if err := idx.Validate(); err != nil {
+		t.Fatalf("expected valid index after rebuild, got %v", err)
+	}
+
+	idx.DropPoint(7)
+	if err := idx.Validate(); err == nil {
+		t.Error("expected Validate to report a leaf id missing from the index")
+	}
+}
+
+func TestRPTIndex_SentinelErrors(t *testing.T) {
+	idx := rpt.NewRPTIndex(2, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	if _, err := idx.Search([]float32{0, 0}, 1); !errors.Is(err, core.ErrEmptyIndex) {
+		t.Errorf("expected ErrEmptyIndex, got %v", err)
+	}
+	if err := idx.Add(1, []float32{1, 1}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if err := idx.Delete(99); !errors.Is(err, core.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := idx.Add(1, []float32{2, 2}); !errors.Is(err, core.ErrDuplicateID) {
+		t.Errorf("expected ErrDuplicateID, got %v", err)
+	}
+	if err := idx.Add(2, []float32{1, 2, 3}); !errors.Is(err, core.ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch, got %v", err)
+	}
+	if _, err := idx.Search([]float32{0, 0}, 0); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK, got %v", err)
+	}
+}
+
+func TestRPTIndex_CosineSplits(t *testing.T) {
+	t.Setenv("HANN_SEED", "7")
+	dim := 16
+	rng := rand.New(rand.NewSource(1))
+	vectors := make(map[int][]float32, 2000)
+	for i := 0; i < 2000; i++ {
+		// Cosine ignores the norm, so the vectors have large, varying magnitudes.
+		scale := 100 * (0.5 + rng.Float64())
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = float32(rng.NormFloat64() * scale)
+		}
+		vectors[i] = vec
+	}
+
+	fraction := func(name string) float64 {
+		idx := rpt.NewRPTIndex(dim, defaultLeafCapacity, defaultCandidateProjections,
+			defaultParallelThreshold, defaultProbeMargin)
+		idx.Distance = core.Distances[name]
+		idx.DistanceName = name
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		return idx.ProjectionSplitFraction()
+	}
+	euclidean, cosine := fraction("euclidean"), fraction("cosine")
+	t.Logf("splits separated by their projection: euclidean %.3f, cosine %.3f", euclidean, cosine)
+	// The jitter is scaled by the cosine spread (at most 2), so splits stay near the median instead of
+	// pushing every point to one side and falling back to an even split by id.
+	if cosine < 0.9 {
+		t.Errorf("expected at least 90%% of cosine splits to follow their projection, got %.3f", cosine)
+	}
+}
+
+func TestRPTIndex_MaxCandidates(t *testing.T) {
+	t.Setenv("HANN_SEED", "3")
+	dim, n := 8, 1000
+	rng := rand.New(rand.NewSource(9))
+	vectors := make(map[int][]float32, n)
+	for i := 0; i < n; i++ {
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = rng.Float32()
+		}
+		vectors[i] = vec
+	}
+	// A huge probe margin makes every split look close to the query, so all leaves are probed.
+	idx := rpt.NewRPTIndex(dim, defaultLeafCapacity, defaultCandidateProjections, defaultParallelThreshold, 1e9)
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := vectors[123]
+	if got := len(idx.CandidateIDs(query, 5)); got != n {
+		t.Fatalf("expected all %d points as candidates without a cap, got %d", n, got)
+	}
+
+	idx.MaxCandidates = 50
+	candidates := idx.CandidateIDs(query, 5)
+	if len(candidates) > idx.MaxCandidates {
+		t.Errorf("expected at most %d candidates, got %d", idx.MaxCandidates, len(candidates))
+	}
+	seen := make(map[int]bool)
+	for _, id := range candidates {
+		if seen[id] {
+			t.Fatalf("duplicate candidate id %d", id)
+		}
+		seen[id] = true
+	}
+	results, err := idx.Search(query, 5)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("expected 5 results, got %d", len(results))
+	}
+	for _, res := range results {
+		if !seen[res.ID] {
+			t.Errorf("result %d is not among the capped candidates", res.ID)
+		}
+	}
+
+	// The cap never drops below k.
+	if got := len(idx.CandidateIDs(query, 80)); got != 80 {
+		t.Errorf("expected 80 candidates for k=80, got %d", got)
+	}
+}
+
+func TestRPTIndex_Close(t *testing.T) {
+	idx := rpt.NewRPTIndex(2, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	if err := idx.Add(1, []float32{1, 1}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := idx.Close(); err != nil {
+			t.Errorf("Close call %d failed: %v", i+1, err)
+		}
+	}
+}
+
+func TestRPTIndex_BuildStats(t *testing.T) {
+	rng := rand.New(rand.NewSource(31))
+	var counts []int64
+	for _, n := range []int{200, 800} {
+		vectors := make(map[int][]float32, n)
+		for i := 0; i < n; i++ {
+			vec := make([]float32, 8)
+			for j := range vec {
+				vec[j] = rng.Float32()
+			}
+			vectors[i] = vec
+		}
+		idx := rpt.NewRPTIndex(8, defaultLeafCapacity, defaultCandidateProjections,
+			defaultParallelThreshold, defaultProbeMargin)
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		idx.Rebuild()
+		before := idx.BuildStats().DistanceEvaluations
+		if before < int64(n*defaultCandidateProjections) {
+			t.Fatalf("expected the root split alone to count %d projections, got %d evaluations",
+				n*defaultCandidateProjections, before)
+		}
+		if _, err := idx.Search(vectors[0], 10); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if after := idx.BuildStats().DistanceEvaluations; after != before {
+			t.Errorf("expected Search not to count build distances, got %d then %d", before, after)
+		}
+		counts = append(counts, before)
+	}
+	if counts[1] < 2*counts[0] {
+		t.Errorf("expected 4x the vectors to take at least 2x the distance evaluations, got %d and %d",
+			counts[0], counts[1])
+	}
+
+	// Exact median splits compute no distances, but their projections are still counted.
+	idx := rpt.NewRPTIndex(2, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	idx.UseExactMedian = true
+	vectors := make(map[int][]float32, 100)
+	for i := 0; i < 100; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	idx.Rebuild()
+	if got := idx.BuildStats().DistanceEvaluations; got < int64(100*defaultCandidateProjections) {
+		t.Errorf("expected at least %d evaluations for an exact median build, got %d",
+			100*defaultCandidateProjections, got)
+	}
+}
+
+func TestRPTIndex_MaxParallelism(t *testing.T) {
+	defer core.SetMaxParallelism(0)
+	t.Setenv("HANN_SEED", "9")
+	var active, peak atomic.Int64
+	idx := rpt.NewRPTIndex(4, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	idx.Distance = func(a, b []float32) float64 {
+		cur := active.Add(1)
+		for {
+			p := peak.Load()
+			if cur <= p || peak.CompareAndSwap(p, cur) {
+				break
+			}
+		}
+		defer active.Add(-1)
+		return core.Euclidean(a, b)
+	}
+	rng := rand.New(rand.NewSource(9))
+	vectors := make(map[int][]float32, 500)
+	for i := 0; i < 500; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	idx.Rebuild()
+	query := []float32{0.5, 0.5, 0.5, 0.5}
+	// Asking for every vector makes the search compute distances to all of them.
+	want, err := idx.Search(query, 500)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	for _, limit := range []struct{ global, index, max int }{{1, 0, 1}, {8, 2, 2}} {
+		core.SetMaxParallelism(limit.global)
+		idx.MaxParallelism = limit.index
+		peak.Store(0)
+		got, err := idx.Search(query, 500)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("limit %+v: results differ from the default parallelism", limit)
+		}
+		if p := peak.Load(); p > int64(limit.max) {
+			t.Errorf("limit %+v: %d distance computations ran at once", limit, p)
+		}
+	}
+}
+
+func TestRPTIndex_SearchFarthest(t *testing.T) {
+	idx := rpt.NewRPTIndex(2, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	idx.Distance = core.Manhattan
+	vectors := map[int][]float32{1: {0, 0}, 2: {3, 0}, 3: {2, 2}, 4: {-1, 1}}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	// By Manhattan distance id 3 is the farthest, by Euclidean distance id 2 would be.
+	got, err := idx.SearchFarthest([]float32{0, 0}, 2)
+	if err != nil {
+		t.Fatalf("SearchFarthest failed: %v", err)
+	}
+	want := []core.Neighbor{{ID: 3, Distance: 4}, {ID: 2, Distance: 3}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v by the index distance, got %+v", want, got)
+	}
+}
+
+func TestRPTIndex_Freeze(t *testing.T) {
+	idx := rpt.NewRPTIndex(2, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	if err := idx.BulkAdd(map[int][]float32{1: {0, 0}, 2: {1, 0}, 3: {5, 5}}); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	if idx.Frozen() {
+		t.Fatal("expected a new index not to be frozen")
+	}
+	other := rpt.NewRPTIndex(2, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	if err := other.Add(7, []float32{3, 3}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	var saved, compressed bytes.Buffer
+	if err := other.Save(&saved); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := other.SaveCompressed(&compressed); err != nil {
+		t.Fatalf("SaveCompressed failed: %v", err)
+	}
+	idx.Freeze()
+	if !idx.Frozen() {
+		t.Fatal("expected the index to be frozen after Freeze")
+	}
+	mutations := map[string]func() error{
+		"Add":            func() error { return idx.Add(4, []float32{2, 2}) },
+		"BulkAdd":        func() error { return idx.BulkAdd(map[int][]float32{4: {2, 2}}) },
+		"Delete":         func() error { return idx.Delete(1) },
+		"BulkDelete":     func() error { return idx.BulkDelete([]int{1, 2}) },
+		"Update":         func() error { return idx.Update(1, []float32{9, 9}) },
+		"BulkUpdate":     func() error { return idx.BulkUpdate(map[int][]float32{1: {9, 9}}) },
+		"Load":           func() error { return idx.Load(&saved) },
+		"LoadCompressed": func() error { return idx.LoadCompressed(&compressed) },
+	}
+	for name, mutate := range mutations {
+		if err := mutate(); !errors.Is(err, core.ErrFrozen) {
+			t.Errorf("%s: expected ErrFrozen, got %v", name, err)
+		}
+	}
+	if n, failures := idx.BulkAddLenient(map[int][]float32{4: {2, 2}}); n != 0 || !errors.Is(failures[4], core.ErrFrozen) {
+		t.Errorf("BulkAddLenient: expected no additions and ErrFrozen, got %d, %v", n, failures)
+	}
+	if n := idx.Stats().Count; n != 3 {
+		t.Errorf("expected 3 vectors after rejected mutations, got %d", n)
+	}
+	if vec, err := idx.GetVector(1); err != nil || !reflect.DeepEqual(vec, []float32{0, 0}) {
+		t.Errorf("expected vector 1 unchanged, got %v, %v", vec, err)
+	}
+	results, err := idx.Search([]float32{0.9, 0}, 1)
+	if err != nil {
+		t.Fatalf("Search on frozen index failed: %v", err)
+	}
+	if results[0].ID != 2 {
+		t.Errorf("expected id 2, got %d", results[0].ID)
+	}
+}
+
+func TestRPTIndex_Prefetch(t *testing.T) {
+	idx := rpt.NewRPTIndex(2, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	vectors := map[int][]float32{1: {0, 0}, 2: {1, 0}, 3: {5, 5}, 4: {9, 9}}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	before, err := idx.Search([]float32{0.9, 0}, 2)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	for _, ids := range [][]int{nil, {1, 2}} {
+		if err := idx.Prefetch(ids); err != nil {
+			t.Fatalf("Prefetch(%v) failed: %v", ids, err)
+		}
+	}
+	after, err := idx.Search([]float32{0.9, 0}, 2)
+	if err != nil {
+		t.Fatalf("Search after Prefetch failed: %v", err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("expected the same results after Prefetch, got %v, want %v", after, before)
+	}
+}
+
+func TestRPTIndex_KthDistance(t *testing.T) {
+	idx := rpt.NewRPTIndex(4, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	rng := rand.New(rand.NewSource(8))
+	vectors := make(map[int][]float32, 100)
+	for i := 0; i < 100; i++ {
+		vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	query := []float32{0.5, 0.5, 0.5, 0.5}
+	for _, k := range []int{1, 5, 10} {
+		results, err := idx.Search(query, k)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		got, err := idx.KthDistance(query, k)
+		if err != nil {
+			t.Fatalf("KthDistance failed: %v", err)
+		}
+		if got != results[k-1].Distance {
+			t.Errorf("k=%d: KthDistance = %f; want %f", k, got, results[k-1].Distance)
+		}
+	}
+	if _, err := idx.KthDistance(query, 101); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK for k above the index size, got %v", err)
+	}
+	if _, err := idx.KthDistance(query, 0); !errors.Is(err, core.ErrInvalidK) {
+		t.Errorf("expected ErrInvalidK for k=0, got %v", err)
+	}
+}
+
+func TestRPTIndex_UseExactMedian(t *testing.T) {
+	// In one dimension every projection is +1 or -1, so the projections of the points are known up to sign.
+	build := func(n int) *rpt.RPTIndex {
+		idx := rpt.NewRPTIndex(1, 2, defaultCandidateProjections, defaultParallelThreshold, 0)
+		idx.UseExactMedian = true
+		vectors := make(map[int][]float32, n)
+		for i := 1; i <= n; i++ {
+			vectors[i] = []float32{float32(i)}
+		}
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		return idx
+	}
+
+	threshold, left, right := build(16).RootSplit()
+	if math.Abs(threshold) != 8.5 {
+		t.Errorf("expected the root threshold at the median 8.5 (up to sign), got %v", threshold)
+	}
+	if len(left) != 8 || len(right) != 8 {
+		t.Errorf("expected an 8/8 split, got %d/%d", len(left), len(right))
+	}
+	sort.Ints(left)
+	if left[0] != 1 && left[0] != 9 {
+		t.Errorf("expected the left subtree to hold one half of the values, got %v", left)
+	}
+
+	// With an odd count the threshold is the middle value, which goes left.
+	threshold, left, right = build(15).RootSplit()
+	if math.Abs(threshold) != 8 {
+		t.Errorf("expected the root threshold at the median 8 (up to sign), got %v", threshold)
+	}
+	if len(left) != 8 || len(right) != 7 {
+		t.Errorf("expected an 8/7 split with the median on the left, got %d/%d", len(left), len(right))
+	}
+
+	want := build(16).Leaves()
+	for i := 0; i < 3; i++ {
+		if got := build(16).Leaves(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected the same leaves on every build, got %v and %v", got, want)
+		}
+	}
+}
+
+func TestRPTIndex_SearchWith(t *testing.T) {
+	idx := rpt.NewRPTIndex(1, defaultLeafCapacity, defaultCandidateProjections,
+		defaultParallelThreshold, defaultProbeMargin)
+	vectors := make(map[int][]float32, 100)
+	for i := 0; i < 100; i++ {
+		vectors[i] = []float32{float32(i)}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	opts := core.SearchOptions{
+		Filter:     func(id int) bool { return id%10 == 0 },
+		ExcludeIDs: []int{0},
+		Ef:         1, // ignored by RPT
+	}
+	results, err := idx.SearchWith([]float32{0}, 3, opts)
+	if err != nil {
+		t.Fatalf("SearchWith failed: %v", err)
+	}
+	var got []int
+	for _, n := range results {
+		got = append(got, n.ID)
+	}
+	if want := []int{10, 20, 30}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestRPTIndex_SparseLargeIDs(t *testing.T) {
+	idx := rpt.NewRPTIndex(2, 4, defaultCandidateProjections, defaultParallelThreshold, defaultProbeMargin)
+	rng := rand.New(rand.NewSource(8))
+	vectors := make(map[int][]float32, 50)
+	for len(vectors) < 50 {
+		// Scattered ids across the whole int64 range, including negative ones.
+		vectors[int(rng.Uint64())] = []float32{rng.Float32() * 10, rng.Float32() * 10}
+	}
+	if err := idx.BulkAdd(vectors); err != nil {
+		t.Fatalf("BulkAdd failed: %v", err)
+	}
+	deleted := 0
+	for id := range vectors {
+		if deleted == 10 {
+			break
+		}
+		if err := idx.Delete(id); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		delete(vectors, id)
+		deleted++
+	}
+	if err := idx.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if n := idx.Stats().Count; n != len(vectors) {
+		t.Fatalf("expected %d vectors, got %d", len(vectors), n)
+	}
+	for id, vec := range vectors {
+		stored, err := idx.GetVector(id)
+		if err != nil || !reflect.DeepEqual(stored, vec) {
+			t.Fatalf("id %d: expected %v, got %v (%v)", id, vec, stored, err)
+		}
+		results, err := idx.Search(vec, len(vectors))
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if results[0].ID != id || results[0].Distance != 0 {
+			t.Errorf("expected id %d at distance 0 first, got %v", id, results[0])
+		}
+		for _, n := range results {
+			if _, ok := vectors[n.ID]; !ok {
+				t.Fatalf("result id %d is not a stored id", n.ID)
+			}
+		}
+	}
+}
+
+func TestRPTIndex_AutoLeafCapacity(t *testing.T) {
+	t.Setenv("HANN_SEED", "9")
+	rng := rand.New(rand.NewSource(9))
+	build := func(n int) *rpt.RPTIndex {
+		idx := rpt.NewRPTIndex(4, 3, defaultCandidateProjections, defaultParallelThreshold, defaultProbeMargin)
+		idx.AutoLeafCapacity = true
+		vectors := make(map[int][]float32, n)
+		for i := 0; i < n; i++ {
+			vectors[i] = []float32{rng.Float32(), rng.Float32(), rng.Float32(), rng.Float32()}
+		}
+		if err := idx.BulkAdd(vectors); err != nil {
+			t.Fatalf("BulkAdd failed: %v", err)
+		}
+		return idx
+	}
+
+	small, large := build(100), build(20000)
+	if got := small.EffectiveLeafCapacity(); got != 10 {
+		t.Errorf("expected leaf capacity 10 for 100 points, got %d", got)
+	}
+	if got := large.EffectiveLeafCapacity(); got != 15 {
+		t.Errorf("expected leaf capacity 15 for 20000 points, got %d", got)
+	}
+	maxLeaf := 0
+	for _, leaf := range large.Leaves() {
+		maxLeaf = max(maxLeaf, len(leaf))
+	}
+	if maxLeaf <= 10 || maxLeaf > 15 {
+		t.Errorf("expected the largest leaf to hold between 11 and 15 points, got %d", maxLeaf)
+	}
+
+	large.AutoLeafCapacity = false
+	if got := large.EffectiveLeafCapacity(); got != 3 {
+		t.Errorf("expected the explicit leaf capacity 3 without AutoLeafCapacity, got %d", got)
+	}
+}
+
+func TestRPTIndex_DegenerateParameters(t *testing.T) {
+	idx := rpt.NewRPTIndex(2, 0, -1, defaultParallelThreshold, defaultProbeMargin)
+	if idx.LeafCapacity != 1 || idx.CandidateProjections != 1 {
+		t.Fatalf("expected leaf capacity and candidate projections to be raised to 1, got %d and %d",
+			idx.LeafCapacity, idx.CandidateProjections)
+	}
+	for i := 0; i < 50; i++ {
+		if err := idx.Add(i, []float32{float32(i), float32(i % 5)}); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	results, err := idx.Search([]float32{20, 0}, 3)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 3 || results[0].ID != 20 {
+		t.Errorf("expected 3 results starting with id 20, got %v", results)
+	}
+	for _, leaf := range idx.Leaves() {
+		if len(leaf) != 1 {
+			t.Errorf("expected every leaf to hold exactly one point, got %v", leaf)
+		}
+	}
+}